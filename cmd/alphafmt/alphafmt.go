@@ -6,704 +6,953 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/format"
-	"go/parser"
-	"go/printer"
-	"go/token"
+	"go/scanner"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
 
-	"espra.dev/pkg/obs"
+	"espra.dev/cmd/internal/fmtcmd"
+	"espra.dev/pkg/alphafmt"
 	"espra.dev/pkg/process"
 )
 
-type declItem struct {
-	name string
-	decl ast.Decl
+// Exit statuses. A status of 0 means that no errors occurred, and that, when
+// using -check or -l without -w, all files were already formatted.
+const (
+	exitChanged = 1 // files need formatting, with -check or -l, unless -w is set
+	exitUsage   = 2 // invalid flags or arguments
+	exitError   = 3 // files could not be read, parsed, formatted, or written
+)
+
+// errTooManyFiles is returned when walking finds more files than the limit set
+// via -max-files.
+var errTooManyFiles = errors.New("too many files to format")
+
+// fileResult holds the result of formatting a file. The done channel is
+// closed once the result has been set.
+type fileResult struct {
+	changed bool
+	done    chan struct{}
+	err     error
+	out     []byte
 }
 
-func appendDeclItems(blocks []ast.Decl, singles []declItem) []ast.Decl {
-	decls := slices.Clone(blocks)
-	for _, item := range singles {
-		decls = append(decls, item.decl)
-	}
-	return decls
+// formatter formats Go files according to the command line options.
+type formatter struct {
+	cache            *cache
+	includeGenerated bool
+	maxFileSize      int64
+	opts             *alphafmt.Options
+	overlay          overlay
+	verify           bool
 }
 
-func buildImportSection(fset *token.FileSet, importDecls []ast.Decl) string {
-	if len(importDecls) == 0 {
-		return ""
+// format formats the given source for the file at path, which may be a Go
+// source file, or a go.mod or go.work file.
+func (f *formatter) format(path string, src []byte) ([]byte, error) {
+	if alphafmt.IsModFile(path) {
+		return alphafmt.FormatModFile(path, src)
 	}
+	return alphafmt.FormatWithOptions(path, src, f.opts)
+}
 
-	var docGroups []*ast.CommentGroup
-	var stdSpecs []*ast.ImportSpec
-	var otherSpecs []*ast.ImportSpec
-	for _, decl := range importDecls {
-		gen, ok := decl.(*ast.GenDecl)
-		if !ok || gen.Tok != token.IMPORT {
-			continue
-		}
-		if gen.Doc != nil {
-			docGroups = append(docGroups, gen.Doc)
+// formatFile formats the Go file at the given path, reading it from the
+// overlay if it has a replacement there. Generated files are left
+// untouched unless includeGenerated is set, and files larger than maxFileSize
+// result in an error without being read. If verify is set, the output is
+// formatted a second time, and an error is returned if that changes it.
+func (f *formatter) formatFile(path string) (bool, []byte, error) {
+	if f.maxFileSize > 0 {
+		info, err := f.overlay.stat(path)
+		if err != nil {
+			return false, nil, err
 		}
-		for _, spec := range gen.Specs {
-			importSpec, ok := spec.(*ast.ImportSpec)
-			if !ok {
-				continue
-			}
-			path := importPath(importSpec)
-			if isStdImport(path) {
-				stdSpecs = append(stdSpecs, importSpec)
-			} else {
-				otherSpecs = append(otherSpecs, importSpec)
-			}
+		if info.Size() > f.maxFileSize {
+			logger.Debug("skipping file", "path", path, "reason", "too large", "size", info.Size())
+			return false, nil, fmt.Errorf("%s: file is too large to format (%d bytes), the limit is %d bytes, see -max-file-size", path, info.Size(), f.maxFileSize)
 		}
 	}
-
-	sortImportSpecs(stdSpecs)
-	sortImportSpecs(otherSpecs)
-
-	buf := &bytes.Buffer{}
-	for _, group := range docGroups {
-		for _, line := range group.List {
-			buf.WriteString(line.Text)
-			buf.WriteByte('\n')
+	src, err := f.overlay.readFile(path)
+	if err != nil {
+		return false, nil, err
+	}
+	if !f.includeGenerated && !alphafmt.IsModFile(path) && alphafmt.IsGenerated(src) {
+		logger.Debug("skipping file", "path", path, "reason", "generated")
+		return false, src, nil
+	}
+	key := ""
+	if f.cache != nil {
+		key, err = f.cache.key(path, f.opts, src)
+		if err != nil {
+			return false, nil, err
+		}
+		if !f.verify && key != "" && f.cache.has(key) {
+			logger.Debug("cache hit", "path", path)
+			return false, src, nil
 		}
 	}
-	buf.WriteString("import (\n")
-	writeImportSpecs(buf, fset, stdSpecs)
-	if len(stdSpecs) > 0 && len(otherSpecs) > 0 {
-		buf.WriteByte('\n')
+	start := time.Now()
+	formatted, err := f.format(path, src)
+	if err != nil {
+		return false, nil, err
 	}
-	writeImportSpecs(buf, fset, otherSpecs)
-	buf.WriteString(")\n")
-	return strings.TrimRight(buf.String(), "\n")
-}
-
-func buildTypeSection(fset *token.FileSet, comments []*ast.CommentGroup, typeDecls []declItem, methods map[string][]*ast.FuncDecl) string {
-	if len(typeDecls) == 0 && len(methods) == 0 {
-		return ""
-	}
-
-	parts := []string{}
-	seen := map[string]struct{}{}
-	for _, item := range typeDecls {
-		seen[item.name] = struct{}{}
-		typeString := formatDecl(fset, comments, item.decl)
-		parts = append(parts, typeString)
-		if typeMethods := methods[item.name]; len(typeMethods) > 0 {
-			for _, method := range typeMethods {
-				methodString := formatDecl(fset, comments, method)
-				parts = append(parts, methodString)
+	if f.verify {
+		again, err := f.format(path, formatted)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s: failed to reformat output: %w", path, err)
+		}
+		if line := firstDiffLine(formatted, again); line > 0 {
+			return false, nil, fmt.Errorf("%s:%d: formatting is not stable, a second pass changes the output", path, line)
+		}
+	}
+	changed := !bytes.Equal(src, formatted)
+	logger.Debug("formatted file", "path", path, "changed", changed, "elapsed", time.Since(start))
+	if f.cache != nil && key != "" {
+		// The formatted output is known to be formatted, whether or not it
+		// ends up being written. This isn't the case in minimal mode, where
+		// each run only moves declarations part of the way.
+		if !changed {
+			f.cache.add(key)
+		} else if !f.opts.Minimal {
+			key, err = f.cache.key(path, f.opts, formatted)
+			if err != nil {
+				return false, nil, err
 			}
+			f.cache.add(key)
 		}
 	}
+	return changed, formatted, nil
+}
 
-	remaining := []string{}
-	for name := range methods {
-		if _, ok := seen[name]; ok {
-			continue
-		}
-		remaining = append(remaining, name)
+// formatFiles formats the given files concurrently using the given number of
+// workers. The emit function is called for each file in the original order.
+func (f *formatter) formatFiles(files []string, workers int, emit func(path string, res *fileResult)) {
+	results := make([]*fileResult, len(files))
+	for i := range results {
+		results[i] = &fileResult{done: make(chan struct{})}
+	}
+	jobs := make(chan int)
+	for range min(workers, len(files)) {
+		go func() {
+			for i := range jobs {
+				res := results[i]
+				res.changed, res.out, res.err = f.formatFile(files[i])
+				close(res.done)
+			}
+		}()
 	}
-
-	sort.Strings(remaining)
-	for _, name := range remaining {
-		for _, method := range methods[name] {
-			methodString := formatDecl(fset, comments, method)
-			parts = append(parts, methodString)
+	go func() {
+		for i := range files {
+			jobs <- i
 		}
+		close(jobs)
+	}()
+	for i, res := range results {
+		<-res.done
+		emit(files[i], res)
+		results[i] = nil
 	}
-	return strings.Join(parts, "\n\n")
 }
 
-func collectDeclStrings(fset *token.FileSet, comments []*ast.CommentGroup, decls []ast.Decl) string {
-	if len(decls) == 0 {
-		return ""
-	}
-	parts := []string{}
-	for _, decl := range decls {
-		part := formatDecl(fset, comments, decl)
-		parts = append(parts, part)
-	}
-	return strings.Join(parts, "\n\n")
+// ignoreRules determines which files and directories are skipped when walking
+// directories.
+type ignoreRules struct {
+	git      bool
+	patterns stringList
 }
 
-func collectDocComments(node ast.Node, out map[*ast.CommentGroup]struct{}) {
-	ast.Inspect(node, func(n ast.Node) bool {
-		switch typed := n.(type) {
-		case *ast.Field:
-			if typed.Doc != nil {
-				out[typed.Doc] = struct{}{}
-			}
-		case *ast.FuncDecl:
-			if typed.Doc != nil {
-				out[typed.Doc] = struct{}{}
-			}
-		case *ast.GenDecl:
-			if typed.Doc != nil {
-				out[typed.Doc] = struct{}{}
-			}
-		case *ast.ImportSpec:
-			if typed.Doc != nil {
-				out[typed.Doc] = struct{}{}
-			}
-		case *ast.TypeSpec:
-			if typed.Doc != nil {
-				out[typed.Doc] = struct{}{}
-			}
-		case *ast.ValueSpec:
-			if typed.Doc != nil {
-				out[typed.Doc] = struct{}{}
-			}
+// match reports whether the given path matches any of the ignore patterns.
+// Patterns are matched against both the base name and the slash-separated
+// path, so that "testdata_*" and "internal/legacy/*" both work as expected.
+func (r *ignoreRules) match(path string) bool {
+	name := filepath.Base(path)
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, pattern := range r.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
 		}
-		return true
-	})
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
 }
 
-func collectFuncStrings(fset *token.FileSet, comments []*ast.CommentGroup, funcs []*ast.FuncDecl) string {
-	if len(funcs) == 0 {
-		return ""
-	}
-	parts := []string{}
-	for _, decl := range funcs {
-		part := formatDecl(fset, comments, decl)
-		parts = append(parts, part)
-	}
-	return strings.Join(parts, "\n\n")
+// stringList is a flag value that can be set multiple times.
+type stringList []string
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
-func collectGoFiles(paths []string) []string {
-	var files []string
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
 
-	for _, p := range paths {
-		info, err := os.Stat(p)
+// walkOptions determine how directories are walked when looking for files to
+// format. Limits of zero are ignored.
+type walkOptions struct {
+	follow   bool
+	ignore   *ignoreRules
+	maxDepth int
+	maxFiles int
+	overlay  overlay
+}
+
+// collectGoFiles returns the sorted list of Go files at the given paths,
+// along with any errors encountered while looking for them. Files and
+// directories found while walking are skipped if they match the ignore rules,
+// and directories nested deeper than the depth limit are skipped with an
+// error. If more files than the file limit are found, walking stops, and the
+// errors include errTooManyFiles.
+//
+// Symlinked directories are only walked if follow is set, in which case the
+// real path of each directory is tracked so that cycles are only walked once,
+// and files reachable via multiple paths are only returned once.
+func collectGoFiles(paths []string, opts *walkOptions) ([]string, []error) {
+	var (
+		errs  []error
+		files []string
+		stop  bool
+		walk  func(root string, depth int)
+	)
+	// add records the given file, and reports whether the file limit has
+	// been exceeded.
+	add := func(path string) bool {
+		if replacement, ok := opts.overlay.lookup(path); ok && replacement == "" {
+			logger.Debug("skipping file", "path", path, "reason", "deleted in overlay")
+			return false
+		}
+		files = append(files, path)
+		if opts.maxFiles > 0 && len(files) > opts.maxFiles {
+			errs = append(errs, fmt.Errorf("%w: found more than %d files, see -max-files", errTooManyFiles, opts.maxFiles))
+			stop = true
+		}
+		return stop
+	}
+	visited := map[string]struct{}{}
+	// seen reports whether the real path of the given directory has already
+	// been walked, and records it otherwise.
+	seen := func(dir string) bool {
+		real, err := filepath.EvalSymlinks(dir)
 		if err != nil {
-			obs.Fatalf("Failed to stat %q: %v", p, err)
+			errs = append(errs, err)
+			return true
 		}
-		if !info.IsDir() {
-			if filepath.Ext(p) != ".go" {
-				obs.Fatalf("File at path %q does not end in .go", p)
-			}
-			files = append(files, p)
-			continue
+		if _, ok := visited[real]; ok {
+			return true
 		}
-		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, walkErr error) error {
-			if walkErr != nil {
-				return walkErr
+		visited[real] = struct{}{}
+		return false
+	}
+	// The depth of the root directory is given, so that the depth of symlinked
+	// directories carries over from the directory linking to them.
+	walk = func(root string, depth int) {
+		if stop {
+			return
+		}
+		if opts.follow && seen(root) {
+			logger.Debug("skipping directory", "path", root, "reason", "already visited")
+			return
+		}
+		// WalkDir doesn't descend into a root which is itself a symlink, unless
+		// it has a trailing separator.
+		if info, err := os.Lstat(root); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			root += string(filepath.Separator)
+		}
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if stop {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if path != root && opts.ignore.match(path) {
+				logger.Debug("skipping path", "path", path, "reason", "ignore pattern")
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			level := depth
+			if rel, err := filepath.Rel(root, path); err == nil && rel != "." {
+				level += strings.Count(rel, string(filepath.Separator)) + 1
+			}
+			if d.Type()&fs.ModeSymlink != 0 && opts.follow {
+				info, err := os.Stat(path)
+				if err != nil {
+					errs = append(errs, err)
+					return nil
+				}
+				if info.IsDir() {
+					if opts.maxDepth > 0 && level > opts.maxDepth {
+						errs = append(errs, errTooDeep(path, opts.maxDepth))
+						return nil
+					}
+					skip, err := alphafmt.SkipDir(path)
+					if err != nil {
+						errs = append(errs, err)
+					} else if skip {
+						logger.Debug("skipping directory", "path", path, "reason", "skipped by alphafmt")
+					} else {
+						walk(path, level)
+					}
+					return nil
+				}
 			}
 			if d.IsDir() {
-				name := d.Name()
-				if strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata" {
+				if path == root {
+					return nil
+				}
+				skip, err := alphafmt.SkipDir(path)
+				if err != nil {
+					errs = append(errs, err)
+					return filepath.SkipDir
+				}
+				if skip {
+					logger.Debug("skipping directory", "path", path, "reason", "skipped by alphafmt")
+					return filepath.SkipDir
+				}
+				if opts.follow && seen(path) {
+					logger.Debug("skipping directory", "path", path, "reason", "already visited")
+					return filepath.SkipDir
+				}
+				if opts.maxDepth > 0 && level > opts.maxDepth {
+					errs = append(errs, errTooDeep(path, opts.maxDepth))
 					return filepath.SkipDir
 				}
 				return nil
 			}
-			if filepath.Ext(path) == ".go" {
-				files = append(files, path)
+			if !isFormattable(path) {
+				return nil
+			}
+			logger.Debug("considering file", "path", path)
+			if add(path) {
+				return filepath.SkipAll
 			}
 			return nil
 		})
-		if err != nil {
-			obs.Fatalf("Failed to walk directory %q: %v", p, err)
-		}
-	}
-	sort.Strings(files)
-	return files
-}
-
-func commentsForDecl(comments []*ast.CommentGroup, decl ast.Decl) []*ast.CommentGroup {
-	start, end := declRange(decl)
-	if start == token.NoPos || end == token.NoPos {
-		return nil
 	}
-
-	docComments := map[*ast.CommentGroup]struct{}{}
-	collectDocComments(decl, docComments)
-
-	var filtered []*ast.CommentGroup
-	for _, comment := range comments {
-		if comment.Pos() < start || comment.End() > end {
+	for _, p := range paths {
+		// Accept Go-style package patterns like ./... as well, since we always
+		// walk directories recursively.
+		if p == "..." {
+			p = "."
+		} else if strings.HasSuffix(p, "/...") {
+			p = strings.TrimSuffix(p, "/...")
+			if p == "" {
+				p = "/"
+			}
+		}
+		info, err := opts.overlay.stat(p)
+		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
-		if _, ok := docComments[comment]; ok {
+		if !info.IsDir() {
+			if !isFormattable(p) {
+				errs = append(errs, &fs.PathError{Op: "format", Path: p, Err: errors.New("file is not a .go, go.mod, or go.work file")})
+				continue
+			}
+			logger.Debug("considering file", "path", p)
+			if add(p) {
+				break
+			}
 			continue
 		}
-		filtered = append(filtered, comment)
+		walk(p, 0)
+		if stop {
+			break
+		}
 	}
-	return filtered
-}
-
-func declRange(decl ast.Decl) (token.Pos, token.Pos) {
-	switch node := decl.(type) {
-	case *ast.GenDecl:
-		if len(node.Specs) == 1 {
-			spec := node.Specs[0]
-			return spec.Pos(), spec.End()
+	if stop {
+		return nil, errs
+	}
+	if opts.ignore.git {
+		ignored, err := gitIgnored(files)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			files = slices.DeleteFunc(files, func(path string) bool {
+				_, ok := ignored[path]
+				if ok {
+					logger.Debug("skipping file", "path", path, "reason", "ignored by git")
+				}
+				return ok
+			})
 		}
 	}
-	return decl.Pos(), decl.End()
+	// Paths can overlap, e.g. when a file is both listed via -files-from and
+	// within a given directory.
+	sort.Strings(files)
+	files = slices.Compact(files)
+	if opts.follow {
+		// Formatting the same file via multiple paths would race on writes.
+		realFiles := map[string]struct{}{}
+		files = slices.DeleteFunc(files, func(path string) bool {
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return false
+			}
+			if _, ok := realFiles[real]; ok {
+				logger.Debug("skipping file", "path", path, "reason", "already visited", "real", real)
+				return true
+			}
+			realFiles[real] = struct{}{}
+			return false
+		})
+	}
+	return files, errs
 }
 
-func firstDeclName(decl ast.Decl) string {
-	gen, ok := decl.(*ast.GenDecl)
-	if !ok || len(gen.Specs) == 0 {
-		return ""
-	}
+// errTooDeep returns the error for a directory nested deeper than the limit
+// set via -max-depth.
+func errTooDeep(path string, limit int) error {
+	return fmt.Errorf("%s: skipping directory nested more than %d levels deep, see -max-depth", path, limit)
+}
 
-	return specFirstName(gen.Specs[0])
+// fatalf prints the given error message to stderr, and exits with the status
+// for runtime errors.
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	process.Exit(exitError)
 }
 
-func formatDecl(fset *token.FileSet, comments []*ast.CommentGroup, decl ast.Decl) string {
-	buf := &bytes.Buffer{}
-	cfg := &printer.Config{
-		Mode:     printer.TabIndent | printer.UseSpaces,
-		Tabwidth: 8,
+// firstDiffLine returns the 1-based number of the first line that differs
+// between a and b, or 0 if they are identical.
+func firstDiffLine(a []byte, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 0
 	}
-	var docComment *ast.CommentGroup
-	if gen, ok := decl.(*ast.GenDecl); ok && gen.Doc != nil {
-		docComment = gen.Doc
-		gen.Doc = nil
-	}
-	node := &printer.CommentedNode{
-		Comments: commentsForDecl(comments, decl),
-		Node:     decl,
-	}
-	if docComment != nil {
-		for _, line := range docComment.List {
-			buf.WriteString(line.Text)
-			buf.WriteByte('\n')
+	line := 1
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '\n' {
+			line++
 		}
 	}
-	if err := cfg.Fprint(buf, fset, node); err != nil {
-		obs.Fatalf("Failed to format declaration: %v", err)
-	}
-	return strings.TrimRight(buf.String(), "\n")
+	return line
 }
 
-func formatFile(path string) (bool, []byte) {
-	src, err := os.ReadFile(path)
-	if err != nil {
-		obs.Fatalf("Failed to read file %q: %v", path, err)
+// formatStdin formats the source piped via stdin, and writes the result, or a
+// diff against the source, to stdout. The filename is used for finding the
+// config, and in any errors, if it is not empty. Input larger than maxSize is
+// rejected, unless maxSize is zero.
+func formatStdin(filename string, opts *alphafmt.Options, diff bool, color bool, maxSize int64) {
+	var r io.Reader = os.Stdin
+	if maxSize > 0 {
+		r = io.LimitReader(os.Stdin, maxSize+1)
 	}
-	formatted := formatSource(path, src)
-	return !bytes.Equal(src, formatted), formatted
-}
-
-func formatImportSpec(fset *token.FileSet, spec *ast.ImportSpec) string {
-	if spec == nil {
-		return ""
-	}
-	buf := &bytes.Buffer{}
-	cfg := &printer.Config{
-		Mode:     printer.TabIndent | printer.UseSpaces,
-		Tabwidth: 8,
+	src, err := io.ReadAll(r)
+	if err != nil {
+		fatalf("Failed to read from stdin: %v", err)
 	}
-	if err := cfg.Fprint(buf, fset, spec); err != nil {
-		obs.Fatalf("Failed to format import spec: %v", err)
+	if maxSize > 0 && int64(len(src)) > maxSize {
+		fatalf("Failed to read from stdin: input is larger than the limit of %d bytes, see -max-file-size", maxSize)
 	}
-	return strings.TrimRight(buf.String(), "\n")
-}
-
-func formatSource(filename string, src []byte) []byte {
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
-	if err != nil {
-		obs.Fatalf("Failed to parse file %q: %v", filename, err)
+	name := filename
+	if name == "" {
+		name = "stdin"
 	}
-	ordered := orderFileDecls(fset, file)
-	formatted, err := format.Source(ordered)
+	formatted, err := alphafmt.FormatWithOptions(name, src, opts)
 	if err != nil {
-		obs.Fatalf("Failed to format file %q: %v", filename, err)
+		printErrors([]error{err})
+		process.Exit(exitError)
 	}
-	return formatted
-}
-
-func formatStdin() {
-	src, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		obs.Fatalf("Failed to read from stdin: %v", err)
+	if diff {
+		formatted = fmtcmd.UnifiedDiff(name, src, formatted)
+		if color {
+			formatted = fmtcmd.ColorizeDiff(formatted)
+		}
 	}
-	formatted := formatSource("stdin", src)
 	if _, err = os.Stdout.Write(formatted); err != nil {
-		obs.Fatalf("Failed to write to stdout: %v", err)
+		fatalf("Failed to write to stdout: %v", err)
 	}
 }
 
-func importPath(spec *ast.ImportSpec) string {
-	if spec == nil || spec.Path == nil {
-		return ""
-	}
-	path, err := strconv.Unquote(spec.Path.Value)
-	if err != nil {
-		return strings.Trim(spec.Path.Value, "\"")
-	}
-	return path
+// isFormattable reports whether the file at the given path is one that
+// alphafmt formats, i.e. Go source, or a go.mod or go.work file.
+func isFormattable(path string) bool {
+	return filepath.Ext(path) == ".go" || alphafmt.IsModFile(path)
 }
 
-func isStdImport(path string) bool {
-	if path == "" {
-		return true
-	}
-	if strings.HasPrefix(path, ".") {
-		return false
+// isTooManyFiles reports whether the given error is due to the -max-files
+// limit being exceeded.
+func isTooManyFiles(err error) bool {
+	return errors.Is(err, errTooManyFiles)
+}
+
+// plural formats n with the given noun, pluralized if needed.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
 	}
-	first, _, _ := strings.Cut(path, "/")
-	return !strings.Contains(first, ".")
+	return fmt.Sprintf("%d %ss", n, noun)
 }
 
-func orderFileDecls(fset *token.FileSet, file *ast.File) []byte {
-	var constBlocks []ast.Decl
-	var constSingles []declItem
-	var funcs []*ast.FuncDecl
-	var importDecls []ast.Decl
-	var initFuncs []*ast.FuncDecl
-	var mainFuncs []*ast.FuncDecl
-	var typeDecls []declItem
-	var varBlocks []ast.Decl
-	var varSingles []declItem
-
-	methods := map[string][]*ast.FuncDecl{}
-	for _, decl := range file.Decls {
-		switch node := decl.(type) {
-		case *ast.GenDecl:
-			switch node.Tok {
-			case token.IMPORT:
-				importDecls = append(importDecls, node)
-			case token.CONST:
-				block, singles := splitValueDecls(node)
-				if block != nil {
-					constBlocks = append(constBlocks, block)
-					continue
-				}
-				constSingles = append(constSingles, singles...)
-			case token.VAR:
-				block, singles := splitValueDecls(node)
-				if block != nil {
-					sortVarBlockSpecs(block)
-					varBlocks = append(varBlocks, block)
-					continue
-				}
-				varSingles = append(varSingles, singles...)
-			case token.TYPE:
-				items := splitTypeDecls(node)
-				typeDecls = append(typeDecls, items...)
-			}
-		case *ast.FuncDecl:
-			if node.Recv != nil {
-				recvName := receiverTypeName(node.Recv)
-				if recvName == "" {
-					funcs = append(funcs, node)
-					continue
-				}
-				methods[recvName] = append(methods[recvName], node)
-				continue
-			}
-			switch node.Name.Name {
-			case "main":
-				mainFuncs = append(mainFuncs, node)
-			case "init":
-				initFuncs = append(initFuncs, node)
-			default:
-				funcs = append(funcs, node)
+// printErrors writes the given errors to stderr, with each error from a parse
+// error list written on its own line.
+func printErrors(errs []error) {
+	for _, err := range errs {
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				fmt.Fprintln(os.Stderr, e)
 			}
+			continue
 		}
+		fmt.Fprintln(os.Stderr, err)
 	}
+}
 
-	sort.SliceStable(constBlocks, func(i, j int) bool {
-		return firstDeclName(constBlocks[i]) < firstDeclName(constBlocks[j])
-	})
-	sort.SliceStable(constSingles, func(i, j int) bool {
-		return constSingles[i].name < constSingles[j].name
-	})
-	sort.SliceStable(varSingles, func(i, j int) bool {
-		return varSingles[i].name < varSingles[j].name
-	})
-	sort.SliceStable(varBlocks, func(i, j int) bool {
-		return firstDeclName(varBlocks[i]) < firstDeclName(varBlocks[j])
-	})
-	sort.SliceStable(typeDecls, func(i, j int) bool {
-		return typeDecls[i].name < typeDecls[j].name
-	})
-	sort.SliceStable(funcs, func(i, j int) bool {
-		return funcs[i].Name.Name < funcs[j].Name.Name
-	})
-
-	for recv := range methods {
-		sort.SliceStable(methods[recv], func(i, j int) bool {
-			return methods[recv][i].Name.Name < methods[recv][j].Name.Name
-		})
+// readFileList reads a list of paths from the given file, or from stdin if
+// the name is "-". Paths are separated by newlines, or by NUL bytes if there
+// are any, e.g. from git's -z output. Only files that alphafmt formats are
+// kept, and entries for files which no longer exist, e.g. deletions listed by
+// git, are skipped.
+func readFileList(name string) ([]string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
 	}
-
-	buf := &bytes.Buffer{}
-	writeLeadingComments(buf, fset, file)
-	buf.WriteString("package ")
-	buf.WriteString(file.Name.Name)
-	buf.WriteByte('\n')
-
-	wrote := false
-	appendSection := func(section string) {
-		if section == "" {
-			return
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) != -1 {
+		sep = "\x00"
+	}
+	var paths []string
+	for path := range strings.SplitSeq(string(data), sep) {
+		path = strings.TrimRight(path, "\r")
+		if path == "" || !isFormattable(path) {
+			continue
 		}
-		if !wrote {
-			buf.WriteByte('\n')
-			wrote = true
-		} else {
-			buf.WriteString("\n\n")
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			continue
 		}
-		buf.WriteString(section)
+		paths = append(paths, path)
 	}
-
-	section := buildImportSection(fset, importDecls)
-	appendSection(section)
-
-	section = collectDeclStrings(fset, file.Comments, appendDeclItems(constBlocks, constSingles))
-	appendSection(section)
-
-	section = collectDeclStrings(fset, file.Comments, appendDeclItems(varBlocks, varSingles))
-	appendSection(section)
-
-	typeSection := buildTypeSection(fset, file.Comments, typeDecls, methods)
-	appendSection(typeSection)
-
-	section = collectFuncStrings(fset, file.Comments, funcs)
-	appendSection(section)
-
-	section = collectFuncStrings(fset, file.Comments, mainFuncs)
-	appendSection(section)
-
-	section = collectFuncStrings(fset, file.Comments, initFuncs)
-	appendSection(section)
-	return buf.Bytes()
+	return paths, nil
 }
 
-func receiverTypeName(fieldList *ast.FieldList) string {
-	if fieldList == nil || len(fieldList.List) == 0 {
-		return ""
-	}
-	return typeName(fieldList.List[0].Type)
+// usageErrorf prints the given error message to stderr, and exits with the
+// status for usage errors.
+func usageErrorf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	process.Exit(exitUsage)
 }
 
-func sortImportSpecs(specs []*ast.ImportSpec) {
-	sort.SliceStable(specs, func(i, j int) bool {
-		return importPath(specs[i]) < importPath(specs[j])
-	})
-}
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "hook":
+			runHookCommand(os.Args[2:])
+			return
+		case "selftest":
+			runSelftestCommand(os.Args[2:])
+			return
+		}
+	}
 
-func sortVarBlockSpecs(decl ast.Decl) {
-	gen, ok := decl.(*ast.GenDecl)
-	if !ok || gen.Tok != token.VAR || len(gen.Specs) == 0 {
-		return
+	flag.CommandLine = flag.NewFlagSet("alphafmt", flag.ExitOnError)
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = func() {
+		fmt.Println("Usage: alphafmt [flags] [path ...]")
+		fmt.Println("       alphafmt hook install")
+		fmt.Println("       alphafmt selftest [flags] [path ...]")
+		fmt.Println()
+		flag.PrintDefaults()
 	}
-	sort.SliceStable(gen.Specs, func(i, j int) bool {
-		return specFirstName(gen.Specs[i]) < specFirstName(gen.Specs[j])
-	})
-}
 
-func specFirstName(spec ast.Spec) string {
-	switch typed := spec.(type) {
-	case *ast.ValueSpec:
-		if len(typed.Names) == 0 {
-			return ""
-		}
-		return typed.Names[0].Name
-	case *ast.TypeSpec:
-		if typed.Name == nil {
-			return ""
-		}
-		return typed.Name.Name
+	backupSuffix := flag.String("backup", "", "save the originals of rewritten files alongside them, with the given `suffix`, e.g. .orig")
+	backupDir := flag.String("backup-dir", "", "save the originals of rewritten files within the given `dir`, mirroring their paths")
+	useCache := flag.Bool("cache", false, "skip files which a previous run found to already be formatted")
+	cacheDir := flag.String("cache-dir", "", "directory for the cache, defaults to alphafmt within the user cache directory")
+	check := flag.Bool("check", false, "list files whose formatting differs without writing anything, and exit with status 1 if there are any")
+	colorMode := flag.String("color", colorAuto, "colorize diffs: auto, i.e. only when writing to a terminal, always, or never")
+	diff := flag.Bool("d", false, "display diffs instead of rewriting files")
+	filesFrom := flag.String("files-from", "", "also format the paths listed in the given `file`, one per line, or read them from stdin if -")
+	fixImports := flag.Bool("fix-imports", false, "add missing imports and remove unused ones, like goimports")
+	followSymlinks := flag.Bool("follow-symlinks", false, "walk into symlinked directories, skipping any already visited via another path")
+	format := flag.String("format", formatText, "output format for results: text, json, or sarif")
+	gitignore := flag.Bool("gitignore", false, "skip files and directories ignored by git")
+	ignore := &ignoreRules{}
+	flag.Var(&ignore.patterns, "ignore", "skip files and directories matching the given glob `pattern` (can be repeated)")
+	includeGenerated := flag.Bool("include-generated", false, "also format files marked as generated with a \"Code generated ... DO NOT EDIT.\" comment")
+	keepCRLF := flag.Bool("keep-crlf", false, "keep CRLF line endings in files which mostly use them, instead of converting to LF")
+	list := flag.Bool("l", false, "list files whose formatting differs, and exit with status 1 if there are any, unless -w is set")
+	lsp := flag.Bool("lsp", false, "run as a language server over stdio, providing document formatting")
+	maxDepth := flag.Int("max-depth", 100, "skip directories nested more than `n` levels below the given paths, or 0 for no limit")
+	maxFileSize := flag.Int64("max-file-size", 16<<20, "report an error for files larger than the given number of `bytes`, or 0 for no limit")
+	maxFiles := flag.Int("max-files", 100000, "exit without formatting anything if more than `n` files are found, or 0 for no limit")
+	maxLen := flag.Int("maxlen", 0, "wrap function parameters, call arguments, and composite literals on lines longer than `n` columns, or 0 to not wrap")
+	minimal := flag.Bool("minimal", false, "only swap neighbouring declarations which are out of order, to keep diffs small")
+	overlayFile := flag.String("overlay", "", "read files from the replacements listed in the given JSON `file`, in the same format as go build -overlay")
+	since := flag.String("since", "", "only format files that have changed in git since the given ref")
+	staged := flag.Bool("staged", false, "only format files that are staged in git")
+	separator := flag.Bool("separator", false, "when writing formatted source to stdout, write a \"// file: path\" banner before each file")
+	showStat := flag.Bool("stat", false, "print how many declarations moved and lines changed in each file that differs, along with totals, instead of the formatted source")
+	stdinFilename := flag.String("stdin-filename", "", "`path` of the file being piped via stdin, used for its config and in errors")
+	strict := flag.Bool("strict", false, "apply stricter formatting rules on top of gofmt, similar to gofumpt")
+	summary := flag.Bool("summary", false, "print totals for files scanned, changed and errors, and the elapsed time, to stderr")
+	workers := flag.Int("p", runtime.GOMAXPROCS(0), "number of files to format in parallel")
+	verbose := flag.Bool("v", false, "log debug events, e.g. the files considered, skip reasons, cache hits, and per-file timings, to stderr")
+	verify := flag.Bool("verify", false, "format the output a second time, and report an error for any file where that changes it")
+	watch := flag.Bool("watch", false, "keep running and reformat files whenever they change (requires -w)")
+	write := flag.Bool("w", false, "write result to (source) file instead of stdout")
+	flag.Parse()
+	if *verbose {
+		enableDebugLogging()
+	}
+	ignore.git = *gitignore
+	if *maxDepth < 0 || *maxFileSize < 0 || *maxFiles < 0 {
+		usageErrorf("Cannot use negative values for -max-depth, -max-file-size, or -max-files")
+	}
+	switch *colorMode {
+	case colorAlways, colorAuto, colorNever:
 	default:
-		return ""
+		usageErrorf("Invalid value for -color: %q", *colorMode)
 	}
-}
-
-func splitTypeDecls(decl *ast.GenDecl) []declItem {
-	var items []declItem
-	for i, spec := range decl.Specs {
-		typeSpec, ok := spec.(*ast.TypeSpec)
-		if !ok {
-			continue
-		}
-		newTypeSpec := &ast.TypeSpec{
-			Assign:     typeSpec.Assign,
-			Comment:    typeSpec.Comment,
-			Name:       typeSpec.Name,
-			Type:       typeSpec.Type,
-			TypeParams: typeSpec.TypeParams,
-		}
-		newDecl := &ast.GenDecl{
-			Specs: []ast.Spec{newTypeSpec},
-			Tok:   token.TYPE,
-		}
-		if typeSpec.Doc != nil {
-			newDecl.Doc = typeSpec.Doc
-		} else if i == 0 && decl.Doc != nil {
-			newDecl.Doc = decl.Doc
-		}
-		items = append(items, declItem{
-			name: typeSpec.Name.Name,
-			decl: newDecl,
-		})
+	color := *diff && useColor(*colorMode)
+	if *maxLen < 0 {
+		usageErrorf("Cannot use a negative value for -maxlen")
 	}
-	return items
-}
-
-func splitValueDecls(decl *ast.GenDecl) (ast.Decl, []declItem) {
-	if decl.Lparen != token.NoPos {
-		return decl, nil
+	if *minimal && *verify {
+		usageErrorf("Cannot use -verify together with -minimal")
 	}
-
-	var singles []declItem
-	for i, spec := range decl.Specs {
-		valueSpec, ok := spec.(*ast.ValueSpec)
-		if !ok || len(valueSpec.Names) == 0 {
-			continue
+	var backup *fmtcmd.BackupConfig
+	if *backupDir != "" || *backupSuffix != "" {
+		if !*write {
+			usageErrorf("Cannot use -backup or -backup-dir without -w")
 		}
-		newValueSpec := &ast.ValueSpec{
-			Comment: valueSpec.Comment,
-			Names:   valueSpec.Names,
-			Type:    valueSpec.Type,
-			Values:  valueSpec.Values,
+		if *backupDir == "" && strings.ContainsAny(*backupSuffix, `/\`) {
+			usageErrorf("The -backup suffix cannot contain path separators")
 		}
-		newDecl := &ast.GenDecl{
-			Specs: []ast.Spec{newValueSpec},
-			Tok:   decl.Tok,
+		backup = &fmtcmd.BackupConfig{Dir: *backupDir, Suffix: *backupSuffix}
+	}
+	f := &formatter{
+		includeGenerated: *includeGenerated,
+		maxFileSize:      *maxFileSize,
+		opts:             &alphafmt.Options{FixImports: *fixImports, KeepCRLF: *keepCRLF, MaxLineLength: *maxLen, Minimal: *minimal, Strict: *strict},
+		verify:           *verify,
+	}
+	if *overlayFile != "" {
+		if *write {
+			usageErrorf("Cannot use -w together with -overlay")
 		}
-		if valueSpec.Doc != nil {
-			newDecl.Doc = valueSpec.Doc
-		} else if i == 0 && decl.Doc != nil {
-			newDecl.Doc = decl.Doc
+		o, err := loadOverlay(*overlayFile)
+		if err != nil {
+			fatalf("Failed to load overlay: %v", err)
 		}
-		singles = append(singles, declItem{
-			name: valueSpec.Names[0].Name,
-			decl: newDecl,
-		})
+		f.overlay = o
 	}
-	return nil, singles
-}
-
-func typeName(expr ast.Expr) string {
-	switch node := expr.(type) {
-	case *ast.Ident:
-		return node.Name
-	case *ast.StarExpr:
-		return typeName(node.X)
-	case *ast.IndexExpr:
-		return typeName(node.X)
-	case *ast.IndexListExpr:
-		return typeName(node.X)
-	case *ast.SelectorExpr:
-		return node.Sel.Name
-	default:
-		return ""
+	if *useCache {
+		c, err := openCache(*cacheDir)
+		if err != nil {
+			fatalf("Failed to open cache: %v", err)
+		}
+		f.cache = c
 	}
-}
 
-func writeImportSpecs(buf *bytes.Buffer, fset *token.FileSet, specs []*ast.ImportSpec) {
-	for _, spec := range specs {
-		formatted := formatImportSpec(fset, spec)
-		if formatted == "" {
-			continue
+	walk := &walkOptions{
+		follow:   *followSymlinks,
+		ignore:   ignore,
+		maxDepth: *maxDepth,
+		maxFiles: *maxFiles,
+		overlay:  f.overlay,
+	}
+	paths := flag.Args()
+	if *lsp {
+		if len(paths) > 0 {
+			usageErrorf("Cannot specify paths when using -lsp")
 		}
-		lines := strings.Split(formatted, "\n")
-		for _, line := range lines {
-			if line != "" {
-				buf.WriteByte('\t')
-				buf.WriteString(line)
-			}
-			buf.WriteByte('\n')
+		if *overlayFile != "" {
+			usageErrorf("Cannot use -overlay together with -lsp")
 		}
-	}
-}
-
-func writeLeadingComments(buf *bytes.Buffer, fset *token.FileSet, file *ast.File) {
-	var leading []*ast.CommentGroup
-	for _, comment := range file.Comments {
-		if comment.End() >= file.Name.Pos() {
-			break
+		srv := &lspServer{docs: map[string]string{}, opts: f.opts, out: os.Stdout}
+		status, err := srv.serve(os.Stdin)
+		if err != nil && err != io.EOF {
+			fatalf("Failed to serve LSP requests: %v", err)
 		}
-		leading = append(leading, comment)
+		process.Exit(status)
 	}
-	for i, comment := range leading {
-		for _, line := range comment.List {
-			buf.WriteString(line.Text)
-			buf.WriteByte('\n')
+	if *watch {
+		if len(paths) == 0 {
+			usageErrorf("Must specify paths when using -watch")
 		}
-		nextLine := fset.Position(file.Name.Pos()).Line
-		if i+1 < len(leading) {
-			nextLine = fset.Position(leading[i+1].Pos()).Line
+		if !*write {
+			usageErrorf("Must use -w when using -watch")
 		}
-		endLine := fset.Position(comment.End()).Line
-		if nextLine > endLine+1 {
-			buf.WriteByte('\n')
+		if *check || *list || *showStat || *format != formatText {
+			usageErrorf("Cannot use -check, -format, -l, or -stat together with -watch")
 		}
+		w := &watcher{backup: backup, formatter: f, paths: paths, walk: walk}
+		w.run()
 	}
-}
 
-func main() {
-	flag.CommandLine = flag.NewFlagSet("alphafmt", flag.ExitOnError)
-	flag.CommandLine.SetOutput(os.Stdout)
-	flag.Usage = func() {
-		fmt.Println("Usage: alphafmt [flags] [path ...]")
-		flag.PrintDefaults()
+	fromList := *filesFrom != ""
+	if fromList {
+		list, err := readFileList(*filesFrom)
+		if err != nil {
+			fatalf("Failed to read file list from %q: %v", *filesFrom, err)
+		}
+		paths = append(paths, list...)
 	}
 
-	list := flag.Bool("l", false, "list files whose formatting differs")
-	write := flag.Bool("w", false, "write result to (source) file instead of stdout")
-	flag.Parse()
-
 	stat, err := os.Stdin.Stat()
 	if err != nil {
-		obs.Fatalf("Failed to stat stdin: %v", err)
+		fatalf("Failed to stat stdin: %v", err)
 	}
-
-	paths := flag.Args()
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
+	if !fromList && (stat.Mode()&os.ModeCharDevice) == 0 {
 		if len(paths) > 0 {
-			obs.Fatalf("Cannot specify paths when piping via stdin")
+			usageErrorf("Cannot specify paths when piping via stdin")
+		}
+		if *check {
+			usageErrorf("Cannot use -check when piping via stdin")
+		}
+		if *format != formatText {
+			usageErrorf("Cannot use -format when piping via stdin")
 		}
 		if *list {
-			obs.Fatalf("Cannot use -l when piping via stdin")
+			usageErrorf("Cannot use -l when piping via stdin")
+		}
+		if *overlayFile != "" {
+			usageErrorf("Cannot use -overlay when piping via stdin")
+		}
+		if *separator {
+			usageErrorf("Cannot use -separator when piping via stdin")
+		}
+		if *showStat {
+			usageErrorf("Cannot use -stat when piping via stdin")
 		}
 		if *write {
-			obs.Fatalf("Cannot use -w when piping via stdin")
+			usageErrorf("Cannot use -w when piping via stdin")
 		}
-		formatStdin()
+		formatStdin(*stdinFilename, f.opts, *diff, color, f.maxFileSize)
 		return
 	}
+	if *stdinFilename != "" {
+		usageErrorf("Cannot use -stdin-filename unless piping via stdin")
+	}
 
-	if len(paths) == 0 {
-		flag.Usage()
-		process.Exit(0)
+	gitFilter := *staged || *since != ""
+	if len(paths) == 0 && !fromList {
+		if !gitFilter {
+			flag.Usage()
+			process.Exit(0)
+		}
+		paths = []string{"."}
+	}
+	if *staged && *since != "" {
+		usageErrorf("Cannot use -staged together with -since")
+	}
+	if *workers < 1 {
+		usageErrorf("The -p flag must be at least 1")
+	}
+	if *check && *write {
+		usageErrorf("Cannot use -check together with -w")
+	}
+	structured := false
+	switch *format {
+	case formatJSON, formatSARIF:
+		structured = true
+	case formatText:
+	default:
+		usageErrorf("Invalid value for -format: %q", *format)
+	}
+	if structured && *list && *format != formatJSON {
+		usageErrorf("Cannot use -l together with -format %s", *format)
+	}
+	if structured && *diff {
+		usageErrorf("Cannot use -d together with -format %s", *format)
+	}
+	if structured && *showStat {
+		usageErrorf("Cannot use -stat together with -format %s", *format)
+	}
+	if *diff && *showStat {
+		usageErrorf("Cannot use -d together with -stat")
+	}
+	// The formatted source is only written to stdout if nothing else is.
+	toStdout := !*check && !*diff && !*list && !*showStat && !*write && !structured
+	if *separator && !toStdout {
+		usageErrorf("Cannot use -separator together with -check, -d, -format, -l, -stat, or -w")
+	}
+	// With -l, the JSON format streams a record for each file as it's
+	// formatted, instead of writing a report at the end.
+	records := *list && *format == formatJSON
+	if records {
+		structured = false
+	}
+	// Changed files are listed before they're written, so that -l -w reports
+	// the files that it rewrites, like gofmt.
+	listPaths := (*check || *list) && !*diff && !*showStat && !records && !structured
+	emitRecord := func(rec *fileRecord) {
+		if err := writeRecord(os.Stdout, rec); err != nil {
+			fatalf("Failed to write to stdout: %v", err)
+		}
 	}
 
-	files := collectGoFiles(paths)
-	for _, path := range files {
-		changed, out := formatFile(path)
-		if *list {
-			if changed {
-				fmt.Println(path)
+	start := time.Now()
+	rep := &report{}
+	files, errs := collectGoFiles(paths, walk)
+	if slices.ContainsFunc(errs, isTooManyFiles) {
+		printErrors(errs)
+		process.Exit(exitError)
+	}
+	if gitFilter {
+		changed, err := gitChangedFiles(*staged, *since)
+		if err != nil {
+			fatalf("Failed to get changed files from git: %v", err)
+		}
+		files = slices.DeleteFunc(files, func(path string) bool {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return true
 			}
-			continue
+			_, ok := changed[abs]
+			if !ok {
+				logger.Debug("skipping file", "path", path, "reason", "unchanged in git")
+			}
+			return !ok
+		})
+	}
+	// Files are written to stdout in sorted path order, so that the output
+	// doesn't depend on how the paths were given or walked.
+	if toStdout {
+		slices.Sort(files)
+	}
+	for _, err := range errs {
+		rep.addError("", err)
+		if records {
+			emitRecord(&fileRecord{Error: err.Error(), Path: errorPath("", err)})
+		}
+	}
+	changedFiles := 0
+	var stats []*diffStat
+	f.formatFiles(files, *workers, func(path string, res *fileResult) {
+		if res.err != nil {
+			errs = append(errs, res.err)
+			rep.addError(path, res.err)
+			if records {
+				emitRecord(&fileRecord{Error: res.err.Error(), Path: path})
+			}
+			return
+		}
+		if res.changed {
+			changedFiles++
+		}
+		if structured {
+			rep.addFile(path, res.changed)
+		}
+		if records {
+			rec := &fileRecord{
+				BytesAfter:  len(res.out),
+				BytesBefore: len(res.out),
+				Changed:     res.changed,
+				Path:        path,
+			}
+			if res.changed {
+				info, err := f.overlay.stat(path)
+				if err != nil {
+					errs = append(errs, err)
+					rec.Error = err.Error()
+				} else {
+					rec.BytesBefore = int(info.Size())
+				}
+			}
+			emitRecord(rec)
+		}
+		if *showStat && res.changed {
+			src, err := f.overlay.readFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				rep.addError(path, err)
+				return
+			}
+			stats = append(stats, newDiffStat(path, src, res.out))
+		}
+		if *diff && res.changed {
+			src, err := f.overlay.readFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				rep.addError(path, err)
+				return
+			}
+			out := fmtcmd.UnifiedDiff(path, src, res.out)
+			if color {
+				out = fmtcmd.ColorizeDiff(out)
+			}
+			if _, err := os.Stdout.Write(out); err != nil {
+				fatalf("Failed to write to stdout: %v", err)
+			}
+		}
+		if listPaths && res.changed {
+			fmt.Println(path)
 		}
 		if *write {
-			if changed {
-				if err := os.WriteFile(path, out, 0o644); err != nil {
-					obs.Fatalf("Failed to write output to %q: %v", path, err)
+			if res.changed {
+				if err := fmtcmd.WriteFile(path, res.out, backup); err != nil {
+					errs = append(errs, err)
+					rep.addError(path, err)
 				}
 			}
-		} else if _, err := os.Stdout.Write(out); err != nil {
-			obs.Fatalf("Failed to write to stdout: %v", err)
+			return
+		}
+		if !toStdout {
+			return
+		}
+		if *separator {
+			if _, err := fmt.Printf("// file: %s\n", path); err != nil {
+				fatalf("Failed to write to stdout: %v", err)
+			}
+		}
+		if _, err := os.Stdout.Write(res.out); err != nil {
+			fatalf("Failed to write to stdout: %v", err)
 		}
+	})
+	if *showStat {
+		if err := writeDiffStats(os.Stdout, stats); err != nil {
+			fatalf("Failed to write to stdout: %v", err)
+		}
+	}
+	if structured {
+		if err := rep.write(os.Stdout, *format); err != nil {
+			fatalf("Failed to write to stdout: %v", err)
+		}
+	} else if len(errs) > 0 && !records {
+		printErrors(errs)
+	}
+	if *summary {
+		verb := "changed"
+		if !*write {
+			verb = "need formatting"
+		}
+		fmt.Fprintf(os.Stderr, "Scanned %s, %d %s, %s, in %s\n",
+			plural(len(files), "file"), changedFiles, verb, plural(len(errs), "error"),
+			time.Since(start).Round(time.Millisecond))
+	}
+	if len(errs) > 0 {
+		process.Exit(exitError)
+	}
+	// With -w, the changed files have been rewritten, so none of them still
+	// need formatting.
+	if (*check || *list) && changedFiles > 0 && !*write {
+		process.Exit(exitChanged)
 	}
 }
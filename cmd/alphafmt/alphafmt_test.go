@@ -0,0 +1,317 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	formattedSrc   = "package a\n\nfunc a() {}\n\nfunc b() {}\n"
+	unformattedSrc = "package a\n\nfunc b() {}\n\nfunc a() {}\n"
+)
+
+// TestMain runs alphafmt instead of the tests when ALPHAFMT_RUN_MAIN is set,
+// so that tests can check its output and exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("ALPHAFMT_RUN_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestCache(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		files   map[string]string
+		sibling string
+	}{
+		{
+			name: "order struct literals",
+			files: map[string]string{
+				".alphafmt": "order struct literals = true\n",
+				"a.go":      "package a\n\nvar x = T{A: 1, B: 2}\n",
+				"b.go":      "package a\n\ntype T struct {\n\tA, B int\n}\n",
+			},
+			sibling: "package a\n\ntype T struct {\n\tB, A int\n}\n",
+		},
+		{
+			name: "fix imports",
+			args: []string{"-fix-imports"},
+			files: map[string]string{
+				"a.go": "package a\n\nfunc f() string {\n\treturn strings.ToUpper(\"a\")\n}\n",
+				"b.go": "package a\n\nvar strings struct{ ToUpper func(string) string }\n",
+			},
+			sibling: "package a\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.files["go.mod"] = "module example.com/a\n"
+			writeFiles(t, dir, tt.files)
+			args := append([]string{"-cache", "-cache-dir", t.TempDir(), "-l"}, tt.args...)
+			args = append(args, ".")
+			if stdout, status := runMain(t, dir, args...); status != 0 || stdout != "" {
+				t.Fatalf("unexpected result before editing b.go: got status %d and output %q", status, stdout)
+			}
+			// Changing the sibling file changes how a.go is formatted, so the
+			// cached result for it mustn't be used.
+			writeFiles(t, dir, map[string]string{"b.go": tt.sibling})
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(filepath.Join(dir, "b.go"), later, later); err != nil {
+				t.Fatal(err)
+			}
+			if stdout, status := runMain(t, dir, args...); status != exitChanged || stdout != "a.go\n" {
+				t.Errorf("unexpected result after editing b.go: got status %d and output %q", status, stdout)
+			}
+		})
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		args   []string
+		files  map[string]string
+		status int
+		stdout string
+		after  map[string]string
+	}{
+		{
+			name:   "list",
+			args:   []string{"-l", "."},
+			files:  map[string]string{"a.go": unformattedSrc, "b.go": formattedSrc},
+			status: exitChanged,
+			stdout: "a.go\n",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "list when formatted",
+			args:   []string{"-l", "."},
+			files:  map[string]string{"a.go": formattedSrc},
+			status: 0,
+			stdout: "",
+		},
+		{
+			name:   "check",
+			args:   []string{"-check", "a.go"},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitChanged,
+			stdout: "a.go\n",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "write",
+			args:   []string{"-w", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: 0,
+			stdout: "",
+			after:  map[string]string{"a.go": formattedSrc},
+		},
+		{
+			name:   "write with backup",
+			args:   []string{"-w", "-backup", ".orig", "a.go"},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: 0,
+			stdout: "",
+			after:  map[string]string{"a.go": formattedSrc, "a.go.orig": unformattedSrc},
+		},
+		{
+			name:   "diff",
+			args:   []string{"-d", "a.go"},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: 0,
+			stdout: "diff a.go.orig a.go\n--- a.go.orig\n+++ a.go\n@@ -1,5 +1,5 @@\n package a\n \n-func b() {}\n-\n func a() {}\n+\n+func b() {}\n",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "stat",
+			args:   []string{"-stat", "a.go"},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: 0,
+			stdout: "a.go | 1 declaration moved, 4 lines changed (+2 -2)\n1 file changed, 1 declaration moved, 4 lines changed (+2 -2)\n",
+		},
+		{
+			name:   "list as json",
+			args:   []string{"-l", "-format", "json", "a.go"},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitChanged,
+			stdout: `{"bytesAfter":36,"bytesBefore":36,"changed":true,"path":"a.go"}` + "\n",
+		},
+		{
+			name:   "files from",
+			args:   []string{"-l", "-files-from", "files.txt"},
+			files:  map[string]string{"a.go": unformattedSrc, "b.go": unformattedSrc, "files.txt": "b.go\n"},
+			status: exitChanged,
+			stdout: "b.go\n",
+		},
+		{
+			name:   "ignore",
+			args:   []string{"-l", "-ignore", "a.go", "."},
+			files:  map[string]string{"a.go": unformattedSrc, "b.go": formattedSrc},
+			status: 0,
+			stdout: "",
+		},
+		{
+			name:   "parse error",
+			args:   []string{"-l", "."},
+			files:  map[string]string{"a.go": "package a\n\nfunc (\n"},
+			status: exitError,
+			stdout: "",
+		},
+		{
+			name:   "missing file",
+			args:   []string{"-l", "missing.go"},
+			status: exitError,
+			stdout: "",
+		},
+		{
+			name:   "too many files",
+			args:   []string{"-w", "-max-files", "1", "."},
+			files:  map[string]string{"a.go": unformattedSrc, "b.go": unformattedSrc},
+			status: exitError,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc, "b.go": unformattedSrc},
+		},
+		{
+			name:   "invalid format",
+			args:   []string{"-format", "xml", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "invalid parallelism",
+			args:   []string{"-p", "0", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "staged and since",
+			args:   []string{"-staged", "-since", "HEAD", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "backup without write",
+			args:   []string{"-backup", ".orig", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "verify and minimal",
+			args:   []string{"-verify", "-minimal", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "separator and list",
+			args:   []string{"-separator", "-l", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+		{
+			name:   "list and write",
+			args:   []string{"-l", "-w", "."},
+			files:  map[string]string{"a.go": unformattedSrc, "b.go": formattedSrc},
+			status: 0,
+			stdout: "a.go\n",
+			after:  map[string]string{"a.go": formattedSrc, "b.go": formattedSrc},
+		},
+		{
+			name:   "list and write when formatted",
+			args:   []string{"-l", "-w", "."},
+			files:  map[string]string{"a.go": formattedSrc},
+			status: 0,
+			stdout: "",
+		},
+		{
+			name:   "check and write",
+			args:   []string{"-check", "-w", "."},
+			files:  map[string]string{"a.go": unformattedSrc},
+			status: exitUsage,
+			stdout: "",
+			after:  map[string]string{"a.go": unformattedSrc},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			stdout, status := runMain(t, dir, tt.args...)
+			if status != tt.status {
+				t.Errorf("unexpected exit status: got %d, want %d", status, tt.status)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("unexpected output: got %q, want %q", stdout, tt.stdout)
+			}
+			for name, want := range tt.after {
+				got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("unexpected contents of %s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// runMain runs alphafmt with the given args within dir, by running the test
+// binary with ALPHAFMT_RUN_MAIN set, and returns its stdout and exit status.
+func runMain(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("failed to find the test binary: %v", err)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "ALPHAFMT_RUN_MAIN=1")
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	err = cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("failed to run alphafmt %q: %v", args, err)
+	}
+	return stdout.String(), 0
+}
+
+// writeFiles writes the given files, keyed by their slash-separated paths,
+// within dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"espra.dev/pkg/alphafmt"
+)
+
+// cache records the hashes of file contents which are known to already be
+// formatted, so that repeated runs can skip them. Keys also cover the alphafmt
+// binary and the config in effect, so that entries are invalidated whenever
+// either changes.
+type cache struct {
+	dir  string
+	salt string
+}
+
+// add records that the given key is for formatted content. Failures are
+// ignored, as the cache is only an optimization.
+func (c *cache) add(key string) {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return
+	}
+	os.WriteFile(path, nil, 0o644)
+}
+
+// has reports whether the given key is for content known to be formatted.
+func (c *cache) has(key string) bool {
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+// key returns the cache key for the given content of the file at path, when
+// formatted with the given options. The key is empty if the output depends on
+// other packages, in which case the file mustn't be cached.
+func (c *cache) key(path string, opts *alphafmt.Options, src []byte) (string, error) {
+	cfg, err := alphafmt.ConfigKey(path, opts)
+	if err != nil || cfg == "" {
+		return "", err
+	}
+	h := sha256.New()
+	io.WriteString(h, c.salt)
+	h.Write([]byte{0})
+	io.WriteString(h, cfg)
+	h.Write([]byte{0})
+	fmt.Fprintf(h, "%+v", *opts)
+	h.Write([]byte{0})
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key[2:])
+}
+
+// openCache returns the cache within the user's cache directory, or within
+// the given directory if it is not empty.
+func openCache(dir string) (*cache, error) {
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(base, "alphafmt")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	// Hash the running binary rather than relying on its version, so that
+	// development builds never reuse stale entries.
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return &cache{dir: dir, salt: hex.EncodeToString(h.Sum(nil))}, nil
+}
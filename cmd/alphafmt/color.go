@@ -0,0 +1,33 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"os"
+)
+
+// Supported values for the -color flag.
+const (
+	colorAlways = "always"
+	colorAuto   = "auto"
+	colorNever  = "never"
+)
+
+// useColor reports whether diffs written to stdout should be colorized for the
+// given -color mode. In auto mode, they are only colorized if stdout is a
+// terminal, and colors haven't been disabled via the NO_COLOR environment
+// variable or a dumb terminal.
+func useColor(mode string) bool {
+	switch mode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,110 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitPathspecs match the files which alphafmt formats.
+var gitPathspecs = []string{"*.go", "go.mod", "*/go.mod", "go.work", "*/go.work"}
+
+// gitChangedFiles returns the absolute paths of the files that git reports
+// as changed. If staged is true, only files staged in the index are returned.
+// Otherwise, files that differ from the given ref in the working tree are
+// returned, along with any untracked files.
+func gitChangedFiles(staged bool, since string) (map[string]struct{}, error) {
+	root, err := runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	if staged {
+		out, err = runGitLines(append([]string{"diff", "--cached", "--name-only", "--diff-filter=ACMR", "-z", "--"}, gitPathspecs...)...)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		out, err = runGitLines(append([]string{"diff", "--name-only", "--diff-filter=ACMR", "-z", since, "--"}, gitPathspecs...)...)
+		if err != nil {
+			return nil, err
+		}
+		untracked, err := runGitLines(append([]string{"ls-files", "--others", "--exclude-standard", "--full-name", "-z", "--"}, gitPathspecs...)...)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, untracked...)
+	}
+	files := map[string]struct{}{}
+	for _, path := range out {
+		files[filepath.Join(root, filepath.FromSlash(path))] = struct{}{}
+	}
+	return files, nil
+}
+
+// gitIgnored returns the subset of the given paths which are ignored by git,
+// e.g. via .gitignore files. Tracked files are never considered ignored.
+func gitIgnored(paths []string) (map[string]struct{}, error) {
+	ignored := map[string]struct{}{}
+	if len(paths) == 0 {
+		return ignored, nil
+	}
+	cmd := exec.Command("git", "check-ignore", "-z", "--stdin")
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// An exit status of 1 means that none of the paths are ignored.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return ignored, nil
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git check-ignore: %s", msg)
+		}
+		return nil, fmt.Errorf("git check-ignore: %w", err)
+	}
+	for path := range strings.SplitSeq(string(out), "\x00") {
+		if path != "" {
+			ignored[path] = struct{}{}
+		}
+	}
+	return ignored, nil
+}
+
+func runGit(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runGitLines runs a git command with NUL-separated output, and returns the
+// individual entries.
+func runGitLines(args ...string) ([]string, error) {
+	out, err := runGit(args...)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for line := range strings.SplitSeq(out, "\x00") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
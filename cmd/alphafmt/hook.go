@@ -0,0 +1,118 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hookMarker identifies pre-commit hooks installed by alphafmt.
+const hookMarker = "# Installed by alphafmt hook install."
+
+const hookScript = `#!/bin/sh
+` + hookMarker + `
+
+hooks=$(dirname "$0")
+if [ -x "$hooks/pre-commit.local" ]; then
+	"$hooks/pre-commit.local" "$@" || exit $?
+fi
+
+exec alphafmt -check -staged
+`
+
+const lefthookConfig = `pre-commit:
+  commands:
+    alphafmt:
+      glob: "*.{go,mod,work}"
+      run: alphafmt -check -staged
+`
+
+const preCommitConfig = `- repo: local
+  hooks:
+    - id: alphafmt
+      name: alphafmt
+      entry: alphafmt -check -staged
+      language: system
+      files: '\.go$|(^|/)go\.(mod|work)$'
+      pass_filenames: false
+`
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// installHook writes a git pre-commit hook which checks the formatting of
+// staged Go files. Any existing hook is kept, and run before the check.
+func installHook() error {
+	root, err := runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	// Hook managers overwrite .git/hooks themselves, so point users at their
+	// config instead.
+	for _, name := range []string{"lefthook.yml", ".lefthook.yml"} {
+		if exists(filepath.Join(root, name)) {
+			fmt.Printf("Found %s, so add the following to it instead of installing a git hook:\n\n%s", name, lefthookConfig)
+			return nil
+		}
+	}
+	if exists(filepath.Join(root, ".pre-commit-config.yaml")) {
+		fmt.Printf("Found .pre-commit-config.yaml, so add the following to its repos instead of installing a git hook:\n\n%s", preCommitConfig)
+		return nil
+	}
+	dir, err := runGit("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(dir) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(cwd, dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "pre-commit")
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if strings.Contains(string(existing), hookMarker) {
+			fmt.Printf("The alphafmt pre-commit hook is already installed at %s\n", path)
+			return nil
+		}
+		local := filepath.Join(dir, "pre-commit.local")
+		if exists(local) {
+			return fmt.Errorf("cannot chain the existing pre-commit hook, as %s already exists", local)
+		}
+		if err := os.Rename(path, local); err != nil {
+			return err
+		}
+		fmt.Printf("Moved the existing pre-commit hook to %s, and chained it\n", local)
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := os.WriteFile(path, []byte(hookScript), 0o755); err != nil {
+		return err
+	}
+	fmt.Printf("Installed the alphafmt pre-commit hook at %s\n", path)
+	return nil
+}
+
+// runHookCommand handles the hook subcommand.
+func runHookCommand(args []string) {
+	if len(args) != 1 || args[0] != "install" {
+		usageErrorf("Usage: alphafmt hook install")
+	}
+	if err := installHook(); err != nil {
+		fatalf("Failed to install pre-commit hook: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logger receives debug events, e.g. the files considered, why any were
+// skipped, cache hits, and how long each file took to format. It discards
+// everything unless -v is set.
+var logger = slog.New(slog.DiscardHandler)
+
+// enableDebugLogging writes debug events to stderr as structured key=value
+// lines.
+func enableDebugLogging() {
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
@@ -0,0 +1,292 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"espra.dev/cmd/internal/fmtcmd"
+	"espra.dev/pkg/alphafmt"
+)
+
+// JSON-RPC and LSP error codes.
+const (
+	errParse          = -32700
+	errInvalidRequest = -32600
+	errMethodNotFound = -32601
+	errRequestFailed  = -32803
+)
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspMessage struct {
+	Error   *lspError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+}
+
+type lspPosition struct {
+	Character int `json:"character"`
+	Line      int `json:"line"`
+}
+
+type lspRange struct {
+	End   lspPosition `json:"end"`
+	Start lspPosition `json:"start"`
+}
+
+// lspServer implements the subset of the Language Server Protocol needed for
+// document formatting. Documents are synced in full, so that unsaved buffer
+// contents are formatted rather than the files on disk.
+type lspServer struct {
+	docs     map[string]string
+	opts     *alphafmt.Options
+	out      io.Writer
+	shutdown bool
+}
+
+func (s *lspServer) handle(msg *lspMessage) (any, *lspError) {
+	if s.shutdown {
+		// Once shut down, requests fail and notifications are ignored until
+		// the client sends exit.
+		if msg.ID == nil {
+			return nil, nil
+		}
+		return nil, &lspError{Code: errInvalidRequest, Message: "server is shut down"}
+	}
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"documentFormattingProvider": true,
+				"textDocumentSync": map[string]any{
+					"change":    1, // full document sync
+					"openClose": true,
+				},
+			},
+			"serverInfo": map[string]any{"name": "alphafmt"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didChange":
+		var params struct {
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: errInvalidRequest, Message: err.Error()}
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: errInvalidRequest, Message: err.Error()}
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				Text string `json:"text"`
+				URI  string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: errInvalidRequest, Message: err.Error()}
+		}
+		s.docs[params.TextDocument.URI] = params.TextDocument.Text
+		return nil, nil
+	case "textDocument/formatting":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{Code: errInvalidRequest, Message: err.Error()}
+		}
+		uri := params.TextDocument.URI
+		src, ok := s.docs[uri]
+		if !ok {
+			return nil, &lspError{Code: errRequestFailed, Message: fmt.Sprintf("document %q is not open", uri)}
+		}
+		// Keep the document's line endings, so that editors on Windows
+		// don't get an edit for every line.
+		opts := alphafmt.Options{}
+		if s.opts != nil {
+			opts = *s.opts
+		}
+		opts.KeepCRLF = true
+		formatted, err := alphafmt.FormatWithOptions(uriFilename(uri), []byte(src), &opts)
+		if err != nil {
+			return nil, &lspError{Code: errRequestFailed, Message: err.Error()}
+		}
+		return lineEdits(src, string(formatted)), nil
+	}
+	if msg.ID == nil {
+		// Unknown notifications, e.g. initialized or $/cancelRequest, are
+		// ignored.
+		return nil, nil
+	}
+	return nil, &lspError{Code: errMethodNotFound, Message: fmt.Sprintf("method %q is not supported", msg.Method)}
+}
+
+// serve reads messages from r until the client sends the exit notification,
+// and returns the process exit status. Messages that aren't valid JSON get a
+// parse error response, as the messages after them can still be read.
+func (s *lspServer) serve(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	for {
+		data, err := readLSPContent(br)
+		if err != nil {
+			return 1, err
+		}
+		msg := &lspMessage{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			rpcErr := &lspError{Code: errParse, Message: fmt.Sprintf("invalid message: %v", err)}
+			if err := s.write(&lspMessage{Error: rpcErr, ID: json.RawMessage("null"), JSONRPC: "2.0"}); err != nil {
+				return 1, err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0, nil
+			}
+			return 1, nil
+		}
+		result, rpcErr := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		resp := &lspMessage{Error: rpcErr, ID: msg.ID, JSONRPC: "2.0"}
+		if rpcErr == nil {
+			resp.Result = result
+			if result == nil {
+				resp.Result = json.RawMessage("null")
+			}
+		}
+		if err := s.write(resp); err != nil {
+			return 1, err
+		}
+	}
+}
+
+func (s *lspServer) write(msg *lspMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+type lspTextEdit struct {
+	NewText string   `json:"newText"`
+	Range   lspRange `json:"range"`
+}
+
+// isDriveLetter reports whether c is an ASCII letter, as used for Windows
+// drive letters.
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// lineEdits returns the text edits needed to turn src into dst. Edits always
+// span whole lines, so that only changed regions of the document are touched.
+func lineEdits(src string, dst string) []lspTextEdit {
+	lines := fmtcmd.SplitLines(src)
+	// Positions are on line boundaries, except for the end of a document
+	// which lacks a trailing newline.
+	pos := func(line int) lspPosition {
+		if line == len(lines) && line > 0 && !strings.HasSuffix(lines[line-1], "\n") {
+			return lspPosition{Character: len(utf16.Encode([]rune(lines[line-1]))), Line: line - 1}
+		}
+		return lspPosition{Line: line}
+	}
+	b := fmtcmd.SplitLines(dst)
+	edits := []lspTextEdit{}
+	for _, change := range fmtcmd.DiffLines(lines, b) {
+		edits = append(edits, lspTextEdit{
+			NewText: strings.Join(b[change.B0:change.B1], ""),
+			Range:   lspRange{End: pos(change.A1), Start: pos(change.A0)},
+		})
+	}
+	return edits
+}
+
+// readLSPContent reads the next message from r, and returns its content
+// without the headers.
+func readLSPContent(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("lsp: invalid header line %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("lsp: invalid Content-Length header %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("lsp: missing Content-Length header")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// uriFilename returns the filesystem path for the given document URI, which is
+// used to find the relevant config file. Paths with a drive letter, e.g. from
+// file:///C:/x.go, lose the leading slash.
+func uriFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "stdin"
+	}
+	path := u.Path
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' && isDriveLetter(path[1]) {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
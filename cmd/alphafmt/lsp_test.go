@@ -0,0 +1,146 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"espra.dev/pkg/alphafmt"
+)
+
+func TestLSPServe(t *testing.T) {
+	uri := "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "main.go"))
+	src := "package main\n\nfunc main() {\n\n\tprintln()\n}\n"
+	open, _ := json.Marshal(map[string]any{"textDocument": map[string]string{"text": src, "uri": uri}})
+	in := &bytes.Buffer{}
+	for _, msg := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":` + string(open) + `}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/formatting","params":{"textDocument":{"uri":"` + uri + `"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","id":5,"method":"textDocument/formatting","params":{"textDocument":{"uri":"` + uri + `"}}}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	out := &bytes.Buffer{}
+	srv := &lspServer{docs: map[string]string{}, opts: &alphafmt.Options{Strict: true}, out: out}
+	status, err := srv.serve(in)
+	if err != nil || status != 0 {
+		t.Fatalf("unexpected result from serve: got status %d and error %v", status, err)
+	}
+	var resps []*lspMessage
+	r := bufio.NewReader(out)
+	for r.Buffered() > 0 || out.Len() > 0 {
+		data, err := readLSPContent(r)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		resp := &lspMessage{}
+		if err := json.Unmarshal(data, resp); err != nil {
+			t.Fatalf("failed to decode response %s: %v", data, err)
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) != 5 {
+		t.Fatalf("unexpected number of responses: got %d, want 5", len(resps))
+	}
+	// Malformed messages get a parse error, and later messages are still
+	// handled.
+	if resp := resps[1]; resp.Error == nil || resp.Error.Code != errParse || string(resp.ID) != "null" {
+		t.Errorf("unexpected response to a malformed message: got error %+v with id %s", resp.Error, resp.ID)
+	}
+	// The formatting options given on the command line are used, e.g. the
+	// blank line at the start of the func is removed by -strict.
+	edits, _ := json.Marshal(resps[2].Result)
+	want := `[{"newText":"","range":{"end":{"character":0,"line":4},"start":{"character":0,"line":3}}}]`
+	if string(resps[2].ID) != "3" || string(edits) != want {
+		t.Errorf("unexpected formatting response %s: got %s, want %s", resps[2].ID, edits, want)
+	}
+	// Requests after shutdown are rejected.
+	if resp := resps[4]; resp.Error == nil || resp.Error.Code != errInvalidRequest || string(resp.ID) != "5" {
+		t.Errorf("unexpected response to a request after shutdown: got error %+v with id %s", resp.Error, resp.ID)
+	}
+}
+
+func TestLineEdits(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		dst  string
+		want []lspTextEdit
+	}{
+		{"a\nb\n", "a\nb\n", []lspTextEdit{}},
+		{"a\nb\nc\n", "a\nB\nc\n", []lspTextEdit{
+			{NewText: "B\n", Range: lspRange{End: lspPosition{Line: 2}, Start: lspPosition{Line: 1}}},
+		}},
+		{"a\nb\n", "a\nb\nc\n", []lspTextEdit{
+			{NewText: "c\n", Range: lspRange{End: lspPosition{Line: 2}, Start: lspPosition{Line: 2}}},
+		}},
+		{"a\nb\nc\nd\n", "b\nc\nD\n", []lspTextEdit{
+			{NewText: "", Range: lspRange{End: lspPosition{Line: 1}, Start: lspPosition{Line: 0}}},
+			{NewText: "D\n", Range: lspRange{End: lspPosition{Line: 4}, Start: lspPosition{Line: 3}}},
+		}},
+		// The end of a document without a trailing newline is given in UTF-16
+		// code units.
+		{"a\n\"🙂\"", "a\n\"🙂\"\n", []lspTextEdit{
+			{NewText: "\"🙂\"\n", Range: lspRange{End: lspPosition{Character: 4, Line: 1}, Start: lspPosition{Line: 1}}},
+		}},
+	} {
+		if got := lineEdits(tt.src, tt.dst); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("unexpected edits for %q -> %q: got %+v, want %+v", tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestReadLSPContent(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{"Content-Length: 2\r\n\r\n{}", "{}", ""},
+		{"content-length:  2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}", "{}", ""},
+		{"Content-Length: 2\n\n{}", "{}", ""},
+		{"Content-Type: text/plain\r\n\r\n{}", "", "lsp: missing Content-Length header"},
+		{"Content-Length: x\r\n\r\n{}", "", `lsp: invalid Content-Length header " x"`},
+		{"{}\r\n\r\n", "", `lsp: invalid header line "{}"`},
+		{"Content-Length: 4\r\n\r\n{}", "", "unexpected EOF"},
+	} {
+		got, err := readLSPContent(bufio.NewReader(strings.NewReader(tt.in)))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("unexpected error when reading %q: got %v, want %s", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("unexpected content when reading %q: got %q and error %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestURIFilename(t *testing.T) {
+	for _, tt := range []struct {
+		uri  string
+		want string
+	}{
+		{"file:///home/tav/main.go", "/home/tav/main.go"},
+		{"file:///home/tav/my%20code/main.go", "/home/tav/my code/main.go"},
+		{"file:///C:/code/main.go", "C:/code/main.go"},
+		{"file:///c%3A/code/main.go", "c:/code/main.go"},
+		{"untitled:Untitled-1", "stdin"},
+	} {
+		if got := uriFilename(tt.uri); got != filepath.FromSlash(tt.want) {
+			t.Errorf("unexpected filename for %q: got %q, want %q", tt.uri, got, filepath.FromSlash(tt.want))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// overlay maps the absolute paths of files to the paths of files holding
+// their actual contents, e.g. the unsaved buffers of an editor. An empty
+// replacement means that the file is treated as deleted.
+type overlay map[string]string
+
+// lookup returns the replacement for the file at the given path, if any.
+func (o overlay) lookup(path string) (string, bool) {
+	if len(o) == 0 {
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	replacement, ok := o[abs]
+	return replacement, ok
+}
+
+// readFile returns the contents of the file at the given path, read from its
+// replacement if it has one.
+func (o overlay) readFile(path string) ([]byte, error) {
+	replacement, ok := o.lookup(path)
+	if !ok {
+		return os.ReadFile(path)
+	}
+	if replacement == "" {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return os.ReadFile(replacement)
+}
+
+// stat returns the file info for the file at the given path, using its
+// replacement if it has one.
+func (o overlay) stat(path string) (fs.FileInfo, error) {
+	replacement, ok := o.lookup(path)
+	if !ok {
+		return os.Stat(path)
+	}
+	if replacement == "" {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	return os.Stat(replacement)
+}
+
+// loadOverlay reads an overlay file in the same format as the one used by
+// go build -overlay, i.e. a JSON object with a Replace field mapping paths to
+// their replacements. Relative paths are resolved against the current working
+// directory.
+func loadOverlay(name string) (overlay, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var spec struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+	o := overlay{}
+	for path, replacement := range spec.Replace {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := o[abs]; ok {
+			return nil, fmt.Errorf("failed to decode %s: duplicate entries for %s", name, abs)
+		}
+		if replacement != "" {
+			if replacement, err = filepath.Abs(replacement); err != nil {
+				return nil, err
+			}
+		}
+		o[abs] = replacement
+	}
+	return o, nil
+}
@@ -0,0 +1,205 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"go/scanner"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// Supported values for the -format flag.
+const (
+	formatJSON  = "json"
+	formatSARIF = "sarif"
+	formatText  = "text"
+)
+
+// SARIF rule IDs for the kinds of results we report.
+const (
+	ruleError       = "error"
+	ruleUnformatted = "unformatted"
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type diagnostic struct {
+	Column  int    `json:"column,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// fileRecord is written for each file with -l -format json, as a single line
+// of JSON.
+type fileRecord struct {
+	BytesAfter  int    `json:"bytesAfter,omitempty"`
+	BytesBefore int    `json:"bytesBefore,omitempty"`
+	Changed     bool   `json:"changed"`
+	Error       string `json:"error,omitempty"`
+	Path        string `json:"path"`
+}
+
+type fileReport struct {
+	Changed bool         `json:"changed"`
+	Errors  []diagnostic `json:"errors,omitempty"`
+	Path    string       `json:"path"`
+}
+
+// report accumulates per-file results for the structured output formats.
+type report struct {
+	files []*fileReport
+	index map[string]*fileReport
+}
+
+// addError records the given error against the file it relates to. Parse
+// error lists are split into separate diagnostics.
+func (r *report) addError(path string, err error) {
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		for _, e := range list {
+			f := r.file(e.Pos.Filename)
+			f.Errors = append(f.Errors, diagnostic{
+				Column:  e.Pos.Column,
+				Line:    e.Pos.Line,
+				Message: e.Msg,
+			})
+		}
+		return
+	}
+	f := r.file(errorPath(path, err))
+	f.Errors = append(f.Errors, diagnostic{Message: err.Error()})
+}
+
+func (r *report) addFile(path string, changed bool) {
+	r.file(path).Changed = changed
+}
+
+func (r *report) file(path string) *fileReport {
+	if f, ok := r.index[path]; ok {
+		return f
+	}
+	if r.index == nil {
+		r.index = map[string]*fileReport{}
+	}
+	f := &fileReport{Path: path}
+	r.files = append(r.files, f)
+	r.index[path] = f
+	return f
+}
+
+// sarif converts the report into a SARIF 2.1.0 log.
+func (r *report) sarif() *sarifLog {
+	results := []sarifResult{}
+	for _, f := range r.files {
+		loc := sarifLocation{}
+		loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f.Path)
+		if f.Changed {
+			results = append(results, sarifResult{
+				Level:     "warning",
+				Locations: []sarifLocation{loc},
+				Message:   sarifMessage{Text: "File is not formatted with alphafmt"},
+				RuleID:    ruleUnformatted,
+			})
+		}
+		for _, diag := range f.Errors {
+			loc := loc
+			if diag.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{
+					StartColumn: diag.Column,
+					StartLine:   diag.Line,
+				}
+			}
+			results = append(results, sarifResult{
+				Level:     "error",
+				Locations: []sarifLocation{loc},
+				Message:   sarifMessage{Text: diag.Message},
+				RuleID:    ruleError,
+			})
+		}
+	}
+	run := sarifRun{Results: results}
+	run.Tool.Driver.Name = "alphafmt"
+	run.Tool.Driver.Rules = []sarifRule{
+		{ID: ruleError, ShortDescription: sarifMessage{Text: "File could not be read, parsed, or written"}},
+		{ID: ruleUnformatted, ShortDescription: sarifMessage{Text: "File is not formatted with alphafmt"}},
+	}
+	return &sarifLog{
+		Runs:    []sarifRun{run},
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+	}
+}
+
+func (r *report) write(w io.Writer, format string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if format == formatJSON {
+		return enc.Encode(map[string]any{"files": r.files})
+	}
+	return enc.Encode(r.sarif())
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifLog struct {
+	Runs    []sarifRun `json:"runs"`
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartColumn int `json:"startColumn,omitempty"`
+	StartLine   int `json:"startLine"`
+}
+
+type sarifResult struct {
+	Level     string          `json:"level"`
+	Locations []sarifLocation `json:"locations"`
+	Message   sarifMessage    `json:"message"`
+	RuleID    string          `json:"ruleId"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifRun struct {
+	Results []sarifResult `json:"results"`
+	Tool    struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+}
+
+// errorPath returns the path of the file that the given error relates to,
+// falling back to the path within the error if none is given.
+func errorPath(path string, err error) string {
+	var pathErr *fs.PathError
+	if path == "" && errors.As(err, &pathErr) {
+		return pathErr.Path
+	}
+	return path
+}
+
+// writeRecord writes the given record as a single line of JSON.
+func writeRecord(w io.Writer, rec *fileRecord) error {
+	return json.NewEncoder(w).Encode(rec)
+}
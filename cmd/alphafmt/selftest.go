@@ -0,0 +1,73 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+
+	"espra.dev/pkg/alphafmt"
+	"espra.dev/pkg/process"
+)
+
+// runSelftestCommand formats every Go file under the given paths, including
+// generated files, and reports any file whose output changes when formatted a
+// second time, or which loses comments, along with any formatting errors.
+func runSelftestCommand(args []string) {
+	flags := flag.NewFlagSet("alphafmt selftest", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Println("Usage: alphafmt selftest [flags] [path ...]")
+		fmt.Println()
+		flags.PrintDefaults()
+	}
+	strict := flags.Bool("strict", false, "apply stricter formatting rules on top of gofmt, similar to gofumpt")
+	workers := flags.Int("p", runtime.GOMAXPROCS(0), "number of files to format in parallel")
+	flags.Parse(args)
+	if *workers < 1 {
+		usageErrorf("The -p flag must be at least 1")
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, errs := collectGoFiles(paths, &walkOptions{ignore: &ignoreRules{}, maxDepth: 100})
+	if slices.ContainsFunc(errs, isTooManyFiles) {
+		printErrors(errs)
+		process.Exit(exitError)
+	}
+	// Module files have no comments to lose, and are formatted independently
+	// of any config.
+	files = slices.DeleteFunc(files, func(path string) bool {
+		return filepath.Ext(path) != ".go"
+	})
+	f := &formatter{
+		includeGenerated: true,
+		opts:             &alphafmt.Options{Strict: *strict},
+	}
+	failed := 0
+	f.formatFiles(files, *workers, func(path string, res *fileResult) {
+		err := res.err
+		if err == nil {
+			src, readErr := os.ReadFile(path)
+			if readErr != nil {
+				err = readErr
+			} else {
+				err = alphafmt.VerifyOutput(path, src, res.out, f.opts)
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+			failed++
+		}
+	})
+	printErrors(errs)
+	fmt.Fprintf(os.Stderr, "Checked %s, %d failed\n", plural(len(files), "file"), failed)
+	if len(errs) > 0 {
+		process.Exit(exitError)
+	}
+}
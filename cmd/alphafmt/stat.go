@@ -0,0 +1,169 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"strconv"
+
+	"espra.dev/cmd/internal/fmtcmd"
+	"espra.dev/pkg/alphafmt"
+)
+
+// diffStat summarizes the changes that formatting makes to a file.
+type diffStat struct {
+	deleted  int
+	inserted int
+	moved    int
+	path     string
+}
+
+// declKeys returns a key identifying each top-level declaration within the
+// given Go source, in order, or nil if it can't be parsed. Repeated keys, e.g.
+// for multiple init funcs, are numbered so that each key is unique.
+func declKeys(path string, src []byte) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	seen := map[string]int{}
+	keys := make([]string, 0, len(file.Decls))
+	for _, decl := range file.Decls {
+		var key string
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			key = "func " + decl.Name.Name
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				key = "method " + recvName(decl.Recv.List[0].Type) + "." + decl.Name.Name
+			}
+		case *ast.GenDecl:
+			key = decl.Tok.String()
+			if len(decl.Specs) > 0 {
+				switch spec := decl.Specs[0].(type) {
+				case *ast.ImportSpec:
+					key += " " + spec.Path.Value
+				case *ast.TypeSpec:
+					key += " " + spec.Name.Name
+				case *ast.ValueSpec:
+					key += " " + spec.Names[0].Name
+				}
+			}
+		}
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			key += "#" + strconv.Itoa(n)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// movedDecls returns the number of declarations present in both a and b whose
+// position relative to the others differs, i.e. the number of common
+// declarations outside of their longest common subsequence.
+func movedDecls(a []string, b []string) int {
+	inA := map[string]struct{}{}
+	for _, key := range a {
+		inA[key] = struct{}{}
+	}
+	inB := map[string]struct{}{}
+	for _, key := range b {
+		inB[key] = struct{}{}
+	}
+	var x, y []string
+	for _, key := range a {
+		if _, ok := inB[key]; ok {
+			x = append(x, key)
+		}
+	}
+	for _, key := range b {
+		if _, ok := inA[key]; ok {
+			y = append(y, key)
+		}
+	}
+	if len(x)*len(y) > fmtcmd.MaxDiffCells {
+		moved := 0
+		for i := range x {
+			if x[i] != y[i] {
+				moved++
+			}
+		}
+		return moved
+	}
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return len(x) - lcs[0][0]
+}
+
+// newDiffStat returns the stats for the changes between the original src and
+// the formatted dst of the file at path. Declarations are only counted for Go
+// source files, and not for go.mod or go.work files.
+func newDiffStat(path string, src []byte, dst []byte) *diffStat {
+	stat := &diffStat{path: path}
+	for _, change := range fmtcmd.DiffLines(fmtcmd.SplitLines(string(src)), fmtcmd.SplitLines(string(dst))) {
+		stat.deleted += change.A1 - change.A0
+		stat.inserted += change.B1 - change.B0
+	}
+	if !alphafmt.IsModFile(path) {
+		stat.moved = movedDecls(declKeys(path, src), declKeys(path, dst))
+	}
+	return stat
+}
+
+// recvName returns the name of the type of a method receiver.
+func recvName(expr ast.Expr) string {
+	switch typ := expr.(type) {
+	case *ast.Ident:
+		return typ.Name
+	case *ast.IndexExpr:
+		return recvName(typ.X)
+	case *ast.IndexListExpr:
+		return recvName(typ.X)
+	case *ast.ParenExpr:
+		return recvName(typ.X)
+	case *ast.StarExpr:
+		return recvName(typ.X)
+	}
+	return ""
+}
+
+// writeDiffStats writes a line for each of the given stats, followed by the
+// totals across all of them.
+func writeDiffStats(w io.Writer, stats []*diffStat) error {
+	width := 0
+	for _, stat := range stats {
+		width = max(width, len(stat.path))
+	}
+	total := &diffStat{}
+	for _, stat := range stats {
+		_, err := fmt.Fprintf(w, "%-*s | %s moved, %s changed (+%d -%d)\n",
+			width, stat.path, plural(stat.moved, "declaration"),
+			plural(stat.inserted+stat.deleted, "line"), stat.inserted, stat.deleted)
+		if err != nil {
+			return err
+		}
+		total.deleted += stat.deleted
+		total.inserted += stat.inserted
+		total.moved += stat.moved
+	}
+	_, err := fmt.Fprintf(w, "%s changed, %s moved, %s changed (+%d -%d)\n",
+		plural(len(stats), "file"), plural(total.moved, "declaration"),
+		plural(total.inserted+total.deleted, "line"), total.inserted, total.deleted)
+	return err
+}
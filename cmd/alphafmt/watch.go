@@ -0,0 +1,122 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"espra.dev/cmd/internal/fmtcmd"
+	"espra.dev/pkg/process"
+)
+
+// Timings for watch mode. Files are polled for changes, and only reformatted
+// once they've stopped changing for the debounce period, so that we don't race
+// with editors that save in multiple steps.
+const (
+	watchDebounce = 200 * time.Millisecond
+	watchInterval = 100 * time.Millisecond
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watcher reformats Go files under a set of paths whenever they change.
+type watcher struct {
+	backup    *fmtcmd.BackupConfig
+	errs      []string // the walk errors that were last printed
+	formatter *formatter
+	pending   map[string]time.Time
+	paths     []string
+	seen      map[string]fileState
+	walk      *walkOptions
+}
+
+// collect returns the files to watch, printing any errors encountered while
+// looking for them whenever they differ from the last scan, so that the same
+// errors aren't printed on every poll. As with a normal run, exceeding
+// -max-files is fatal.
+func (w *watcher) collect() []string {
+	files, errs := collectGoFiles(w.paths, w.walk)
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	if !slices.Equal(msgs, w.errs) {
+		printErrors(errs)
+		w.errs = msgs
+	}
+	if slices.ContainsFunc(errs, isTooManyFiles) {
+		process.Exit(exitError)
+	}
+	return files
+}
+
+// format reformats the file at the given path, and records its resulting
+// state so that our own write isn't seen as a change.
+func (w *watcher) format(path string) {
+	changed, out, err := w.formatter.formatFile(path)
+	if err != nil {
+		printErrors([]error{err})
+		return
+	}
+	if changed {
+		if err := fmtcmd.WriteFile(path, out, w.backup); err != nil {
+			printErrors([]error{err})
+			return
+		}
+		fmt.Println(path)
+	}
+	if info, err := os.Stat(path); err == nil {
+		w.seen[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+}
+
+// run polls for changes until the process is terminated. Files are formatted
+// once on startup, and then whenever they are modified or created.
+func (w *watcher) run() {
+	w.pending = map[string]time.Time{}
+	w.seen = map[string]fileState{}
+	files := w.collect()
+	for _, path := range files {
+		w.format(path)
+	}
+	for {
+		time.Sleep(watchInterval)
+		w.scan(time.Now())
+	}
+}
+
+func (w *watcher) scan(now time.Time) {
+	files := w.collect()
+	current := map[string]struct{}{}
+	for _, path := range files {
+		current[path] = struct{}{}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		state := fileState{modTime: info.ModTime(), size: info.Size()}
+		if prev, ok := w.seen[path]; !ok || prev != state {
+			w.pending[path] = now
+			w.seen[path] = state
+		}
+	}
+	for path := range w.seen {
+		if _, ok := current[path]; !ok {
+			delete(w.pending, path)
+			delete(w.seen, path)
+		}
+	}
+	for path, changed := range w.pending {
+		if now.Sub(changed) >= watchDebounce {
+			delete(w.pending, path)
+			w.format(path)
+		}
+	}
+}
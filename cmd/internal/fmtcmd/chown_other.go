@@ -0,0 +1,13 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+//go:build !unix
+
+package fmtcmd
+
+import (
+	"os"
+)
+
+// chown is a no-op on platforms without Unix file ownership.
+func chown(f *os.File, info os.FileInfo) {}
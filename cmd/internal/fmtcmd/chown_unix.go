@@ -0,0 +1,19 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+//go:build unix
+
+package fmtcmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown tries to give f the same owner and group as the file described by
+// info. Failures are ignored, as only privileged users can change the owner.
+func chown(f *os.File, info os.FileInfo) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(stat.Uid), int(stat.Gid))
+	}
+}
@@ -0,0 +1,195 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+// Package fmtcmd provides the diffing and file writing shared by the
+// formatting commands.
+package fmtcmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ANSI escape sequences used to colorize diffs.
+const (
+	ansiBold  = "\x1b[1m"
+	ansiCyan  = "\x1b[36m"
+	ansiGreen = "\x1b[32m"
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
+)
+
+// Beyond this many cells in the line diff table, we fall back to a single edit
+// covering all changed lines.
+const MaxDiffCells = 4_000_000
+
+// Number of unchanged lines shown around each change in unified diffs.
+const diffContext = 3
+
+// LineChange represents the replacement of the lines a[A0:A1] with b[B0:B1].
+type LineChange struct {
+	A0, A1 int
+	B0, B1 int
+}
+
+// ColorizeDiff returns the given unified diffs with ANSI colors, in the style
+// of git diff, i.e. with file headers in bold, hunk headers in cyan, removed
+// lines in red, and added lines in green.
+func ColorizeDiff(diff []byte) []byte {
+	buf := &bytes.Buffer{}
+	header := false
+	for _, line := range SplitLines(string(diff)) {
+		text := strings.TrimSuffix(line, "\n")
+		color := ""
+		switch {
+		case strings.HasPrefix(text, "diff "):
+			header = true
+			color = ansiBold
+		case strings.HasPrefix(text, "@@"):
+			header = false
+			color = ansiCyan
+		case header:
+			color = ansiBold
+		case strings.HasPrefix(text, "-"):
+			color = ansiRed
+		case strings.HasPrefix(text, "+"):
+			color = ansiGreen
+		}
+		if color == "" || text == "" {
+			buf.WriteString(line)
+			continue
+		}
+		buf.WriteString(color)
+		buf.WriteString(text)
+		buf.WriteString(ansiReset)
+		buf.WriteString(line[len(text):])
+	}
+	return buf.Bytes()
+}
+
+// DiffLines returns the changes needed to turn the lines in a into the lines
+// in b, in order.
+func DiffLines(a []string, b []string) []LineChange {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if len(a)*len(b) > MaxDiffCells {
+		return []LineChange{{A0: prefix, A1: prefix + len(a), B0: prefix, B1: prefix + len(b)}}
+	}
+	// Compute the longest common subsequence of lines, and emit a change for
+	// each run of lines outside of it.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var changes []LineChange
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			i++
+			j++
+			continue
+		}
+		si, sj := i, j
+		for i < len(a) || j < len(b) {
+			if i < len(a) && j < len(b) && a[i] == b[j] {
+				break
+			}
+			if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+				i++
+			} else {
+				j++
+			}
+		}
+		changes = append(changes, LineChange{A0: prefix + si, A1: prefix + i, B0: prefix + sj, B1: prefix + j})
+	}
+	return changes
+}
+
+// SplitLines splits s into lines, with each line retaining its trailing
+// newline.
+func SplitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// UnifiedDiff returns a unified diff between the original src and the
+// formatted dst for the file with the given name, in the style of gofmt -d.
+func UnifiedDiff(name string, src []byte, dst []byte) []byte {
+	a, b := SplitLines(string(src)), SplitLines(string(dst))
+	changes := DiffLines(a, b)
+	if len(changes) == 0 {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "diff %s.orig %s\n--- %s.orig\n+++ %s\n", name, name, name, name)
+	writeLine := func(prefix byte, line string) {
+		buf.WriteByte(prefix)
+		buf.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	for len(changes) > 0 {
+		// Group changes whose context would overlap into a single hunk.
+		n := 1
+		for n < len(changes) && changes[n].A0-changes[n-1].A1 <= 2*diffContext {
+			n++
+		}
+		first, last := changes[0], changes[n-1]
+		a0 := max(first.A0-diffContext, 0)
+		a1 := min(last.A1+diffContext, len(a))
+		b0 := first.B0 - (first.A0 - a0)
+		b1 := last.B1 + (a1 - last.A1)
+		fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", hunkStart(a0, a1-a0), a1-a0, hunkStart(b0, b1-b0), b1-b0)
+		i := a0
+		for _, change := range changes[:n] {
+			for ; i < change.A0; i++ {
+				writeLine(' ', a[i])
+			}
+			for _, line := range a[change.A0:change.A1] {
+				writeLine('-', line)
+			}
+			for _, line := range b[change.B0:change.B1] {
+				writeLine('+', line)
+			}
+			i = change.A1
+		}
+		for ; i < a1; i++ {
+			writeLine(' ', a[i])
+		}
+		changes = changes[n:]
+	}
+	return buf.Bytes()
+}
+
+// hunkStart returns the start line for a unified diff hunk header, which is
+// the line before the hunk when it is empty.
+func hunkStart(start int, count int) int {
+	if count == 0 {
+		return start
+	}
+	return start + 1
+}
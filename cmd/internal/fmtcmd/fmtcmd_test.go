@@ -0,0 +1,89 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package fmtcmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		dst  string
+		want string
+	}{
+		{
+			name: "unchanged",
+			src:  "a\nb\n",
+			dst:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "replaced line",
+			src:  "a\nb\nc\n",
+			dst:  "a\nx\nc\n",
+			want: "diff f.orig f\n--- f.orig\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "separate hunks",
+			src:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			dst:  "0\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			want: "diff f.orig f\n--- f.orig\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+0\n 2\n 3\n 4\n@@ -7,4 +7,3 @@\n 7\n 8\n 9\n-10\n",
+		},
+		{
+			name: "missing final newline",
+			src:  "a",
+			dst:  "a\n",
+			want: "diff f.orig f\n--- f.orig\n+++ f\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(UnifiedDiff("f", []byte(tt.src), []byte(tt.dst)))
+			if got != tt.want {
+				t.Errorf("unexpected diff:\ngot:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := WriteFile(link, []byte("new"), &BackupConfig{Suffix: ".orig"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected %s to still be a symlink", link)
+	}
+	for name, want := range map[string]string{"a.txt": "new", "link.txt.orig": "old"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("unexpected contents of %s: got %q, want %q", name, got, want)
+		}
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("unexpected permissions: got %o, want %o", perm, 0o600)
+	}
+}
@@ -0,0 +1,93 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package fmtcmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BackupConfig specifies where the originals of rewritten files are saved.
+type BackupConfig struct {
+	Dir    string
+	Suffix string
+}
+
+// save copies the file at path to its backup location. If a backup directory
+// is set, the file's path is mirrored within it, otherwise the backup is saved
+// alongside the original. A nil config disables backups.
+func (b *BackupConfig) save(path string) error {
+	if b == nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dst := path + b.Suffix
+	if b.Dir != "" {
+		rel := filepath.Clean(path)
+		if !filepath.IsLocal(rel) {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			rel = strings.TrimLeft(abs[len(filepath.VolumeName(abs)):], string(filepath.Separator))
+		}
+		dst = filepath.Join(b.Dir, rel) + b.Suffix
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(dst, data, info.Mode().Perm())
+}
+
+// WriteFile atomically replaces the file at path with the given data. The data
+// is written to a temporary file in the same directory, synced to disk, given
+// the original file's permissions and, where possible, its ownership, and then
+// renamed over the original, so that a crash never leaves a truncated file.
+// Symlinks are followed, so that the link itself is preserved. If backups are
+// enabled, the original is saved first.
+func WriteFile(path string, data []byte, backup *BackupConfig) (err error) {
+	if err := backup.save(path); err != nil {
+		return err
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	chown(tmp, info)
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
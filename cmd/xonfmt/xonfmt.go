@@ -0,0 +1,346 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+// Command xonfmt formats XON files in canonical style.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"espra.dev/cmd/internal/fmtcmd"
+	"espra.dev/pkg/process"
+	"espra.dev/pkg/xon"
+)
+
+// Exit statuses. A status of 0 means that no errors occurred, and that, when
+// using -l, all files were already formatted.
+const (
+	exitChanged = 1 // files need formatting, with -l
+	exitUsage   = 2 // invalid flags or arguments
+	exitError   = 3 // files could not be read, parsed, or written
+)
+
+// formatter formats XON files according to the command line options.
+type formatter struct {
+	json     bool
+	sortKeys bool
+}
+
+// format returns the canonical form of the given XON source. Parse errors are
+// reported with the given name, in the style of gofmt.
+//
+// In JSON mode, XON sources are converted into JSON instead, while sources
+// which start with a '{', and are thus JSON, as no XON document can start with
+// one, are converted into XON.
+func (f *formatter) format(name string, src []byte) ([]byte, error) {
+	if f.json && bytes.HasPrefix(bytes.TrimLeft(src, " \t\r\n"), []byte("{")) {
+		nodes, err := xon.FromJSON(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return xon.Marshal(normalizeNodes(nodes, f.sortKeys))
+	}
+	nodes, err := xon.Parse(src)
+	if err != nil {
+		var perr *xon.Error
+		if errors.As(err, &perr) {
+			return nil, fmt.Errorf("%s:%d:%d: %s", name, perr.Line, perr.Column, perr.Message)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	nodes = normalizeNodes(nodes, f.sortKeys)
+	if !f.json {
+		return xon.Marshal(nodes)
+	}
+	data, err := xon.ToJSON(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, data, "", "    "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// formatFile formats the file at path, and returns the original source along
+// with the formatted output.
+func (f *formatter) formatFile(path string) ([]byte, []byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := f.format(path, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, out, nil
+}
+
+// collectFiles returns the files with any of the given extensions within the
+// given paths. Files given directly are always included, while directories are
+// walked, skipping any hidden directories within them.
+func collectFiles(paths []string, exts ...string) ([]string, []error) {
+	var (
+		errs  []error
+		files []string
+	)
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if slices.Contains(exts, filepath.Ext(path)) {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	return files, errs
+}
+
+// fatalf prints the given error message to stderr, and exits with the status
+// for runtime errors.
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	process.Exit(exitError)
+}
+
+// formatStdin formats the source piped via stdin, and writes the result, or a
+// diff against the source, to stdout.
+func formatStdin(f *formatter, diff bool) {
+	src, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fatalf("Failed to read from stdin: %v", err)
+	}
+	out, err := f.format("stdin", src)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if diff {
+		out = fmtcmd.UnifiedDiff("stdin", src, out)
+	}
+	if _, err = os.Stdout.Write(out); err != nil {
+		fatalf("Failed to write to stdout: %v", err)
+	}
+}
+
+// isPinned returns whether the given node needs to stay in place when sorting,
+// i.e. if it's a versioned block, include directive, or reference, or if it
+// defines an anchor, either directly or within a nested block.
+func isPinned(node xon.Node) bool {
+	switch node := node.(type) {
+	case *xon.Block:
+		return node.Anchor != "" || slices.ContainsFunc(node.Nodes, isPinned)
+	case *xon.Include, *xon.Reference, *xon.VersionedBlock:
+		return true
+	case *xon.KeyValue:
+		return node.Anchor != ""
+	}
+	return false
+}
+
+// nodeKey returns the key that the given node is sorted by.
+func nodeKey(node xon.Node) string {
+	switch node := node.(type) {
+	case *xon.Block:
+		return node.Name
+	case *xon.KeyValue:
+		return node.Key
+	}
+	return ""
+}
+
+// normalizeNodes normalizes the given nodes and their descendants, so that
+// strings are only quoted where needed. If sortKeys is set, keys and blocks are
+// also sorted, with any comments preceding a node moving along with it.
+// Versioned blocks, include directives, references, and nodes which define
+// anchors stay in place, so that anchors are still defined before they're
+// referenced, and the nodes between them are sorted separately, as are any
+// trailing comments which aren't attached to a node.
+func normalizeNodes(nodes []xon.Node, sortKeys bool) []xon.Node {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *xon.Block:
+			node.Nodes = normalizeNodes(node.Nodes, sortKeys)
+		case *xon.KeyValue:
+			normalizeValue(node.Value)
+		case *xon.VersionedBlock:
+			node.Block.Nodes = normalizeNodes(node.Block.Nodes, sortKeys)
+		}
+	}
+	if !sortKeys {
+		return nodes
+	}
+	var (
+		group  []xon.Node
+		groups [][]xon.Node
+		out    []xon.Node
+	)
+	flush := func() {
+		slices.SortStableFunc(groups, func(a, b []xon.Node) int {
+			return strings.Compare(nodeKey(a[len(a)-1]), nodeKey(b[len(b)-1]))
+		})
+		for _, g := range groups {
+			out = append(out, g...)
+		}
+		groups = nil
+	}
+	for _, node := range nodes {
+		if isPinned(node) {
+			flush()
+			out = append(out, group...)
+			out = append(out, node)
+			group = nil
+			continue
+		}
+		group = append(group, node)
+		if _, ok := node.(*xon.Comment); !ok {
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	flush()
+	return append(out, group...)
+}
+
+// normalizeValue drops the quotes from strings which don't need them. Only
+// the string "nil" needs to stay quoted, as it would otherwise be decoded as a
+// nil value, and the printer adds quotes to any other strings which need them.
+// Raw strings are left as they are, as they're written to avoid escapes.
+func normalizeValue(value xon.Value) {
+	switch value := value.(type) {
+	case *xon.List:
+		for _, elem := range value.Content {
+			normalizeValue(elem)
+		}
+	case *xon.String:
+		if !value.Raw {
+			value.Quoted = value.Quoted && value.Value == "nil"
+		}
+	}
+}
+
+// usageErrorf prints the given error message to stderr, and exits with the
+// status for usage errors.
+func usageErrorf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	process.Exit(exitUsage)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "yaml" {
+		runYAMLCommand(os.Args[2:])
+		return
+	}
+
+	flag.CommandLine = flag.NewFlagSet("xonfmt", flag.ExitOnError)
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.Usage = func() {
+		fmt.Println("Usage: xonfmt [flags] [path ...]")
+		fmt.Println("       xonfmt yaml [flags] [path ...]")
+		fmt.Println()
+		flag.PrintDefaults()
+	}
+
+	diff := flag.Bool("d", false, "display diffs instead of rewriting files")
+	toJSON := flag.Bool("json", false, "convert XON to JSON, and JSON, i.e. sources starting with '{', to XON")
+	list := flag.Bool("l", false, "list files whose formatting differs, and exit with status 1 if there are any")
+	sortKeys := flag.Bool("sort", false, "sort keys and blocks alphabetically, instead of keeping their original order")
+	write := flag.Bool("w", false, "write result to (source) file instead of stdout")
+	flag.Parse()
+
+	if *toJSON && (*diff || *list || *write) {
+		usageErrorf("Cannot use -d, -l, or -w together with -json")
+	}
+	f := &formatter{json: *toJSON, sortKeys: *sortKeys}
+	paths := flag.Args()
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		fatalf("Failed to stat stdin: %v", err)
+	}
+	if (stat.Mode() & os.ModeCharDevice) == 0 {
+		if len(paths) > 0 {
+			usageErrorf("Cannot specify paths when piping via stdin")
+		}
+		if *list {
+			usageErrorf("Cannot use -l when piping via stdin")
+		}
+		if *write {
+			usageErrorf("Cannot use -w when piping via stdin")
+		}
+		formatStdin(f, *diff)
+		return
+	}
+	if len(paths) == 0 {
+		flag.Usage()
+		process.Exit(0)
+	}
+
+	files, errs := collectFiles(paths, ".xon")
+	changed := 0
+	for _, path := range files {
+		src, out, err := f.formatFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !bytes.Equal(src, out) {
+			changed++
+			if *list {
+				fmt.Println(path)
+			}
+			if *diff {
+				if _, err := os.Stdout.Write(fmtcmd.UnifiedDiff(path, src, out)); err != nil {
+					fatalf("Failed to write to stdout: %v", err)
+				}
+			}
+			if *write {
+				if err := fmtcmd.WriteFile(path, out, nil); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if !*diff && !*list && !*write {
+			if _, err := os.Stdout.Write(out); err != nil {
+				fatalf("Failed to write to stdout: %v", err)
+			}
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		process.Exit(exitError)
+	}
+	if *list && changed > 0 {
+		process.Exit(exitChanged)
+	}
+}
@@ -0,0 +1,132 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"espra.dev/pkg/process"
+	"espra.dev/pkg/xon"
+)
+
+// convertYAML converts the given YAML source into XON in canonical style, with
+// one output for each document within the source.
+func convertYAML(name string, src []byte, sortKeys bool) ([][]byte, error) {
+	docs, err := xon.FromYAML(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	out := make([][]byte, len(docs))
+	for i, nodes := range docs {
+		if out[i], err = xon.Marshal(normalizeNodes(nodes, sortKeys)); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return out, nil
+}
+
+// convertYAMLFile converts the YAML file at path, and writes each document to
+// a .xon file alongside it, returning the paths of the written files. Files
+// with multiple documents are written to numbered files, e.g. config.1.xon.
+func convertYAMLFile(path string, force bool, sortKeys bool) ([]string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := convertYAML(path, src, sortKeys)
+	if err != nil {
+		return nil, err
+	}
+	base := path[:len(path)-len(filepath.Ext(path))]
+	outputs := make([]string, len(docs))
+	for i := range docs {
+		if len(docs) == 1 {
+			outputs[i] = base + ".xon"
+		} else {
+			outputs[i] = base + "." + strconv.Itoa(i+1) + ".xon"
+		}
+		if force {
+			continue
+		}
+		if _, err := os.Stat(outputs[i]); err == nil {
+			return nil, fmt.Errorf("%s: %s already exists, use -f to overwrite it", path, outputs[i])
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	for i, out := range docs {
+		if err := os.WriteFile(outputs[i], out, 0o666); err != nil {
+			return outputs[:i], err
+		}
+	}
+	return outputs, nil
+}
+
+// runYAMLCommand converts YAML files into XON, writing a .xon file alongside
+// each one, or converts the YAML piped via stdin, writing the result to stdout.
+func runYAMLCommand(args []string) {
+	flags := flag.NewFlagSet("xonfmt yaml", flag.ExitOnError)
+	flags.SetOutput(os.Stdout)
+	flags.Usage = func() {
+		fmt.Println("Usage: xonfmt yaml [flags] [path ...]")
+		fmt.Println()
+		flags.PrintDefaults()
+	}
+	force := flags.Bool("f", false, "overwrite any existing .xon files")
+	sortKeys := flags.Bool("sort", false, "sort keys and blocks alphabetically, instead of keeping their original order")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		fatalf("Failed to stat stdin: %v", err)
+	}
+	if (stat.Mode() & os.ModeCharDevice) == 0 {
+		if len(paths) > 0 {
+			usageErrorf("Cannot specify paths when piping via stdin")
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatalf("Failed to read from stdin: %v", err)
+		}
+		docs, err := convertYAML("stdin", src, *sortKeys)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if len(docs) != 1 {
+			fatalf("stdin: found %d YAML documents, but can only write one to stdout; convert a file instead", len(docs))
+		}
+		if _, err = os.Stdout.Write(docs[0]); err != nil {
+			fatalf("Failed to write to stdout: %v", err)
+		}
+		return
+	}
+	if len(paths) == 0 {
+		flags.Usage()
+		process.Exit(0)
+	}
+
+	files, errs := collectFiles(paths, ".yaml", ".yml")
+	for _, path := range files {
+		written, err := convertYAMLFile(path, *force, *sortKeys)
+		for _, out := range written {
+			fmt.Println(out)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		process.Exit(exitError)
+	}
+}
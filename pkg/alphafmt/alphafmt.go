@@ -0,0 +1,2176 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+// Package alphafmt implements gofmt with section sorting.
+//
+// Declarations are grouped into sections, i.e. imports, consts, vars, types
+// and their methods, funcs, main and init, with each section sorted
+// alphabetically. Sorting can be customized with an .alphafmt config file at
+// the module root.
+package alphafmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"espra.dev/pkg/xon"
+)
+
+// Comment directives for controlling reordering.
+const (
+	directiveIgnore   = "//alphafmt:ignore"
+	directiveOff      = "//alphafmt:off"
+	directiveOn       = "//alphafmt:on"
+	directiveSort     = "//alphafmt:sort"
+	directiveSortKeys = "//alphafmt:sort-keys"
+	directiveWith     = "//alphafmt:with"
+)
+
+// Built-in names for import groups.
+const (
+	groupLocal      = "local"
+	groupStd        = "std"
+	groupThirdParty = "third-party"
+)
+
+// Supported values for the methods setting.
+const (
+	methodsAfterTypes = "after types"
+	methodsWithType   = "with type"
+)
+
+// Supported values for the sort setting.
+const (
+	sortBytewise = "bytewise"
+	sortICase    = "icase"
+	sortNatural  = "natural"
+)
+
+// Supported values for the std imports setting.
+const (
+	stdGoList    = "go list"
+	stdHeuristic = "heuristic"
+)
+
+const configFile = ".alphafmt"
+
+var (
+	configMu sync.Mutex                   // protects configs
+	configs  = map[string]*cachedConfig{} // keyed by directory
+)
+
+// sectionPattern matches section marker comments, e.g.
+// "// --- Section: Encoding ---".
+var sectionPattern = regexp.MustCompile(`^//\s*---\s*Section:\s*(.*?)\s*---$`)
+
+var utf8BOM = []byte("\ufeff")
+
+// Options configures how source is formatted.
+type Options struct {
+	// FixImports adds imports for packages which are referenced but not
+	// imported, resolving them to std packages first, and then to packages
+	// from the module and its dependencies. Unused imports are also removed,
+	// as with the remove unused imports config setting, so that alphafmt can
+	// be used in place of goimports.
+	FixImports bool
+
+	// KeepCRLF uses CRLF line endings in the output if the majority of lines
+	// in the source end with them. Otherwise, the output always uses LF line
+	// endings, like gofmt.
+	KeepCRLF bool
+
+	// MaxLineLength wraps the parameters of functions, the arguments of calls,
+	// and the elements of composite literals onto multiple lines, with one
+	// entry per line, when they're on lines longer than this many columns,
+	// with tabs counted as 4 columns. Zero disables wrapping.
+	MaxLineLength int
+
+	// Minimal only swaps neighbouring top-level declarations which are out
+	// of order, without rewriting them, so that alphafmt can be adopted on an
+	// existing codebase gradually with small diffs. Each run moves
+	// declarations closer to their sorted positions.
+	Minimal bool
+
+	// Strict applies stricter formatting rules on top of gofmt, similar to
+	// gofumpt, e.g. removing empty lines at the start and end of blocks.
+	Strict bool
+}
+
+// cachedConfig is a config loaded for a directory, along with the state of the
+// files it was loaded from, so that it's reloaded once any of them change,
+// e.g. when running in watch or LSP mode.
+type cachedConfig struct {
+	cfg        *config
+	configTime time.Time // of the .alphafmt file within the directory
+	modTime    time.Time // of the go.mod file, if the directory is the root
+	parent     *config   // the config the directory's settings are applied to
+}
+
+type config struct {
+	canonicalTags        bool
+	constructorsWithType bool
+	embeddedFirst        bool
+	importGroups         []string
+	methodGroups         []string
+	methods              string
+	module               string
+	orderLiterals        bool
+	preserveOrder        bool
+	removeUnusedImports  bool
+	root                 string
+	rules                []string
+	skipDirs             []string
+	sortMapKeys          bool
+	sortOrder            string
+	sortStructFields     bool
+	sortVarBlocks        bool
+	stdImports           string
+	structTagOrder       []string
+}
+
+// less reports whether the name a sorts before the name b.
+func (c *config) less(a, b string) bool {
+	switch c.sortOrder {
+	case sortICase:
+		la, lb := strings.ToLower(a), strings.ToLower(b)
+		if la != lb {
+			return la < lb
+		}
+	case sortNatural:
+		if cmp := compareNatural(a, b); cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return a < b
+}
+
+func (c *config) skipDir(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata" {
+		return true
+	}
+	if len(c.skipDirs) == 0 {
+		return false
+	}
+	rel := ""
+	if c.root != "" {
+		abs, err := filepath.Abs(path)
+		if err == nil {
+			rel, _ = filepath.Rel(c.root, abs)
+			rel = filepath.ToSlash(rel)
+		}
+	}
+	for _, dir := range c.skipDirs {
+		if dir == name || dir == rel {
+			return true
+		}
+	}
+	return false
+}
+
+type declItem struct {
+	name string
+	decl ast.Decl
+}
+
+// declPrinter formats declarations along with their associated comments.
+type declPrinter struct {
+	anchors      map[*ast.FuncDecl]*ast.FuncDecl
+	attached     map[*ast.FuncDecl][]*ast.FuncDecl
+	comments     []*ast.CommentGroup
+	err          error // the first error from printing a declaration
+	floating     *commentPlan
+	fset         *token.FileSet
+	methodGroups []*methodGroup
+	testFile     bool
+	verbatim     map[ast.Decl]string
+}
+
+// formatDecl returns the formatted source for the given declaration, preceded
+// by any floating comments that move along with it. Frozen declarations are
+// returned verbatim. If the declaration can't be printed, the error is kept in
+// p.err for the caller to check once all of the declarations are formatted.
+func (p *declPrinter) formatDecl(decl ast.Decl) string {
+	leading := p.floating.leadingText(decl)
+	if text, ok := p.verbatim[decl]; ok {
+		return leading + text
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(leading)
+	cfg := &printer.Config{
+		Mode:     printer.TabIndent | printer.UseSpaces,
+		Tabwidth: 8,
+	}
+
+	var docComment *ast.CommentGroup
+	switch node := decl.(type) {
+	case *ast.FuncDecl:
+		docComment = node.Doc
+		node.Doc = nil
+	case *ast.GenDecl:
+		docComment = node.Doc
+		node.Doc = nil
+	}
+	node := &printer.CommentedNode{
+		Comments: commentsForDecl(p.comments, decl),
+		Node:     decl,
+	}
+	if docComment != nil {
+		for _, line := range docComment.List {
+			// Section markers directly above a declaration are written
+			// separately.
+			if markerText(line.Text) != "" {
+				continue
+			}
+			buf.WriteString(line.Text)
+			buf.WriteByte('\n')
+		}
+	}
+	if err := cfg.Fprint(buf, p.fset, node); err != nil {
+		if p.err == nil {
+			p.err = fmt.Errorf("alphafmt: failed to format declaration: %w", err)
+		}
+		return ""
+	}
+	text := strings.TrimRight(buf.String(), "\n")
+	// The printer drops comments after the end of the node, so a trailing
+	// comment on the last line of the declaration needs to be added back,
+	// unless it was printed as part of a spec.
+	if comment := p.trailingComment(decl); comment != "" && !strings.HasSuffix(text, comment) {
+		text += " " + comment
+	}
+	return text
+}
+
+// formatFunc returns the formatted source for the given function, followed by
+// that of any functions attached to it via //alphafmt:with directives.
+func (p *declPrinter) formatFunc(fn *ast.FuncDecl) []string {
+	parts := []string{p.formatDecl(fn)}
+	for _, attached := range p.attached[fn] {
+		parts = append(parts, p.formatFunc(attached)...)
+	}
+	return parts
+}
+
+// formatMethods returns the formatted source for the given methods of a type.
+// When method groups are enabled, the methods are clustered by the interfaces
+// they implement, with a comment header above each cluster.
+func (p *declPrinter) formatMethods(methods []*ast.FuncDecl) []string {
+	var parts []string
+	if len(p.methodGroups) == 0 || len(methods) == 0 {
+		for _, method := range methods {
+			parts = append(parts, p.formatDecl(method))
+		}
+		return parts
+	}
+	clusters, groups := clusterMethods(methods, p.methodGroups)
+	for i, cluster := range clusters {
+		if len(clusters) > 1 || groups[i] != nil {
+			first := cluster[0]
+			p.floating.leading[first] = append([]string{groups[i].header()}, p.floating.leading[first]...)
+		}
+		for _, method := range cluster {
+			parts = append(parts, p.formatDecl(method))
+		}
+	}
+	return parts
+}
+
+// trailingComment returns the text of any comment which follows the given
+// declaration on the same line.
+func (p *declPrinter) trailingComment(decl ast.Decl) string {
+	end := decl.End()
+	line := p.fset.Position(end).Line
+	for _, group := range p.comments {
+		if group.Pos() < end {
+			continue
+		}
+		if p.fset.Position(group.Pos()).Line != line {
+			return ""
+		}
+		var parts []string
+		for _, comment := range group.List {
+			parts = append(parts, comment.Text)
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+// region represents the range between an //alphafmt:off directive and its
+// matching //alphafmt:on directive, or the end of the file. Declarations
+// within a region keep their original order.
+type region struct {
+	end    token.Pos
+	placed bool
+	start  token.Pos
+	text   string
+}
+
+// sectionMarker represents a "// --- Section: <name> ---" comment, or an
+// editor fold region comment like "// region: <name>" or "// endregion".
+// Markers partition a file into sections, with declarations sorted within
+// each section, and the sections kept in their original order.
+type sectionMarker struct {
+	pos  token.Pos
+	text string
+}
+
+// ConfigKey returns a string identifying the config which applies to the given
+// file. The key changes whenever a config change could affect how the file is
+// formatted with the given options, and is suitable for use within cache keys.
+//
+// When struct literals or fields are being ordered, the key also reflects the
+// size and modification time of the other files within the file's package, as
+// those may define the structs being used. If the output depends on other
+// packages, i.e. when fixing imports, removing unused ones, or grouping
+// methods by interface, an empty key is returned, as the output could change
+// without any file in the package changing, and it shouldn't be cached.
+func ConfigKey(filename string, opts *Options) (string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		return "", err
+	}
+	if opts.FixImports || cfg.removeUnusedImports || len(cfg.methodGroups) > 0 {
+		return "", nil
+	}
+	key := fmt.Sprintf("%+v", *cfg)
+	if cfg.stdImports == stdGoList {
+		// The std packages depend on the Go toolchain in use.
+		std, err := loadStdPackages(cfg)
+		if err != nil {
+			return "", err
+		}
+		key += " " + strings.Join(slices.Sorted(maps.Keys(std)), ",")
+	}
+	if cfg.orderLiterals || cfg.sortStructFields {
+		for _, path := range packageFiles(filename) {
+			if info, err := os.Stat(path); err == nil {
+				key += fmt.Sprintf(" %s:%d:%d", filepath.Base(path), info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	}
+	return key, nil
+}
+
+// Format formats the given Go source with gofmt and sorts its declarations into
+// sections. The filename is used for error messages, and to find the module
+// root and any .alphafmt config file within it.
+func Format(filename string, src []byte) ([]byte, error) {
+	return FormatWithOptions(filename, src, nil)
+}
+
+// FormatWithOptions is like Format, but with the given options. A nil opts is
+// the same as the zero value.
+func FormatWithOptions(filename string, src []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	// A leading byte order mark is stripped, and restored afterwards, so that
+	// files saved by Windows editors don't produce noisy diffs.
+	bom := bytes.HasPrefix(src, utf8BOM)
+	src = bytes.TrimPrefix(src, utf8BOM)
+	crlf := bytes.Count(src, []byte("\r\n"))
+	if crlf > 0 {
+		src = bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+	}
+	out, err := formatSource(filename, src, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.KeepCRLF && crlf > bytes.Count(src, []byte("\n"))-crlf {
+		out = bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n"))
+	}
+	if bom {
+		out = append(slices.Clip(utf8BOM), out...)
+	}
+	return out, nil
+}
+
+// IsGenerated reports whether the given Go source is marked as machine
+// generated with the standard "// Code generated ... DO NOT EDIT." comment.
+func IsGenerated(src []byte) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
+	}
+	return ast.IsGenerated(file)
+}
+
+// SkipDir reports whether the directory at the given path should be skipped
+// when looking for Go files to format. Hidden, vendor and testdata directories
+// are always skipped, along with any listed in the module's config file.
+func SkipDir(path string) (bool, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return false, err
+	}
+	return cfg.skipDir(path), nil
+}
+
+func appendDeclItems(blocks []ast.Decl, singles []declItem) []ast.Decl {
+	decls := slices.Clone(blocks)
+	for _, item := range singles {
+		decls = append(decls, item.decl)
+	}
+	return decls
+}
+
+// buildImportSection splits imports into the configured import groups, with
+// each group sorted by path, and duplicate imports merged. If std is not nil,
+// it is the set of std packages used to classify imports. Any imports within
+// unused are dropped, unless that would leave no imports to attach the doc
+// comments of the import declarations to.
+func buildImportSection(fset *token.FileSet, importDecls []ast.Decl, cfg *config, std map[string]struct{}, unused map[*ast.ImportSpec]struct{}) (string, error) {
+	if len(importDecls) == 0 {
+		return "", nil
+	}
+
+	var (
+		cgo       *ast.ImportSpec
+		cgoDoc    *ast.CommentGroup
+		docGroups []*ast.CommentGroup
+	)
+	groups := make([][]*ast.ImportSpec, len(cfg.importGroups)+1)
+	for _, decl := range importDecls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		doc := gen.Doc
+		for _, spec := range gen.Specs {
+			importSpec, ok := spec.(*ast.ImportSpec)
+			if !ok {
+				continue
+			}
+			// The cgo preamble must directly precede a standalone import
+			// of "C", so it is kept separate from the other imports.
+			if importPath(importSpec) == "C" && cgo == nil {
+				cgo, cgoDoc = importSpec, importSpec.Doc
+				if !gen.Lparen.IsValid() {
+					cgoDoc, doc = gen.Doc, nil
+				}
+				importSpec.Doc = nil
+				continue
+			}
+			idx := importGroup(importPath(importSpec), cfg.importGroups, cfg.module, std)
+			groups[idx] = append(groups[idx], importSpec)
+		}
+		if doc != nil {
+			docGroups = append(docGroups, doc)
+		}
+	}
+
+	if len(unused) > 0 {
+		kept := make([][]*ast.ImportSpec, len(groups))
+		for i, specs := range groups {
+			for _, spec := range specs {
+				if _, ok := unused[spec]; !ok {
+					kept[i] = append(kept[i], spec)
+				}
+			}
+		}
+		if len(docGroups) == 0 || slices.IndexFunc(kept, func(specs []*ast.ImportSpec) bool { return len(specs) > 0 }) != -1 {
+			groups = kept
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if cgo != nil {
+		if cgoDoc != nil {
+			for _, line := range cgoDoc.List {
+				buf.WriteString(line.Text)
+				buf.WriteByte('\n')
+			}
+		}
+		formatted, err := formatImportSpec(fset, cgo)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString("import ")
+		buf.WriteString(formatted)
+		buf.WriteString("\n\n")
+	}
+	if slices.IndexFunc(groups, func(specs []*ast.ImportSpec) bool { return len(specs) > 0 }) == -1 {
+		return strings.TrimRight(buf.String(), "\n"), nil
+	}
+	for _, group := range docGroups {
+		for _, line := range group.List {
+			buf.WriteString(line.Text)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteString("import (\n")
+	wrote := false
+	for _, specs := range groups {
+		if len(specs) == 0 {
+			continue
+		}
+		if wrote {
+			buf.WriteByte('\n')
+		}
+		specs = dedupeImports(specs)
+		sortImportSpecs(specs)
+		if err := writeImportSpecs(buf, fset, specs); err != nil {
+			return "", err
+		}
+		wrote = true
+	}
+	buf.WriteString(")\n")
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+func buildTypeSection(p *declPrinter, typeDecls []declItem, constructors map[string][]*ast.FuncDecl, methods map[string][]*ast.FuncDecl, cfg *config) string {
+	if len(typeDecls) == 0 && len(methods) == 0 {
+		return ""
+	}
+
+	parts := []string{}
+	seen := map[string]struct{}{}
+	for _, item := range typeDecls {
+		typeString := p.formatDecl(item.decl)
+		parts = append(parts, typeString)
+		for _, constructor := range constructors[item.name] {
+			parts = append(parts, p.formatFunc(constructor)...)
+		}
+		if cfg.methods == methodsAfterTypes {
+			continue
+		}
+		seen[item.name] = struct{}{}
+		parts = append(parts, p.formatMethods(methods[item.name])...)
+	}
+
+	remaining := []string{}
+	for name := range methods {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		remaining = append(remaining, name)
+	}
+
+	sort.Slice(remaining, func(i, j int) bool {
+		return cfg.less(remaining[i], remaining[j])
+	})
+	for _, name := range remaining {
+		parts = append(parts, p.formatMethods(methods[name])...)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func collectDeclStrings(p *declPrinter, decls []ast.Decl) string {
+	if len(decls) == 0 {
+		return ""
+	}
+	parts := []string{}
+	for _, decl := range decls {
+		part := p.formatDecl(decl)
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func collectFuncStrings(p *declPrinter, funcs []*ast.FuncDecl) string {
+	if len(funcs) == 0 {
+		return ""
+	}
+	parts := []string{}
+	for _, decl := range funcs {
+		parts = append(parts, p.formatFunc(decl)...)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// commentsForDecl returns the comments within the given declaration. This
+// includes the doc comments of nested fields and specs, as the printer only
+// uses the comments it is given once there are any.
+func commentsForDecl(comments []*ast.CommentGroup, decl ast.Decl) []*ast.CommentGroup {
+	start, end := declRange(decl)
+	if start == token.NoPos || end == token.NoPos {
+		return nil
+	}
+	// Empty comments within an empty block, e.g. `var ( // )`, are dropped, as
+	// gofmt drops them on its next run, and the output wouldn't be stable.
+	gen, ok := decl.(*ast.GenDecl)
+	empty := ok && len(gen.Specs) == 0
+	var filtered []*ast.CommentGroup
+	for _, comment := range comments {
+		if comment.Pos() < start || comment.End() > end {
+			continue
+		}
+		if empty && !slices.ContainsFunc(comment.List, func(c *ast.Comment) bool { return !isEmptyComment(c.Text) }) {
+			continue
+		}
+		filtered = append(filtered, comment)
+	}
+	return filtered
+}
+
+// compareNatural compares the given names, treating runs of digits as numbers,
+// so that e.g. Handler2 sorts before Handler10.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == "" || db == "" {
+			if a[0] != b[0] {
+				return int(a[0]) - int(b[0])
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		if len(na) != len(nb) {
+			return len(na) - len(nb)
+		}
+		if cmp := strings.Compare(na, nb); cmp != 0 {
+			return cmp
+		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	return len(a) - len(b)
+}
+
+// configBool returns the value of a setting which must be either true or
+// false.
+func configBool(filename string, kv *xon.KeyValue) (bool, error) {
+	value, err := configString(filename, kv)
+	if err != nil {
+		return false, err
+	}
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("alphafmt: invalid value for %q in config file %q: %q", kv.Key, filename, value)
+}
+
+func configList(filename string, kv *xon.KeyValue) ([]string, error) {
+	list, ok := kv.Value.(*xon.List)
+	if !ok {
+		return nil, fmt.Errorf("alphafmt: invalid value for %q in config file %q: expected a list", kv.Key, filename)
+	}
+	var values []string
+	for _, elem := range list.Content {
+		switch elem := elem.(type) {
+		case *xon.Comment:
+		case *xon.String:
+			values = append(values, elem.Value)
+		default:
+			return nil, fmt.Errorf("alphafmt: invalid value for %q in config file %q: expected a list of strings", kv.Key, filename)
+		}
+	}
+	return values, nil
+}
+
+func configString(filename string, kv *xon.KeyValue) (string, error) {
+	value, ok := kv.Value.(*xon.String)
+	if !ok {
+		return "", fmt.Errorf("alphafmt: invalid value for %q in config file %q: expected a string", kv.Key, filename)
+	}
+	return value.Value, nil
+}
+
+// constructorType returns the name of the type constructed by the given func,
+// if it is a constructor like NewFoo or MustFoo for one of the given types.
+// Otherwise, it returns an empty string.
+func constructorType(fn *ast.FuncDecl, types map[string]struct{}) string {
+	if fn.Recv != nil || fn.Type.Results == nil || len(fn.Type.Results.List) == 0 {
+		return ""
+	}
+	name := fn.Name.Name
+	prefix := false
+	for _, p := range []string{"New", "new", "Must", "must"} {
+		if rest, ok := strings.CutPrefix(name, p); ok {
+			if rest == "" || !unicode.IsLower(rune(rest[0])) {
+				prefix = true
+			}
+			break
+		}
+	}
+	if !prefix {
+		return ""
+	}
+	expr := fn.Type.Results.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch node := expr.(type) {
+	case *ast.IndexExpr:
+		expr = node.X
+	case *ast.IndexListExpr:
+		expr = node.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	if _, ok := types[ident.Name]; !ok {
+		return ""
+	}
+	return ident.Name
+}
+
+func declRange(decl ast.Decl) (token.Pos, token.Pos) {
+	switch node := decl.(type) {
+	case *ast.GenDecl:
+		if len(node.Specs) == 1 {
+			spec := node.Specs[0]
+			return spec.Pos(), spec.End()
+		}
+	}
+	return decl.Pos(), decl.End()
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func findModuleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// findPragmas returns the text of top-level comment groups containing compiler
+// directives, e.g. //go:generate or //go:linkname, which aren't attached to a
+// declaration, either as its doc or trailing comment. As they would otherwise
+// be lost when declarations are moved, they are kept in their original order,
+// directly after the imports.
+func findPragmas(fset *token.FileSet, file *ast.File, regions []*region) []string {
+	docs := map[*ast.CommentGroup]struct{}{}
+	for _, decl := range file.Decls {
+		switch node := decl.(type) {
+		case *ast.FuncDecl:
+			if node.Doc != nil {
+				docs[node.Doc] = struct{}{}
+			}
+		case *ast.GenDecl:
+			if node.Doc != nil {
+				docs[node.Doc] = struct{}{}
+			}
+		}
+	}
+	var pragmas []string
+	tf := fset.File(file.Pos())
+	for _, group := range file.Comments {
+		if group.Pos() < file.Name.Pos() || withinDecl(file, group) || inRegion(regions, group.Pos()) {
+			continue
+		}
+		if _, ok := docs[group]; ok {
+			continue
+		}
+		if !slices.ContainsFunc(group.List, isPragma) || trailsDecl(tf, file, group) {
+			continue
+		}
+		var lines []string
+		for _, comment := range group.List {
+			if markerText(comment.Text) == "" {
+				lines = append(lines, comment.Text)
+			}
+		}
+		pragmas = append(pragmas, strings.Join(lines, "\n"))
+	}
+	return pragmas
+}
+
+func findRegion(regions []*region, decl ast.Decl) *region {
+	for _, r := range regions {
+		if decl.Pos() >= r.start && decl.End() <= r.end {
+			return r
+		}
+	}
+	return nil
+}
+
+// findRegions returns the regions marked by top-level //alphafmt:off and
+// //alphafmt:on directives. A region starts at the beginning of the comment
+// group with the //alphafmt:off directive, as gofmt moves directives to the end
+// of doc comments, and the doc comment would otherwise be split by the region.
+func findRegions(fset *token.FileSet, file *ast.File, src []byte) []*region {
+	var (
+		current *region
+		regions []*region
+	)
+	tf := fset.File(file.Pos())
+	for _, group := range file.Comments {
+		if withinDecl(file, group) {
+			continue
+		}
+		start := group.Pos()
+		for _, comment := range group.List {
+			if !start.IsValid() {
+				start = comment.Pos()
+			}
+			switch strings.TrimSpace(comment.Text) {
+			case directiveOff:
+				if current == nil {
+					current = &region{start: start}
+				}
+			case directiveOn:
+				if current != nil {
+					current.end = comment.End()
+					regions = append(regions, current)
+					current = nil
+				}
+				start = token.NoPos
+			}
+		}
+	}
+	if current != nil {
+		current.end = token.Pos(tf.Base() + tf.Size())
+		regions = append(regions, current)
+	}
+	for _, r := range regions {
+		text := src[tf.Offset(r.start):tf.Offset(r.end)]
+		r.text = strings.TrimRight(string(text), "\n")
+	}
+	return regions
+}
+
+// findSectionMarkers returns the top-level section and fold region markers in
+// the given file, ignoring any within //alphafmt:off regions.
+func findSectionMarkers(file *ast.File, regions []*region) []*sectionMarker {
+	var markers []*sectionMarker
+	for _, group := range file.Comments {
+		if group.Pos() < file.Name.Pos() || withinDecl(file, group) {
+			continue
+		}
+		for _, comment := range group.List {
+			text := markerText(comment.Text)
+			if text == "" || inRegion(regions, comment.Pos()) {
+				continue
+			}
+			markers = append(markers, &sectionMarker{
+				pos:  comment.Pos(),
+				text: text,
+			})
+		}
+	}
+	return markers
+}
+
+func firstDeclName(decl ast.Decl) string {
+	gen, ok := decl.(*ast.GenDecl)
+	if !ok || len(gen.Specs) == 0 {
+		return ""
+	}
+
+	return specFirstName(gen.Specs[0])
+}
+
+func formatImportSpec(fset *token.FileSet, spec *ast.ImportSpec) (string, error) {
+	if spec == nil {
+		return "", nil
+	}
+	buf := &bytes.Buffer{}
+	cfg := &printer.Config{
+		Mode:     printer.TabIndent | printer.UseSpaces,
+		Tabwidth: 8,
+	}
+	if err := cfg.Fprint(buf, fset, spec); err != nil {
+		return "", fmt.Errorf("alphafmt: failed to format import spec: %w", err)
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+func formatSource(filename string, src []byte, cfg *config, opts *Options) ([]byte, error) {
+	if opts.FixImports {
+		var err error
+		src, err = fixImports(filename, src, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.removeUnusedImports {
+			clone := *cfg
+			clone.removeUnusedImports = true
+			cfg = &clone
+		}
+	}
+	if cfg.canonicalTags {
+		var err error
+		src, err = canonicalizeTags(filename, src, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.sortStructFields {
+		var err error
+		src, err = sortStructFields(filename, src, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.orderLiterals {
+		var err error
+		src, err = orderStructLiterals(filename, src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.sortMapKeys || bytes.Contains(src, []byte(directiveSortKeys)) {
+		var err error
+		src, err = sortMapLiterals(filename, src, cfg, cfg.sortMapKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSortDirectives(fset, file); err != nil {
+		return nil, err
+	}
+	ordered := src
+	switch {
+	case cfg.preserveOrder || hasIgnoreDirective(file):
+	case opts.Minimal:
+		ordered, err = orderFileDeclsMinimal(fset, file, src, cfg)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		ordered, err = orderFileDecls(fset, file, src, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := checkComments(fset, file, filename, ordered, cfg); err != nil {
+		return nil, err
+	}
+	out, err := format.Source(ordered)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.rules) > 0 && !opts.Minimal && !cfg.preserveOrder && !hasIgnoreDirective(file) {
+		out, err = applyRules(filename, out, cfg.rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Strict {
+		out, err = applyStrict(filename, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxLineLength > 0 {
+		return wrapLongLines(filename, out, opts.MaxLineLength)
+	}
+	return out, nil
+}
+
+func hasIgnoreDirective(file *ast.File) bool {
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if strings.TrimSpace(comment.Text) == directiveIgnore {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importGroup returns the index of the import group that the given path
+// belongs to. Explicit path patterns take precedence, with the longest
+// matching prefix winning. Otherwise, the path falls into the built-in std,
+// local, or third-party group, with the third-party group acting as the
+// fallback if the built-in group isn't listed.
+func importGroup(path string, groups []string, module string, std map[string]struct{}) int {
+	best, bestLen := -1, -1
+	for i, group := range groups {
+		switch group {
+		case groupLocal, groupStd, groupThirdParty:
+			continue
+		}
+		prefix := strings.TrimSuffix(group, "/...")
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		if len(prefix) > bestLen {
+			best, bestLen = i, len(prefix)
+		}
+	}
+	if best != -1 {
+		return best
+	}
+	builtin := groupThirdParty
+	switch {
+	case isStdImport(path, std):
+		builtin = groupStd
+	case isLocalImport(path, module):
+		builtin = groupLocal
+	}
+	if idx := slices.Index(groups, builtin); idx != -1 {
+		return idx
+	}
+	if idx := slices.Index(groups, groupThirdParty); idx != -1 {
+		return idx
+	}
+	return len(groups)
+}
+
+func importPath(spec *ast.ImportSpec) string {
+	if spec == nil || spec.Path == nil {
+		return ""
+	}
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return strings.Trim(spec.Path.Value, "\"")
+	}
+	return path
+}
+
+func inRegion(regions []*region, pos token.Pos) bool {
+	for _, r := range regions {
+		if pos >= r.start && pos < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// isFoldMarker reports whether the given comment text starts or ends an editor
+// fold region, e.g. "// region: Encoding", "//region Encoding", "// #region",
+// or "// endregion". To avoid matching prose, comments like "// region foo"
+// with a space after the slashes need a colon or a hash.
+func isFoldMarker(text string) bool {
+	rest := strings.TrimPrefix(strings.TrimSpace(text), "//")
+	spaced := rest != strings.TrimLeft(rest, " \t")
+	rest = strings.TrimLeft(rest, " \t")
+	rest, hash := strings.CutPrefix(rest, "#")
+	rest, end := strings.CutPrefix(rest, "end")
+	rest, ok := strings.CutPrefix(rest, "region")
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		return true
+	}
+	switch rest[0] {
+	case ':':
+		return true
+	case ' ', '\t':
+		return hash || !spaced || (end && strings.TrimSpace(rest) == "")
+	}
+	return false
+}
+
+func isLocalImport(path string, module string) bool {
+	if module == "" {
+		return false
+	}
+	return path == module || strings.HasPrefix(path, module+"/")
+}
+
+// isPragma reports whether the given comment is a compiler directive.
+func isPragma(comment *ast.Comment) bool {
+	return strings.HasPrefix(comment.Text, "//go:") || strings.HasPrefix(comment.Text, "//line ")
+}
+
+// isStdImport reports whether the given import path is for a std package. If
+// std is nil, this is guessed from the first path element not containing a
+// dot.
+func isStdImport(path string, std map[string]struct{}) bool {
+	if std != nil {
+		_, ok := std[path]
+		return ok
+	}
+	if path == "" {
+		return true
+	}
+	if strings.HasPrefix(path, ".") {
+		return false
+	}
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// lineEnd returns the offset just past the newline that ends the line
+// containing the given offset.
+func lineEnd(src []byte, offset int) int {
+	idx := bytes.IndexByte(src[offset:], '\n')
+	if idx == -1 {
+		return len(src)
+	}
+	return offset + idx + 1
+}
+
+// loadConfig returns the config for the directory containing the given path,
+// or for the path itself if it is a directory.
+func loadConfig(path string) (*config, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := abs
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+	root := findModuleRoot(dir)
+	configMu.Lock()
+	defer configMu.Unlock()
+	return loadDirConfig(dir, root)
+}
+
+// loadDirConfig returns the config for the given directory within the module
+// at root. The config is read from the .alphafmt file at the module root, if
+// one exists, with any .alphafmt files in the directories between the root and
+// the given directory overriding the settings they specify. Configs are cached
+// until any of the files they were loaded from are modified. The caller must
+// hold configMu.
+func loadDirConfig(dir string, root string) (*config, error) {
+	var parent *config
+	if root != "" && dir != root {
+		var err error
+		parent, err = loadDirConfig(filepath.Dir(dir), root)
+		if err != nil {
+			return nil, err
+		}
+	}
+	filename := filepath.Join(dir, configFile)
+	entry := &cachedConfig{configTime: modTime(filename), parent: parent}
+	if root != "" && dir == root {
+		entry.modTime = modTime(filepath.Join(root, "go.mod"))
+	}
+	if cached, ok := configs[dir]; ok && cached.parent == parent && cached.configTime.Equal(entry.configTime) && cached.modTime.Equal(entry.modTime) {
+		return cached.cfg, nil
+	}
+	var cfg *config
+	if root == "" || dir == root {
+		cfg = &config{
+			importGroups:   []string{groupStd, groupThirdParty, groupLocal},
+			methods:        methodsWithType,
+			root:           root,
+			sortOrder:      sortBytewise,
+			sortVarBlocks:  true,
+			stdImports:     stdHeuristic,
+			structTagOrder: []string{"json", "xon", "db"},
+		}
+		if root == "" {
+			entry.cfg = cfg
+			configs[dir] = entry
+			return cfg, nil
+		}
+		var err error
+		cfg.module, err = readModulePath(filepath.Join(root, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cfg = parent
+	}
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		if dir != root {
+			// The parent's config is shared with its other subdirectories.
+			clone := *cfg
+			clone.skipDirs = slices.Clone(cfg.skipDirs)
+			cfg = &clone
+		}
+		err = parseConfig(cfg, filename, data)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry.cfg = cfg
+	configs[dir] = entry
+	return cfg, nil
+}
+
+// markerText returns the canonical text of the given comment if it is a
+// section marker or fold region marker, or an empty string otherwise. The
+// markers for fold regions are kept as is, so that editors still recognize
+// them.
+func markerText(text string) string {
+	if name := sectionName(text); name != "" {
+		return "// --- Section: " + name + " ---"
+	}
+	if isFoldMarker(text) {
+		return strings.TrimSpace(text)
+	}
+	return ""
+}
+
+// modTime returns the modification time of the file at the given path, or the
+// zero time if it doesn't exist.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// normalizedDecl returns the given declaration source as formatted by gofmt,
+// along with its parsed form, so that entries which share a line are split
+// onto their own lines before being sorted. It returns a nil decl if the
+// source is already formatted, or can't be parsed on its own.
+func normalizedDecl(text string) (*token.FileSet, []byte, *ast.GenDecl) {
+	const header = "package p\n\n"
+	out, err := format.Source([]byte(header + text))
+	if err != nil || string(out) == header+text {
+		return nil, nil, nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", out, parser.ParseComments)
+	if err != nil || len(file.Decls) != 1 {
+		return nil, nil, nil
+	}
+	decl, ok := file.Decls[0].(*ast.GenDecl)
+	if !ok {
+		return nil, nil, nil
+	}
+	return fset, out, decl
+}
+
+// orderDecls returns the formatted source for the given non-import
+// declarations, sorted into sections.
+func orderDecls(p *declPrinter, decls []ast.Decl, src []byte, cfg *config, regions []*region) string {
+	var benchmarks []*ast.FuncDecl
+	var constBlocks []ast.Decl
+	var constSingles []declItem
+	var examples []*ast.FuncDecl
+	var funcs []*ast.FuncDecl
+	var fuzzTests []*ast.FuncDecl
+	var initFuncs []*ast.FuncDecl
+	var mainFuncs []*ast.FuncDecl
+	var typeDecls []declItem
+	var varBlocks []ast.Decl
+	var varSingles []declItem
+
+	methods := map[string][]*ast.FuncDecl{}
+	// Sorted blocks are placed according to their first entry once sorted,
+	// so that the result is stable.
+	sortedNames := map[ast.Decl]string{}
+	sortBlock := func(block ast.Decl, node *ast.GenDecl) {
+		if text := sortBlockSpecs(p.fset, src, node, cfg); text != "" {
+			p.verbatim[block] = text
+			name := ""
+			for _, spec := range node.Specs {
+				if first := specFirstName(spec); name == "" || cfg.less(first, name) {
+					name = first
+				}
+			}
+			sortedNames[block] = name
+		}
+	}
+	blockName := func(decl ast.Decl) string {
+		if name, ok := sortedNames[decl]; ok {
+			return name
+		}
+		return firstDeclName(decl)
+	}
+	for _, decl := range decls {
+		frozen := false
+		if r := findRegion(regions, decl); r != nil {
+			if r.placed {
+				continue
+			}
+			r.placed = true
+			p.verbatim[decl] = r.text
+			frozen = true
+		}
+		switch node := decl.(type) {
+		case *ast.GenDecl:
+			if frozen {
+				// The region is placed according to its first declaration.
+				switch node.Tok {
+				case token.CONST:
+					constBlocks = append(constBlocks, node)
+				case token.VAR:
+					varBlocks = append(varBlocks, node)
+				case token.TYPE:
+					typeDecls = append(typeDecls, declItem{
+						name: firstDeclName(node),
+						decl: node,
+					})
+				}
+				continue
+			}
+			switch node.Tok {
+			case token.CONST:
+				block, singles := splitValueDecls(node)
+				if block != nil {
+					// Const blocks are only sorted on request, and never if
+					// their values depend on the order of their entries.
+					if hasSortDirective(node.Doc) {
+						sortBlock(block, node)
+					}
+					constBlocks = append(constBlocks, block)
+					continue
+				}
+				if len(singles) > 0 {
+					p.floating.move(node, singles[0].decl)
+				}
+				constSingles = append(constSingles, singles...)
+			case token.VAR:
+				block, singles := splitValueDecls(node)
+				if block != nil {
+					if cfg.sortVarBlocks {
+						sortBlock(block, node)
+					}
+					varBlocks = append(varBlocks, block)
+					continue
+				}
+				if len(singles) > 0 {
+					p.floating.move(node, singles[0].decl)
+				}
+				varSingles = append(varSingles, singles...)
+			case token.TYPE:
+				items, floating := splitTypeDecls(p.fset, node, p.comments)
+				if len(items) > 0 {
+					p.floating.move(node, items[0].decl)
+				}
+				for decl, groups := range floating {
+					p.floating.leading[decl] = append(p.floating.leading[decl], groups...)
+				}
+				for _, item := range items {
+					if text := sortInterfaceMethods(p.fset, src, item.decl.(*ast.GenDecl), cfg); text != "" {
+						p.verbatim[item.decl] = text
+					}
+				}
+				typeDecls = append(typeDecls, items...)
+			}
+		case *ast.FuncDecl:
+			// Attached functions are written after their anchor.
+			if _, ok := p.anchors[node]; ok {
+				continue
+			}
+			if node.Recv != nil {
+				recvName := receiverTypeName(node.Recv)
+				if recvName == "" {
+					funcs = append(funcs, node)
+					continue
+				}
+				methods[recvName] = append(methods[recvName], node)
+				continue
+			}
+			switch node.Name.Name {
+			case "main":
+				mainFuncs = append(mainFuncs, node)
+			case "init":
+				initFuncs = append(initFuncs, node)
+			default:
+				if !p.testFile {
+					funcs = append(funcs, node)
+					continue
+				}
+				switch testFuncRank(node) {
+				case rankBenchmark:
+					benchmarks = append(benchmarks, node)
+				case rankExample:
+					examples = append(examples, node)
+				case rankFuzz:
+					fuzzTests = append(fuzzTests, node)
+				default:
+					funcs = append(funcs, node)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(constBlocks, func(i, j int) bool {
+		return cfg.less(blockName(constBlocks[i]), blockName(constBlocks[j]))
+	})
+	sort.SliceStable(constSingles, func(i, j int) bool {
+		return cfg.less(constSingles[i].name, constSingles[j].name)
+	})
+	sort.SliceStable(varSingles, func(i, j int) bool {
+		return cfg.less(varSingles[i].name, varSingles[j].name)
+	})
+	sort.SliceStable(varBlocks, func(i, j int) bool {
+		return cfg.less(blockName(varBlocks[i]), blockName(varBlocks[j]))
+	})
+	sort.SliceStable(typeDecls, func(i, j int) bool {
+		return cfg.less(typeDecls[i].name, typeDecls[j].name)
+	})
+	for _, list := range [][]*ast.FuncDecl{benchmarks, examples, funcs, fuzzTests} {
+		sort.SliceStable(list, func(i, j int) bool {
+			return cfg.less(list[i].Name.Name, list[j].Name.Name)
+		})
+	}
+
+	constructors := map[string][]*ast.FuncDecl{}
+	if cfg.constructorsWithType {
+		types := map[string]struct{}{}
+		for _, item := range typeDecls {
+			for _, spec := range item.decl.(*ast.GenDecl).Specs {
+				types[spec.(*ast.TypeSpec).Name.Name] = struct{}{}
+			}
+		}
+		remaining := funcs[:0]
+		for _, fn := range funcs {
+			if name := constructorType(fn, types); name != "" {
+				constructors[name] = append(constructors[name], fn)
+				continue
+			}
+			remaining = append(remaining, fn)
+		}
+		funcs = remaining
+	}
+	if p.testFile {
+		// TestMain sets up the other tests, so it is kept first.
+		if idx := slices.IndexFunc(funcs, func(fn *ast.FuncDecl) bool {
+			return fn.Name.Name == "TestMain"
+		}); idx > 0 {
+			main := funcs[idx]
+			copy(funcs[1:idx+1], funcs[:idx])
+			funcs[0] = main
+		}
+	}
+
+	for recv := range methods {
+		sort.SliceStable(methods[recv], func(i, j int) bool {
+			return cfg.less(methods[recv][i].Name.Name, methods[recv][j].Name.Name)
+		})
+	}
+
+	var sections []string
+	for _, section := range []string{
+		collectDeclStrings(p, appendDeclItems(constBlocks, constSingles)),
+		collectDeclStrings(p, appendDeclItems(varBlocks, varSingles)),
+		buildTypeSection(p, typeDecls, constructors, methods, cfg),
+		collectFuncStrings(p, funcs),
+		collectFuncStrings(p, benchmarks),
+		collectFuncStrings(p, fuzzTests),
+		collectFuncStrings(p, examples),
+		collectFuncStrings(p, mainFuncs),
+		collectFuncStrings(p, initFuncs),
+	} {
+		if section != "" {
+			sections = append(sections, section)
+		}
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+func orderFileDecls(fset *token.FileSet, file *ast.File, src []byte, cfg *config) ([]byte, error) {
+	var importDecls []ast.Decl
+	p := &declPrinter{
+		comments: file.Comments,
+		fset:     fset,
+		verbatim: map[ast.Decl]string{},
+	}
+	regions := findRegions(fset, file, src)
+	markers := findSectionMarkers(file, regions)
+	parts := make([][]ast.Decl, len(markers)+1)
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			if findRegion(regions, decl) != nil {
+				return nil, fmt.Errorf("alphafmt: %s: import declarations cannot be within an %s region", fset.Position(gen.Pos()), directiveOff)
+			}
+			importDecls = append(importDecls, decl)
+			continue
+		}
+		idx := 0
+		for idx < len(markers) && markers[idx].pos < decl.Pos() {
+			idx++
+		}
+		parts[idx] = append(parts[idx], decl)
+	}
+	p.floating = planComments(fset, file, regions, markers, parts, cfg)
+	filename := fset.Position(file.Pos()).Filename
+	p.testFile = strings.HasSuffix(filename, "_test.go")
+	anchors, err := findAnchors(fset, filename, slices.Concat(parts...), regions, markers)
+	if err != nil {
+		return nil, err
+	}
+	p.anchors = anchors
+	p.attached = map[*ast.FuncDecl][]*ast.FuncDecl{}
+	for fn, anchor := range p.anchors {
+		p.attached[anchor] = append(p.attached[anchor], fn)
+	}
+	for _, list := range p.attached {
+		sort.Slice(list, func(i, j int) bool {
+			return cfg.less(list[i].Name.Name, list[j].Name.Name)
+		})
+	}
+	std, err := loadStdPackages(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var unused map[*ast.ImportSpec]struct{}
+	if cfg.removeUnusedImports {
+		dir, err := filepath.Abs(filepath.Dir(filename))
+		if err != nil {
+			return nil, err
+		}
+		unused = unusedImports(file, dir)
+	}
+	if len(cfg.methodGroups) > 0 {
+		dir, err := filepath.Abs(filepath.Dir(fset.Position(file.Pos()).Filename))
+		if err != nil {
+			return nil, err
+		}
+		p.methodGroups, err = loadMethodGroups(cfg, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	writeLeadingComments(buf, fset, file)
+	buf.WriteString("package ")
+	buf.WriteString(file.Name.Name)
+	buf.WriteByte('\n')
+
+	wrote := false
+	appendSection := func(section string) {
+		if section == "" {
+			return
+		}
+		if !wrote {
+			buf.WriteByte('\n')
+			wrote = true
+		} else {
+			buf.WriteString("\n\n")
+		}
+		buf.WriteString(section)
+	}
+
+	imports, err := buildImportSection(fset, importDecls, cfg, std, unused)
+	if err != nil {
+		return nil, err
+	}
+	appendSection(strings.Join(p.floating.preamble, "\n\n"))
+	appendSection(imports)
+	appendSection(strings.Join(findPragmas(fset, file, regions), "\n\n"))
+	for i, decls := range parts {
+		if i > 0 {
+			appendSection(markers[i-1].text)
+		}
+		appendSection(orderDecls(p, decls, src, cfg, regions))
+		appendSection(strings.Join(p.floating.trailing[i], "\n\n"))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return buf.Bytes(), nil
+}
+
+func parseConfig(cfg *config, filename string, data []byte) error {
+	nodes, err := xon.Parse(data)
+	if err != nil {
+		return fmt.Errorf("alphafmt: failed to parse config file %q: %w", filename, err)
+	}
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *xon.Comment:
+		case *xon.KeyValue:
+			switch node.Key {
+			case "canonical struct tags":
+				cfg.canonicalTags, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "constructors with type":
+				cfg.constructorsWithType, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "embedded fields first":
+				cfg.embeddedFirst, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "import groups":
+				groups, err := configList(filename, node)
+				if err != nil {
+					return err
+				}
+				cfg.importGroups = nil
+				for _, group := range groups {
+					if slices.Contains(cfg.importGroups, group) {
+						return fmt.Errorf("alphafmt: duplicate import group %q in config file %q", group, filename)
+					}
+					cfg.importGroups = append(cfg.importGroups, group)
+				}
+			case "method groups":
+				names, err := configList(filename, node)
+				if err != nil {
+					return err
+				}
+				cfg.methodGroups = names
+			case "methods":
+				value, err := configString(filename, node)
+				if err != nil {
+					return err
+				}
+				if value != methodsAfterTypes && value != methodsWithType {
+					return fmt.Errorf("alphafmt: invalid value for %q in config file %q: %q", node.Key, filename, value)
+				}
+				cfg.methods = value
+			case "order struct literals":
+				cfg.orderLiterals, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "preserve order":
+				cfg.preserveOrder, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "remove unused imports":
+				cfg.removeUnusedImports, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "rules":
+				names, err := configList(filename, node)
+				if err != nil {
+					return err
+				}
+				cfg.rules = names
+			case "skip":
+				dirs, err := configList(filename, node)
+				if err != nil {
+					return err
+				}
+				// Paths are relative to the directory of the config file.
+				prefix, err := filepath.Rel(cfg.root, filepath.Dir(filename))
+				if err != nil {
+					return err
+				}
+				for _, dir := range dirs {
+					dir = strings.Trim(dir, "/")
+					if prefix != "." && strings.Contains(dir, "/") {
+						dir = path.Join(filepath.ToSlash(prefix), dir)
+					}
+					cfg.skipDirs = append(cfg.skipDirs, dir)
+				}
+			case "sort":
+				value, err := configString(filename, node)
+				if err != nil {
+					return err
+				}
+				switch value {
+				case sortBytewise, sortICase, sortNatural:
+					cfg.sortOrder = value
+				default:
+					return fmt.Errorf("alphafmt: invalid value for %q in config file %q: %q", node.Key, filename, value)
+				}
+			case "sort map keys":
+				cfg.sortMapKeys, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "sort struct fields":
+				cfg.sortStructFields, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "sort var blocks":
+				cfg.sortVarBlocks, err = configBool(filename, node)
+				if err != nil {
+					return err
+				}
+			case "std imports":
+				value, err := configString(filename, node)
+				if err != nil {
+					return err
+				}
+				if value != stdGoList && value != stdHeuristic {
+					return fmt.Errorf("alphafmt: invalid value for %q in config file %q: %q", node.Key, filename, value)
+				}
+				cfg.stdImports = value
+			case "struct tag order":
+				keys, err := configList(filename, node)
+				if err != nil {
+					return err
+				}
+				cfg.structTagOrder = keys
+			default:
+				return fmt.Errorf("alphafmt: unknown setting %q in config file %q", node.Key, filename)
+			}
+		default:
+			return fmt.Errorf("alphafmt: unexpected block in config file %q", filename)
+		}
+	}
+	return nil
+}
+
+// readModulePath returns the module path declared in the given go.mod file.
+func readModulePath(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	for line := range strings.Lines(string(data)) {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if path, err := strconv.Unquote(fields[1]); err == nil {
+			return path, nil
+		}
+		return fields[1], nil
+	}
+	return "", nil
+}
+
+func receiverTypeName(fieldList *ast.FieldList) string {
+	if fieldList == nil || len(fieldList.List) == 0 {
+		return ""
+	}
+	return typeName(fieldList.List[0].Type)
+}
+
+// sectionName returns the name of the section if the given comment text is a
+// section marker, or an empty string otherwise.
+func sectionName(text string) string {
+	match := sectionPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// sortBlockSpecs returns the source for a var or const block with its entries
+// sorted by name. Comments and blank lines preceding an entry, as well as any
+// comment on the same line, are moved along with it, so that e.g. //go:embed
+// directives stay with their vars.
+//
+// Entries which share a line are split onto their own lines first, as gofmt
+// would. An empty string is returned if the order is unchanged, or for const
+// blocks whose values depend on the order of their entries, e.g. via iota.
+func sortBlockSpecs(fset *token.FileSet, src []byte, decl *ast.GenDecl, cfg *config) string {
+	specs := decl.Specs
+	if len(specs) < 2 || orderDependentSpec(decl) != nil {
+		return ""
+	}
+	order := make([]int, len(specs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return cfg.less(specFirstName(specs[order[i]]), specFirstName(specs[order[j]]))
+	})
+	if slices.IsSorted(order) {
+		return ""
+	}
+
+	tf := fset.File(decl.Pos())
+	prevLine := tf.Line(decl.Lparen)
+	ownLines := true
+	for _, spec := range specs {
+		start := spec.Pos()
+		if doc := spec.(*ast.ValueSpec).Doc; doc != nil {
+			start = doc.Pos()
+		}
+		if tf.Line(start) <= prevLine {
+			ownLines = false
+		}
+		prevLine = tf.Line(spec.End())
+	}
+	if !ownLines || tf.Line(decl.Rparen) <= prevLine {
+		// Sort the entries once gofmt has split them onto their own lines,
+		// so that the output is the same as for the formatted source.
+		start := tf.Offset(decl.Pos())
+		if decl.Doc != nil {
+			start = tf.Offset(decl.Doc.Pos())
+		}
+		text := string(src[start:lineEnd(src, tf.Offset(decl.Rparen))])
+		if fset, src, decl := normalizedDecl(text); decl != nil {
+			return sortBlockSpecs(fset, src, decl, cfg)
+		}
+		return ""
+	}
+
+	chunks := make([]string, len(specs))
+	start := lineEnd(src, tf.Offset(decl.Lparen))
+	for i, spec := range specs {
+		end := lineEnd(src, tf.Offset(spec.End()))
+		chunks[i] = string(src[start:end])
+		start = end
+	}
+
+	buf := &strings.Builder{}
+	if decl.Doc != nil {
+		for _, line := range decl.Doc.List {
+			buf.WriteString(line.Text)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.Write(src[tf.Offset(decl.Pos()):lineEnd(src, tf.Offset(decl.Lparen))])
+	for i, idx := range order {
+		chunk := chunks[idx]
+		if i == 0 {
+			chunk = trimBlankLines(chunk)
+		}
+		buf.WriteString(chunk)
+	}
+	buf.WriteString(strings.TrimRight(string(src[start:lineEnd(src, tf.Offset(decl.Rparen))]), "\n"))
+	return buf.String()
+}
+
+func sortImportSpecs(specs []*ast.ImportSpec) {
+	sort.SliceStable(specs, func(i, j int) bool {
+		return importPath(specs[i]) < importPath(specs[j])
+	})
+}
+
+// sortInterfaceMethods returns the source for a type declaration with the
+// methods of its interface sorted by name, after any embedded types. Comments
+// and blank lines preceding a method are moved along with it.
+//
+// Methods which share a line are split onto their own lines first, as gofmt
+// would. An empty string is returned if the order is unchanged.
+func sortInterfaceMethods(fset *token.FileSet, src []byte, decl *ast.GenDecl, cfg *config) string {
+	spec := decl.Specs[0].(*ast.TypeSpec)
+	iface, ok := spec.Type.(*ast.InterfaceType)
+	if !ok || len(iface.Methods.List) < 2 {
+		return ""
+	}
+	fields := iface.Methods.List
+	order := make([]int, len(fields))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := fields[order[i]], fields[order[j]]
+		if len(a.Names) == 0 || len(b.Names) == 0 {
+			return len(a.Names) == 0 && len(b.Names) != 0
+		}
+		return cfg.less(a.Names[0].Name, b.Names[0].Name)
+	})
+	if slices.IsSorted(order) {
+		return ""
+	}
+
+	tf := fset.File(spec.Pos())
+	prevLine := tf.Line(iface.Methods.Opening)
+	ownLines := true
+	for _, field := range fields {
+		start := field.Pos()
+		if field.Doc != nil {
+			start = field.Doc.Pos()
+		}
+		if tf.Line(start) <= prevLine {
+			ownLines = false
+		}
+		prevLine = tf.Line(field.End())
+	}
+	if !ownLines || tf.Line(iface.Methods.Closing) <= prevLine {
+		// Sort the methods once gofmt has split them onto their own lines,
+		// as for var and const blocks.
+		text := &strings.Builder{}
+		if decl.Doc != nil {
+			for _, line := range decl.Doc.List {
+				text.WriteString(line.Text + "\n")
+			}
+		}
+		text.WriteString("type ")
+		text.Write(src[tf.Offset(spec.Pos()):tf.Offset(spec.End())])
+		if spec.Comment != nil {
+			for _, line := range spec.Comment.List {
+				text.WriteString(" " + line.Text)
+			}
+		}
+		if fset, src, decl := normalizedDecl(text.String() + "\n"); decl != nil && len(decl.Specs) == 1 {
+			return sortInterfaceMethods(fset, src, decl, cfg)
+		}
+		return ""
+	}
+
+	chunks := make([]string, len(fields))
+	start := lineEnd(src, tf.Offset(iface.Methods.Opening))
+	for i, field := range fields {
+		end := lineEnd(src, tf.Offset(field.End()))
+		chunks[i] = string(src[start:end])
+		start = end
+	}
+
+	buf := &strings.Builder{}
+	if decl.Doc != nil {
+		for _, line := range decl.Doc.List {
+			buf.WriteString(line.Text)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteString("type ")
+	buf.Write(src[tf.Offset(spec.Pos()):lineEnd(src, tf.Offset(iface.Methods.Opening))])
+	for i, idx := range order {
+		chunk := chunks[idx]
+		if i == 0 {
+			chunk = trimBlankLines(chunk)
+		}
+		buf.WriteString(chunk)
+	}
+	buf.Write(src[start:tf.Offset(spec.End())])
+	if spec.Comment != nil {
+		for _, line := range spec.Comment.List {
+			buf.WriteByte(' ')
+			buf.WriteString(line.Text)
+		}
+	}
+	return buf.String()
+}
+
+func specFirstName(spec ast.Spec) string {
+	switch typed := spec.(type) {
+	case *ast.ValueSpec:
+		if len(typed.Names) == 0 {
+			return ""
+		}
+		return typed.Names[0].Name
+	case *ast.TypeSpec:
+		if typed.Name == nil {
+			return ""
+		}
+		return typed.Name.Name
+	default:
+		return ""
+	}
+}
+
+// splitTypeDecls splits the given type declaration into a declaration for each
+// of its specs. The comments within a group that don't belong to any spec are
+// returned as floating comments, keyed by the declaration of the spec that
+// follows them, or the last spec. This includes the group's doc comment when
+// the first spec has a doc comment of its own, e.g. one on the line of the
+// opening parenthesis.
+func splitTypeDecls(fset *token.FileSet, decl *ast.GenDecl, comments []*ast.CommentGroup) ([]declItem, map[ast.Decl][]string) {
+	var (
+		items []declItem
+		specs []*ast.TypeSpec
+	)
+	owned := map[*ast.CommentGroup]struct{}{}
+	for _, spec := range decl.Specs {
+		typeSpec, ok := spec.(*ast.TypeSpec)
+		if !ok {
+			continue
+		}
+		newTypeSpec := &ast.TypeSpec{
+			Assign:     typeSpec.Assign,
+			Comment:    typeSpec.Comment,
+			Name:       typeSpec.Name,
+			Type:       typeSpec.Type,
+			TypeParams: typeSpec.TypeParams,
+		}
+		newDecl := &ast.GenDecl{
+			Doc:   typeSpec.Doc,
+			Specs: []ast.Spec{newTypeSpec},
+			Tok:   token.TYPE,
+		}
+		for _, group := range []*ast.CommentGroup{typeSpec.Doc, typeSpec.Comment} {
+			if group != nil {
+				owned[group] = struct{}{}
+			}
+		}
+		items = append(items, declItem{
+			name: typeSpec.Name.Name,
+			decl: newDecl,
+		})
+		specs = append(specs, typeSpec)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	loose := make([][]*ast.CommentGroup, len(specs))
+	if decl.Lparen.IsValid() {
+		for _, group := range comments {
+			if group.Pos() < decl.Lparen || group.End() > decl.Rparen {
+				continue
+			}
+			if _, ok := owned[group]; ok {
+				continue
+			}
+			idx := len(specs) - 1
+			for i, spec := range specs {
+				if spec.Pos() <= group.Pos() && group.End() <= spec.End() {
+					idx = -1
+					break
+				}
+				if group.End() < spec.Pos() {
+					idx = i
+					break
+				}
+			}
+			if idx >= 0 {
+				loose[idx] = append(loose[idx], group)
+			}
+		}
+	}
+	first := items[0].decl.(*ast.GenDecl)
+	// A comment directly above the first spec, which the parser doesn't
+	// attach when it follows the opening parenthesis, is its doc comment.
+	if n := len(loose[0]); first.Doc == nil && n > 0 && fset.Position(loose[0][n-1].End()).Line+1 == fset.Position(specs[0].Pos()).Line {
+		first.Doc = loose[0][n-1]
+		loose[0] = loose[0][:n-1]
+	}
+	if decl.Doc != nil {
+		if first.Doc == nil {
+			first.Doc = decl.Doc
+		} else {
+			loose[0] = append([]*ast.CommentGroup{decl.Doc}, loose[0]...)
+		}
+	}
+	floating := map[ast.Decl][]string{}
+	for i, groups := range loose {
+		for _, group := range groups {
+			if lines := commentLines(group); len(lines) > 0 {
+				floating[items[i].decl] = append(floating[items[i].decl], strings.Join(lines, "\n"))
+			}
+		}
+	}
+	return items, floating
+}
+
+func splitValueDecls(decl *ast.GenDecl) (ast.Decl, []declItem) {
+	if decl.Lparen != token.NoPos {
+		return decl, nil
+	}
+
+	var singles []declItem
+	for i, spec := range decl.Specs {
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok || len(valueSpec.Names) == 0 {
+			continue
+		}
+		newValueSpec := &ast.ValueSpec{
+			Comment: valueSpec.Comment,
+			Names:   valueSpec.Names,
+			Type:    valueSpec.Type,
+			Values:  valueSpec.Values,
+		}
+		newDecl := &ast.GenDecl{
+			Specs: []ast.Spec{newValueSpec},
+			Tok:   decl.Tok,
+		}
+		if valueSpec.Doc != nil {
+			newDecl.Doc = valueSpec.Doc
+		} else if i == 0 && decl.Doc != nil {
+			newDecl.Doc = decl.Doc
+		}
+		singles = append(singles, declItem{
+			name: valueSpec.Names[0].Name,
+			decl: newDecl,
+		})
+	}
+	return nil, singles
+}
+
+// trimBlankLines removes any leading lines that only contain whitespace.
+func trimBlankLines(text string) string {
+	for {
+		line, rest, ok := strings.Cut(text, "\n")
+		if !ok || strings.TrimSpace(line) != "" {
+			return text
+		}
+		text = rest
+	}
+}
+
+func typeName(expr ast.Expr) string {
+	switch node := expr.(type) {
+	case *ast.Ident:
+		return node.Name
+	case *ast.StarExpr:
+		return typeName(node.X)
+	case *ast.IndexExpr:
+		return typeName(node.X)
+	case *ast.IndexListExpr:
+		return typeName(node.X)
+	case *ast.SelectorExpr:
+		return node.Sel.Name
+	default:
+		return ""
+	}
+}
+
+func withinDecl(file *ast.File, group *ast.CommentGroup) bool {
+	for _, decl := range file.Decls {
+		if decl.Pos() <= group.Pos() && group.End() <= decl.End() {
+			return true
+		}
+	}
+	return false
+}
+
+func writeImportSpecs(buf *bytes.Buffer, fset *token.FileSet, specs []*ast.ImportSpec) error {
+	for _, spec := range specs {
+		formatted, err := formatImportSpec(fset, spec)
+		if err != nil {
+			return err
+		}
+		if formatted == "" {
+			continue
+		}
+		lines := strings.Split(formatted, "\n")
+		for _, line := range lines {
+			if line != "" {
+				buf.WriteByte('\t')
+				buf.WriteString(line)
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	return nil
+}
+
+func writeLeadingComments(buf *bytes.Buffer, fset *token.FileSet, file *ast.File) {
+	var leading []*ast.CommentGroup
+	for _, comment := range file.Comments {
+		if comment.End() >= file.Name.Pos() {
+			break
+		}
+		leading = append(leading, comment)
+	}
+	for i, comment := range leading {
+		for _, line := range comment.List {
+			buf.WriteString(line.Text)
+			buf.WriteByte('\n')
+		}
+		nextLine := fset.Position(file.Name.Pos()).Line
+		if i+1 < len(leading) {
+			nextLine = fset.Position(leading[i+1].Pos()).Line
+		}
+		endLine := fset.Position(comment.End()).Line
+		if nextLine > endLine+1 {
+			buf.WriteByte('\n')
+		}
+	}
+}
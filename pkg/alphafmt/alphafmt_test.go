@@ -0,0 +1,1303 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"espra.dev/pkg/alphafmt"
+)
+
+// bannedImports is a rule which rejects files importing "unsafe".
+type bannedImports struct{}
+
+func (bannedImports) Name() string {
+	return "banned-imports"
+}
+
+func (bannedImports) Order(fset *token.FileSet, file *ast.File) ([]ast.Decl, error) {
+	for _, spec := range file.Imports {
+		if spec.Path.Value == `"unsafe"` {
+			return nil, errors.New("unsafe must not be imported")
+		}
+	}
+	return nil, nil
+}
+
+// exportedFirst is a rule which moves exported funcs before all other
+// declarations.
+type exportedFirst struct{}
+
+func (exportedFirst) Name() string {
+	return "exported-first"
+}
+
+func (exportedFirst) Order(fset *token.FileSet, file *ast.File) ([]ast.Decl, error) {
+	decls := slices.Clone(file.Decls)
+	slices.SortStableFunc(decls, func(a, b ast.Decl) int {
+		return rank(a) - rank(b)
+	})
+	return decls, nil
+}
+
+func TestFormat(t *testing.T) {
+	src := `package main
+
+func main() {}
+
+// b has a doc comment, as well as comments within its body.
+func b() {
+	// Do nothing.
+}
+
+type T struct{}
+
+func a() {} // a has a trailing comment.
+
+const c = 1
+`
+	want := `package main
+
+const c = 1
+
+type T struct{}
+
+func a() {} // a has a trailing comment.
+
+// b has a doc comment, as well as comments within its body.
+func b() {
+	// Do nothing.
+}
+
+func main() {}
+`
+	got, err := alphafmt.Format("main.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	_, err = alphafmt.Format("main.go", []byte("package main\n\nfunc ("))
+	if err == nil {
+		t.Fatalf("expected an error when formatting invalid source")
+	}
+}
+
+func TestFormatConfigErrors(t *testing.T) {
+	src := []byte("package main\n")
+	for _, tt := range []struct {
+		config string
+		want   string
+	}{
+		{"preserve order = false\nsort var blocks = true\n", ""},
+		{"sort var blocks = yes\n", `invalid value for "sort var blocks" in config file %q: "yes"`},
+		{"sort map keys = [true]\n", `invalid value for "sort map keys" in config file %q: expected a string`},
+		{"sort everything = true\n", `unknown setting "sort everything" in config file %q`},
+	} {
+		dir := tempModule(t, tt.config)
+		_, err := alphafmt.Format(filepath.Join(dir, "main.go"), src)
+		if tt.want == "" {
+			if err != nil {
+				t.Fatalf("failed to format source with config %q: %v", tt.config, err)
+			}
+			continue
+		}
+		want := "alphafmt: " + fmt.Sprintf(tt.want, filepath.Join(dir, ".alphafmt"))
+		if err == nil || err.Error() != want {
+			t.Fatalf("unexpected error for config %q: got %v, want %s", tt.config, err, want)
+		}
+	}
+}
+
+func TestFormatConstBlocks(t *testing.T) {
+	src := `package main
+
+//alphafmt:sort
+const (
+	Zulu  = "z"
+	Alpha = "a" // first
+)
+
+const (
+	B = "b"
+	A = "a"
+)
+`
+	want := `package main
+
+//alphafmt:sort
+const (
+	Alpha = "a" // first
+	Zulu  = "z"
+)
+
+const (
+	B = "b"
+	A = "a"
+)
+`
+	got, err := alphafmt.Format("main.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	again, err := alphafmt.Format("main.go", got)
+	if err != nil {
+		t.Fatalf("failed to reformat output: %v", err)
+	}
+	if string(again) != want {
+		t.Fatalf("output is not stable: got\n%s", again)
+	}
+	for _, src := range []string{
+		"package main\n\n//alphafmt:sort\nconst (\n\tB = iota\n\tA\n)\n",
+		"package main\n\n//alphafmt:sort\nconst (\n\tB = 1 << (iota * 10)\n\tA = 2\n)\n",
+		"package main\n\n//alphafmt:sort\nconst (\n\tB, C = 1, 2\n\tA, D\n)\n",
+		"package main\n\n//alphafmt:sort\nvar (\n\tB = 1\n\tA = 2\n)\n",
+	} {
+		if _, err := alphafmt.Format("main.go", []byte(src)); err == nil {
+			t.Fatalf("expected an error for //alphafmt:sort in:\n%s", src)
+		}
+	}
+}
+
+func TestFormatConstructors(t *testing.T) {
+	dir := tempModule(t, "constructors with type = true\n")
+	src := `package ctor
+
+import "bytes"
+
+func (b *Buffer) Len() int { return 0 }
+
+func MustBuffer() *Buffer { return nil }
+
+func Newton() *Buffer { return nil }
+
+func NewBytes() *bytes.Buffer { return nil }
+
+type Buffer struct{}
+
+func NewBuffer() (*Buffer, error) { return nil, nil }
+`
+	want := `package ctor
+
+import (
+	"bytes"
+)
+
+type Buffer struct{}
+
+func MustBuffer() *Buffer { return nil }
+
+func NewBuffer() (*Buffer, error) { return nil, nil }
+
+func (b *Buffer) Len() int { return 0 }
+
+func NewBytes() *bytes.Buffer { return nil }
+
+func Newton() *Buffer { return nil }
+`
+	filename := filepath.Join(dir, "ctor.go")
+	got, err := alphafmt.Format(filename, []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	opts := &alphafmt.Options{Minimal: true}
+	for range 5 {
+		src = string(got)
+		got, err = alphafmt.FormatWithOptions(filename, []byte(src), opts)
+		if err != nil {
+			t.Fatalf("failed to format source in minimal mode: %v", err)
+		}
+	}
+	if string(got) != want {
+		t.Fatalf("minimal mode moved already sorted declarations: got\n%s", got)
+	}
+}
+
+// TestFormatCorpus formats each testdata/corpus/*.input file, and compares the
+// output against the corresponding .golden file, which must itself be stable.
+func TestFormatCorpus(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.input"))
+	if err != nil {
+		t.Fatalf("failed to find corpus files: %v", err)
+	}
+	if len(inputs) == 0 {
+		t.Fatalf("no corpus files found")
+	}
+	for _, input := range inputs {
+		base := strings.TrimSuffix(input, ".input")
+		src, err := os.ReadFile(input)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", input, err)
+		}
+		want, err := os.ReadFile(base + ".golden")
+		if err != nil {
+			t.Fatalf("failed to read golden file for %s: %v", input, err)
+		}
+		got, err := alphafmt.Format(base+".go", src)
+		if err != nil {
+			t.Fatalf("failed to format %s: %v", input, err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("unexpected output for %s: got\n%s\nwant\n%s", input, got, want)
+		}
+		again, err := alphafmt.Format(base+".go", want)
+		if err != nil {
+			t.Fatalf("failed to format golden file for %s: %v", input, err)
+		}
+		if string(again) != string(want) {
+			t.Fatalf("golden file for %s is not stable: got\n%s", input, again)
+		}
+	}
+}
+
+func TestFormatFixImports(t *testing.T) {
+	src := `package main
+
+import "strings"
+
+func main() {
+	fmt.Println(strings.ToUpper("a"), rand.N(10), rand2.Intn(1), helper.Name, missing.Value)
+}
+`
+	want := `package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+func main() {
+	fmt.Println(strings.ToUpper("a"), rand.N(10), rand2.Intn(1), helper.Name, missing.Value)
+}
+`
+	dir := tempModule(t, "")
+	sibling := "package main\n\nvar helper = struct{ Name string }{}\n"
+	if err := os.WriteFile(filepath.Join(dir, "helper.go"), []byte(sibling), 0o644); err != nil {
+		t.Fatalf("failed to write helper.go: %v", err)
+	}
+	opts := &alphafmt.Options{FixImports: true}
+	got, err := alphafmt.FormatWithOptions(filepath.Join(dir, "main.go"), []byte(src), opts)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	// Packages are matched on the names used from them.
+	src = strings.Replace(src, "rand.N(10)", "rand.Intn(10)", 1)
+	got, err = alphafmt.FormatWithOptions(filepath.Join(dir, "main.go"), []byte(src), opts)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if !strings.Contains(string(got), "\t\"math/rand\"\n") {
+		t.Fatalf("expected math/rand to be imported:\n%s", got)
+	}
+}
+
+func TestFormatFoldRegions(t *testing.T) {
+	src := `package main
+
+func zeta() {}
+
+// region: Encoding
+
+func encodeB() {}
+
+var encodeTable = []byte{}
+
+// Doc for encodeA.
+func encodeA() {}
+
+// endregion
+
+//#region Decoding
+func decode() {}
+
+const decodeLimit = 1
+
+//#endregion
+
+func alpha() {}
+
+// region is a plain comment, not a marker.
+func beta() {}
+`
+	want := `package main
+
+func zeta() {}
+
+// region: Encoding
+
+var encodeTable = []byte{}
+
+// Doc for encodeA.
+func encodeA() {}
+
+func encodeB() {}
+
+// endregion
+
+//#region Decoding
+
+const decodeLimit = 1
+
+func decode() {}
+
+//#endregion
+
+func alpha() {}
+
+// region is a plain comment, not a marker.
+func beta() {}
+`
+	got, err := alphafmt.Format("main.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	again, err := alphafmt.Format("main.go", got)
+	if err != nil {
+		t.Fatalf("failed to reformat output: %v", err)
+	}
+	if string(again) != want {
+		t.Fatalf("formatting is not stable: got\n%s\nwant\n%s", again, want)
+	}
+}
+
+func TestFormatImports(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"strings"
+	_ "embed"
+	o "os"
+	"math/rand/v2"
+	"fmt"
+	"bytes" // kept for later
+)
+
+func main() {
+	fmt.Println(rand.N(10))
+}
+`
+	want := `package main
+
+import (
+	"bytes" // kept for later
+	_ "embed"
+	"fmt"
+	"math/rand/v2"
+)
+
+func main() {
+	fmt.Println(rand.N(10))
+}
+`
+	dir := tempModule(t, "remove unused imports = true\n")
+	got, err := alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	// Without the config setting, duplicates are still merged.
+	dir = tempModule(t, "")
+	got, err = alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if strings.Count(string(got), `"fmt"`) != 1 || !strings.Contains(string(got), `"strings"`) {
+		t.Fatalf("unexpected output without remove unused imports:\n%s", got)
+	}
+}
+
+func TestFormatLineEndings(t *testing.T) {
+	src := "\ufeffpackage main\r\n\r\nfunc b() {}\r\n\r\nfunc a() {}\r\n"
+	sorted := "package main\n\nfunc a() {}\n\nfunc b() {}\n"
+	for _, tt := range []struct {
+		keep bool
+		want string
+	}{
+		{false, "\ufeff" + sorted},
+		{true, "\ufeff" + strings.ReplaceAll(sorted, "\n", "\r\n")},
+	} {
+		opts := &alphafmt.Options{KeepCRLF: tt.keep}
+		got, err := alphafmt.FormatWithOptions("main.go", []byte(src), opts)
+		if err != nil {
+			t.Fatalf("failed to format source with KeepCRLF = %v: %v", tt.keep, err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("unexpected output with KeepCRLF = %v: got %q, want %q", tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMapKeys(t *testing.T) {
+	src := `package main
+
+//alphafmt:sort-keys
+var codes = map[string]int{
+	"not found": 404, // the usual
+	// Success.
+	"ok":          200,
+	"bad request": 400,
+}
+
+var ints = map[int]string{3: "c", -1: "a", 0x2: "b"}
+
+var mixed = map[any]int{"b": 1, 2: 2, "a": 3}
+
+var calls = map[string]int{"b": f(), "a": f()}
+
+func f() int { return 0 }
+`
+	want := `package main
+
+var calls = map[string]int{"b": f(), "a": f()}
+
+//alphafmt:sort-keys
+var codes = map[string]int{
+	"bad request": 400,
+	"not found":   404, // the usual
+	// Success.
+	"ok": 200,
+}
+
+var ints = map[int]string{-1: "a", 0x2: "b", 3: "c"}
+
+var mixed = map[any]int{"b": 1, 2: 2, "a": 3}
+
+func f() int { return 0 }
+`
+	dir := tempModule(t, "sort map keys = true\n")
+	got, err := alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	// Without the config setting, only literals marked with the directive
+	// are sorted.
+	got, err = alphafmt.Format("main.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if strings.Index(string(got), `"bad request"`) > strings.Index(string(got), `"ok"`) || !strings.Contains(string(got), `{3: "c", -1: "a", 0x2: "b"}`) {
+		t.Fatalf("unexpected output without sort map keys: got\n%s", got)
+	}
+}
+
+func TestFormatMethodGroups(t *testing.T) {
+	dir := tempModule(t, "method groups = [sort.Interface, fmt.Stringer]\n")
+	src := `package names
+
+type byName []string
+
+func (b byName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b byName) Extra() {}
+
+func (b byName) String() string { return "" }
+
+// Less is documented.
+func (b byName) Less(i, j int) bool { return b[i] < b[j] }
+
+func (b byName) Len() int { return len(b) }
+`
+	want := `package names
+
+type byName []string
+
+// Methods implementing sort.Interface.
+
+func (b byName) Len() int { return len(b) }
+
+// Less is documented.
+func (b byName) Less(i, j int) bool { return b[i] < b[j] }
+
+func (b byName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+// Methods implementing fmt.Stringer.
+
+func (b byName) String() string { return "" }
+
+// Other methods.
+
+func (b byName) Extra() {}
+`
+	filename := filepath.Join(dir, "names.go")
+	got, err := alphafmt.Format(filename, []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	again, err := alphafmt.Format(filename, got)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(again) != want {
+		t.Fatalf("method group headers are not stable: got\n%s", again)
+	}
+	dir = tempModule(t, "method groups = [sort.Missing]\n")
+	if _, err := alphafmt.Format(filepath.Join(dir, "names.go"), []byte(src)); err == nil {
+		t.Fatalf("expected an error for an unknown interface")
+	}
+}
+
+func TestFormatMinimal(t *testing.T) {
+	src := `package main
+
+func main() {}
+
+func c() {}
+
+// b is documented.
+func b() {}
+
+func a() {}
+`
+	// A single pass only swaps neighbours, so a is not yet in place.
+	want := `package main
+
+func c() {}
+
+// b is documented.
+func b() {}
+
+func a() {}
+
+func main() {}
+`
+	opts := &alphafmt.Options{Minimal: true}
+	got, err := alphafmt.FormatWithOptions("main.go", []byte(src), opts)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	for range 3 {
+		got, err = alphafmt.FormatWithOptions("main.go", got, opts)
+		if err != nil {
+			t.Fatalf("failed to format source: %v", err)
+		}
+	}
+	full, err := alphafmt.Format("main.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != string(full) {
+		t.Fatalf("repeated minimal passes did not converge: got\n%s\nwant\n%s", got, full)
+	}
+}
+
+func TestFormatModFile(t *testing.T) {
+	src := "module   example.com/m\ngo 1.22\nrequire (\n  b.com/x v1.0.0\n  a.com/y v1.2.0 // indirect\n)\n"
+	want := "module example.com/m\n\ngo 1.22\n\nrequire (\n\ta.com/y v1.2.0 // indirect\n\tb.com/x v1.0.0\n)\n"
+	got, err := alphafmt.FormatModFile("go.mod", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format go.mod: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	src = "go 1.22\nuse (\n ./b\n ./a\n)\n"
+	want = "go 1.22\n\nuse (\n\t./a\n\t./b\n)\n"
+	got, err = alphafmt.FormatModFile("go.work", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format go.work: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	if _, err := alphafmt.FormatModFile("go.sum", nil); err == nil {
+		t.Fatalf("expected an error when formatting a go.sum file")
+	}
+}
+
+func TestFormatNestedConfig(t *testing.T) {
+	dir := tempModule(t, "sort = icase\nskip = [gen]\n")
+	configs := map[string]string{
+		"legacy":         "preserve order = true\nskip = [old/v1]\n",
+		"natural":        "sort = natural\n",
+		"natural/nested": "",
+	}
+	for sub, config := range configs {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", sub, err)
+		}
+		if config == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, sub, ".alphafmt"), []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write %s/.alphafmt: %v", sub, err)
+		}
+	}
+	src := `package main
+
+func Handler10() {}
+
+func Handler2() {}
+
+func httpClient() {}
+`
+	for _, tt := range []struct {
+		sub  string
+		want []string
+	}{
+		{".", []string{"Handler10", "Handler2", "httpClient"}},
+		{"legacy", []string{"Handler10", "Handler2", "httpClient"}},
+		{"natural", []string{"Handler2", "Handler10", "httpClient"}},
+		{"natural/nested", []string{"Handler2", "Handler10", "httpClient"}},
+	} {
+		got, err := alphafmt.Format(filepath.Join(dir, tt.sub, "main.go"), []byte(src))
+		if err != nil {
+			t.Fatalf("failed to format source in %s: %v", tt.sub, err)
+		}
+		want := "package main\n"
+		for _, name := range tt.want {
+			want += "\nfunc " + name + "() {}\n"
+		}
+		if string(got) != want {
+			t.Fatalf("unexpected output in %s: got\n%s\nwant\n%s", tt.sub, got, want)
+		}
+	}
+	for sub, want := range map[string]bool{
+		"gen":            true,
+		"legacy/gen":     true,
+		"legacy/old/v1":  true,
+		"natural/gen":    true,
+		"old/v1":         false,
+		"natural/old/v1": false,
+	} {
+		got, err := alphafmt.SkipDir(filepath.Join(dir, sub))
+		if err != nil {
+			t.Fatalf("failed to check whether to skip %s: %v", sub, err)
+		}
+		if got != want {
+			t.Fatalf("unexpected result from SkipDir for %s: got %v, want %v", sub, got, want)
+		}
+	}
+	// Changes to a config file apply to later calls.
+	filename := filepath.Join(dir, ".alphafmt")
+	if err := os.WriteFile(filename, []byte("sort = natural\n"), 0o644); err != nil {
+		t.Fatalf("failed to update .alphafmt: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatalf("failed to update the modification time of .alphafmt: %v", err)
+	}
+	got, err := alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source after updating the config: %v", err)
+	}
+	want := "package main\n\nfunc Handler2() {}\n\nfunc Handler10() {}\n\nfunc httpClient() {}\n"
+	if string(got) != want {
+		t.Fatalf("unexpected output after updating the config: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatRules(t *testing.T) {
+	dir := tempModule(t, "rules = [exported-first, banned-imports]\n")
+	src := `package rules
+
+import "fmt"
+
+const c = 1
+
+func helper() {}
+
+// Floating comment about Print.
+
+// Print is documented.
+func Print() { fmt.Println(c) }
+`
+	want := `package rules
+
+import (
+	"fmt"
+)
+
+// Floating comment about Print.
+
+// Print is documented.
+func Print() { fmt.Println(c) }
+
+const c = 1
+
+func helper() {}
+`
+	filename := filepath.Join(dir, "rules.go")
+	got, err := alphafmt.Format(filename, []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	src = "package rules\n\nimport \"unsafe\"\n\nvar _ = unsafe.Sizeof(0)\n"
+	if _, err := alphafmt.Format(filename, []byte(src)); err == nil {
+		t.Fatalf("expected an error when formatting a file with a banned import")
+	}
+	dir = tempModule(t, "rules = [missing]\n")
+	if _, err := alphafmt.Format(filepath.Join(dir, "rules.go"), []byte(src)); err == nil {
+		t.Fatalf("expected an error when using an unknown rule")
+	}
+}
+
+func TestFormatSections(t *testing.T) {
+	src := `package main
+
+func z() {}
+
+// --- Section: Encoding ---
+
+func b() {}
+
+const c = 1
+
+//  --- Section:  Decoding  ---
+// y is documented.
+func y() {}
+
+func x() {}
+`
+	want := `package main
+
+func z() {}
+
+// --- Section: Encoding ---
+
+const c = 1
+
+func b() {}
+
+// --- Section: Decoding ---
+
+func x() {}
+
+// y is documented.
+func y() {}
+`
+	got, err := alphafmt.Format("main.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatSortOrder(t *testing.T) {
+	src := `package main
+
+func Handler10() {}
+
+func Handler2() {}
+
+func httpClient() {}
+
+func HTTPServer() {}
+
+func Handler() {}
+`
+	for _, tt := range []struct {
+		order string
+		want  []string
+	}{
+		{"bytewise", []string{"HTTPServer", "Handler", "Handler10", "Handler2", "httpClient"}},
+		{"icase", []string{"Handler", "Handler10", "Handler2", "httpClient", "HTTPServer"}},
+		{"natural", []string{"HTTPServer", "Handler", "Handler2", "Handler10", "httpClient"}},
+	} {
+		dir := tempModule(t, "sort = "+tt.order+"\n")
+		got, err := alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+		if err != nil {
+			t.Fatalf("failed to format source with sort = %s: %v", tt.order, err)
+		}
+		want := "package main\n"
+		for _, name := range tt.want {
+			want += "\nfunc " + name + "() {}\n"
+		}
+		if string(got) != want {
+			t.Fatalf("unexpected output with sort = %s: got\n%s\nwant\n%s", tt.order, got, want)
+		}
+	}
+}
+
+func TestFormatStdImports(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"legacy/util"
+)
+`
+	for _, tt := range []struct {
+		mode string
+		want string
+	}{
+		{"heuristic", `package main
+
+import (
+	"fmt"
+	"legacy/util"
+
+	"github.com/pkg/errors"
+)
+`},
+		{"go list", `package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"legacy/util"
+)
+`},
+	} {
+		dir := tempModule(t, "std imports = "+tt.mode+"\n")
+		got, err := alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+		if err != nil {
+			t.Fatalf("failed to format source with std imports = %s: %v", tt.mode, err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("unexpected output with std imports = %s: got\n%s\nwant\n%s", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestFormatStrict(t *testing.T) {
+	src := `package main
+
+import "os"
+
+func main() {
+
+	//nolint:errcheck
+	os.Chmod("x", 0755)
+	var x = 1
+	var _ = x
+	var y int = 2
+	_ = y
+
+}
+
+func a() {
+
+}
+`
+	want := `package main
+
+import (
+	"os"
+)
+
+func a() {
+}
+
+func main() {
+	//nolint:errcheck
+	os.Chmod("x", 0o755)
+	x := 1
+	var _ = x
+	var y int = 2
+	_ = y
+}
+`
+	opts := &alphafmt.Options{Strict: true}
+	got, err := alphafmt.FormatWithOptions("main.go", []byte(src), opts)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatStructFields(t *testing.T) {
+	src := `package main
+
+type Point struct {
+	Y int
+	X int
+}
+
+type Server struct {
+	name string // Used in logs.
+	sync.Mutex
+
+	// Protected by the mutex.
+	count int
+	*log.Logger
+}
+
+var origin = Point{1, 2}
+`
+	want := `package main
+
+var origin = Point{1, 2}
+
+type Point struct {
+	Y int
+	X int
+}
+
+type Server struct {
+	sync.Mutex
+	*log.Logger
+
+	// Protected by the mutex.
+	count int
+	name  string // Used in logs.
+}
+`
+	dir := tempModule(t, "sort struct fields = true\nembedded fields first = true\n")
+	got, err := alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	// Without embedded fields first, embedded fields are sorted by type name.
+	dir = tempModule(t, "sort struct fields = true\n")
+	got, err = alphafmt.Format(filepath.Join(dir, "main.go"), []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if !strings.Contains(string(got), "\t*log.Logger\n\tsync.Mutex\n\n\t// Protected by the mutex.\n") {
+		t.Fatalf("expected embedded fields to be sorted with the others: got\n%s", got)
+	}
+}
+
+func TestFormatStructLiterals(t *testing.T) {
+	dir := tempModule(t, "order struct literals = true\n")
+	types := "package shapes\n\ntype Point struct {\n\tX, Y int\n\tZ    int\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "types.go"), []byte(types), 0o644); err != nil {
+		t.Fatalf("failed to write types.go: %v", err)
+	}
+	src := `package shapes
+
+var a = Point{Z: 3, X: 1, Y: 2}
+
+var b = &Line{
+	// The end.
+	To: Point{Y: 4, X: 3},
+
+	From: Point{
+		Y: 2, // trailing
+		X: 1,
+	},
+}
+
+var c = Point{Y: f(), X: f()}
+
+var d = Point{Y: 2, W: 1}
+
+var e = struct{ A, B int }{B: 2, A: 1}
+
+type Line struct {
+	From Point
+	To   Point
+}
+
+func f() int { return 0 }
+`
+	want := `package shapes
+
+var a = Point{X: 1, Y: 2, Z: 3}
+
+var b = &Line{
+	From: Point{
+		X: 1,
+		Y: 2, // trailing
+	},
+	// The end.
+	To: Point{X: 3, Y: 4},
+}
+
+var c = Point{Y: f(), X: f()}
+
+var d = Point{Y: 2, W: 1}
+
+var e = struct{ A, B int }{A: 1, B: 2}
+
+type Line struct {
+	From Point
+	To   Point
+}
+
+func f() int { return 0 }
+`
+	filename := filepath.Join(dir, "shapes.go")
+	got, err := alphafmt.Format(filename, []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatStructTags(t *testing.T) {
+	dir := tempModule(t, "canonical struct tags = true\nstruct tag order = [json, db]\n")
+	filename := filepath.Join(dir, "tags.go")
+	src := "package tags\n\ntype T struct {\n\tA int `db:\"a\"   yaml:\"a\"  json:\"a,omitempty\" db:\"a\"`\n\tB int \"db:\\\"b\\\" json:\\\"b\\\"\"\n}\n"
+	want := "package tags\n\ntype T struct {\n\tA int `json:\"a,omitempty\" db:\"a\" yaml:\"a\"`\n\tB int \"json:\\\"b\\\" db:\\\"b\\\"\"\n}\n"
+	got, err := alphafmt.Format(filename, []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	for _, tag := range []string{"`json:\"a\" json:\"b\"`", "`json:a`", "`json:\"a\"db:\"b\"`"} {
+		src := "package tags\n\ntype T struct {\n\tA int " + tag + "\n}\n"
+		if _, err := alphafmt.Format(filename, []byte(src)); err == nil {
+			t.Fatalf("expected an error for the struct tag %s", tag)
+		}
+	}
+}
+
+func TestFormatTestFiles(t *testing.T) {
+	src := `package main
+
+import "testing"
+
+func ExampleParse() {}
+
+func TestParse(t *testing.T) {}
+
+func BenchmarkParse(b *testing.B) {}
+
+// checkParse is a helper for TestParse.
+//
+//alphafmt:with TestParse
+func checkParse(t *testing.T) {}
+
+func Benchmarking() {}
+
+func FuzzParse(f *testing.F) {}
+
+func TestMain(m *testing.M) {}
+
+func TestDecode(t *testing.T) {}
+`
+	want := `package main
+
+import (
+	"testing"
+)
+
+func TestMain(m *testing.M) {}
+
+func Benchmarking() {}
+
+func TestDecode(t *testing.T) {}
+
+func TestParse(t *testing.T) {}
+
+// checkParse is a helper for TestParse.
+//
+//alphafmt:with TestParse
+func checkParse(t *testing.T) {}
+
+func BenchmarkParse(b *testing.B) {}
+
+func FuzzParse(f *testing.F) {}
+
+func ExampleParse() {}
+`
+	got, err := alphafmt.Format("main_test.go", []byte(src))
+	if err != nil {
+		t.Fatalf("failed to format test file: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output for test file: got\n%s\nwant\n%s", got, want)
+	}
+	plain := strings.ReplaceAll(src, "//\n//alphafmt:with TestParse\n", "")
+	got, err = alphafmt.Format("main.go", []byte(plain))
+	if err != nil {
+		t.Fatalf("failed to format non-test file: %v", err)
+	}
+	if !strings.Contains(string(got), "func ExampleParse() {}\n\nfunc FuzzParse") {
+		t.Fatalf("unexpected test sections within non-test file: got\n%s", got)
+	}
+	for _, src := range []string{
+		"package main\n\n//alphafmt:with b\nfunc a() {}\n\n//alphafmt:with a\nfunc b() {}\n",
+		"package main\n\n//alphafmt:with missing\nfunc a() {}\n",
+	} {
+		if _, err := alphafmt.Format("main_test.go", []byte(src)); err == nil {
+			t.Fatalf("expected an error for invalid //alphafmt:with directive in:\n%s", src)
+		}
+	}
+}
+
+func TestFormatWrap(t *testing.T) {
+	src := `package main
+
+func configure(name string, timeout int, retries int, verbose bool, opts ...string) error {
+	return run(name, lookup(name, timeout, retries, verbose), []string{"alpha", "beta"}, opts...)
+}
+
+func short(a int) {}
+
+// A comment keeps this line as is: []string{"alpha", "beta", "gamma", "delta"}
+`
+	want := `package main
+
+func configure(
+	name string,
+	timeout int,
+	retries int,
+	verbose bool,
+	opts ...string,
+) error {
+	return run(
+		name,
+		lookup(name, timeout, retries, verbose),
+		[]string{"alpha", "beta"},
+		opts...,
+	)
+}
+
+func short(a int) {}
+
+// A comment keeps this line as is: []string{"alpha", "beta", "gamma", "delta"}
+`
+	opts := &alphafmt.Options{MaxLineLength: 60}
+	got, err := alphafmt.FormatWithOptions("main.go", []byte(src), opts)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+	// Inner lists are wrapped in later passes if lines are still too long.
+	opts.MaxLineLength = 30
+	got, err = alphafmt.FormatWithOptions("main.go", []byte(src), opts)
+	if err != nil {
+		t.Fatalf("failed to format source: %v", err)
+	}
+	if !strings.Contains(string(got), "\t\tlookup(\n\t\t\tname,\n") {
+		t.Fatalf("expected nested call to be wrapped: got\n%s", got)
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want bool
+	}{
+		{"// Code generated by stringer. DO NOT EDIT.\n\npackage main\n", true},
+		{"// Package main is documented.\n//\n// Code generated by hand. DO NOT EDIT.\npackage main\n", true},
+		{"package main\n\n// Code generated by stringer. DO NOT EDIT.\n", false},
+		{"// Code generated by stringer.\n\npackage main\n", false},
+		{"package main\n", false},
+	} {
+		if got := alphafmt.IsGenerated([]byte(tt.src)); got != tt.want {
+			t.Errorf("IsGenerated(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+// rank returns 0 for imports, 1 for exported funcs, and 2 for all other
+// declarations.
+func rank(decl ast.Decl) int {
+	switch node := decl.(type) {
+	case *ast.FuncDecl:
+		if node.Name.IsExported() {
+			return 1
+		}
+	case *ast.GenDecl:
+		if node.Tok == token.IMPORT {
+			return 0
+		}
+	}
+	return 2
+}
+
+// tempModule creates a module within a temporary directory, with the given
+// contents for its .alphafmt config file.
+func tempModule(t *testing.T, config string) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		".alphafmt": config,
+		"go.mod":    "module example.com/tmp\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// FuzzFormat checks that formatting is stable, and never loses comments, for
+// any source which can be formatted, starting from the testdata corpus.
+func FuzzFormat(f *testing.F) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.input"))
+	if err != nil {
+		f.Fatalf("failed to find corpus files: %v", err)
+	}
+	for _, input := range inputs {
+		src, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatalf("failed to read %s: %v", input, err)
+		}
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		// gofmt rewrites build constraints, e.g. moving //go:build lines above
+		// the package doc, which isn't always stable on its own.
+		if bytes.Contains(src, []byte("build")) {
+			once, err := format.Source(src)
+			if err != nil {
+				return
+			}
+			if twice, err := format.Source(once); err != nil || !bytes.Equal(once, twice) {
+				return
+			}
+		}
+		out, err := alphafmt.Format("fuzz.go", src)
+		if err != nil {
+			return
+		}
+		if err := alphafmt.VerifyOutput("fuzz.go", src, out, nil); err != nil {
+			t.Fatalf("%v\ninput:\n%s\noutput:\n%s", err, src, out)
+		}
+	})
+}
+
+func init() {
+	alphafmt.RegisterRule(bannedImports{})
+	alphafmt.RegisterRule(exportedFirst{})
+}
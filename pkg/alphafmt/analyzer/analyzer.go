@@ -0,0 +1,104 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+// Package analyzer provides a go/analysis Analyzer which reports files whose
+// declarations are not in alphafmt order.
+//
+// Each diagnostic is reported at the first out of place declaration, and comes
+// with a suggested fix which rewrites the file as alphafmt would, so that the
+// check can be run within vet or golangci-lint pipelines.
+package analyzer
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+
+	"golang.org/x/tools/go/analysis"
+
+	"espra.dev/pkg/alphafmt"
+)
+
+// Analyzer reports files that are not formatted with alphafmt.
+var Analyzer = &analysis.Analyzer{
+	Doc:  "report files whose declarations are not in alphafmt order",
+	Name: "alphafmt",
+	Run:  run,
+	URL:  "https://pkg.go.dev/espra.dev/pkg/alphafmt/analyzer",
+}
+
+// declStart returns the start of the given declaration, including its doc
+// comment.
+func declStart(decl ast.Decl) token.Pos {
+	switch node := decl.(type) {
+	case *ast.FuncDecl:
+		if node.Doc != nil {
+			return node.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if node.Doc != nil {
+			return node.Doc.Pos()
+		}
+	}
+	return decl.Pos()
+}
+
+// firstDiffOffset returns the offset of the first byte that differs between a
+// and b.
+func firstDiffOffset(a []byte, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	for _, file := range pass.Files {
+		tf := pass.Fset.File(file.Pos())
+		if tf == nil || filepath.Ext(tf.Name()) != ".go" {
+			continue
+		}
+		src, err := pass.ReadFile(tf.Name())
+		if err != nil {
+			return nil, err
+		}
+		if alphafmt.IsGenerated(src) {
+			continue
+		}
+		formatted, err := alphafmt.Format(tf.Name(), src)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+		pos := tf.Pos(firstDiffOffset(src, formatted))
+		msg := "file is not formatted with alphafmt"
+		for _, decl := range file.Decls {
+			if decl.End() > pos {
+				if declStart(decl) <= pos {
+					msg = "declaration is not in alphafmt order"
+					pos = declStart(decl)
+				}
+				break
+			}
+		}
+		pass.Report(analysis.Diagnostic{
+			Message: msg,
+			Pos:     pos,
+			SuggestedFixes: []analysis.SuggestedFix{{
+				Message: "Format with alphafmt",
+				TextEdits: []analysis.TextEdit{{
+					End:     tf.Pos(tf.Size()),
+					NewText: formatted,
+					Pos:     tf.Pos(0),
+				}},
+			}},
+		})
+	}
+	return nil, nil
+}
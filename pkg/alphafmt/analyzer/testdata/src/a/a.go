@@ -0,0 +1,6 @@
+package a
+
+func b() {} // want "declaration is not in alphafmt order"
+
+// a is documented.
+func a() {}
@@ -0,0 +1,5 @@
+package b
+
+func a() {}
+
+func b() {}
@@ -0,0 +1,208 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+// commentPlan records where the floating comments within a file, i.e. the
+// top-level comment groups which aren't attached to any declaration, are
+// written once the declarations have been reordered:
+//
+//   - Comments before or between the imports are written above the imports.
+//   - Comments before a declaration are written above it, separated by an
+//     empty line, and move along with it.
+//   - Comments after the last declaration within a section are written at
+//     the end of that section.
+//
+// All other comments are owned by the file header, a declaration, an
+// //alphafmt:off region, or are hoisted as pragmas or section markers.
+type commentPlan struct {
+	leading  map[ast.Decl][]string
+	preamble []string
+	trailing [][]string
+}
+
+// leadingText returns the floating comments to be written above the given
+// declaration, including the separating empty line.
+func (c *commentPlan) leadingText(decl ast.Decl) string {
+	if c == nil || len(c.leading[decl]) == 0 {
+		return ""
+	}
+	return strings.Join(c.leading[decl], "\n\n") + "\n\n"
+}
+
+// move transfers the floating comments of a declaration to the declaration
+// which replaces it, e.g. the first of the declarations it is split into.
+func (c *commentPlan) move(from ast.Decl, to ast.Decl) {
+	if c == nil || from == to {
+		return
+	}
+	if groups, ok := c.leading[from]; ok {
+		c.leading[to] = append(groups, c.leading[to]...)
+		delete(c.leading, from)
+	}
+}
+
+// checkComments returns an error if any comment within the given file is
+// missing from the reordered source. Comments are compared with their
+// whitespace normalized, as the printer may reindent them. Empty comments, which
+// gofmt may drop, are ignored, as are method group headers when method groups
+// are enabled, as those are regenerated on each run.
+func checkComments(fset *token.FileSet, file *ast.File, filename string, ordered []byte, cfg *config) error {
+	parsed, err := parser.ParseFile(token.NewFileSet(), filename, ordered, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return fmt.Errorf("alphafmt: failed to parse reordered source for %s: %w", filename, err)
+	}
+	seen := map[string]int{}
+	for _, group := range parsed.Comments {
+		for _, comment := range group.List {
+			seen[commentKey(comment.Text)]++
+		}
+	}
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if isEmptyComment(comment.Text) || (len(cfg.methodGroups) > 0 && methodGroupPattern.MatchString(comment.Text)) {
+				continue
+			}
+			key := commentKey(comment.Text)
+			if seen[key] == 0 {
+				return fmt.Errorf("alphafmt: %s: comment would be lost when reordering declarations: %s", fset.Position(comment.Pos()), firstLine(comment.Text))
+			}
+			seen[key]--
+		}
+	}
+	return nil
+}
+
+// commentKey returns the text of the given comment with its whitespace
+// normalized, and section markers in their canonical form.
+func commentKey(text string) string {
+	if marker := markerText(text); marker != "" {
+		return marker
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// commentLines returns the lines of the given comment group, excluding any
+// section markers, which are written separately.
+func commentLines(group *ast.CommentGroup) []string {
+	var lines []string
+	for _, comment := range group.List {
+		if markerText(comment.Text) == "" {
+			lines = append(lines, comment.Text)
+		}
+	}
+	return lines
+}
+
+func firstLine(text string) string {
+	line, _, ok := strings.Cut(text, "\n")
+	if ok {
+		return line + " ..."
+	}
+	return line
+}
+
+// isEmptyComment reports whether the given comment has no text, e.g. `//` or
+// `/* */`.
+func isEmptyComment(text string) bool {
+	switch strings.Join(strings.Fields(text), "") {
+	case "//", "/**/":
+		return true
+	}
+	return false
+}
+
+// planComments assigns each floating comment group within the file to its
+// owner. The parts are the non-import declarations within each section, in
+// their original order.
+func planComments(fset *token.FileSet, file *ast.File, regions []*region, markers []*sectionMarker, parts [][]ast.Decl, cfg *config) *commentPlan {
+	plan := &commentPlan{
+		leading:  map[ast.Decl][]string{},
+		trailing: make([][]string, len(parts)),
+	}
+	docs := map[*ast.CommentGroup]struct{}{}
+	var importEnd token.Pos
+	for _, decl := range file.Decls {
+		switch node := decl.(type) {
+		case *ast.FuncDecl:
+			if node.Doc != nil {
+				docs[node.Doc] = struct{}{}
+			}
+		case *ast.GenDecl:
+			if node.Doc != nil {
+				docs[node.Doc] = struct{}{}
+			}
+			if node.Tok == token.IMPORT {
+				importEnd = node.End()
+			}
+		}
+	}
+	tf := fset.File(file.Pos())
+	for _, group := range file.Comments {
+		if group.Pos() < file.Name.Pos() || withinDecl(file, group) || inRegion(regions, group.Pos()) {
+			continue
+		}
+		if _, ok := docs[group]; ok || slices.ContainsFunc(group.List, isPragma) || trailsDecl(tf, file, group) {
+			continue
+		}
+		// Method group headers are regenerated.
+		if len(cfg.methodGroups) > 0 && isMethodGroupHeader(group) {
+			continue
+		}
+		lines := commentLines(group)
+		if len(lines) == 0 {
+			continue
+		}
+		text := strings.Join(lines, "\n")
+		if group.Pos() < importEnd {
+			plan.preamble = append(plan.preamble, text)
+			continue
+		}
+		// Comments are placed within the section of their first line that
+		// isn't a section marker.
+		pos := group.Pos()
+		for _, comment := range group.List {
+			if markerText(comment.Text) == "" {
+				pos = comment.Pos()
+				break
+			}
+		}
+		idx := 0
+		for idx < len(markers) && markers[idx].pos < pos {
+			idx++
+		}
+		placed := false
+		for _, decl := range parts[idx] {
+			if decl.Pos() > pos {
+				plan.leading[decl] = append(plan.leading[decl], text)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			plan.trailing[idx] = append(plan.trailing[idx], text)
+		}
+	}
+	return plan
+}
+
+// trailsDecl reports whether the given comment group starts on the same line
+// that a declaration ends, and is thus written as its trailing comment.
+func trailsDecl(tf *token.File, file *ast.File, group *ast.CommentGroup) bool {
+	line := tf.Line(group.Pos())
+	for _, decl := range file.Decls {
+		if decl.End() <= group.Pos() && tf.Line(decl.End()) == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+// Package emit helps code generators produce Go files that are already in
+// alphafmt order.
+//
+// Declarations can be added in whatever order is convenient for the generator,
+// e.g.
+//
+//	f := emit.NewFile("gen")
+//	f.Header("Code generated by gen. DO NOT EDIT.")
+//	f.Import("fmt")
+//	f.Decl("func (c Color) String() string { return fmt.Sprint(int(c)) }")
+//	f.Declf("const Red Color = %d", 1)
+//	f.Decl("type Color int")
+//	out, err := f.Format("gen/color.go")
+//
+// The output is formatted with alphafmt using the config which applies to the
+// given filename, so that generated files are born compliant.
+package emit
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"espra.dev/pkg/alphafmt"
+)
+
+// File accumulates the imports and declarations for a generated Go file.
+//
+// Errors, e.g. from declarations that don't parse, are deferred until the
+// file is formatted, so that generators don't need to check each call.
+type File struct {
+	decls   []string
+	err     error
+	header  []string
+	imports map[string]string // keyed by path, with empty names if not renamed
+	pkg     string
+}
+
+// Decl adds the given source, which must consist of one or more top-level
+// declarations other than imports, which are added via Import instead. The
+// source can include doc comments.
+func (f *File) Decl(src string) {
+	if f.err != nil {
+		return
+	}
+	n := len(f.decls) + 1
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		f.err = fmt.Errorf("emit: failed to parse declaration #%d: %w", n, err)
+		return
+	}
+	if len(file.Decls) == 0 {
+		f.err = fmt.Errorf("emit: declaration #%d does not contain any declarations", n)
+		return
+	}
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			f.err = fmt.Errorf("emit: declaration #%d contains an import, use Import instead", n)
+			return
+		}
+	}
+	f.decls = append(f.decls, strings.TrimSpace(src))
+}
+
+// Declf is like Decl, but with the source produced by fmt.Sprintf.
+func (f *File) Declf(format string, args ...any) {
+	f.Decl(fmt.Sprintf(format, args...))
+}
+
+// Format returns the alphafmt-formatted source for the file. The filename is
+// used to find the config that applies, and within error messages.
+func (f *File) Format(filename string) ([]byte, error) {
+	return f.FormatWithOptions(filename, nil)
+}
+
+// FormatWithOptions is like Format, but with the given options.
+func (f *File) FormatWithOptions(filename string, opts *alphafmt.Options) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	buf := &bytes.Buffer{}
+	for _, line := range f.header {
+		if line == "" {
+			buf.WriteString("//\n")
+			continue
+		}
+		buf.WriteString("// ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if len(f.header) > 0 {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("package ")
+	buf.WriteString(f.pkg)
+	buf.WriteString("\n")
+	if len(f.imports) > 0 {
+		buf.WriteString("\nimport (\n")
+		for _, path := range slices.Sorted(maps.Keys(f.imports)) {
+			buf.WriteByte('\t')
+			if name := f.imports[path]; name != "" {
+				buf.WriteString(name)
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(strconv.Quote(path))
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(")\n")
+	}
+	for _, decl := range f.decls {
+		buf.WriteByte('\n')
+		buf.WriteString(decl)
+		buf.WriteByte('\n')
+	}
+	out, err := alphafmt.FormatWithOptions(filename, buf.Bytes(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("emit: failed to format %s: %w", filename, err)
+	}
+	return out, nil
+}
+
+// Header sets the comment written above the package clause, e.g. the standard
+// "Code generated ... DO NOT EDIT." line. Each line of the text is written as
+// a separate line comment.
+func (f *File) Header(text string) {
+	f.header = strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+// Import adds an import of the given path. Adding the same path more than once
+// has no effect.
+func (f *File) Import(path string) {
+	f.ImportAs("", path)
+}
+
+// ImportAs adds an import of the given path under the given name, e.g. "_" or
+// "." or an alias. An empty name is the same as calling Import.
+func (f *File) ImportAs(name string, path string) {
+	if f.err != nil {
+		return
+	}
+	if prev, ok := f.imports[path]; ok && prev != name {
+		f.err = fmt.Errorf("emit: conflicting names %q and %q for the import of %q", prev, name, path)
+		return
+	}
+	f.imports[path] = name
+}
+
+// WriteFile formats the file, and writes it to the given path with the given
+// permissions, e.g. 0o644. Nothing is written if formatting fails.
+func (f *File) WriteFile(path string, perm fs.FileMode) error {
+	out, err := f.Format(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, perm)
+}
+
+// NewFile returns an empty file for the given package name.
+func NewFile(pkg string) *File {
+	return &File{
+		imports: map[string]string{},
+		pkg:     pkg,
+	}
+}
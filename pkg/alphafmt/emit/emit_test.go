@@ -0,0 +1,76 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package emit_test
+
+import (
+	"testing"
+
+	"espra.dev/pkg/alphafmt/emit"
+)
+
+func TestFile(t *testing.T) {
+	f := emit.NewFile("gen")
+	f.Header("Code generated by gen. DO NOT EDIT.")
+	f.Import("strconv")
+	f.Import("fmt")
+	f.Declf("func (c Color) String() string { return fmt.Sprint(int(c)) }")
+	f.Declf("func parse(s string) (Color, error) { n, err := strconv.Atoi(s); return Color(n), err }")
+	f.Decl("// Color is a generated type.\ntype Color int")
+	f.Declf("const %s Color = %d", "Red", 1)
+	f.Declf("var names = map[Color]string{%s: %q}", "Red", "red")
+	got, err := f.Format("color.go")
+	if err != nil {
+		t.Fatalf("failed to format file: %v", err)
+	}
+	want := `// Code generated by gen. DO NOT EDIT.
+
+package gen
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const Red Color = 1
+
+var names = map[Color]string{Red: "red"}
+
+// Color is a generated type.
+type Color int
+
+func (c Color) String() string { return fmt.Sprint(int(c)) }
+
+func parse(s string) (Color, error) { n, err := strconv.Atoi(s); return Color(n), err }
+`
+	if string(got) != want {
+		t.Fatalf("unexpected output: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		build func(f *emit.File)
+	}{
+		{"conflicting import", func(f *emit.File) {
+			f.Import("fmt")
+			f.ImportAs("f", "fmt")
+		}},
+		{"import decl", func(f *emit.File) {
+			f.Decl(`import "fmt"`)
+		}},
+		{"invalid decl", func(f *emit.File) {
+			f.Decl("func {")
+		}},
+		{"no decl", func(f *emit.File) {
+			f.Decl("// Just a comment.")
+		}},
+	} {
+		f := emit.NewFile("gen")
+		tt.build(f)
+		if _, err := f.Format("gen.go"); err == nil {
+			t.Fatalf("expected an error for %s", tt.name)
+		}
+	}
+}
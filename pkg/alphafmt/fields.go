@@ -0,0 +1,156 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"slices"
+)
+
+// addUnkeyedStructs adds the names of the types used in unkeyed composite
+// literals within the given file, including literals whose type is elided
+// within slice, array, and map literals.
+func addUnkeyedStructs(unkeyed map[string]struct{}, file *ast.File) {
+	var check func(lit *ast.CompositeLit, typ ast.Expr)
+	check = func(lit *ast.CompositeLit, typ ast.Expr) {
+		if len(lit.Elts) > 0 {
+			if _, ok := lit.Elts[0].(*ast.KeyValueExpr); !ok {
+				if name := typeName(typ); name != "" {
+					unkeyed[name] = struct{}{}
+				}
+			}
+		}
+		var elem, key ast.Expr
+		switch typ := typ.(type) {
+		case *ast.ArrayType:
+			elem = typ.Elt
+		case *ast.MapType:
+			elem, key = typ.Value, typ.Key
+		default:
+			return
+		}
+		elided := func(expr ast.Expr, typ ast.Expr) {
+			if unary, ok := expr.(*ast.UnaryExpr); ok && unary.Op == token.AND {
+				expr = unary.X
+				if star, ok := typ.(*ast.StarExpr); ok {
+					typ = star.X
+				}
+			}
+			if inner, ok := expr.(*ast.CompositeLit); ok && inner.Type == nil {
+				check(inner, typ)
+			}
+		}
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				if key != nil {
+					elided(kv.Key, key)
+				}
+				elided(kv.Value, elem)
+				continue
+			}
+			elided(elt, elem)
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.CompositeLit); ok && lit.Type != nil {
+			check(lit, lit.Type)
+		}
+		return true
+	})
+}
+
+// fieldName returns the name that the given struct field is sorted by, i.e.
+// its first name, or the name of its type if it is embedded.
+func fieldName(field *ast.Field) string {
+	if len(field.Names) == 0 {
+		return typeName(field.Type)
+	}
+	return field.Names[0].Name
+}
+
+// sortStructFields sorts the fields of the top-level struct types declared in
+// the given source by name. If embeddedFirst is set, embedded fields are
+// placed first, in their original order. Fields are moved along with their
+// comments, and structs are only sorted if each field is on its own lines.
+// Structs whose values are created with unkeyed literals anywhere within the
+// package are left alone, as reordering their fields would change what those
+// literals mean.
+func sortStructFields(filename string, src []byte, cfg *config) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	unkeyed := map[string]struct{}{}
+	addUnkeyedStructs(unkeyed, file)
+	for _, path := range packageFiles(filename) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sibling, err := parser.ParseFile(token.NewFileSet(), path, data, parser.SkipObjectResolution)
+		if err != nil || sibling.Name.Name != file.Name.Name {
+			continue
+		}
+		addUnkeyedStructs(unkeyed, sibling)
+	}
+	tf := fset.File(file.Pos())
+	var edits []*literalEdit
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			st, ok := spec.Type.(*ast.StructType)
+			if !ok || len(st.Fields.List) < 2 {
+				continue
+			}
+			if _, ok := unkeyed[spec.Name.Name]; ok {
+				continue
+			}
+			fields := st.Fields.List
+			order := make([]int, len(fields))
+			elems := make([]ast.Node, len(fields))
+			for i, field := range fields {
+				order[i] = i
+				elems[i] = field
+			}
+			slices.SortStableFunc(order, func(a, b int) int {
+				if cfg.embeddedFirst {
+					embeddedA, embeddedB := len(fields[a].Names) == 0, len(fields[b].Names) == 0
+					switch {
+					case embeddedA && embeddedB:
+						return 0
+					case embeddedA:
+						return -1
+					case embeddedB:
+						return 1
+					}
+				}
+				switch nameA, nameB := fieldName(fields[a]), fieldName(fields[b]); {
+				case cfg.less(nameA, nameB):
+					return -1
+				case cfg.less(nameB, nameA):
+					return 1
+				}
+				return 0
+			})
+			if slices.IsSorted(order) {
+				continue
+			}
+			if edit := reorderLines(tf, src, st.Fields.Opening, st.Fields.Closing, elems, order); edit != nil {
+				edits = append(edits, edit)
+			}
+		}
+	}
+	if len(edits) == 0 {
+		return src, nil
+	}
+	return applyEdits(src, edits), nil
+}
@@ -0,0 +1,309 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	packageExports   = map[string]map[string]struct{}{} // keyed by directory
+	packageExportsMu sync.Mutex                         // protects packageExports
+	packageIndexes   = map[string][]*indexedPackage{}   // keyed by module root
+	packageIndexesMu sync.Mutex                         // protects packageIndexes
+)
+
+// indexedPackage is a package which can be imported from within a module.
+type indexedPackage struct {
+	dir  string
+	name string
+	path string
+	std  bool
+}
+
+// declaredNames returns the names declared at the top level of the given file,
+// excluding methods.
+func declaredNames(file *ast.File) []string {
+	var names []string
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, spec.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// fixImports adds imports for the packages referenced by the given source
+// which aren't declared or imported. Each missing package name is resolved to
+// a package from the std library first, and then from the dependencies of the
+// module, which exports all of the referenced names, preferring the shortest
+// import path. Names which can't be resolved are left alone, as is any file
+// with a dot import.
+func fixImports(filename string, src []byte, cfg *config) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	unresolved := map[*ast.Ident]struct{}{}
+	for _, ident := range file.Unresolved {
+		unresolved[ident] = struct{}{}
+	}
+	refs := map[string]map[string]struct{}{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if _, ok := unresolved[ident]; !ok {
+			return true
+		}
+		if refs[ident.Name] == nil {
+			refs[ident.Name] = map[string]struct{}{}
+		}
+		refs[ident.Name][sel.Sel.Name] = struct{}{}
+		return true
+	})
+	var unnamed []string
+	for _, spec := range file.Imports {
+		if spec.Name == nil {
+			delete(refs, assumedPackageName(importPath(spec)))
+			unnamed = append(unnamed, importPath(spec))
+			continue
+		}
+		if spec.Name.Name == "." {
+			return src, nil
+		}
+		delete(refs, spec.Name.Name)
+	}
+	if len(refs) == 0 {
+		return src, nil
+	}
+	// Names declared by the other files within the package aren't resolved
+	// by the parser.
+	for _, path := range packageFiles(filename) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sibling, err := parser.ParseFile(token.NewFileSet(), path, data, parser.SkipObjectResolution)
+		if err != nil || sibling.Name.Name != file.Name.Name {
+			continue
+		}
+		for _, name := range declaredNames(sibling) {
+			delete(refs, name)
+		}
+	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	// The names of existing imports are only guessed above, so confirm them
+	// before adding what could be a duplicate.
+	for _, path := range unnamed {
+		if len(refs) == 0 {
+			break
+		}
+		if pkg, err := build.Import(path, dir, 0); err == nil {
+			delete(refs, pkg.Name)
+		}
+	}
+	if len(refs) == 0 {
+		return src, nil
+	}
+	index, err := loadPackageIndex(cfg.root)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(refs)) {
+		pkg := resolvePackage(index, name, refs[name], dir, cfg)
+		if pkg == nil {
+			continue
+		}
+		line := "import " + strconv.Quote(pkg.path)
+		if assumedPackageName(pkg.path) != pkg.name {
+			line = "import " + pkg.name + " " + strconv.Quote(pkg.path)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return src, nil
+	}
+	pos := file.Name.End()
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			pos = gen.End()
+		}
+	}
+	offset := lineEnd(src, fset.Position(pos).Offset)
+	buf := &bytes.Buffer{}
+	buf.Write(src[:offset])
+	if offset > 0 && src[offset-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	for _, line := range lines {
+		buf.WriteByte('\n')
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.Write(src[offset:])
+	return buf.Bytes(), nil
+}
+
+// importable reports whether the package at the given path can be imported
+// from within the given module, as internal packages can only be imported by
+// packages rooted at the parent of the internal directory.
+func importable(path string, module string) bool {
+	elems := strings.Split(path, "/")
+	for i, elem := range elems {
+		switch elem {
+		case "internal":
+			parent := strings.Join(elems[:i], "/")
+			if module == "" || parent == "" || (module != parent && !strings.HasPrefix(module, parent+"/")) {
+				return false
+			}
+		case "testdata", "vendor":
+			return false
+		}
+	}
+	return true
+}
+
+// loadPackageExports returns the exported names declared at the top level of
+// the package in the given directory.
+func loadPackageExports(dir string) map[string]struct{} {
+	packageExportsMu.Lock()
+	defer packageExportsMu.Unlock()
+	if exports, ok := packageExports[dir]; ok {
+		return exports
+	}
+	exports := map[string]struct{}{}
+	if pkg, err := build.ImportDir(dir, 0); err == nil {
+		for _, name := range slices.Concat(pkg.GoFiles, pkg.CgoFiles) {
+			file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+			if err != nil {
+				continue
+			}
+			for _, name := range declaredNames(file) {
+				if token.IsExported(name) {
+					exports[name] = struct{}{}
+				}
+			}
+		}
+	}
+	packageExports[dir] = exports
+	return exports
+}
+
+// loadPackageIndex returns the packages from the std library, and from the
+// module at the given root and its dependencies, according to the go command.
+// If the module can't be loaded, e.g. as there's no go.mod file, only the std
+// packages are returned.
+func loadPackageIndex(root string) ([]*indexedPackage, error) {
+	packageIndexesMu.Lock()
+	defer packageIndexesMu.Unlock()
+	if index, ok := packageIndexes[root]; ok {
+		return index, nil
+	}
+	var index []*indexedPackage
+	seen := map[string]struct{}{}
+	for _, pattern := range []string{"std", "all"} {
+		cmd := exec.Command("go", "list", "-e", "-f", "{{.ImportPath}}\t{{.Name}}\t{{.Dir}}\t{{.Standard}}", pattern)
+		cmd.Dir = root
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if pattern == "all" {
+				break
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("alphafmt: failed to list packages: %s", msg)
+			}
+			return nil, fmt.Errorf("alphafmt: failed to list packages: %w", err)
+		}
+		for line := range strings.Lines(string(out)) {
+			fields := strings.Split(strings.TrimRight(line, "\n"), "\t")
+			if len(fields) != 4 || fields[1] == "" || fields[1] == "main" || fields[2] == "" {
+				continue
+			}
+			if _, ok := seen[fields[0]]; ok {
+				continue
+			}
+			seen[fields[0]] = struct{}{}
+			index = append(index, &indexedPackage{
+				dir:  fields[2],
+				name: fields[1],
+				path: fields[0],
+				std:  fields[3] == "true",
+			})
+		}
+	}
+	packageIndexes[root] = index
+	return index, nil
+}
+
+// resolvePackage returns the package with the given name which exports all of
+// the given names, or nil if there isn't one. Std packages take precedence,
+// followed by the package with the shortest import path.
+func resolvePackage(index []*indexedPackage, name string, sels map[string]struct{}, dir string, cfg *config) *indexedPackage {
+	var match *indexedPackage
+	for _, pkg := range index {
+		if pkg.name != name || pkg.dir == dir || !importable(pkg.path, cfg.module) {
+			continue
+		}
+		if match != nil {
+			if match.std && !pkg.std {
+				continue
+			}
+			if match.std == pkg.std && (len(match.path) < len(pkg.path) || (len(match.path) == len(pkg.path) && match.path < pkg.path)) {
+				continue
+			}
+		}
+		exports := loadPackageExports(pkg.dir)
+		missing := false
+		for sel := range sels {
+			if _, ok := exports[sel]; !ok {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			match = pkg
+		}
+	}
+	return match
+}
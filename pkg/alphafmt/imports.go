@@ -0,0 +1,121 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"go/ast"
+	"go/build"
+	"strings"
+	"unicode"
+)
+
+// assumedPackageName returns the likely name of the package at the given
+// import path, using the same rules as goimports, e.g. "yaml" for
+// "gopkg.in/yaml.v3", and "bar" for "example.com/go-bar/v2".
+func assumedPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if idx := strings.IndexFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}); idx >= 0 {
+		name = name[:idx]
+	}
+	return name
+}
+
+// dedupeImports removes import specs which have the same name and path as an
+// earlier one, as they would otherwise fail to compile. Specs with comments
+// are kept, unless the earlier spec has none, in which case they replace it.
+func dedupeImports(specs []*ast.ImportSpec) []*ast.ImportSpec {
+	seen := map[string]int{}
+	var out []*ast.ImportSpec
+	for _, spec := range specs {
+		key := importPath(spec)
+		if spec.Name != nil {
+			key = spec.Name.Name + " " + key
+		}
+		idx, ok := seen[key]
+		if !ok {
+			seen[key] = len(out)
+			out = append(out, spec)
+			continue
+		}
+		switch {
+		case !hasImportComments(spec):
+		case !hasImportComments(out[idx]):
+			out[idx] = spec
+		default:
+			out = append(out, spec)
+		}
+	}
+	return out
+}
+
+func hasImportComments(spec *ast.ImportSpec) bool {
+	return spec.Doc != nil || spec.Comment != nil
+}
+
+func isMajorVersion(elem string) bool {
+	rest, ok := strings.CutPrefix(elem, "v")
+	if !ok || rest == "" {
+		return false
+	}
+	for _, r := range rest {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// unusedImports returns the imports within the given file whose package name
+// isn't referenced anywhere within it. The names of unnamed imports are first
+// guessed from their paths, and then confirmed by loading the package relative
+// to dir, so that imports are only reported if they are definitely unused.
+// Blank, dot, and cgo imports, and imports with comments, are never reported.
+func unusedImports(file *ast.File, dir string) map[*ast.ImportSpec]struct{} {
+	refs := map[string]struct{}{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok {
+			refs[ident.Name] = struct{}{}
+		}
+		return true
+	})
+	unused := map[*ast.ImportSpec]struct{}{}
+	for _, spec := range file.Imports {
+		path := importPath(spec)
+		if path == "C" || hasImportComments(spec) {
+			continue
+		}
+		if spec.Name != nil {
+			switch name := spec.Name.Name; name {
+			case "_", ".":
+			default:
+				if _, ok := refs[name]; !ok {
+					unused[spec] = struct{}{}
+				}
+			}
+			continue
+		}
+		if _, ok := refs[assumedPackageName(path)]; ok {
+			continue
+		}
+		pkg, err := build.Import(path, dir, build.ImportComment)
+		if err != nil || pkg.Name == "" {
+			continue
+		}
+		if _, ok := refs[pkg.Name]; !ok {
+			unused[spec] = struct{}{}
+		}
+	}
+	return unused
+}
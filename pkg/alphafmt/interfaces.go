@@ -0,0 +1,182 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	interfaces   = map[string]*methodGroup{} // keyed by module root and name
+	interfacesMu sync.Mutex                  // protects interfaces
+)
+
+// methodGroupPattern matches the comment headers written above each cluster
+// of methods when method groups are enabled.
+var methodGroupPattern = regexp.MustCompile(`^// (Methods implementing \S+|Other methods)\.$`)
+
+// methodGroup is an interface whose methods are clustered together.
+type methodGroup struct {
+	methods []string // name and signature of each method
+	name    string
+}
+
+// header returns the comment header for the given cluster of methods.
+func (g *methodGroup) header() string {
+	if g == nil {
+		return "// Other methods."
+	}
+	return "// Methods implementing " + g.name + "."
+}
+
+// astSignature returns the signature of the given func, in the same form as
+// typeSignature.
+func astSignature(fn *ast.FuncDecl) string {
+	fields := func(list *ast.FieldList) string {
+		if list == nil {
+			return "()"
+		}
+		var parts []string
+		for _, field := range list.List {
+			typ := types.ExprString(field.Type)
+			for range max(len(field.Names), 1) {
+				parts = append(parts, typ)
+			}
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return fn.Name.Name + fields(fn.Type.Params) + " " + fields(fn.Type.Results)
+}
+
+// clusterMethods splits the given methods of a type into clusters, one for
+// each of the given interfaces that the type implements, in order, followed
+// by any remaining methods. Each method is only included within the first
+// matching cluster. The group for the remaining methods is nil.
+func clusterMethods(methods []*ast.FuncDecl, groups []*methodGroup) ([][]*ast.FuncDecl, []*methodGroup) {
+	var (
+		clusters [][]*ast.FuncDecl
+		owners   []*methodGroup
+	)
+	claimed := map[*ast.FuncDecl]struct{}{}
+	sigs := make([]string, len(methods))
+	for i, method := range methods {
+		sigs[i] = astSignature(method)
+	}
+	for _, group := range groups {
+		var cluster []*ast.FuncDecl
+		for i, method := range methods {
+			if _, ok := claimed[method]; ok {
+				continue
+			}
+			for _, sig := range group.methods {
+				if sig == sigs[i] {
+					cluster = append(cluster, method)
+				}
+			}
+		}
+		if len(cluster) != len(group.methods) {
+			continue
+		}
+		for _, method := range cluster {
+			claimed[method] = struct{}{}
+		}
+		clusters = append(clusters, cluster)
+		owners = append(owners, group)
+	}
+	if len(clusters) == 0 {
+		return [][]*ast.FuncDecl{methods}, []*methodGroup{nil}
+	}
+	var rest []*ast.FuncDecl
+	for _, method := range methods {
+		if _, ok := claimed[method]; !ok {
+			rest = append(rest, method)
+		}
+	}
+	if len(rest) > 0 {
+		clusters = append(clusters, rest)
+		owners = append(owners, nil)
+	}
+	return clusters, owners
+}
+
+// isMethodGroupHeader reports whether the given comment group only consists
+// of method group headers, which are regenerated on each run.
+func isMethodGroupHeader(group *ast.CommentGroup) bool {
+	for _, comment := range group.List {
+		if !methodGroupPattern.MatchString(comment.Text) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadMethodGroups resolves the configured method groups, e.g. sort.Interface
+// or io.Reader, using the type information for the interfaces. The dir is used
+// to resolve import paths.
+func loadMethodGroups(cfg *config, dir string) ([]*methodGroup, error) {
+	interfacesMu.Lock()
+	defer interfacesMu.Unlock()
+	var groups []*methodGroup
+	for _, name := range cfg.methodGroups {
+		key := cfg.root + "\x00" + name
+		if group, ok := interfaces[key]; ok {
+			groups = append(groups, group)
+			continue
+		}
+		idx := strings.LastIndexByte(name, '.')
+		if idx <= 0 || idx == len(name)-1 {
+			return nil, fmt.Errorf("alphafmt: invalid method group %q: must be of the form <import-path>.<Interface>", name)
+		}
+		imp := importer.ForCompiler(token.NewFileSet(), "source", nil).(types.ImporterFrom)
+		pkg, err := imp.ImportFrom(name[:idx], dir, 0)
+		if err != nil {
+			return nil, fmt.Errorf("alphafmt: failed to load method group %q: %w", name, err)
+		}
+		obj := pkg.Scope().Lookup(name[idx+1:])
+		if obj == nil {
+			return nil, fmt.Errorf("alphafmt: failed to load method group %q: %s is not declared within %s", name, name[idx+1:], pkg.Path())
+		}
+		iface, ok := obj.Type().Underlying().(*types.Interface)
+		if !ok || iface.NumMethods() == 0 {
+			return nil, fmt.Errorf("alphafmt: invalid method group %q: not an interface with methods", name)
+		}
+		group := &methodGroup{name: name}
+		for method := range iface.Methods() {
+			group.methods = append(group.methods, typeSignature(method))
+		}
+		interfaces[key] = group
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// typeSignature returns the name of the given method along with the types of
+// its parameters and results, with types from other packages qualified by the
+// package name, e.g. "WriteTo(io.Writer) (int64, error)".
+func typeSignature(fn *types.Func) string {
+	qualifier := func(pkg *types.Package) string {
+		return pkg.Name()
+	}
+	sig := fn.Type().(*types.Signature)
+	tuple := func(vars *types.Tuple, variadic bool) string {
+		parts := make([]string, vars.Len())
+		for i := range vars.Len() {
+			typ := vars.At(i).Type()
+			if variadic && i == vars.Len()-1 {
+				parts[i] = "..." + types.TypeString(typ.(*types.Slice).Elem(), qualifier)
+				continue
+			}
+			parts[i] = types.TypeString(typ, qualifier)
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return fn.Name() + tuple(sig.Params(), sig.Variadic()) + " " + tuple(sig.Results(), false)
+}
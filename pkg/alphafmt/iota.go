@@ -0,0 +1,88 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// checkSortDirectives returns an error for any //alphafmt:sort directive which
+// isn't in the doc comment of a const block, or which is on a const block
+// whose entries depend on their order.
+func checkSortDirectives(fset *token.FileSet, file *ast.File) error {
+	docs := map[*ast.CommentGroup]*ast.GenDecl{}
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Doc != nil {
+			docs[gen.Doc] = gen
+		}
+	}
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if strings.TrimSpace(comment.Text) != directiveSort {
+				continue
+			}
+			decl, ok := docs[group]
+			if !ok || decl.Tok != token.CONST || !decl.Lparen.IsValid() {
+				return fmt.Errorf("alphafmt: %s: %s can only be used in the doc comment of a const block", fset.Position(comment.Pos()), directiveSort)
+			}
+			if spec := orderDependentSpec(decl); spec != nil {
+				return fmt.Errorf("alphafmt: %s: cannot sort const block, as the value of %s depends on its position within the block", fset.Position(comment.Pos()), spec.Names[0].Name)
+			}
+		}
+	}
+	return nil
+}
+
+// hasSortDirective reports whether the given doc comment contains an
+// //alphafmt:sort directive.
+func hasSortDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, comment := range doc.List {
+		if strings.TrimSpace(comment.Text) == directiveSort {
+			return true
+		}
+	}
+	return false
+}
+
+// orderDependentSpec returns the first entry within the given const block
+// whose value depends on its position within the block, i.e. one that uses
+// iota, or that has no values and thus implicitly repeats the expression of
+// the previous entry. It returns nil if the block can be safely reordered.
+func orderDependentSpec(decl *ast.GenDecl) *ast.ValueSpec {
+	if decl.Tok != token.CONST {
+		return nil
+	}
+	for _, spec := range decl.Specs {
+		spec := spec.(*ast.ValueSpec)
+		if len(spec.Values) == 0 {
+			return spec
+		}
+		for _, value := range spec.Values {
+			if usesIota(value) {
+				return spec
+			}
+		}
+	}
+	return nil
+}
+
+// usesIota reports whether the given expression refers to iota. Any reference
+// is treated as one to the predeclared identifier, even if it is shadowed, so
+// as to err on the side of never reordering.
+func usesIota(expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == "iota" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,299 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Bound on the number of passes made when reordering the elements of struct
+// and map literals, as each pass only rewrites the outermost of any nested
+// literals.
+const maxLiteralPasses = 8
+
+// literalEdit replaces the source between start and end.
+type literalEdit struct {
+	end   int
+	start int
+	text  string
+}
+
+func addStructs(structs map[string][]string, file *ast.File) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.TypeSpec)
+			if st, ok := spec.Type.(*ast.StructType); ok && !spec.Assign.IsValid() {
+				structs[spec.Name.Name] = structFieldNames(st)
+			}
+		}
+	}
+}
+
+// applyEdits returns the source with the given non-overlapping edits applied,
+// in order.
+func applyEdits(src []byte, edits []*literalEdit) []byte {
+	buf := &bytes.Buffer{}
+	prev := 0
+	for _, edit := range edits {
+		buf.Write(src[prev:edit.start])
+		buf.WriteString(edit.text)
+		prev = edit.end
+	}
+	buf.Write(src[prev:])
+	return buf.Bytes()
+}
+
+// hasEffects reports whether evaluating the given expression could involve a
+// function call or channel receive, whose order relative to other elements
+// would matter.
+func hasEffects(expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			found = true
+		case *ast.FuncLit:
+			return false
+		case *ast.UnaryExpr:
+			if node.Op == token.ARROW {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// literalEditFor returns the edit which reorders the elements of the given
+// keyed struct literal to match the order of the given fields, or nil if the
+// literal is already in order or can't be safely reordered.
+func literalEditFor(fset *token.FileSet, src []byte, comments []*ast.CommentGroup, lit *ast.CompositeLit, fields []string) *literalEdit {
+	if len(lit.Elts) < 2 {
+		return nil
+	}
+	order := make([]int, len(lit.Elts))
+	ranks := make([]int, len(lit.Elts))
+	effects := 0
+	for i, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		ranks[i] = slices.Index(fields, key.Name)
+		if ranks[i] == -1 {
+			return nil
+		}
+		if hasEffects(kv.Value) {
+			effects++
+		}
+		order[i] = i
+	}
+	// Element values are evaluated in order, so literals with more than one
+	// call or receive are left alone.
+	if effects > 1 {
+		return nil
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return ranks[a] - ranks[b]
+	})
+	return reorderEdit(fset, src, comments, lit, order)
+}
+
+// orderStructLiterals reorders the elements of keyed struct literals to match
+// the order of the fields in the struct's definition. Struct types are
+// resolved from the given file, as well as from the other files within the
+// same package.
+func orderStructLiterals(filename string, src []byte) ([]byte, error) {
+	var structs map[string][]string
+	for range maxLiteralPasses {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		if structs == nil {
+			structs = packageStructs(filename, file)
+		}
+		var edits []*literalEdit
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			var fields []string
+			switch typ := lit.Type.(type) {
+			case *ast.Ident:
+				fields = structs[typ.Name]
+			case *ast.IndexExpr:
+				fields = structs[typeName(typ)]
+			case *ast.IndexListExpr:
+				fields = structs[typeName(typ)]
+			case *ast.StructType:
+				fields = structFieldNames(typ)
+			}
+			if fields == nil {
+				return true
+			}
+			if edit := literalEditFor(fset, src, file.Comments, lit, fields); edit != nil {
+				edits = append(edits, edit)
+				// Nested literals are handled in a later pass.
+				return false
+			}
+			return true
+		})
+		if len(edits) == 0 {
+			return src, nil
+		}
+		src = applyEdits(src, edits)
+	}
+	return src, nil
+}
+
+// packageFiles returns the paths of the other Go files within the same
+// directory as the given file. Test files are only included for test files.
+func packageFiles(filename string) []string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(abs))
+	if err != nil {
+		return nil
+	}
+	test := strings.HasSuffix(abs, "_test.go")
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".go" || name == filepath.Base(abs) {
+			continue
+		}
+		if strings.HasSuffix(name, "_test.go") && !test {
+			continue
+		}
+		paths = append(paths, filepath.Join(filepath.Dir(abs), name))
+	}
+	return paths
+}
+
+// packageStructs returns the field names of the struct types declared at the
+// top level of the given file, and the other files in its package.
+func packageStructs(filename string, file *ast.File) map[string][]string {
+	structs := map[string][]string{}
+	for _, path := range packageFiles(filename) {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sibling, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+		if err != nil || sibling.Name.Name != file.Name.Name {
+			continue
+		}
+		addStructs(structs, sibling)
+	}
+	// Declarations within the file itself take precedence.
+	addStructs(structs, file)
+	return structs
+}
+
+// reorderEdit returns the edit which rewrites the elements of the given
+// literal in the given order, or nil if the order is unchanged or the literal
+// is laid out in a way that can't be safely reordered.
+func reorderEdit(fset *token.FileSet, src []byte, comments []*ast.CommentGroup, lit *ast.CompositeLit, order []int) *literalEdit {
+	if slices.IsSorted(order) {
+		return nil
+	}
+	tf := fset.File(lit.Pos())
+	offset := tf.Offset
+	if tf.Line(lit.Lbrace) == tf.Line(lit.Rbrace) {
+		for _, group := range comments {
+			if group.Pos() > lit.Lbrace && group.End() < lit.Rbrace {
+				return nil
+			}
+		}
+		parts := make([]string, len(order))
+		for i, idx := range order {
+			elt := lit.Elts[idx]
+			parts[i] = string(src[offset(elt.Pos()):offset(elt.End())])
+		}
+		return &literalEdit{
+			end:   offset(lit.Elts[len(lit.Elts)-1].End()),
+			start: offset(lit.Elts[0].Pos()),
+			text:  strings.Join(parts, ", "),
+		}
+	}
+	elems := make([]ast.Node, len(lit.Elts))
+	for i, elt := range lit.Elts {
+		elems[i] = elt
+	}
+	return reorderLines(tf, src, lit.Lbrace, lit.Rbrace, elems, order)
+}
+
+// reorderLines returns the edit which rewrites the given elements, between the
+// open and close delimiters, in the given order, or nil if any element doesn't
+// start on a new line, as the elements are moved a line at a time so that
+// preceding comments and blank lines, and comments at the end of their last
+// line, move along with them.
+func reorderLines(tf *token.File, src []byte, open token.Pos, close token.Pos, elems []ast.Node, order []int) *literalEdit {
+	prevLine := tf.Line(open)
+	for _, elem := range elems {
+		if tf.Line(elem.Pos()) <= prevLine {
+			return nil
+		}
+		prevLine = tf.Line(elem.End())
+	}
+	if tf.Line(close) <= prevLine {
+		return nil
+	}
+	chunks := make([]string, len(elems))
+	start := lineEnd(src, tf.Offset(open))
+	for i, elem := range elems {
+		end := lineEnd(src, tf.Offset(elem.End()))
+		chunks[i] = string(src[start:end])
+		start = end
+	}
+	buf := &strings.Builder{}
+	for i, idx := range order {
+		chunk := chunks[idx]
+		if i == 0 {
+			chunk = trimBlankLines(chunk)
+		}
+		buf.WriteString(chunk)
+	}
+	return &literalEdit{
+		end:   start,
+		start: lineEnd(src, tf.Offset(open)),
+		text:  buf.String(),
+	}
+}
+
+// structFieldNames returns the names of the fields of the given struct type,
+// in order, with embedded fields named after their type.
+func structFieldNames(st *ast.StructType) []string {
+	names := []string{}
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			names = append(names, typeName(field.Type))
+			continue
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
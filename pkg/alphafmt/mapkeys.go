@@ -0,0 +1,152 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// mapKey is the constant key of a map literal element.
+type mapKey struct {
+	num int64
+	str string
+}
+
+// constantKey returns the value of the given map key, if it is a string or
+// integer literal, along with whether it's a string.
+func constantKey(expr ast.Expr) (mapKey, token.Token, bool) {
+	neg := false
+	if unary, ok := expr.(*ast.UnaryExpr); ok && unary.Op == token.SUB {
+		neg, expr = true, unary.X
+	}
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok {
+		return mapKey{}, token.ILLEGAL, false
+	}
+	switch lit.Kind {
+	case token.INT:
+		num, err := strconv.ParseInt(lit.Value, 0, 64)
+		if err != nil {
+			return mapKey{}, token.ILLEGAL, false
+		}
+		if neg {
+			num = -num
+		}
+		return mapKey{num: num}, token.INT, true
+	case token.STRING:
+		if neg {
+			return mapKey{}, token.ILLEGAL, false
+		}
+		str, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return mapKey{}, token.ILLEGAL, false
+		}
+		return mapKey{str: str}, token.STRING, true
+	}
+	return mapKey{}, token.ILLEGAL, false
+}
+
+// mapLiteralEditFor returns the edit which sorts the elements of the given
+// map literal by key, or nil if the literal is already sorted or can't be
+// safely reordered. All of the keys must be string literals, or all of them
+// integer literals.
+func mapLiteralEditFor(fset *token.FileSet, src []byte, comments []*ast.CommentGroup, lit *ast.CompositeLit, cfg *config) *literalEdit {
+	if len(lit.Elts) < 2 {
+		return nil
+	}
+	keys := make([]mapKey, len(lit.Elts))
+	order := make([]int, len(lit.Elts))
+	kind := token.ILLEGAL
+	effects := 0
+	for i, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil
+		}
+		key, tok, ok := constantKey(kv.Key)
+		if !ok || (kind != token.ILLEGAL && tok != kind) {
+			return nil
+		}
+		kind = tok
+		keys[i] = key
+		if hasEffects(kv.Value) {
+			effects++
+		}
+		order[i] = i
+	}
+	// As with struct literals, values are evaluated in order.
+	if effects > 1 {
+		return nil
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if kind == token.INT {
+			switch {
+			case keys[a].num < keys[b].num:
+				return -1
+			case keys[a].num > keys[b].num:
+				return 1
+			}
+			return 0
+		}
+		switch {
+		case cfg.less(keys[a].str, keys[b].str):
+			return -1
+		case cfg.less(keys[b].str, keys[a].str):
+			return 1
+		}
+		return 0
+	})
+	return reorderEdit(fset, src, comments, lit, order)
+}
+
+// sortMapLiterals sorts the elements of map literals with constant string or
+// integer keys. If all is false, only literals starting on the line directly
+// after an //alphafmt:sort-keys directive are sorted.
+func sortMapLiterals(filename string, src []byte, cfg *config, all bool) ([]byte, error) {
+	for range maxLiteralPasses {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		tf := fset.File(file.Pos())
+		marked := map[int]struct{}{}
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				if strings.TrimSpace(comment.Text) == directiveSortKeys {
+					marked[tf.Line(comment.End())+1] = struct{}{}
+				}
+			}
+		}
+		var edits []*literalEdit
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			if _, ok := lit.Type.(*ast.MapType); !ok {
+				return true
+			}
+			if _, ok := marked[tf.Line(lit.Pos())]; !ok && !all {
+				return true
+			}
+			if edit := mapLiteralEditFor(fset, src, file.Comments, lit, cfg); edit != nil {
+				edits = append(edits, edit)
+				// Nested literals are handled in a later pass.
+				return false
+			}
+			return true
+		})
+		if len(edits) == 0 {
+			return src, nil
+		}
+		src = applyEdits(src, edits)
+	}
+	return src, nil
+}
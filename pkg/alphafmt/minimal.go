@@ -0,0 +1,190 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// Section ranks for top-level declarations in minimal mode.
+const (
+	rankConst = iota + 1
+	rankVar
+	rankType
+	rankMethod
+	rankFunc
+	rankBenchmark
+	rankFuzz
+	rankExample
+	rankMain
+	rankInit
+)
+
+// declKey determines the sorted position of a top-level declaration.
+type declKey struct {
+	group string
+	name  string
+	rank  int
+	sub   int
+}
+
+func (k declKey) less(o declKey, cfg *config) bool {
+	if k.rank != o.rank {
+		return k.rank < o.rank
+	}
+	if k.group != o.group {
+		return cfg.less(k.group, o.group)
+	}
+	if k.sub != o.sub {
+		return k.sub < o.sub
+	}
+	return cfg.less(k.name, o.name)
+}
+
+// minimalChunk is the source text for a top-level declaration, along with any
+// comments preceding it.
+type minimalChunk struct {
+	frozen bool
+	key    declKey
+	text   string
+}
+
+// keyForDecl returns the sort key for the given declaration, mirroring the
+// order used when fully sorting a file. The types are those declared within
+// the file, and are used to identify constructors. Functions attached to an
+// anchor via an //alphafmt:with directive sort directly after it.
+func keyForDecl(decl ast.Decl, cfg *config, types map[string]struct{}, testFile bool, anchors map[*ast.FuncDecl]*ast.FuncDecl) declKey {
+	switch node := decl.(type) {
+	case *ast.FuncDecl:
+		if anchor, ok := anchors[node]; ok {
+			key := keyForDecl(anchor, cfg, types, testFile, anchors)
+			key.name += "\x00" + node.Name.Name
+			return key
+		}
+		if node.Recv != nil {
+			recv := receiverTypeName(node.Recv)
+			if cfg.methods == methodsWithType {
+				return declKey{group: recv, name: node.Name.Name, rank: rankType, sub: 2}
+			}
+			return declKey{group: recv, name: node.Name.Name, rank: rankMethod}
+		}
+		if cfg.constructorsWithType {
+			if name := constructorType(node, types); name != "" {
+				return declKey{group: name, name: node.Name.Name, rank: rankType, sub: 1}
+			}
+		}
+		switch node.Name.Name {
+		case "main":
+			return declKey{rank: rankMain}
+		case "init":
+			return declKey{rank: rankInit}
+		}
+		if testFile {
+			if node.Name.Name == "TestMain" {
+				return declKey{rank: rankFunc, sub: -1}
+			}
+			return declKey{name: node.Name.Name, rank: testFuncRank(node)}
+		}
+		return declKey{name: node.Name.Name, rank: rankFunc}
+	case *ast.GenDecl:
+		sub := 1
+		if node.Lparen.IsValid() {
+			sub = 0
+		}
+		switch node.Tok {
+		case token.CONST:
+			return declKey{name: firstDeclName(node), rank: rankConst, sub: sub}
+		case token.TYPE:
+			return declKey{group: firstDeclName(node), rank: rankType}
+		case token.VAR:
+			return declKey{name: firstDeclName(node), rank: rankVar, sub: sub}
+		}
+	}
+	return declKey{}
+}
+
+// orderFileDeclsMinimal moves declarations towards their sorted positions
+// without rewriting them. In a single pass, each declaration is only swapped
+// with its immediate neighbour if the two are out of order, so that repeated
+// runs converge on the fully sorted order while keeping each diff small.
+// Declarations within //alphafmt:off regions are never moved, and neither are
+// section markers.
+func orderFileDeclsMinimal(fset *token.FileSet, file *ast.File, src []byte, cfg *config) ([]byte, error) {
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+	headerEnd := lineEnd(src, offset(file.Name.End()))
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			headerEnd = lineEnd(src, offset(gen.End()))
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	if len(decls) < 2 {
+		return src, nil
+	}
+	types := map[string]struct{}{}
+	for _, decl := range decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.TYPE {
+			for _, spec := range gen.Specs {
+				types[spec.(*ast.TypeSpec).Name.Name] = struct{}{}
+			}
+		}
+	}
+	regions := findRegions(fset, file, src)
+	markers := findSectionMarkers(file, regions)
+	filename := fset.Position(file.Pos()).Filename
+	anchors, err := findAnchors(fset, filename, decls, regions, markers)
+	if err != nil {
+		return nil, err
+	}
+	testFile := strings.HasSuffix(filename, "_test.go")
+	var chunks []*minimalChunk
+	start := headerEnd
+	for _, decl := range decls {
+		// Section markers become frozen chunks of their own, so that no
+		// declaration is ever moved across them.
+		for len(markers) > 0 && markers[0].pos < decl.Pos() {
+			if end := lineEnd(src, offset(markers[0].pos)); end > start {
+				chunks = append(chunks, &minimalChunk{
+					frozen: true,
+					text:   strings.Trim(string(src[start:end]), "\n"),
+				})
+				start = end
+			}
+			markers = markers[1:]
+		}
+		end := lineEnd(src, offset(decl.End()))
+		text := string(src[start:end])
+		chunks = append(chunks, &minimalChunk{
+			frozen: findRegion(regions, decl) != nil || strings.Contains(text, directiveOff) || strings.Contains(text, directiveOn),
+			key:    keyForDecl(decl, cfg, types, testFile, anchors),
+			text:   strings.Trim(text, "\n"),
+		})
+		start = end
+	}
+	for i := 0; i+1 < len(chunks); i++ {
+		a, b := chunks[i], chunks[i+1]
+		if a.frozen || b.frozen {
+			continue
+		}
+		if b.key.less(a.key, cfg) {
+			chunks[i], chunks[i+1] = b, a
+		}
+	}
+	buf := &bytes.Buffer{}
+	buf.Write(src[:headerEnd])
+	for _, chunk := range chunks {
+		buf.WriteByte('\n')
+		buf.WriteString(chunk.text)
+		buf.WriteByte('\n')
+	}
+	buf.Write(src[start:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+// FormatModFile formats the given go.mod or go.work file. The entries within
+// blocks like require and replace are sorted, duplicate exclude and replace
+// directives are removed, and whitespace is normalized, like go mod edit -fmt.
+// The filename is used to determine the type of file, and for error messages.
+func FormatModFile(filename string, src []byte) ([]byte, error) {
+	switch filepath.Base(filename) {
+	case "go.mod":
+		file, err := modfile.Parse(filename, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		file.SortBlocks()
+		file.Cleanup()
+		return modfile.Format(file.Syntax), nil
+	case "go.work":
+		file, err := modfile.ParseWork(filename, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		file.SortBlocks()
+		file.Cleanup()
+		return modfile.Format(file.Syntax), nil
+	}
+	return nil, fmt.Errorf("alphafmt: %s is not a go.mod or go.work file", filename)
+}
+
+// IsModFile reports whether the given path is for a go.mod or go.work file.
+func IsModFile(path string) bool {
+	switch filepath.Base(path) {
+	case "go.mod", "go.work":
+		return true
+	}
+	return false
+}
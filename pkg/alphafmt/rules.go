@@ -0,0 +1,161 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+	"sync"
+)
+
+var (
+	rules   = map[string]Rule{} // keyed by name
+	rulesMu sync.RWMutex        // protects rules
+)
+
+// Rule is a custom ordering pass, e.g. for domain-specific grouping of
+// declarations, or for checks like banned imports. Rules are registered with
+// RegisterRule, and enabled for a module by listing their names within the
+// rules setting of its config file.
+//
+// Enabled rules are run in the listed order, on each file after the built-in
+// ordering has been applied. Rules are not run in minimal mode, or on files
+// with an //alphafmt:ignore directive.
+type Rule interface {
+	// Name returns the name used to enable the rule within config files.
+	Name() string
+
+	// Order returns the top-level declarations of the given file in the
+	// order they should be written. Import declarations must keep their
+	// positions. A nil slice keeps the current order, and an error is
+	// reported as a failure to format the file.
+	//
+	// Declarations are moved along with any comments preceding them, and
+	// the file must not be modified.
+	Order(fset *token.FileSet, file *ast.File) ([]ast.Decl, error)
+}
+
+// RegisterRule makes the given rule available for use within config files.
+// It is intended to be called from init functions, and panics if the rule's
+// name is empty or has already been registered.
+func RegisterRule(rule Rule) {
+	name := rule.Name()
+	if name == "" {
+		panic("alphafmt: rule name cannot be empty")
+	}
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	if _, ok := rules[name]; ok {
+		panic(fmt.Errorf("alphafmt: rule %q has already been registered", name))
+	}
+	rules[name] = rule
+}
+
+// applyRule reorders the declarations within the given gofmt-formatted source
+// according to the given rule.
+func applyRule(filename string, src []byte, rule Rule) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	decls, err := rule.Order(fset, file)
+	if err != nil {
+		return nil, fmt.Errorf("alphafmt: rule %q failed for %s: %w", rule.Name(), filename, err)
+	}
+	if decls == nil {
+		return src, nil
+	}
+	if !validOrder(file.Decls, decls) {
+		return nil, fmt.Errorf("alphafmt: rule %q returned an invalid declaration order for %s", rule.Name(), filename)
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+	// Each declaration is moved along with the text between it and the
+	// previous declaration, i.e. any doc and floating comments.
+	header := lineEnd(src, offset(file.Name.End()))
+	start := header
+	chunks := map[ast.Decl]string{}
+	for _, decl := range file.Decls {
+		end := lineEnd(src, offset(decl.End()))
+		if isImport(decl) {
+			header = end
+		} else {
+			chunks[decl] = strings.Trim(string(src[start:end]), "\n")
+		}
+		start = end
+	}
+	if len(chunks) == 0 {
+		return src, nil
+	}
+	buf := &bytes.Buffer{}
+	buf.Write(src[:header])
+	for _, decl := range decls {
+		if text, ok := chunks[decl]; ok {
+			buf.WriteByte('\n')
+			buf.WriteString(text)
+			buf.WriteByte('\n')
+		}
+	}
+	if tail := strings.Trim(string(src[start:]), "\n"); tail != "" {
+		buf.WriteByte('\n')
+		buf.WriteString(tail)
+		buf.WriteByte('\n')
+	}
+	return format.Source(buf.Bytes())
+}
+
+// applyRules applies the rules with the given names, in order.
+func applyRules(filename string, src []byte, names []string) ([]byte, error) {
+	for _, name := range names {
+		rulesMu.RLock()
+		rule, ok := rules[name]
+		rulesMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("alphafmt: unknown rule %q in the config for %s", name, filename)
+		}
+		var err error
+		src, err = applyRule(filename, src, rule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+func isImport(decl ast.Decl) bool {
+	gen, ok := decl.(*ast.GenDecl)
+	return ok && gen.Tok == token.IMPORT
+}
+
+// validOrder reports whether the given declarations are a reordering of the
+// original declarations, with the import declarations in their original
+// positions.
+func validOrder(orig []ast.Decl, decls []ast.Decl) bool {
+	if len(orig) != len(decls) {
+		return false
+	}
+	seen := map[ast.Decl]struct{}{}
+	for i, decl := range decls {
+		if _, ok := seen[decl]; ok {
+			return false
+		}
+		seen[decl] = struct{}{}
+		if isImport(orig[i]) != isImport(decl) || (isImport(decl) && orig[i] != decl) {
+			return false
+		}
+	}
+	for _, decl := range orig {
+		if _, ok := seen[decl]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	stdPackages   = map[string]map[string]struct{}{} // keyed by module root
+	stdPackagesMu sync.Mutex                         // protects stdPackages
+)
+
+// loadStdPackages returns the set of std packages according to the go command
+// for the config's module, so that the toolchain selected by the module is
+// used. It returns nil if std imports are guessed instead.
+func loadStdPackages(cfg *config) (map[string]struct{}, error) {
+	if cfg.stdImports != stdGoList {
+		return nil, nil
+	}
+	stdPackagesMu.Lock()
+	defer stdPackagesMu.Unlock()
+	if std, ok := stdPackages[cfg.root]; ok {
+		return std, nil
+	}
+	cmd := exec.Command("go", "list", "-e", "-f", "{{.ImportPath}}", "std")
+	cmd.Dir = cfg.root
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("alphafmt: failed to list std packages: %s", msg)
+		}
+		return nil, fmt.Errorf("alphafmt: failed to list std packages: %w", err)
+	}
+	std := map[string]struct{}{}
+	for path := range strings.FieldsSeq(string(out)) {
+		std[path] = struct{}{}
+	}
+	if len(std) == 0 {
+		return nil, fmt.Errorf("alphafmt: failed to list std packages: go list returned no packages")
+	}
+	stdPackages[cfg.root] = std
+	return std, nil
+}
@@ -0,0 +1,163 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// directivePattern matches comments which are tool directives, e.g.
+// //go:generate or //nolint:errcheck, and must not have a space inserted.
+var directivePattern = regexp.MustCompile(`^//(line |export |extern |sys|nolint|[a-z0-9]+:[a-z0-9])`)
+
+// applyStrict applies the stricter formatting rules of the -strict profile to
+// the given gofmt-formatted source:
+//
+//   - Blocks don't start or end with empty lines.
+//   - Simple var declarations within functions use short variable
+//     declarations, e.g. x := 1 instead of var x = 1.
+//   - Octal integer literals use the 0o prefix.
+//   - Comments which aren't directives start with a space.
+func applyStrict(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(file.Pos())
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			comment.Text = spaceComment(comment.Text)
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.BasicLit:
+			if node.Kind == token.INT {
+				node.Value = canonicalOctal(node.Value)
+			}
+		case *ast.BlockStmt:
+			node.List = shortVarDecls(node.List)
+			trimBlock(tf, file.Comments, node)
+		case *ast.CaseClause:
+			node.Body = shortVarDecls(node.Body)
+		case *ast.CommClause:
+			node.Body = shortVarDecls(node.Body)
+		}
+		return true
+	})
+	buf := &bytes.Buffer{}
+	if err := format.Node(buf, fset, file); err != nil {
+		return nil, fmt.Errorf("alphafmt: failed to format %s in strict mode: %w", filename, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// canonicalOctal rewrites legacy octal literals like 0755 to use the 0o
+// prefix.
+func canonicalOctal(lit string) string {
+	if len(lit) < 2 || lit[0] != '0' {
+		return lit
+	}
+	for _, c := range lit[1:] {
+		if c < '0' || c > '7' {
+			return lit
+		}
+	}
+	return "0o" + lit[1:]
+}
+
+// shortVarDecls replaces statements like var x = 1 with x := 1. Declarations
+// with types, comments, or multiple specs are left alone, as are those which
+// only declare blank identifiers.
+func shortVarDecls(stmts []ast.Stmt) []ast.Stmt {
+	for i, stmt := range stmts {
+		decl, ok := stmt.(*ast.DeclStmt)
+		if !ok {
+			continue
+		}
+		gen, ok := decl.Decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR || gen.Lparen.IsValid() || gen.Doc != nil || len(gen.Specs) != 1 {
+			continue
+		}
+		spec := gen.Specs[0].(*ast.ValueSpec)
+		if spec.Type != nil || spec.Doc != nil || spec.Comment != nil || len(spec.Values) != len(spec.Names) {
+			continue
+		}
+		blank := true
+		lhs := make([]ast.Expr, len(spec.Names))
+		for j, name := range spec.Names {
+			lhs[j] = name
+			if name.Name != "_" {
+				blank = false
+			}
+		}
+		if blank {
+			continue
+		}
+		stmts[i] = &ast.AssignStmt{
+			Lhs:    lhs,
+			Rhs:    spec.Values,
+			Tok:    token.DEFINE,
+			TokPos: spec.Names[len(spec.Names)-1].End(),
+		}
+	}
+	return stmts
+}
+
+// spaceComment inserts a space after the // of a line comment, unless it is a
+// directive, or doesn't start with a letter or digit.
+func spaceComment(text string) string {
+	if !strings.HasPrefix(text, "//") || directivePattern.MatchString(text) {
+		return text
+	}
+	r, _ := utf8.DecodeRuneInString(text[2:])
+	if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		return text
+	}
+	return "// " + text[2:]
+}
+
+// trimBlock removes any empty lines directly after the opening brace, or
+// before the closing brace, of a block. Lines are merged within the token
+// file, so that the printer sees the block's contents as adjacent to its
+// braces.
+func trimBlock(tf *token.File, comments []*ast.CommentGroup, block *ast.BlockStmt) {
+	var first, last token.Pos
+	if len(block.List) > 0 {
+		first, last = block.List[0].Pos(), block.List[len(block.List)-1].End()
+	}
+	for _, group := range comments {
+		if group.Pos() <= block.Lbrace || group.End() >= block.Rbrace {
+			continue
+		}
+		if first == token.NoPos || group.Pos() < first {
+			first = group.Pos()
+		}
+		if group.End() > last {
+			last = group.End()
+		}
+	}
+	if first == token.NoPos {
+		for tf.Line(block.Rbrace)-tf.Line(block.Lbrace) > 1 {
+			tf.MergeLine(tf.Line(block.Lbrace))
+		}
+		return
+	}
+	for tf.Line(first)-tf.Line(block.Lbrace) > 1 {
+		tf.MergeLine(tf.Line(block.Lbrace))
+	}
+	for tf.Line(block.Rbrace)-tf.Line(last) > 1 {
+		tf.MergeLine(tf.Line(last))
+	}
+}
@@ -0,0 +1,157 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// structTag is a single key:"value" pair within a struct tag.
+type structTag struct {
+	key   string
+	value string // quoted
+}
+
+// canonicalTag returns the canonical form of the given struct tag literal,
+// keeping its quoting style where possible.
+func canonicalTag(lit string, order []string) (string, error) {
+	tag, err := strconv.Unquote(lit)
+	if err != nil {
+		return "", fmt.Errorf("malformed struct tag %s", lit)
+	}
+	pairs, err := parseStructTag(tag)
+	if err != nil {
+		return "", fmt.Errorf("malformed struct tag %s: %w", lit, err)
+	}
+	var deduped []structTag
+	for _, pair := range pairs {
+		idx := slices.IndexFunc(deduped, func(t structTag) bool { return t.key == pair.key })
+		if idx == -1 {
+			deduped = append(deduped, pair)
+			continue
+		}
+		if deduped[idx].value != pair.value {
+			return "", fmt.Errorf("struct tag %s has conflicting values for %q", lit, pair.key)
+		}
+	}
+	rank := func(key string) int {
+		if idx := slices.Index(order, key); idx != -1 {
+			return idx
+		}
+		return len(order)
+	}
+	slices.SortStableFunc(deduped, func(a, b structTag) int {
+		return rank(a.key) - rank(b.key)
+	})
+	parts := make([]string, len(deduped))
+	for i, pair := range deduped {
+		parts[i] = pair.key + ":" + pair.value
+	}
+	tag = strings.Join(parts, " ")
+	if strings.HasPrefix(lit, "`") && !strings.Contains(tag, "`") {
+		return "`" + tag + "`", nil
+	}
+	return strconv.Quote(tag), nil
+}
+
+// canonicalizeTags rewrites the struct tags within the given source so that
+// the well-known keys come first, in the configured order, followed by any
+// other keys in their original order. Pairs are separated by a single space,
+// and duplicate pairs are removed. Malformed tags, as well as keys with
+// conflicting values, are reported as errors.
+func canonicalizeTags(filename string, src []byte, cfg *config) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		errs  scanner.ErrorList
+		lits  []*ast.BasicLit
+		texts []string
+	)
+	ast.Inspect(file, func(n ast.Node) bool {
+		field, ok := n.(*ast.Field)
+		if !ok || field.Tag == nil {
+			return true
+		}
+		text, err := canonicalTag(field.Tag.Value, cfg.structTagOrder)
+		if err != nil {
+			errs.Add(fset.Position(field.Tag.Pos()), err.Error())
+			return true
+		}
+		if text != field.Tag.Value {
+			lits = append(lits, field.Tag)
+			texts = append(texts, text)
+		}
+		return true
+	})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(lits) == 0 {
+		return src, nil
+	}
+	buf := &bytes.Buffer{}
+	prev := 0
+	for i, lit := range lits {
+		start := fset.Position(lit.Pos()).Offset
+		buf.Write(src[prev:start])
+		buf.WriteString(texts[i])
+		prev = start + len(lit.Value)
+	}
+	buf.Write(src[prev:])
+	return buf.Bytes(), nil
+}
+
+// parseStructTag splits a struct tag into its key:"value" pairs, following the
+// conventions used by reflect.StructTag.
+func parseStructTag(tag string) ([]structTag, error) {
+	var pairs []structTag
+	for {
+		tag = strings.TrimLeft(tag, " \t")
+		if tag == "" {
+			return pairs, nil
+		}
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("expected a key at %q", tag)
+		}
+		if i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, fmt.Errorf("expected a quoted value after the key %q", tag[:i])
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, fmt.Errorf("unterminated value for the key %q", key)
+		}
+		value := tag[:i+1]
+		if _, err := strconv.Unquote(value); err != nil {
+			return nil, fmt.Errorf("invalid value for the key %q", key)
+		}
+		pairs = append(pairs, structTag{key: key, value: value})
+		tag = tag[i+1:]
+		if tag != "" && tag[0] != ' ' && tag[0] != '\t' {
+			return nil, fmt.Errorf("expected a space after the value for the key %q", key)
+		}
+	}
+}
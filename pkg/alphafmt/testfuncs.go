@@ -0,0 +1,154 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// findAnchors returns the functions which are kept directly after another
+// function via an //alphafmt:with directive in their doc comment, mapped to
+// that function. The directive can only be used within _test.go files, and
+// both functions must be top-level functions without receivers, within the
+// same section, and outside of //alphafmt:off regions.
+func findAnchors(fset *token.FileSet, filename string, decls []ast.Decl, regions []*region, markers []*sectionMarker) (map[*ast.FuncDecl]*ast.FuncDecl, error) {
+	section := func(pos token.Pos) int {
+		idx := 0
+		for idx < len(markers) && markers[idx].pos < pos {
+			idx++
+		}
+		return idx
+	}
+	funcs := map[string]*ast.FuncDecl{}
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+			funcs[fn.Name.Name] = fn
+		}
+	}
+	anchors := map[*ast.FuncDecl]*ast.FuncDecl{}
+	for _, decl := range decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		target, pos := withDirective(fn.Doc)
+		if !pos.IsValid() {
+			continue
+		}
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("alphafmt: %s: "+format, append([]any{fset.Position(pos)}, args...)...)
+		}
+		if !strings.HasSuffix(filename, "_test.go") {
+			return nil, errorf("%s can only be used within _test.go files", directiveWith)
+		}
+		if fn.Recv != nil || fn.Name.Name == "init" || fn.Name.Name == "main" {
+			return nil, errorf("%s can only be used on functions without receivers", directiveWith)
+		}
+		anchor, ok := funcs[target]
+		if !ok || target == "init" || target == "main" {
+			return nil, errorf("%s target %q is not a function declared within the file", directiveWith, target)
+		}
+		if section(anchor.Pos()) != section(fn.Pos()) {
+			return nil, errorf("%s target %q is within a different section", directiveWith, target)
+		}
+		if findRegion(regions, fn) != nil || findRegion(regions, anchor) != nil {
+			return nil, errorf("%s cannot be used with functions within an %s region", directiveWith, directiveOff)
+		}
+		anchors[fn] = anchor
+	}
+	// Functions attached to each other in a cycle would never be written.
+	for _, decl := range decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		cur := fn
+		for range len(anchors) {
+			next, ok := anchors[cur]
+			if !ok {
+				break
+			}
+			if next == fn {
+				_, pos := withDirective(fn.Doc)
+				return nil, fmt.Errorf("alphafmt: %s: %s directives form a cycle", fset.Position(pos), directiveWith)
+			}
+			cur = next
+		}
+	}
+	return anchors, nil
+}
+
+// isTestFunc reports whether the given name is that of a test function with
+// the given prefix, e.g. Benchmark, using the same rule as go test, i.e. the
+// prefix isn't followed by a lowercase letter.
+func isTestFunc(name string, prefix string) bool {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return !unicode.IsLower(r)
+}
+
+// takesTestingParam reports whether the given function takes a single pointer
+// parameter of the given type from the testing package, e.g. *testing.B, and
+// has no results. Any name is accepted for the package, as it may be imported
+// under a different one.
+func takesTestingParam(fn *ast.FuncDecl, typ string) bool {
+	params := fn.Type.Params.List
+	if fn.Type.Results != nil || len(params) != 1 || len(params[0].Names) > 1 {
+		return false
+	}
+	star, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == typ
+}
+
+// testFuncRank returns the section rank for the given top-level function
+// within a _test.go file, i.e. whether it's a benchmark, fuzz test, example,
+// or any other function. As with go test, functions are only treated as
+// benchmarks, fuzz tests, or examples if they have the expected signature, so
+// that e.g. a helper like ExampleConfig() *Config is left with the others.
+func testFuncRank(fn *ast.FuncDecl) int {
+	if fn.Recv != nil || fn.Type.TypeParams != nil {
+		return rankFunc
+	}
+	switch name := fn.Name.Name; {
+	case isTestFunc(name, "Benchmark") && takesTestingParam(fn, "B"):
+		return rankBenchmark
+	case isTestFunc(name, "Example") && len(fn.Type.Params.List) == 0 && fn.Type.Results == nil:
+		return rankExample
+	case isTestFunc(name, "Fuzz") && takesTestingParam(fn, "F"):
+		return rankFuzz
+	}
+	return rankFunc
+}
+
+// withDirective returns the target of the //alphafmt:with directive within
+// the given doc comment, along with the position of the directive, which is
+// invalid if there isn't one.
+func withDirective(doc *ast.CommentGroup) (string, token.Pos) {
+	if doc == nil {
+		return "", token.NoPos
+	}
+	for _, comment := range doc.List {
+		rest, ok := strings.CutPrefix(comment.Text, directiveWith)
+		if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		return strings.TrimSpace(rest), comment.Pos()
+	}
+	return "", token.NoPos
+}
@@ -0,0 +1,77 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// VerifyOutput checks the output of formatting the given source, and returns
+// an error if formatting the output again would change it, or if any comment
+// within the source is missing from the output. Comments are compared token by
+// token, ignoring whitespace, so that they are checked independently of the
+// safeguards applied during formatting. The filename and opts should match
+// those used to produce the output. As each run only moves declarations part
+// of the way in minimal mode, the output is only checked for lost comments
+// in that mode.
+func VerifyOutput(filename string, src []byte, out []byte, opts *Options) error {
+	if opts == nil || !opts.Minimal {
+		again, err := FormatWithOptions(filename, out, opts)
+		if err != nil {
+			return fmt.Errorf("alphafmt: %s: failed to reformat output: %w", filename, err)
+		}
+		if !bytes.Equal(out, again) {
+			line := 1
+			for i := range min(len(out), len(again)) {
+				if out[i] != again[i] {
+					break
+				}
+				if out[i] == '\n' {
+					line++
+				}
+			}
+			return fmt.Errorf("alphafmt: %s:%d: formatting is not stable, a second pass changes the output", filename, line)
+		}
+	}
+	kept := map[string]int{}
+	for _, text := range scanComments(out) {
+		kept[text]++
+	}
+	for _, text := range scanComments(src) {
+		if kept[text] == 0 {
+			return fmt.Errorf("alphafmt: %s: comment was lost during formatting: %q", filename, text)
+		}
+		kept[text]--
+	}
+	return nil
+}
+
+// scanComments returns the text of each comment token within the given
+// source, with all whitespace removed. Comments without any text are skipped,
+// as gofmt drops them from doc comments.
+func scanComments(src []byte) []string {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s := &scanner.Scanner{}
+	s.Init(file, src, nil, scanner.ScanComments)
+	var comments []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return comments
+		}
+		if tok != token.COMMENT {
+			continue
+		}
+		switch text := strings.Join(strings.Fields(lit), ""); text {
+		case "//", "/**/":
+		default:
+			comments = append(comments, text)
+		}
+	}
+}
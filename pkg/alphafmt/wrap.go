@@ -0,0 +1,155 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package alphafmt
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+	"unicode/utf8"
+)
+
+// Bound on the number of passes made when wrapping long lines, as each pass
+// only wraps the outermost list on each line.
+const maxWrapPasses = 8
+
+// Width of a tab when measuring lines for wrapping, matching how most editors
+// display Go source.
+const wrapTabWidth = 4
+
+// wrapList is a comma-separated list which can be wrapped onto multiple lines,
+// e.g. the arguments of a call.
+type wrapList struct {
+	close token.Pos
+	elems []ast.Node
+	end   token.Pos // end of the last element, including any ellipsis
+	open  token.Pos
+}
+
+// longLines returns the numbers of the lines within src which are longer than
+// the given maximum, with tabs counted as wrapTabWidth columns.
+func longLines(src []byte, maxLen int) map[int]struct{} {
+	long := map[int]struct{}{}
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		width := utf8.RuneCount(line) + bytes.Count(line, []byte("\t"))*(wrapTabWidth-1)
+		if width > maxLen {
+			long[i+1] = struct{}{}
+		}
+	}
+	return long
+}
+
+// wrapLongLines wraps the parameters of functions, the arguments of calls, and
+// the elements of composite literals onto multiple lines, with one entry per
+// line, when they're on a line longer than maxLen. On each line, the outermost
+// list is wrapped first, with any inner lists only wrapped in later passes if
+// the line is still too long. Lists are only wrapped if they are entirely on
+// one line, and that line has no comments.
+func wrapLongLines(filename string, src []byte, maxLen int) ([]byte, error) {
+	for range maxWrapPasses {
+		long := longLines(src, maxLen)
+		if len(long) == 0 {
+			return src, nil
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		tf := fset.File(file.Pos())
+		for _, group := range file.Comments {
+			for _, comment := range group.List {
+				for line := tf.Line(comment.Pos()); line <= tf.Line(comment.End()); line++ {
+					delete(long, line)
+				}
+			}
+		}
+		seen := map[int]struct{}{}
+		var edits []*literalEdit
+		ast.Inspect(file, func(n ast.Node) bool {
+			list := wrappableList(n)
+			if list == nil {
+				return true
+			}
+			line := tf.Line(list.open)
+			if _, ok := long[line]; !ok || tf.Line(list.close) != line {
+				return true
+			}
+			if _, ok := seen[line]; ok {
+				return true
+			}
+			seen[line] = struct{}{}
+			buf := &strings.Builder{}
+			buf.WriteByte('\n')
+			for i, elem := range list.elems {
+				end := elem.End()
+				if i == len(list.elems)-1 {
+					end = list.end
+				}
+				buf.Write(src[tf.Offset(elem.Pos()):tf.Offset(end)])
+				buf.WriteString(",\n")
+			}
+			edits = append(edits, &literalEdit{
+				end:   tf.Offset(list.close),
+				start: tf.Offset(list.open) + 1,
+				text:  buf.String(),
+			})
+			return false
+		})
+		if len(edits) == 0 {
+			return src, nil
+		}
+		src, err = format.Source(applyEdits(src, edits))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// wrappableList returns the list within the given node which can be wrapped,
+// or nil if there isn't one. For function types, the parameters are wrapped,
+// or the results if there are no parameters.
+func wrappableList(n ast.Node) *wrapList {
+	switch node := n.(type) {
+	case *ast.CallExpr:
+		if len(node.Args) == 0 {
+			return nil
+		}
+		list := &wrapList{close: node.Rparen, end: node.Args[len(node.Args)-1].End(), open: node.Lparen}
+		if node.Ellipsis.IsValid() {
+			list.end = node.Ellipsis + token.Pos(len("..."))
+		}
+		for _, arg := range node.Args {
+			list.elems = append(list.elems, arg)
+		}
+		return list
+	case *ast.CompositeLit:
+		if len(node.Elts) == 0 {
+			return nil
+		}
+		list := &wrapList{close: node.Rbrace, end: node.Elts[len(node.Elts)-1].End(), open: node.Lbrace}
+		for _, elt := range node.Elts {
+			list.elems = append(list.elems, elt)
+		}
+		return list
+	case *ast.FuncType:
+		fields := node.Params
+		if fields.NumFields() == 0 {
+			fields = node.Results
+		}
+		if fields == nil || len(fields.List) == 0 || !fields.Opening.IsValid() {
+			return nil
+		}
+		list := &wrapList{close: fields.Closing, end: fields.List[len(fields.List)-1].End(), open: fields.Opening}
+		for _, field := range fields.List {
+			list.elems = append(list.elems, field)
+		}
+		return list
+	}
+	return nil
+}
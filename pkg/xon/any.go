@@ -0,0 +1,36 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"reflect"
+)
+
+// FromAny converts the given value, e.g. a map[string]any from a template
+// engine or validator, into XON nodes, following the same rules as Marshal.
+// The value must be a map or struct, with maps becoming blocks, slices of maps
+// becoming repeated blocks, and other slices becoming lists. Map keys are
+// sorted, and scalars become strings, with nil becoming `nil`.
+func FromAny(v any) ([]Node, error) {
+	return (&encodeState{}).encodeDocument(reflect.ValueOf(v))
+}
+
+// ToAny converts the given XON nodes into a map[string]any, as if they were
+// decoded into an empty interface by Unmarshal, so that they can be used with
+// libraries that expect generic maps. The result is always a non-nil map.
+//
+// Blocks become maps, and repeated blocks become a []any of maps. Lists become
+// a []any, strings become a string, bytes literals become a []byte, and the
+// unquoted `nil` becomes nil. The contents of versioned blocks and references
+// are merged into their parent, while comments and include directives are
+// dropped. If a name is used more than once, e.g. for both a key and a block,
+// the last one wins.
+func ToAny(nodes []Node) any {
+	m := map[string]any{}
+	d := &decodeState{duplicates: DuplicateKeyLastWins}
+	// Decoding into empty interfaces can't fail, as duplicates are resolved,
+	// and env references aren't expanded.
+	_ = d.decodeMembers(nodes, reflect.ValueOf(m), "")
+	return m
+}
@@ -0,0 +1,109 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"sync"
+)
+
+const defaultChunkSize = 256
+
+// arenaPool holds the arenas used by Unmarshal and Decode, which only need the
+// parsed nodes until the document has been decoded.
+var arenaPool = sync.Pool{New: func() any { return &Arena{} }}
+
+// Arena allocates the nodes and values of parsed documents in chunks, instead
+// of one at a time, and lets their memory be reused across documents parsed
+// with ParseArena. The zero value is ready to use.
+//
+// Nodes from an arena are only valid until it's Reset, after which they will
+// be overwritten by later documents. Nodes which need to outlive the arena can
+// be copied out of it with Clone.
+type Arena struct {
+	ChunkSize int // the number of each kind of node or value to allocate at a time, defaulting to 256
+
+	blocks    slab[Block]
+	comments  slab[Comment]
+	keyValues slab[KeyValue]
+	lists     slab[List]
+	nodes     slab[Node]
+	strings   slab[String]
+	values    slab[Value]
+}
+
+// Reset frees up the memory used by the arena, so that it can be reused for
+// later documents.
+func (a *Arena) Reset() {
+	a.blocks.reset()
+	a.comments.reset()
+	a.keyValues.reset()
+	a.lists.reset()
+	a.nodes.reset()
+	a.strings.reset()
+	a.values.reset()
+}
+
+func (a *Arena) chunkSize() int {
+	if a.ChunkSize > 0 {
+		return a.ChunkSize
+	}
+	return defaultChunkSize
+}
+
+// slab holds the chunks of memory for values of a particular type.
+type slab[T any] struct {
+	chunks [][]T
+	idx    int // the index of the chunk being used
+	used   int // the number of values used within the current chunk
+}
+
+// alloc returns n contiguous zero values, taken from the current chunk if they
+// fit, with their capacity limited so that appending to them never overwrites
+// later values. Runs longer than a chunk are allocated separately.
+func (s *slab[T]) alloc(n int, size int) []T {
+	if n > size {
+		return make([]T, n)
+	}
+	for {
+		if s.idx == len(s.chunks) {
+			s.chunks = append(s.chunks, make([]T, size))
+		}
+		if chunk := s.chunks[s.idx]; s.used+n <= len(chunk) {
+			s.used += n
+			return chunk[s.used-n : s.used : s.used]
+		}
+		s.idx++
+		s.used = 0
+	}
+}
+
+// new returns a pointer to a single zero value.
+func (s *slab[T]) new(size int) *T {
+	return &s.alloc(1, size)[0]
+}
+
+// reset zeroes the chunks that have been used, so that they can be handed out
+// again, and so that they don't keep any sources or other values alive.
+func (s *slab[T]) reset() {
+	for i := 0; i <= s.idx && i < len(s.chunks); i++ {
+		clear(s.chunks[i])
+	}
+	s.idx = 0
+	s.used = 0
+}
+
+// Clone returns a deep copy of the given nodes, e.g. to keep nodes parsed with
+// an Arena after it has been reset.
+func Clone(nodes []Node) []Node {
+	return cloneNodes(nodes)
+}
+
+// ParseArena parses the given XON source like Parse, but allocates the nodes
+// from the given arena, so that parsing many documents, e.g. one per request,
+// can reuse the same memory by resetting the arena between them.
+func ParseArena(src []byte, arena *Arena) ([]Node, error) {
+	p := newParser(src)
+	p.arena = arena
+	return p.parse()
+}
@@ -0,0 +1,87 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Units accepted within byte sizes, from the largest to the smallest, so that
+// formatting picks the largest unit which divides a size exactly. The `kB`
+// spelling of kilobytes is only accepted when decoding.
+var byteSizeUnits = []struct {
+	name  string
+	scale uint64
+}{
+	{"EiB", 1 << 60},
+	{"EB", 1e18},
+	{"PiB", 1 << 50},
+	{"PB", 1e15},
+	{"TiB", 1 << 40},
+	{"TB", 1e12},
+	{"GiB", 1 << 30},
+	{"GB", 1e9},
+	{"MiB", 1 << 20},
+	{"MB", 1e6},
+	{"KiB", 1 << 10},
+	{"KB", 1e3},
+	{"kB", 1e3},
+	{"B", 1},
+}
+
+// ByteSize represents a size in bytes. Within XON, sizes are written with a
+// decimal unit, i.e. `KB`, `MB`, `GB`, `TB`, `PB`, or `EB`, which are powers
+// of 1000, or a binary unit, i.e. `KiB`, `MiB`, `GiB`, `TiB`, `PiB`, or `EiB`,
+// which are powers of 1024, e.g. `512KB` or `4MiB`. Sizes can be fractional,
+// e.g. `1.5GB`, as long as they come to a whole number of bytes, and plain
+// numbers, or those with a `B` unit, are taken as bytes.
+type ByteSize uint64
+
+// String formats the size with the largest unit which divides it exactly, e.g.
+// `4MiB` or `1500MB`, and falls back to bytes, e.g. `1536B`.
+func (b ByteSize) String() string {
+	n := uint64(b)
+	for _, unit := range byteSizeUnits {
+		if n%unit.scale == 0 && (n > 0 || unit.scale == 1) {
+			return strconv.FormatUint(n/unit.scale, 10) + unit.name
+		}
+	}
+	return strconv.FormatUint(n, 10) + "B"
+}
+
+// parseByteSize parses a byte size like `512KB`, `4MiB`, or `1.5GB`.
+func parseByteSize(s string) (ByteSize, error) {
+	scale := uint64(1)
+	for _, unit := range byteSizeUnits {
+		if num, ok := strings.CutSuffix(s, unit.name); ok {
+			s, scale = num, unit.scale
+			break
+		}
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || (len(s) > len(whole) && frac == "") || !isDigits(whole) || (frac != "" && !isDigits(frac)) {
+		return 0, errSyntax
+	}
+	if len(whole) > 1 && whole[0] == '0' {
+		return 0, errLeadingZero
+	}
+	// Compute (whole.frac * scale) exactly, as fractional sizes must come to
+	// a whole number of bytes.
+	whole = strings.ReplaceAll(whole, "_", "")
+	frac = strings.ReplaceAll(frac, "_", "")
+	n, _ := new(big.Int).SetString(whole+frac, 10)
+	n.Mul(n, new(big.Int).SetUint64(scale))
+	div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(len(frac))), nil)
+	n, rem := n.QuoRem(n, div, new(big.Int))
+	if rem.Sign() != 0 {
+		return 0, errors.New("not a whole number of bytes")
+	}
+	if !n.IsUint64() {
+		return 0, errRange
+	}
+	return ByteSize(n.Uint64()), nil
+}
@@ -0,0 +1,162 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Canonical returns the canonical form of the given nodes, so that documents
+// which only differ in their formatting, comments, ordering of keys, or the
+// way that their strings and bytes are written, produce the same output. The
+// canonical form is itself a valid XON document, and is defined as:
+//
+//   - References are replaced by the nodes and values that they refer to, and
+//     anchors, comments, and spec lines are dropped.
+//
+//   - Within the top level and each block, include directives come first, in
+//     their original order, followed by keys and blocks sorted by the bytes of
+//     their names, with repeated blocks kept in their original order, and then
+//     versioned blocks sorted by version.
+//
+//   - Each entry is on its own line, ending with `\n`, and indented by 4 spaces
+//     for each level of nesting, without any blank lines.
+//
+//   - Keys, block names, include paths, and string values are always quoted,
+//     except for the unquoted `nil`, with `"`, `\n`, and other control
+//     characters besides `\t`, invalid UTF-8, and the start of any `<|0x`
+//     sequence written as `<|0xNN|>` byte escapes with uppercase hex digits.
+//
+//   - Lists are written on a single line, with elements separated by `, `, and
+//     bytes literals are written as padded base64, e.g. `b64"aGk="`.
+//
+//   - Empty blocks are written as `{}`, and `+=` is kept for appended lists,
+//     with an `xon 0.2` spec line written first if there are any.
+//
+// Values are otherwise kept exactly as they are, as XON leaves their types to
+// the decoder, e.g. `1.0` and `1` are distinct values.
+func Canonical(nodes []Node) []byte {
+	buf := &bytes.Buffer{}
+	appends := false
+	Walk(nodes, func(node Node) bool {
+		if kv, ok := node.(*KeyValue); ok && kv.Append {
+			appends = true
+		}
+		return !appends
+	})
+	if appends {
+		buf.WriteString("xon 0.2\n")
+	}
+	writeCanonical(buf, nodes, 0)
+	return buf.Bytes()
+}
+
+// CanonicalHash returns the SHA-256 hash of the canonical form of the given
+// nodes, so that documents can be compared, deduplicated, and signed by their
+// content, regardless of how they are formatted.
+func CanonicalHash(nodes []Node) [32]byte {
+	return sha256.Sum256(Canonical(nodes))
+}
+
+// canonicalOrder returns the rank of the given node within the canonical
+// ordering of nodes.
+func canonicalOrder(node Node) int {
+	switch node.(type) {
+	case *Include:
+		return 0
+	case *VersionedBlock:
+		return 2
+	}
+	return 1
+}
+
+// canonicalString returns s as a quoted string with canonical byte escapes.
+func canonicalString(s string) string {
+	return `"` + escape(s, true) + `"`
+}
+
+// canonicalValue returns the canonical form of the given value.
+func canonicalValue(value Value) string {
+	switch value := referencedValue(value).(type) {
+	case *Bytes:
+		return `b64"` + base64.StdEncoding.EncodeToString(value.Data) + `"`
+	case *List:
+		elems := []string{}
+		for _, elem := range value.Content {
+			if _, ok := elem.(*Comment); !ok {
+				elems = append(elems, canonicalValue(elem))
+			}
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case *String:
+		if value.Value == "nil" && !value.Quoted {
+			return "nil"
+		}
+		return canonicalString(value.Value)
+	}
+	return ""
+}
+
+// writeCanonical writes the canonical form of the given nodes to buf.
+func writeCanonical(buf *bytes.Buffer, nodes []Node, depth int) {
+	nodes = slices.DeleteFunc(slices.Clone(flattenReferences(nodes)), func(node Node) bool {
+		switch node.(type) {
+		case *Comment, *Spec:
+			return true
+		}
+		return false
+	})
+	slices.SortStableFunc(nodes, func(a, b Node) int {
+		if c := cmp.Compare(canonicalOrder(a), canonicalOrder(b)); c != 0 {
+			return c
+		}
+		if a, ok := a.(*VersionedBlock); ok {
+			return cmp.Compare(a.Version, b.(*VersionedBlock).Version)
+		}
+		x, _ := entryName(a)
+		y, _ := entryName(b)
+		return strings.Compare(x, y)
+	})
+	indent := strings.Repeat("    ", depth)
+	for _, node := range nodes {
+		buf.WriteString(indent)
+		switch node := node.(type) {
+		case *Block:
+			buf.WriteString(canonicalString(node.Name))
+			writeCanonicalBody(buf, node, depth)
+		case *Include:
+			buf.WriteString("include " + canonicalString(node.Path) + "\n")
+		case *KeyValue:
+			op := " = "
+			if node.Append {
+				op = " += "
+			}
+			buf.WriteString(canonicalString(node.Key) + op + canonicalValue(node.Value) + "\n")
+		case *VersionedBlock:
+			fmt.Fprintf(buf, "[v%d]", node.Version)
+			writeCanonicalBody(buf, node.Block, depth)
+		}
+	}
+}
+
+// writeCanonicalBody writes the braces and canonical contents of the given
+// block to buf.
+func writeCanonicalBody(buf *bytes.Buffer, block *Block, depth int) {
+	if !slices.ContainsFunc(flattenReferences(block.Nodes), func(node Node) bool {
+		_, ok := node.(*Comment)
+		return !ok
+	}) {
+		buf.WriteString(" {}\n")
+		return
+	}
+	buf.WriteString(" {\n")
+	writeCanonical(buf, block.Nodes, depth+1)
+	buf.WriteString(strings.Repeat("    ", depth) + "}\n")
+}
@@ -0,0 +1,1098 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errLeadingZero = errors.New("leading zeros are not allowed")
+	errNoLoader    = errors.New("no loader has been set, see Decoder.SetLoader")
+	errRange       = errors.New("value out of range")
+	errSyntax      = errors.New("invalid syntax")
+)
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	unmarshalerType     = reflect.TypeFor[Unmarshaler]()
+)
+
+// Units accepted within durations, with the multi-character units first so
+// that they match before any single-character prefix.
+var durationUnits = []struct {
+	name  string
+	scale uint64
+}{
+	{"ms", uint64(time.Millisecond)},
+	{"µs", uint64(time.Microsecond)},
+	{"μs", uint64(time.Microsecond)},
+	{"us", uint64(time.Microsecond)},
+	{"ns", uint64(time.Nanosecond)},
+	{"w", uint64(7 * 24 * time.Hour)},
+	{"d", uint64(24 * time.Hour)},
+	{"h", uint64(time.Hour)},
+	{"m", uint64(time.Minute)},
+	{"s", uint64(time.Second)},
+}
+
+// Unmarshaler is implemented by types that decode themselves from a XON value,
+// i.e. a *String, *Bytes, or *List. It takes precedence over
+// encoding.TextUnmarshaler.
+//
+// The value may be backed by memory that's reused once decoding finishes, so
+// it mustn't be kept after UnmarshalXON returns, though the strings within it
+// can be.
+type Unmarshaler interface {
+	UnmarshalXON(value Value) error
+}
+
+// decodeMember holds a key/value pair, or the blocks with the same name, from
+// within a block, along with the value that it decodes into.
+type decodeMember struct {
+	blocks []*Block
+	id     any // the struct field or map key that it sets
+	kv     *KeyValue
+	name   string
+	pos    Position
+	store  func()
+	value  reflect.Value
+}
+
+// decodeState holds the settings for a single call to Unmarshal or Decode.
+type decodeState struct {
+	disallowUnknown bool
+	duplicates      DuplicateKeyPolicy
+	field           *field // the struct field being decoded, if any
+	limits          Limits
+	loader          Loader
+	lookupEnv       func(name string) (string, bool) // expands env references, if set
+	requireFields   bool
+	version         int64
+	versioned       bool
+}
+
+// decodeBlocks decodes the blocks with the same name into the given value.
+// Multiple blocks can only be decoded into slices, arrays, and interfaces.
+func (d *decodeState) decodeBlocks(blocks []*Block, rv reflect.Value, path string) error {
+	rv = indirectAlloc(rv)
+	switch {
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8:
+		slice := reflect.MakeSlice(rv.Type(), len(blocks), len(blocks))
+		for i, block := range blocks {
+			if err := d.decodeMembers(block.Nodes, slice.Index(i), indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+		return nil
+	case rv.Kind() == reflect.Array:
+		if len(blocks) > rv.Len() {
+			return decodeErrorf(path, "cannot decode %d blocks into %s", len(blocks), rv.Type())
+		}
+		for i := range rv.Len() {
+			elem := rv.Index(i)
+			if i >= len(blocks) {
+				elem.SetZero()
+				continue
+			}
+			if err := d.decodeMembers(blocks[i].Nodes, elem, indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case len(blocks) == 1:
+		return d.decodeMembers(blocks[0].Nodes, rv, path)
+	case rv.Kind() == reflect.Interface && rv.NumMethod() == 0:
+		list := make([]any, len(blocks))
+		for i, block := range blocks {
+			if err := d.decodeMembers(block.Nodes, reflect.ValueOf(list).Index(i), indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(reflect.ValueOf(list))
+		return nil
+	}
+	return decodeErrorf(path, "cannot decode %d blocks into %s", len(blocks), rv.Type())
+}
+
+func (d *decodeState) decodeList(list *List, rv reflect.Value, path string) error {
+	var elems []Value
+	for _, elem := range list.Content {
+		if _, ok := elem.(*Comment); !ok {
+			elems = append(elems, elem)
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Array:
+		if len(elems) > rv.Len() {
+			return decodeErrorf(path, "cannot decode a list of %d elements into %s", len(elems), rv.Type())
+		}
+		for i := range rv.Len() {
+			elem := rv.Index(i)
+			if i >= len(elems) {
+				elem.SetZero()
+				continue
+			}
+			if err := d.decodeValue(elems[i], elem, indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(rv.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			if err := d.decodeValue(elem, slice.Index(i), indexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+		return nil
+	}
+	return decodeErrorf(path, "cannot decode a list into %s", rv.Type())
+}
+
+// decodeMembers decodes the key/value pairs and blocks within a block, or at
+// the top level, into the given struct, map, or empty interface.
+func (d *decodeState) decodeMembers(nodes []Node, rv reflect.Value, path string) error {
+	rv = indirectAlloc(rv)
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		m := map[string]any{}
+		if err := d.decodeMembers(nodes, reflect.ValueOf(m), path); err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(m))
+		return nil
+	}
+	if unmarshaler(rv) != nil {
+		return decodeErrorf(path, "cannot decode a block into %s", rv.Type())
+	}
+	var fields []*field
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+	case reflect.Struct:
+		if rv.Type() == timeType {
+			return decodeErrorf(path, "cannot decode a block into %s", rv.Type())
+		}
+		fields = cachedFields(rv.Type())
+	default:
+		return decodeErrorf(path, "cannot decode a block into %s", rv.Type())
+	}
+	// entry returns the value to decode the given key into within a map, along
+	// with a function to store it, and the map key that it sets.
+	entry := func(m reflect.Value, key string) (reflect.Value, func(), any, error) {
+		mk, err := mapKeyValue(m.Type().Key(), key)
+		if err != nil {
+			return reflect.Value{}, nil, nil, decodeErrorf(childPath(path, key), "%v", err)
+		}
+		elem := reflect.New(m.Type().Elem()).Elem()
+		return elem, func() { m.SetMapIndex(mk, elem) }, mk.Interface(), nil
+	}
+	// target returns the value to decode the given key into, a function to
+	// store it for maps, and the struct field or map key that it sets, which
+	// identifies duplicates. The value is invalid for keys without a struct
+	// field.
+	target := func(key string, pos Position) (reflect.Value, func(), any, error) {
+		if rv.Kind() == reflect.Map {
+			return entry(rv, key)
+		}
+		f := lookupField(fields, key)
+		if f == nil {
+			f = inlineField(fields)
+		}
+		if f == nil {
+			if d.disallowUnknown || d.versioned {
+				return reflect.Value{}, nil, nil, decodeErrorf(childPath(path, key), "unknown key at %s for %s", pos, rv.Type())
+			}
+			return reflect.Value{}, nil, nil, nil
+		}
+		fv, err := fieldByIndexAlloc(rv, f.index)
+		if err != nil {
+			return reflect.Value{}, nil, nil, decodeErrorf(childPath(path, key), "%v", err)
+		}
+		if f.inline {
+			if fv.IsNil() {
+				fv.Set(reflect.MakeMap(fv.Type()))
+			}
+			return entry(fv, key)
+		}
+		return fv, func() {}, f, nil
+	}
+	// Each key/value pair is a member, as is each set of blocks with the same
+	// name, which are decoded together.
+	var (
+		blocks  = map[string]int{}
+		members []*decodeMember
+	)
+	for _, node := range d.flattenVersions(nodes) {
+		switch node := node.(type) {
+		case *Block:
+			if i, ok := blocks[node.Name]; ok {
+				members[i].blocks = append(members[i].blocks, node)
+				continue
+			}
+			blocks[node.Name] = len(members)
+			members = append(members, &decodeMember{blocks: []*Block{node}, name: node.Name, pos: node.Pos})
+		case *KeyValue:
+			members = append(members, &decodeMember{kv: node, name: node.Key, pos: node.Pos})
+		}
+	}
+	// Members that set the same struct field or map key are duplicates, e.g.
+	// `port` and `Port`, or the same key repeated when the parser allowed it,
+	// and are resolved according to the duplicate key policy.
+	winners := map[any]*decodeMember{}
+	for _, m := range members {
+		fv, store, id, err := target(m.name, m.pos)
+		if err != nil {
+			return err
+		}
+		if !fv.IsValid() {
+			continue
+		}
+		m.id, m.store, m.value = id, store, fv
+		if prev, ok := winners[id]; ok {
+			switch d.duplicates {
+			case DuplicateKeyError:
+				if prev.name == m.name {
+					return decodeErrorf(childPath(path, m.name), "duplicate key at %s, first defined at %s", m.pos, prev.pos)
+				}
+				return decodeErrorf(childPath(path, m.name), "duplicate key at %s, first defined as %q at %s", m.pos, prev.name, prev.pos)
+			case DuplicateKeyFirstWins:
+				continue
+			}
+		}
+		winners[id] = m
+	}
+	if d.requireFields {
+		for _, f := range fields {
+			if f.required && winners[f] == nil {
+				return decodeErrorf(childPath(path, f.name), "missing required key for %s", rv.Type())
+			}
+		}
+	}
+	defer func(f *field) {
+		d.field = f
+	}(d.field)
+	for _, m := range members {
+		if !m.value.IsValid() || winners[m.id] != m {
+			continue
+		}
+		d.field, _ = m.id.(*field)
+		var err error
+		if m.kv != nil {
+			err = d.decodeValue(m.kv.Value, m.value, childPath(path, m.name))
+		} else {
+			err = d.decodeBlocks(m.blocks, m.value, childPath(path, m.name))
+		}
+		if err != nil {
+			return err
+		}
+		m.store()
+	}
+	return nil
+}
+
+// decodeNodes decodes the given top-level nodes into the value pointed to by v.
+func (d *decodeState) decodeNodes(nodes []Node, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("xon: cannot unmarshal into non-pointer or nil %T", v)
+	}
+	return d.decodeMembers(nodes, rv.Elem(), "")
+}
+
+// decodeValue decodes the value of a key/value pair, or an element of a list,
+// into the given value.
+func (d *decodeState) decodeValue(value Value, rv reflect.Value, path string) error {
+	value = referencedValue(value)
+	if s, ok := value.(*String); ok && !s.Quoted && s.Value == "nil" {
+		switch rv.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+			rv.SetZero()
+			return nil
+		}
+	}
+	rv = indirectAlloc(rv)
+	switch u := unmarshaler(rv).(type) {
+	case Unmarshaler:
+		value, err := d.expandValue(value, path)
+		if err != nil {
+			return err
+		}
+		if err := u.UnmarshalXON(value); err != nil {
+			return decodeErrorf(path, "cannot decode into %s: %v", rv.Type(), err)
+		}
+		return nil
+	case encoding.TextUnmarshaler:
+		switch value := value.(type) {
+		case *Bytes:
+			return decodeErrorf(path, "cannot decode bytes into %s", rv.Type())
+		case *List:
+			return decodeErrorf(path, "cannot decode a list into %s", rv.Type())
+		case *String:
+			s, err := d.expandString(value, path)
+			if err != nil {
+				return err
+			}
+			if err := u.UnmarshalText([]byte(s)); err != nil {
+				return decodeErrorf(path, "cannot decode %q as %s: %v", s, rv.Type(), err)
+			}
+		}
+		return nil
+	}
+	if rv.Kind() == reflect.Interface {
+		if rv.NumMethod() > 0 {
+			return decodeErrorf(path, "cannot decode into non-empty interface %s", rv.Type())
+		}
+		v, err := d.valueToAny(value, path)
+		if err != nil {
+			return err
+		}
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+	switch value := value.(type) {
+	case *Bytes:
+		if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Uint8 {
+			return decodeErrorf(path, "cannot decode bytes into %s", rv.Type())
+		}
+		rv.SetBytes(bytes.Clone(value.Data))
+		return nil
+	case *List:
+		return d.decodeList(value, rv, path)
+	case *String:
+		s, err := d.expandString(value, path)
+		if err != nil {
+			return err
+		}
+		if d.field != nil {
+			switch {
+			case rv.Type() == durationType && d.field.unit > 0:
+				return decodeDurationUnit(s, rv, d.field.unit, path)
+			case rv.Type() == timeType && d.field.layout != "":
+				return decodeTimeLayout(s, rv, d.field.layout, path)
+			}
+		}
+		return decodeString(s, rv, path)
+	}
+	return nil
+}
+
+// expandString returns the value of the given string, with any environment
+// variable references expanded if enabled. Raw strings are never expanded.
+func (d *decodeState) expandString(value *String, path string) (string, error) {
+	if d.lookupEnv == nil || value.Raw {
+		return value.Value, nil
+	}
+	s, err := expandEnv(value.Value, d.lookupEnv)
+	if err != nil {
+		return "", decodeErrorf(path, "%v", err)
+	}
+	return s, nil
+}
+
+// expandValue returns the given value with any environment variable
+// references within its strings expanded if enabled, and any references to
+// other values resolved, for passing to an Unmarshaler.
+func (d *decodeState) expandValue(value Value, path string) (Value, error) {
+	switch value := referencedValue(value).(type) {
+	case *List:
+		list := *value
+		list.Content = make([]Value, len(value.Content))
+		n := 0
+		for i, elem := range value.Content {
+			if _, ok := elem.(*Comment); ok {
+				list.Content[i] = elem
+				continue
+			}
+			var err error
+			if list.Content[i], err = d.expandValue(elem, indexPath(path, n)); err != nil {
+				return nil, err
+			}
+			n++
+		}
+		return &list, nil
+	case *String:
+		s, err := d.expandString(value, path)
+		if err != nil || s == value.Value {
+			return value, err
+		}
+		expanded := *value
+		expanded.Value = s
+		return &expanded, nil
+	default:
+		return value, nil
+	}
+}
+
+// flattenVersions returns the key/value pairs and blocks within the given
+// nodes, with the contents of any versioned blocks merged in. When decoding
+// for a specific version, blocks for later versions are skipped.
+func (d *decodeState) flattenVersions(nodes []Node) []Node {
+	var flat []Node
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *Block, *KeyValue:
+			flat = append(flat, node)
+		case *Reference:
+			flat = append(flat, d.flattenVersions(node.Nodes)...)
+		case *VersionedBlock:
+			if d.versioned && node.Version > d.version {
+				continue
+			}
+			flat = append(flat, d.flattenVersions(node.Block.Nodes)...)
+		}
+	}
+	return flat
+}
+
+// includeLoader returns the loader for resolving include directives, which
+// fails on them if no loader has been set, instead of leaving them unresolved.
+func (d *decodeState) includeLoader() Loader {
+	if d.loader == nil {
+		return LoaderFunc(func(string) ([]byte, error) {
+			return nil, errNoLoader
+		})
+	}
+	return d.loader
+}
+
+func (d *decodeState) unmarshal(data []byte, v any) error {
+	p, err := newLimitedParser(data, d.limits)
+	if err != nil {
+		return err
+	}
+	p.duplicates = d.duplicates != DuplicateKeyError
+	p.loader = d.includeLoader()
+	// The nodes are only needed until they've been decoded, so their memory
+	// can be reused by later calls.
+	arena := arenaPool.Get().(*Arena)
+	defer func() {
+		arena.Reset()
+		arenaPool.Put(arena)
+	}()
+	p.arena = arena
+	nodes, err := p.parse()
+	if err != nil {
+		return err
+	}
+	return d.decodeNodes(nodes, v)
+}
+
+// valueToAny returns the given value as a string, []byte, or []any, with the
+// unquoted `nil` returned as nil.
+func (d *decodeState) valueToAny(value Value, path string) (any, error) {
+	switch value := referencedValue(value).(type) {
+	case *Bytes:
+		return bytes.Clone(value.Data), nil
+	case *List:
+		list := []any{}
+		for _, elem := range value.Content {
+			if _, ok := elem.(*Comment); ok {
+				continue
+			}
+			v, err := d.valueToAny(elem, indexPath(path, len(list)))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case *String:
+		if !value.Quoted && value.Value == "nil" {
+			return nil, nil
+		}
+		return d.expandString(value, path)
+	}
+	return nil, nil
+}
+
+// Decode parses the given XON source, and returns the result as a value of
+// type T, following the same rules as Unmarshal, e.g.
+//
+//	cfg, err := xon.Decode[Config](data)
+func Decode[T any](data []byte) (T, error) {
+	var v T
+	err := Unmarshal(data, &v)
+	return v, err
+}
+
+// DecodeNode returns the given node decoded as a value of type T, following
+// the same rules as Unmarshal, e.g. for nodes found with Query. The contents
+// of blocks, versioned blocks, and references to blocks are decoded like a
+// document, while the value of a key/value pair is decoded directly, e.g.
+//
+//	port, err := xon.DecodeNode[uint16](kv)
+//
+// Errors are reported relative to the name of the node.
+func DecodeNode[T any](node Node) (T, error) {
+	var (
+		d   = &decodeState{}
+		err error
+		v   T
+	)
+	rv := reflect.ValueOf(&v).Elem()
+	switch node := node.(type) {
+	case *Block:
+		err = d.decodeMembers(node.Nodes, rv, node.Name)
+	case *KeyValue:
+		err = d.decodeValue(node.Value, rv, node.Key)
+	case *Reference:
+		err = d.decodeMembers(node.Nodes, rv, "")
+	case *VersionedBlock:
+		err = d.decodeMembers(node.Block.Nodes, rv, "")
+	default:
+		err = fmt.Errorf("xon: cannot decode a %T node", node)
+	}
+	return v, err
+}
+
+// Unmarshal parses the given XON source, and stores the result in the value
+// pointed to by v, which must be a struct, a map with string or integer keys,
+// or an empty interface.
+//
+// Keys are matched to struct fields using the same rules as Marshal, falling
+// back to a case-insensitive match of the field name. Keys without a matching
+// field are stored in the map with the `inline` option, if there is one, and
+// are ignored otherwise. Blocks are decoded into nested structs and maps, and
+// repeated blocks with the same name into slices of them. Pointers are
+// allocated as needed, and the contents of versioned blocks are decoded as if
+// they were part of their parent block.
+//
+// Strings are converted to the target type following the decoder conventions,
+// with the unquoted `nil` resetting pointers, maps, slices, and interfaces.
+// Bytes literals can only be decoded into byte slices, which also accept base64
+// strings. Types that implement Unmarshaler are given the value to decode
+// themselves, and types that implement encoding.TextUnmarshaler are given the
+// text of strings, with the same applying to map keys, except that the built-in
+// handling of time.Time values takes precedence. Values decoded into an empty
+// interface are stored as a string, []byte, []any, or map[string]any, with
+// repeated blocks stored as a []any of maps.
+func Unmarshal(data []byte, v any) error {
+	return (&decodeState{}).unmarshal(data, v)
+}
+
+func childPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// decodeDurationUnit decodes s as a plain number of the given unit, e.g. `30`
+// or `1.5` for seconds, falling back to decoding it as a duration like `1m30s`.
+func decodeDurationUnit(s string, rv reflect.Value, unit time.Duration, path string) error {
+	if n, err := parseInt(s); err == nil {
+		if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+			return decodeErrorf(path, "cannot decode %q as %s: %v", s, rv.Type(), errRange)
+		}
+		rv.SetInt(n * int64(unit))
+		return nil
+	}
+	if f, err := parseFloat(s, 64); err == nil {
+		f = math.Round(f * float64(unit))
+		if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return decodeErrorf(path, "cannot decode %q as %s: %v", s, rv.Type(), errRange)
+		}
+		rv.SetInt(int64(f))
+		return nil
+	}
+	return decodeString(s, rv, path)
+}
+
+func decodeErrorf(path string, format string, args ...any) error {
+	if path == "" {
+		return fmt.Errorf("xon: "+format, args...)
+	}
+	return fmt.Errorf("xon: %q: %s", path, fmt.Sprintf(format, args...))
+}
+
+func decodeString(s string, rv reflect.Value, path string) error {
+	var err error
+	switch rv.Type() {
+	case byteSizeType:
+		var b ByteSize
+		if b, err = parseByteSize(s); err == nil {
+			rv.SetUint(uint64(b))
+			return nil
+		}
+	case durationType:
+		var d time.Duration
+		if d, err = parseDuration(s); err == nil {
+			rv.SetInt(int64(d))
+			return nil
+		}
+	case numberType:
+		var n Number
+		if n, err = parseNumber(s); err == nil {
+			rv.SetString(string(n))
+			return nil
+		}
+	case timeType:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, s); err == nil {
+			rv.Set(reflect.ValueOf(t))
+			return nil
+		}
+		err = errSyntax
+	}
+	if err == nil {
+		switch rv.Kind() {
+		case reflect.Bool:
+			switch s {
+			case "false":
+				rv.SetBool(false)
+				return nil
+			case "true":
+				rv.SetBool(true)
+				return nil
+			}
+			err = errSyntax
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			if n, err = parseInt(s); err == nil {
+				if !rv.OverflowInt(n) {
+					rv.SetInt(n)
+					return nil
+				}
+				err = errRange
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			var n uint64
+			if n, err = parseUint(s); err == nil {
+				if !rv.OverflowUint(n) {
+					rv.SetUint(n)
+					return nil
+				}
+				err = errRange
+			}
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			if f, err = parseFloat(s, rv.Type().Bits()); err == nil {
+				rv.SetFloat(f)
+				return nil
+			}
+		case reflect.String:
+			rv.SetString(s)
+			return nil
+		case reflect.Slice:
+			if rv.Type().Elem().Kind() == reflect.Uint8 {
+				var data []byte
+				if data, err = base64.StdEncoding.DecodeString(s); err == nil {
+					rv.SetBytes(data)
+					return nil
+				}
+				err = errSyntax
+				break
+			}
+			return decodeErrorf(path, "cannot decode a string into %s", rv.Type())
+		default:
+			return decodeErrorf(path, "cannot decode a string into %s", rv.Type())
+		}
+	}
+	return decodeErrorf(path, "cannot decode %q as %s: %v", s, rv.Type(), err)
+}
+
+// decodeTimeLayout decodes s using the given layout, or as a Unix timestamp in
+// seconds for the "unix" layout, falling back to RFC 3339.
+func decodeTimeLayout(s string, rv reflect.Value, layout string, path string) error {
+	if layout == "unix" {
+		if n, err := parseInt(s); err == nil {
+			rv.Set(reflect.ValueOf(time.Unix(n, 0).UTC()))
+			return nil
+		}
+	} else if t, err := time.Parse(layout, s); err == nil {
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	return decodeErrorf(path, "cannot decode %q as %s: expected the layout %q or RFC 3339", s, rv.Type(), layout)
+}
+
+// expandEnv replaces the `${NAME}` and `${NAME:-default}` references within s
+// with the values of the environment variables from lookup. Defaults are used
+// when a variable is unset or empty, and `$${` is kept as a literal `${`.
+func expandEnv(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	b := &strings.Builder{}
+	for {
+		i := strings.Index(s, "${")
+		if i == -1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i+2:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("missing closing '}' in environment variable reference %q", s[i:])
+		}
+		ref := s[i+2 : i+2+end]
+		name, fallback, hasDefault := strings.Cut(ref, ":-")
+		if !isEnvName(name) {
+			return "", fmt.Errorf("invalid environment variable reference %q", "${"+ref+"}")
+		}
+		v, ok := lookup(name)
+		switch {
+		case hasDefault && v == "":
+			v = fallback
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(s[:i] + v)
+		s = s[i+2+end+1:]
+	}
+}
+
+// fieldByIndexAlloc returns the struct field with the given index sequence,
+// allocating any nil embedded struct pointers along the way.
+func fieldByIndexAlloc(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, idx := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(idx)
+	}
+	return rv, nil
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// indirectAlloc follows pointers, allocating them if they are nil, as well as
+// any non-nil pointers held by interfaces.
+func indirectAlloc(rv reflect.Value) reflect.Value {
+	for {
+		switch rv.Kind() {
+		case reflect.Interface:
+			if rv.IsNil() || rv.Elem().Kind() != reflect.Pointer || rv.Elem().IsNil() {
+				return rv
+			}
+			rv = rv.Elem()
+		case reflect.Pointer:
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		default:
+			return rv
+		}
+	}
+}
+
+// inlineField returns the map field with the `inline` option, or nil if there
+// isn't one.
+func inlineField(fields []*field) *field {
+	for _, f := range fields {
+		if f.inline {
+			return f
+		}
+	}
+	return nil
+}
+
+// isDigits returns whether s only consists of decimal digits, with optional
+// `_` separators between them.
+func isDigits(s string) bool {
+	if s == "" || s[0] == '_' || s[len(s)-1] == '_' || strings.Contains(s, "__") {
+		return false
+	}
+	for i := range len(s) {
+		if (s[i] < '0' || s[i] > '9') && s[i] != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// isEnvName returns whether s is a valid environment variable name, i.e. a
+// letter or `_`, followed by any letters, digits, or `_`.
+func isEnvName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := range len(s) {
+		c := s[i]
+		if !(c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupField returns the field with the given name, falling back to a
+// case-insensitive match, or nil if there isn't one.
+func lookupField(fields []*field, name string) *field {
+	for _, f := range fields {
+		if f.name == name && !f.inline {
+			return f
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) && !f.inline {
+			return f
+		}
+	}
+	return nil
+}
+
+func mapKeyValue(t reflect.Type, key string) (reflect.Value, error) {
+	rv := reflect.New(t).Elem()
+	if t.Kind() == reflect.String {
+		rv.SetString(key)
+		return rv, nil
+	}
+	if u, ok := unmarshaler(rv).(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot decode key %q as %s: %v", key, t, err)
+		}
+		return rv, nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := parseInt(key)
+		if err == nil && rv.OverflowInt(n) {
+			err = errRange
+		}
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot decode key %q as %s: %v", key, t, err)
+		}
+		rv.SetInt(n)
+		return rv, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := parseUint(key)
+		if err == nil && rv.OverflowUint(n) {
+			err = errRange
+		}
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot decode key %q as %s: %v", key, t, err)
+		}
+		rv.SetUint(n)
+		return rv, nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported map key type %s", t)
+}
+
+// parseDuration parses a duration like `14h35m0.2s`, where only the seconds
+// can be fractional.
+func parseDuration(s string) (time.Duration, error) {
+	neg := false
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, errSyntax
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	var total uint64
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, errSyntax
+		}
+		whole, err := strconv.ParseUint(s[:i], 10, 64)
+		if err != nil {
+			return 0, errRange
+		}
+		s = s[i:]
+		frac := ""
+		if s != "" && s[0] == '.' {
+			i = 1
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			if i == 1 {
+				return 0, errSyntax
+			}
+			frac, s = s[1:i], s[i:]
+		}
+		var scale uint64
+		for _, unit := range durationUnits {
+			if strings.HasPrefix(s, unit.name) {
+				scale = unit.scale
+				s = s[len(unit.name):]
+				break
+			}
+		}
+		if scale == 0 {
+			return 0, errSyntax
+		}
+		if frac != "" && scale != uint64(time.Second) {
+			return 0, errors.New("only seconds can be fractional")
+		}
+		if whole > math.MaxUint64/scale {
+			return 0, errRange
+		}
+		n := whole * scale
+		if frac != "" {
+			frac = (frac + "000000000")[:9]
+			nanos, _ := strconv.ParseUint(frac, 10, 64)
+			n += nanos
+		}
+		if total+n < total {
+			return 0, errRange
+		}
+		total += n
+	}
+	if neg {
+		if total > 1<<63 {
+			return 0, errRange
+		}
+		return time.Duration(-int64(total-1) - 1), nil
+	}
+	if total > math.MaxInt64 {
+		return 0, errRange
+	}
+	return time.Duration(total), nil
+}
+
+// parseFloat parses a decimal float, optionally in scientific notation, or
+// one of the special values `nan`, `inf`, `+inf`, and `-inf`.
+func parseFloat(s string, bits int) (float64, error) {
+	switch s {
+	case "nan":
+		return math.NaN(), nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	mantissa, exp, hasExp := strings.Cut(strings.ReplaceAll(s, "E", "e"), "e")
+	if mantissa != "" && (mantissa[0] == '+' || mantissa[0] == '-') {
+		mantissa = mantissa[1:]
+	}
+	whole, frac, hasDot := strings.Cut(mantissa, ".")
+	if whole == "" && frac == "" {
+		return 0, errSyntax
+	}
+	if (whole != "" && !isDigits(whole)) || (frac != "" && !isDigits(frac)) || (!hasDot && frac != "") {
+		return 0, errSyntax
+	}
+	if len(whole) > 1 && whole[0] == '0' {
+		return 0, errLeadingZero
+	}
+	if hasExp {
+		if exp != "" && (exp[0] == '+' || exp[0] == '-') {
+			exp = exp[1:]
+		}
+		if !isDigits(exp) {
+			return 0, errSyntax
+		}
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), bits)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, errRange
+		}
+		return 0, errSyntax
+	}
+	return f, nil
+}
+
+func parseInt(s string) (int64, error) {
+	neg, n, err := parseInteger(s)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		if n > 1<<63 {
+			return 0, errRange
+		}
+		return -int64(n-1) - 1, nil
+	}
+	if n > math.MaxInt64 {
+		return 0, errRange
+	}
+	return int64(n), nil
+}
+
+// parseInteger parses a decimal, hex, or octal integer, and returns its sign
+// and magnitude separately.
+func parseInteger(s string) (bool, uint64, error) {
+	neg := false
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	base := 10
+	switch {
+	case len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X'):
+		base = 16
+		s = s[2:]
+	case len(s) > 2 && s[0] == '0' && s[1] == 'o':
+		base = 8
+		s = s[2:]
+	case len(s) > 1 && s[0] == '0' && isDigits(s):
+		return false, 0, errLeadingZero
+	}
+	if s == "" || s[0] == '_' || s[len(s)-1] == '_' || strings.Contains(s, "__") {
+		return false, 0, errSyntax
+	}
+	n, err := strconv.ParseUint(strings.ReplaceAll(s, "_", ""), base, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return false, 0, errRange
+		}
+		return false, 0, errSyntax
+	}
+	return neg, n, nil
+}
+
+func parseUint(s string) (uint64, error) {
+	neg, n, err := parseInteger(s)
+	if err != nil {
+		return 0, err
+	}
+	if neg && n != 0 {
+		return 0, errRange
+	}
+	return n, nil
+}
+
+// unmarshaler returns the Unmarshaler or encoding.TextUnmarshaler implemented
+// by a pointer to the given value, or nil if there isn't one, or if it's a
+// time.Time, which is decoded following the decoder conventions instead.
+func unmarshaler(rv reflect.Value) any {
+	if !rv.CanAddr() || !rv.CanInterface() || rv.Type() == timeType {
+		return nil
+	}
+	t := reflect.PointerTo(rv.Type())
+	if t.Implements(unmarshalerType) || t.Implements(textUnmarshalerType) {
+		return rv.Addr().Interface()
+	}
+	return nil
+}
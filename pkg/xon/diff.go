@@ -0,0 +1,209 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Change kinds.
+const (
+	ChangeAdded    ChangeKind = "added"    // an entry only in the new document
+	ChangeModified ChangeKind = "modified" // a key/value pair with a different value
+	ChangeRemoved  ChangeKind = "removed"  // an entry only in the old document
+)
+
+// Change represents a difference between two documents at the given Path,
+// e.g. `server[1].port`. Old and New hold the key/value pair or block at the
+// path within each document, with Old being nil for additions, and New being
+// nil for removals. Only key/value pairs are ever modified, as blocks that
+// exist within both documents are compared entry by entry.
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	New  Node       `json:"new,omitempty"`
+	Old  Node       `json:"old,omitempty"`
+	Path string     `json:"path"`
+}
+
+// String returns the change in the format used by FormatDiff.
+func (c Change) String() string {
+	b := &strings.Builder{}
+	if c.Old != nil {
+		writeChange(b, "- ", c.Path, c.Old)
+	}
+	if c.New != nil {
+		writeChange(b, "+ ", c.Path, c.New)
+	}
+	return b.String()
+}
+
+// ChangeKind identifies the kind of a Change.
+type ChangeKind string
+
+// Diff returns the structural differences from the nodes of document a to those
+// of document b, in document order. Comments, formatting, and the order of
+// entries are ignored, and values are compared as written, e.g. `1.0` and `1`
+// are different.
+//
+// Blocks with the same name are compared by their position among the blocks
+// with that name, and the contents of versioned blocks are compared under
+// names like `[v2]`. References are compared by the values they resolve to.
+func Diff(a []Node, b []Node) []Change {
+	return diffNodes(nil, a, b, "")
+}
+
+// FormatDiff returns a human-readable rendering of the given changes, with
+// each entry written in XON using its path as the key, quoted if needed, e.g.
+// `server.port = 8443`. Each line of an old entry is prefixed with `- `, and
+// each line of a new entry with `+ `, so that modifications show the old entry
+// followed by the new one.
+func FormatDiff(changes []Change) string {
+	b := &strings.Builder{}
+	for _, change := range changes {
+		b.WriteString(change.String())
+	}
+	return b.String()
+}
+
+// diffEntries returns the names of the key/value pairs and blocks within the
+// given nodes in order of their first appearance, along with the entries for
+// each name. Versioned blocks are treated as blocks named like `[v2]`.
+func diffEntries(nodes []Node) ([]string, map[string][]Node) {
+	var names []string
+	entries := map[string][]Node{}
+	add := func(name string, node Node) {
+		if _, ok := entries[name]; !ok {
+			names = append(names, name)
+		}
+		entries[name] = append(entries[name], node)
+	}
+	for _, node := range flattenReferences(nodes) {
+		switch node := node.(type) {
+		case *Block:
+			add(node.Name, node)
+		case *KeyValue:
+			add(node.Key, node)
+		case *VersionedBlock:
+			block := *node.Block
+			block.Name = "[v" + strconv.FormatInt(node.Version, 10) + "]"
+			add(block.Name, &block)
+		}
+	}
+	return names, entries
+}
+
+// diffEntry appends the changes between two entries with the same name.
+func diffEntry(changes []Change, a Node, b Node, path string) []Change {
+	switch x := a.(type) {
+	case *Block:
+		if y, ok := b.(*Block); ok {
+			return diffNodes(changes, x.Nodes, y.Nodes, path)
+		}
+	case *KeyValue:
+		if y, ok := b.(*KeyValue); ok {
+			if !equalValues(x.Value, y.Value) {
+				changes = append(changes, Change{Kind: ChangeModified, New: b, Old: a, Path: path})
+			}
+			return changes
+		}
+	}
+	// Entries that change between a key/value pair and a block are treated as
+	// being removed and then added.
+	return append(changes,
+		Change{Kind: ChangeRemoved, Old: a, Path: path},
+		Change{Kind: ChangeAdded, New: b, Path: path},
+	)
+}
+
+// diffNodes appends the changes between the nodes of two blocks, with entries
+// that only exist within b being ordered after those within a.
+func diffNodes(changes []Change, a []Node, b []Node, path string) []Change {
+	names, oldEntries := diffEntries(a)
+	newNames, newEntries := diffEntries(b)
+	for _, name := range newNames {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		x, y := oldEntries[name], newEntries[name]
+		for i := range max(len(x), len(y)) {
+			entryPath := childPath(path, name)
+			if len(x) > 1 || len(y) > 1 {
+				entryPath = indexPath(entryPath, i)
+			}
+			switch {
+			case i >= len(x):
+				changes = append(changes, Change{Kind: ChangeAdded, New: y[i], Path: entryPath})
+			case i >= len(y):
+				changes = append(changes, Change{Kind: ChangeRemoved, Old: x[i], Path: entryPath})
+			default:
+				changes = diffEntry(changes, x[i], y[i], entryPath)
+			}
+		}
+	}
+	return changes
+}
+
+// equalValues returns whether two values are the same, ignoring comments and
+// how they're written, e.g. whether strings are quoted, except where it changes
+// the meaning, as with `nil`.
+func equalValues(a Value, b Value) bool {
+	a, b = referencedValue(a), referencedValue(b)
+	switch a := a.(type) {
+	case *Bytes:
+		if b, ok := b.(*Bytes); ok {
+			return bytes.Equal(a.Data, b.Data)
+		}
+	case *List:
+		if b, ok := b.(*List); ok {
+			x, y := listElems(a), listElems(b)
+			if len(x) != len(y) {
+				return false
+			}
+			for i := range x {
+				if !equalValues(x[i], y[i]) {
+					return false
+				}
+			}
+			return true
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value && (a.Value != "nil" || a.Quoted == b.Quoted)
+		}
+	}
+	return false
+}
+
+// listElems returns the elements of the given list, without any comments.
+func listElems(list *List) []Value {
+	var elems []Value
+	for _, elem := range list.Content {
+		if _, ok := elem.(*Comment); !ok {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// writeChange writes the given entry, keyed by its path, with each line
+// starting with the given prefix.
+func writeChange(b *strings.Builder, prefix string, path string, node Node) {
+	switch n := node.(type) {
+	case *Block:
+		clone := *n
+		clone.Anchor, clone.ClosingComment, clone.Name, clone.OpeningComment = "", "", path, ""
+		node = &clone
+	case *KeyValue:
+		clone := *n
+		clone.Anchor, clone.Append, clone.Comment, clone.Key = "", false, "", path
+		node = &clone
+	}
+	buf := &bytes.Buffer{}
+	encode(buf, []Node{node}, encodeState{}, printer{indent: "    ", prefix: prefix})
+	b.Write(buf.Bytes())
+}
@@ -0,0 +1,357 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Edit makes changes to the source of a XON document, e.g. for tools that set
+// config values, while keeping everything else as written, including comments,
+// formatting, and the order of entries. Only the lines holding the entries
+// being changed are modified.
+//
+// Paths follow the same syntax as Query, e.g. `server[1].port`, and must match
+// a single entry. Entries merged in by references can't be edited directly,
+// but setting one adds a key which overrides it.
+type Edit struct {
+	indent string // the indent of nested entries within the source
+	nodes  []Node
+	src    []byte
+}
+
+// Bytes returns the edited source. It must not be modified.
+func (e *Edit) Bytes() []byte {
+	return e.src
+}
+
+// Delete removes the key/value pair or block at the given path, along with any
+// inline comment on its lines. Comments on the lines before it are kept.
+func (e *Edit) Delete(path string) error {
+	segs, err := parseQuery(path)
+	if err != nil {
+		return err
+	}
+	m, err := e.find(segs, path)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return decodeErrorf(path, "no entry to delete")
+	}
+	if kv, ok := m.Node.(*KeyValue); ok && m.Pos != kv.Pos {
+		return decodeErrorf(path, "cannot delete a list element, set the list instead")
+	}
+	pos, end := nodeSpan(m.Node)
+	start, stop := lineStartAt(e.src, pos.Offset), lineEndAt(e.src, end.Offset)
+	// Avoid leaving a double blank line, or a blank line at either end, where
+	// the entry was separated from its neighbours by blank lines.
+	prevBlank := start == 0 || isBlankLine(e.src, lineStartAt(e.src, start-1))
+	switch {
+	case stop < len(e.src) && prevBlank && isBlankLine(e.src, stop):
+		stop = lineEndAt(e.src, stop)
+	case stop == len(e.src) && start > 0 && prevBlank:
+		start = lineStartAt(e.src, start-1)
+	}
+	return e.replace(path, start, stop, "")
+}
+
+// Set sets the value at the given path, which is encoded following the same
+// rules as Marshal, unless it's already a *Bytes, *List, or *String. Existing
+// values, including list elements, e.g. `ports[0]`, are replaced in place.
+// Otherwise, a key/value pair is added after the last entry of its block, and
+// any missing blocks along the path are created.
+func (e *Edit) Set(path string, value any) error {
+	segs, err := parseQuery(path)
+	if err != nil {
+		return err
+	}
+	var val Value
+	switch value := value.(type) {
+	case *Bytes, *List, *String:
+		val = value.(Value)
+	default:
+		state := &encodeState{}
+		rv, err := state.indirect(reflect.ValueOf(value), path)
+		if err != nil {
+			return err
+		}
+		if rv.IsValid() && isBlock(rv) {
+			return decodeErrorf(path, "cannot set %s as a value, as it would be a block", rv.Type())
+		}
+		if val, err = state.encodeValue(rv, path, 0); err != nil {
+			return err
+		}
+	}
+	m, err := e.find(segs, path)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		kv, ok := m.Node.(*KeyValue)
+		if !ok {
+			return decodeErrorf(path, "cannot set the value of a block")
+		}
+		start, end, ctx := valuePos(kv.Value), valueEnd(kv.Value), valueString
+		if m.Pos != kv.Pos {
+			start, end, ctx = m.Pos, m.End, listString
+		}
+		text := e.formatValue(val, ctx, lineIndentAt(e.src, start.Offset))
+		return e.replace(path, start.Offset, end.Offset, e.lineEndings(text))
+	}
+	// Find the closest block along the path, and add the rest of it there.
+	for i := len(segs) - 1; i >= 0; i-- {
+		if seg := segs[i]; seg.wildcard || len(seg.selectors) > 0 {
+			return decodeErrorf(path, "no entry to set, and %q can't be added as it has a wildcard or selector", seg.name)
+		}
+		if i == 0 {
+			return e.insert(path, nil, segs, val)
+		}
+		parent, err := e.find(segs[:i], path)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			continue
+		}
+		block, ok := parent.Node.(*Block)
+		if !ok || parent.Value != nil {
+			return decodeErrorf(path, "cannot set a value within %s, as it isn't a block", parent.Path)
+		}
+		return e.insert(path, block, segs[i:], val)
+	}
+	return nil
+}
+
+// entryText returns the lines for a key/value pair with the given value,
+// nested within blocks for all but the last of the given segments.
+func (e *Edit) entryText(segs []querySegment, value Value, indent string) string {
+	b := &strings.Builder{}
+	p := &printer{}
+	for i, seg := range segs {
+		b.WriteString(indent)
+		b.WriteString(p.formatString(seg.name, false, false, keyString, 0))
+		if i == len(segs)-1 {
+			b.WriteString(" = ")
+			b.WriteString(e.formatValue(value, valueString, indent))
+			b.WriteByte('\n')
+			break
+		}
+		b.WriteString(" {\n")
+		indent += e.indent
+	}
+	for range len(segs) - 1 {
+		indent = indent[:len(indent)-len(e.indent)]
+		b.WriteString(indent + "}\n")
+	}
+	return b.String()
+}
+
+// find returns the single entry written within the source which matches the
+// given segments, or nil if there isn't one.
+func (e *Edit) find(segs []querySegment, path string) (*Match, error) {
+	written := map[Node]bool{}
+	writtenNodes(e.nodes, written)
+	var matches []Match
+	for _, m := range queryMatches(e.nodes, segs) {
+		if written[m.Node] {
+			matches = append(matches, m)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &matches[0], nil
+	}
+	return nil, decodeErrorf(path, "matches %d entries, e.g. %s and %s, instead of a single one", len(matches), matches[0].Path, matches[1].Path)
+}
+
+// formatValue returns the given value as it would be printed within the given
+// context, on a line with the given indentation.
+func (e *Edit) formatValue(value Value, ctx stringContext, indent string) string {
+	b := &strings.Builder{}
+	p := &printer{buf: bufio.NewWriter(b), indent: e.indent, prefix: indent, spec: specVersion(e.nodes)}
+	p.printValue(value, ctx, 0, 0)
+	p.buf.Flush()
+	return b.String()
+}
+
+// insert adds the lines for the given segments and value after the last entry
+// of the given block, or of the top level if it's nil.
+func (e *Edit) insert(path string, parent *Block, segs []querySegment, value Value) error {
+	nodes := e.nodes
+	if parent != nil {
+		nodes = parent.Nodes
+	}
+	var last Node
+	for _, node := range nodes {
+		if _, ok := node.(*Comment); !ok {
+			last = node
+		}
+	}
+	var offset int
+	indent := ""
+	switch {
+	case last != nil:
+		pos, end := nodeSpan(last)
+		indent = lineIndentAt(e.src, pos.Offset)
+		offset = lineEndAt(e.src, end.Offset)
+	case parent == nil:
+		offset = len(e.src)
+	case parent.Pos.Line == parent.End.Line:
+		// Expand an empty block like `name {}` to hold the entry.
+		outer := lineIndentAt(e.src, parent.Pos.Offset)
+		text := "{\n" + e.entryText(segs, value, outer+e.indent) + outer + "}"
+		return e.replace(path, parent.End.Offset-2, parent.End.Offset, e.lineEndings(text))
+	default:
+		indent = lineIndentAt(e.src, parent.Pos.Offset) + e.indent
+		offset = lineStartAt(e.src, parent.End.Offset-1)
+	}
+	text := e.entryText(segs, value, indent)
+	if last != nil && (isBlockNode(last) || len(segs) > 1) {
+		text = "\n" + text
+	}
+	if offset > 0 && e.src[offset-1] != '\n' {
+		text = "\n" + text
+	}
+	return e.replace(path, offset, offset, e.lineEndings(text))
+}
+
+// lineEndings returns the given text with CRLF line endings if the source uses
+// them.
+func (e *Edit) lineEndings(text string) string {
+	if bytes.Contains(e.src, []byte("\r\n")) {
+		return strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	return text
+}
+
+// replace replaces the source between the given offsets with the given text,
+// as long as the result is still a valid document.
+func (e *Edit) replace(path string, start int, end int, text string) error {
+	src := slices.Concat(e.src[:start], []byte(text), e.src[end:])
+	nodes, err := Parse(src)
+	if err != nil {
+		return decodeErrorf(path, "the edit would result in an invalid document: %v", err)
+	}
+	e.nodes, e.src = nodes, src
+	return nil
+}
+
+// NewEdit returns an Edit for the given source, which must be a valid XON
+// document. The source isn't modified.
+func NewEdit(src []byte) (*Edit, error) {
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	e := &Edit{indent: nestedIndent(src, nodes), nodes: nodes, src: slices.Clone(src)}
+	if e.indent == "" {
+		e.indent = "    "
+	}
+	return e, nil
+}
+
+// isBlankLine returns whether the line starting at the given offset is empty
+// or only holds whitespace.
+func isBlankLine(src []byte, offset int) bool {
+	return len(bytes.TrimSpace(src[offset:lineEndAt(src, offset)])) == 0
+}
+
+// lineEndAt returns the offset just after the newline ending the line that
+// holds the given offset, or the end of the source.
+func lineEndAt(src []byte, offset int) int {
+	if i := bytes.IndexByte(src[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(src)
+}
+
+// lineIndentAt returns the whitespace at the start of the line that holds the
+// given offset.
+func lineIndentAt(src []byte, offset int) string {
+	start := lineStartAt(src, offset)
+	end := start
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	return string(src[start:end])
+}
+
+// lineStartAt returns the offset of the start of the line that holds the given
+// offset.
+func lineStartAt(src []byte, offset int) int {
+	return bytes.LastIndexByte(src[:offset], '\n') + 1
+}
+
+// nestedIndent returns the indent of the first nested entry within the given
+// nodes, relative to the block holding it, or an empty string if there isn't
+// one.
+func nestedIndent(src []byte, nodes []Node) string {
+	for _, node := range nodes {
+		block, ok := node.(*Block)
+		if vb, isVersioned := node.(*VersionedBlock); isVersioned {
+			block, ok = vb.Block, true
+		}
+		if !ok {
+			continue
+		}
+		for _, child := range block.Nodes {
+			if _, ok := child.(*Comment); ok {
+				continue
+			}
+			pos, _ := nodeSpan(child)
+			outer, inner := lineIndentAt(src, block.Pos.Offset), lineIndentAt(src, pos.Offset)
+			if len(inner) > len(outer) && strings.HasPrefix(inner, outer) {
+				return inner[len(outer):]
+			}
+			break
+		}
+		if indent := nestedIndent(src, block.Nodes); indent != "" {
+			return indent
+		}
+	}
+	return ""
+}
+
+// nodeSpan returns the start and end positions of the given node.
+func nodeSpan(node Node) (Position, Position) {
+	switch node := node.(type) {
+	case *Block:
+		return node.Pos, node.End
+	case *Comment:
+		return node.Pos, node.End
+	case *Include:
+		return node.Pos, node.End
+	case *KeyValue:
+		return node.Pos, node.End
+	case *Reference:
+		return node.Pos, node.End
+	case *Spec:
+		return node.Pos, node.End
+	case *VersionedBlock:
+		return node.Pos, node.End
+	}
+	return Position{}, Position{}
+}
+
+// writtenNodes adds the given nodes, and those nested within them, to the given
+// set, except for the copies held by references, so that only the entries
+// written within the source are edited.
+func writtenNodes(nodes []Node, set map[Node]bool) {
+	for _, node := range nodes {
+		set[node] = true
+		switch node := node.(type) {
+		case *Block:
+			writtenNodes(node.Nodes, set)
+		case *VersionedBlock:
+			writtenNodes(node.Block.Nodes, set)
+		}
+	}
+}
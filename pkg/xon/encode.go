@@ -0,0 +1,722 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	byteSizeType      = reflect.TypeFor[ByteSize]()
+	durationType      = reflect.TypeFor[time.Duration]()
+	fieldCache        sync.Map // map[reflect.Type][]*field
+	marshalerType     = reflect.TypeFor[Marshaler]()
+	numberType        = reflect.TypeFor[Number]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType          = reflect.TypeFor[time.Time]()
+)
+
+// Named layouts that can be given to the `layout` tag option.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"DateOnly":    time.DateOnly,
+	"DateTime":    time.DateTime,
+	"Kitchen":     time.Kitchen,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RubyDate":    time.RubyDate,
+	"TimeOnly":    time.TimeOnly,
+	"UnixDate":    time.UnixDate,
+}
+
+// Marshaler is implemented by types that encode themselves as a XON value,
+// i.e. a *String, *Bytes, or *List. It takes precedence over
+// encoding.TextMarshaler.
+type Marshaler interface {
+	MarshalXON() (Value, error)
+}
+
+// encodeState holds the settings for a single call to Marshal or Encode, along
+// with the blocks and lists being encoded, so that cycles can be detected.
+type encodeState struct {
+	field    *field // the struct field being encoded, if any
+	maxDepth int
+	visiting map[visitKey]string // the paths of the blocks and lists being encoded
+}
+
+func (e *encodeState) encodeDocument(rv reflect.Value) ([]Node, error) {
+	rv, err := e.indirect(rv, "")
+	if err != nil {
+		return nil, err
+	}
+	if !rv.IsValid() {
+		return nil, errors.New("xon: cannot marshal a nil value")
+	}
+	if !isBlock(rv) {
+		return nil, fmt.Errorf("xon: cannot marshal %s as a document, expected a struct or map", rv.Type())
+	}
+	return e.encodeMembers(rv, "", 0)
+}
+
+// encodeEntry appends the nodes for the given key and value, at the given path
+// within a block at the given depth. This is a single key/value pair or block,
+// except for slices of structs or maps, which result in a block for each
+// element.
+func (e *encodeState) encodeEntry(nodes []Node, key string, rv reflect.Value, path string, depth int) ([]Node, error) {
+	rv, err := e.indirect(rv, path)
+	if err != nil {
+		return nil, err
+	}
+	if !rv.IsValid() {
+		return append(nodes, &KeyValue{Key: key, Value: &String{Value: "nil"}}), nil
+	}
+	if isBlock(rv) {
+		members, err := e.encodeMembers(rv, path, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return append(nodes, &Block{Name: key, Nodes: members}), nil
+	}
+	if ((rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8) || rv.Kind() == reflect.Array) && marshaler(rv) == nil {
+		elems := make([]reflect.Value, rv.Len())
+		blocks := 0
+		for i := range rv.Len() {
+			if elems[i], err = e.indirect(rv.Index(i), indexPath(path, i)); err != nil {
+				return nil, err
+			}
+			if elems[i].IsValid() && isBlock(elems[i]) {
+				blocks++
+			}
+		}
+		if blocks > 0 {
+			if blocks != rv.Len() {
+				return nil, fmt.Errorf("xon: cannot marshal %q: blocks cannot be mixed with other values", key)
+			}
+			for i, elem := range elems {
+				members, err := e.encodeMembers(elem, indexPath(path, i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, &Block{Name: key, Nodes: members})
+			}
+			return nodes, nil
+		}
+	}
+	value, err := e.encodeValue(rv, path, depth)
+	if err != nil {
+		return nil, err
+	}
+	return append(nodes, &KeyValue{Key: key, Value: value}), nil
+}
+
+// encodeMap appends the nodes for the entries of a map within the block at the
+// given path and depth. For maps inlined into a struct, the fields of the
+// struct are given, so that keys which would be decoded into one of them can
+// be rejected.
+func (e *encodeState) encodeMap(nodes []Node, rv reflect.Value, fields []*field, path string, depth int) ([]Node, error) {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, err := mapKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if lookupField(fields, key) != nil {
+			return nil, fmt.Errorf("xon: cannot marshal inline map key %q as it conflicts with a field", key)
+		}
+		entries = append(entries, entry{key, iter.Value()})
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		return strings.Compare(a.key, b.key)
+	})
+	for _, entry := range entries {
+		var err error
+		e.field = nil
+		nodes, err = e.encodeEntry(nodes, entry.key, entry.value, childPath(path, entry.key), depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// encodeMembers returns the nodes for the fields of a struct, or the entries
+// of a map, for the block at the given path and depth.
+func (e *encodeState) encodeMembers(rv reflect.Value, path string, depth int) ([]Node, error) {
+	leave, err := e.enter(rv, path, depth)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	defer func(f *field) {
+		e.field = f
+	}(e.field)
+	nodes := []Node{}
+	if rv.Kind() == reflect.Map {
+		return e.encodeMap(nodes, rv, nil, path, depth)
+	}
+	fields := cachedFields(rv.Type())
+	for _, f := range fields {
+		fv, ok := fieldByIndex(rv, f.index)
+		if !ok || (f.omitEmpty && isEmpty(fv)) {
+			continue
+		}
+		e.field = f
+		if f.inline {
+			nodes, err = e.encodeMap(nodes, fv, fields, path, depth)
+		} else {
+			nodes, err = e.encodeEntry(nodes, f.name, fv, childPath(path, f.name), depth)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f.quoted && indirect(fv).IsValid() {
+			if kv, ok := nodes[len(nodes)-1].(*KeyValue); ok {
+				if s, ok := kv.Value.(*String); ok {
+					s.Quoted = true
+				}
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// encodeValue returns the value for a scalar, or a list for slices and arrays,
+// at the given path within a block or list at the given depth.
+func (e *encodeState) encodeValue(rv reflect.Value, path string, depth int) (Value, error) {
+	rv, err := e.indirect(rv, path)
+	if err != nil {
+		return nil, err
+	}
+	if !rv.IsValid() {
+		return &String{Value: "nil"}, nil
+	}
+	switch rv.Type() {
+	case byteSizeType:
+		return &String{Value: ByteSize(rv.Uint()).String()}, nil
+	case durationType:
+		d := time.Duration(rv.Int())
+		if e.field != nil && e.field.unit > 0 && d%e.field.unit == 0 {
+			return &String{Value: strconv.FormatInt(int64(d/e.field.unit), 10)}, nil
+		}
+		return &String{Value: formatDuration(d)}, nil
+	case numberType:
+		if rv.String() == "" {
+			return &String{Value: "0"}, nil
+		}
+		n, err := parseNumber(rv.String())
+		if err != nil {
+			return nil, fmt.Errorf("xon: cannot marshal invalid number %q: %v", rv.String(), err)
+		}
+		return &String{Value: string(n)}, nil
+	case timeType:
+		t := rv.Interface().(time.Time)
+		if e.field != nil && e.field.layout == "unix" {
+			return &String{Value: strconv.FormatInt(t.Unix(), 10)}, nil
+		}
+		if e.field != nil && e.field.layout != "" {
+			s := t.Format(e.field.layout)
+			return &String{Quoted: s == "nil", Value: s}, nil
+		}
+		if t.Year() < 0 || t.Year() > 9999 {
+			return nil, fmt.Errorf("xon: cannot marshal time %s as it is outside of the RFC 3339 year range", t)
+		}
+		return &String{Value: t.Format(time.RFC3339Nano)}, nil
+	}
+	switch m := marshaler(rv).(type) {
+	case Marshaler:
+		value, err := m.MarshalXON()
+		if err != nil {
+			return nil, fmt.Errorf("xon: cannot marshal %s: %w", rv.Type(), err)
+		}
+		switch value := value.(type) {
+		case nil:
+			return &String{Value: "nil"}, nil
+		case *Bytes, *List, *String:
+			return value, nil
+		}
+		return nil, fmt.Errorf("xon: cannot marshal %s: MarshalXON returned a %T instead of a *String, *Bytes, or *List", rv.Type(), value)
+	case encoding.TextMarshaler:
+		text, err := m.MarshalText()
+		if err != nil {
+			return nil, fmt.Errorf("xon: cannot marshal %s: %w", rv.Type(), err)
+		}
+		s := string(text)
+		return &String{Quoted: s == "nil", Value: s}, nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return &String{Value: strconv.FormatBool(rv.Bool())}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &String{Value: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &String{Value: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &String{Value: formatFloat(rv.Float(), rv.Type().Bits())}, nil
+	case reflect.String:
+		s := rv.String()
+		return &String{Quoted: s == "nil", Value: s}, nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return &Bytes{Data: rv.Bytes()}, nil
+		}
+		fallthrough
+	case reflect.Array:
+		leave, err := e.enter(rv, path, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		list := &List{Content: []Value{}}
+		for i := range rv.Len() {
+			elem, err := e.indirect(rv.Index(i), indexPath(path, i))
+			if err != nil {
+				return nil, err
+			}
+			if elem.IsValid() && isBlock(elem) {
+				return nil, fmt.Errorf("xon: cannot marshal %s within a list", elem.Type())
+			}
+			value, err := e.encodeValue(elem, indexPath(path, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list.Content = append(list.Content, value)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("xon: unsupported type %s", rv.Type())
+}
+
+// enter marks the given block or list as being encoded at the given path and
+// depth, and returns a function to unmark it once done. It fails if the depth
+// exceeds the limit, or if the value is already being encoded further up, i.e.
+// it refers back to itself, which would otherwise recurse forever.
+func (e *encodeState) enter(rv reflect.Value, path string, depth int) (func(), error) {
+	if e.maxDepth > 0 && depth > e.maxDepth {
+		return nil, fmt.Errorf("xon: %q: cannot marshal beyond the maximum depth of %d", path, e.maxDepth)
+	}
+	key := visitKey{typ: rv.Type()}
+	switch {
+	case rv.Kind() == reflect.Map:
+		key.ptr = rv.Pointer()
+	case rv.Kind() == reflect.Slice:
+		key.len, key.ptr = rv.Len(), rv.Pointer()
+	case rv.CanAddr():
+		key.ptr = rv.Addr().Pointer()
+	}
+	if key.ptr == 0 {
+		return func() {}, nil
+	}
+	if prev, ok := e.visiting[key]; ok {
+		if prev == "" {
+			return nil, fmt.Errorf("xon: %q: cannot marshal a value that refers back to the document", path)
+		}
+		return nil, fmt.Errorf("xon: %q: cannot marshal a value that refers back to %q", path, prev)
+	}
+	if e.visiting == nil {
+		e.visiting = map[visitKey]string{}
+	}
+	e.visiting[key] = path
+	return func() { delete(e.visiting, key) }, nil
+}
+
+// indirect follows pointers and interfaces like the indirect function, but
+// fails on pointers that refer back to themselves, e.g. an `any` holding a
+// pointer to itself.
+func (e *encodeState) indirect(rv reflect.Value, path string) (reflect.Value, error) {
+	var seen []uintptr
+	for rv.IsValid() && (rv.Kind() == reflect.Interface || rv.Kind() == reflect.Pointer) {
+		if rv.IsNil() {
+			return reflect.Value{}, nil
+		}
+		if rv.Kind() == reflect.Pointer {
+			if slices.Contains(seen, rv.Pointer()) {
+				return reflect.Value{}, fmt.Errorf("xon: %q: cannot marshal a pointer that refers back to itself", path)
+			}
+			seen = append(seen, rv.Pointer())
+		}
+		rv = rv.Elem()
+	}
+	return rv, nil
+}
+
+// field represents a struct field that maps to a XON key, including fields
+// promoted from embedded structs, or a map with the `inline` option, which
+// holds the keys that don't match any other field.
+type field struct {
+	index     []int
+	inline    bool
+	layout    string // for time.Time values, or "unix" for Unix timestamps
+	name      string
+	omitEmpty bool
+	quoted    bool
+	required  bool
+	unit      time.Duration // for time.Duration values written as plain numbers
+}
+
+// visitKey identifies a map, slice, or addressable struct or array, which are
+// the values that can refer back to themselves.
+type visitKey struct {
+	len int
+	ptr uintptr
+	typ reflect.Type
+}
+
+// Marshal returns the XON encoding of v, which must be a struct or a map, or a
+// pointer to one. The output is indented with 4 spaces.
+//
+// Struct fields are encoded in the order they are declared, and are keyed by
+// the name given in their `xon` tag, or their Go name otherwise. Fields with
+// the tag `xon:"-"` are skipped, as are unexported fields. The fields of
+// embedded structs are encoded as if they were in the outer struct. Map keys,
+// which must be strings or integers, are sorted.
+//
+// The `xon` tag can be followed by comma-separated options:
+//
+//   - `omitempty` skips the field if it's false, 0, a nil pointer or
+//     interface, or an empty string, slice, map, or array.
+//
+//   - `inline` encodes the fields of a struct as if it were embedded, or the
+//     entries of a map as if they were fields of the outer struct, in which
+//     case the map holds any keys without a matching field when decoding.
+//
+//   - `string` encodes scalars as quoted strings, e.g. `port = "8080"`, for
+//     compatibility with systems that expect them. As all values are strings
+//     within XON, they can be decoded either way.
+//
+//   - `required` has no effect on encoding, but makes the field's key required
+//     when decoding with Decoder.RequireFields.
+//
+//   - `unit=<unit>` encodes time.Duration values as a plain number of the
+//     given unit, i.e. one of `w`, `d`, `h`, `m`, `s`, `ms`, `us`, or `ns`,
+//     e.g. `timeout = 30` for `xon:"timeout,unit=s"`, as long as they are a
+//     whole number of it. When decoding, both plain numbers, which can be
+//     fractional, and durations like `1m30s` are accepted.
+//
+//   - `layout=<layout>` encodes time.Time values using the given layout, e.g.
+//     `xon:"date,layout=2006-01-02"`, instead of RFC 3339. The layout can be
+//     the name of a layout constant from the time package, e.g. `DateOnly` or
+//     `RFC1123`, or `unix` for Unix timestamps in seconds. As layouts can
+//     contain commas, this option must come last. When decoding, RFC 3339
+//     values are accepted as well.
+//
+// The `layout` and `unit` options also apply to the elements of lists.
+//
+// Nested structs and maps are encoded as blocks, and slices of them are
+// encoded as repeated blocks with the same name. All other slices and arrays
+// are encoded as lists, except for []byte, which is encoded as a base64 bytes
+// literal. Scalars are encoded following the decoder conventions, with
+// time.Time values in RFC 3339 format, and time.Duration values like `1h30m0s`.
+// Nil pointers and interfaces are encoded as `nil`, and any string values of
+// "nil" are quoted, so that they can be told apart. Values that refer back to
+// themselves, e.g. through pointers, maps, or slices, result in an error with
+// the path at which the cycle was found, though values can be shared as long
+// as they don't contain themselves.
+//
+// Values that implement Marshaler, or encoding.TextMarshaler, are encoded as
+// the value that they return, or as a string of their text, instead of as a
+// block or list, e.g. for IDs, IP addresses, and enums. Map keys which
+// implement encoding.TextMarshaler are encoded as their text. The built-in
+// handling of time.Time values takes precedence over their methods.
+//
+// If v is a []Node, e.g. as returned by Parse, the nodes are written out as
+// is, along with all of their comments, so that documents can be edited
+// programmatically without losing any annotations.
+func Marshal(v any) ([]byte, error) {
+	return MarshalIndent(v, "", "    ")
+}
+
+// MarshalIndent is like Marshal, but each line of the output begins with the
+// given prefix, followed by one copy of the indent for each level of nesting.
+func MarshalIndent(v any, prefix string, indent string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encode(buf, v, encodeState{}, printer{indent: indent, prefix: prefix}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cachedFields(t reflect.Type) []*field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]*field)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return fields.([]*field)
+}
+
+// encode writes the XON encoding of v to w, with the given settings, formatted
+// with the options of the given printer.
+func encode(w io.Writer, v any, e encodeState, p printer) error {
+	nodes, ok := v.([]Node)
+	if !ok {
+		var err error
+		nodes, err = e.encodeDocument(reflect.ValueOf(v))
+		if err != nil {
+			return err
+		}
+	}
+	p.buf = bufio.NewWriter(w)
+	p.spec = specVersion(nodes)
+	if p.spec == 0 && requiredSpec(nodes) >= 2 {
+		// Documents without a spec line are parsed as 0.1, so one is added
+		// for any syntax that was added since.
+		nodes = append([]Node{&Spec{Version: "0.2"}}, nodes...)
+		p.spec = 2
+	}
+	p.printNodes(nodes, 0)
+	return p.buf.Flush()
+}
+
+// fieldByIndex returns the struct field with the given index sequence, or
+// false if it is within an embedded struct pointer which is nil.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(idx)
+	}
+	return rv, true
+}
+
+// formatDuration formats d like time.Duration.String, except that durations
+// of less than a second are written in whole units, e.g. `1ms500µs` instead of
+// `1.5ms`, as only seconds can be fractional when decoding.
+func formatDuration(d time.Duration) string {
+	if d == 0 || d <= -time.Second || d >= time.Second {
+		return d.String()
+	}
+	b := &strings.Builder{}
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	for _, unit := range []struct {
+		name  string
+		scale time.Duration
+	}{{"ms", time.Millisecond}, {"µs", time.Microsecond}, {"ns", time.Nanosecond}} {
+		if d >= unit.scale {
+			b.WriteString(strconv.FormatInt(int64(d/unit.scale), 10))
+			b.WriteString(unit.name)
+			d %= unit.scale
+		}
+	}
+	return b.String()
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+// indirect follows pointers and interfaces, and returns the zero Value if any
+// of them are nil.
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.IsValid() && (rv.Kind() == reflect.Interface || rv.Kind() == reflect.Pointer) {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// isBlock returns whether the given value is encoded as a block.
+func isBlock(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Map:
+		return marshaler(rv) == nil
+	case reflect.Struct:
+		return rv.Type() != timeType && marshaler(rv) == nil
+	}
+	return false
+}
+
+// isEmpty returns whether the given value is skipped by the `omitempty` option.
+func isEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool, reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.IsZero()
+	}
+	return false
+}
+
+func mapKey(rv reflect.Value) (string, error) {
+	if rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	if m, ok := marshaler(rv).(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("xon: cannot marshal map key %s: %w", rv.Type(), err)
+		}
+		return string(text), nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("xon: unsupported map key type %s", rv.Type())
+}
+
+// marshaler returns the Marshaler or encoding.TextMarshaler implemented by the
+// given value, or by a pointer to it if it's addressable, or nil otherwise.
+func marshaler(rv reflect.Value) any {
+	if !rv.CanInterface() {
+		return nil
+	}
+	for _, t := range []reflect.Type{marshalerType, textMarshalerType} {
+		if rv.Type().Implements(t) {
+			return rv.Interface()
+		}
+		if rv.CanAddr() && reflect.PointerTo(rv.Type()).Implements(t) {
+			return rv.Addr().Interface()
+		}
+	}
+	return nil
+}
+
+// typeFields returns the fields of the given struct type, in declaration
+// order. Fields promoted from embedded structs follow Go's visibility rules,
+// so that shallower fields hide deeper ones, and conflicting fields at the
+// same depth are dropped unless exactly one of them is tagged.
+func typeFields(t reflect.Type) []*field {
+	type candidate struct {
+		depth  int
+		field  *field
+		tagged bool
+	}
+	var (
+		candidates []candidate
+		walk       func(t reflect.Type, index []int, visiting map[reflect.Type]bool)
+	)
+	walk = func(t reflect.Type, index []int, visiting map[reflect.Type]bool) {
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			tag := sf.Tag.Get("xon")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			f := &field{index: slices.Concat(index, []int{i})}
+			for opts != "" {
+				var opt string
+				if strings.HasPrefix(opts, "layout=") {
+					opt, opts = opts, ""
+				} else {
+					opt, opts, _ = strings.Cut(opts, ",")
+				}
+				key, value, _ := strings.Cut(opt, "=")
+				switch key {
+				case "inline":
+					f.inline = true
+				case "layout":
+					f.layout = value
+					if layout, ok := timeLayouts[value]; ok {
+						f.layout = layout
+					}
+				case "omitempty":
+					f.omitEmpty = true
+				case "required":
+					f.required = true
+				case "string":
+					f.quoted = true
+				case "unit":
+					for _, unit := range durationUnits {
+						if unit.name == value {
+							f.unit = time.Duration(unit.scale)
+						}
+					}
+				}
+			}
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ((sf.Anonymous && name == "") || (f.inline && sf.IsExported())) && ft.Kind() == reflect.Struct && ft != timeType {
+				if !visiting[ft] {
+					walk(ft, f.index, visiting)
+				}
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			if f.inline && sf.Type.Kind() == reflect.Map {
+				// Inline maps have no name, so that they only conflict with
+				// each other.
+				candidates = append(candidates, candidate{depth: len(index), field: f})
+				continue
+			}
+			f.inline = false
+			tagged := name != ""
+			if !tagged {
+				name = sf.Name
+			}
+			f.name = name
+			candidates = append(candidates, candidate{
+				depth:  len(index),
+				field:  f,
+				tagged: tagged,
+			})
+		}
+	}
+	walk(t, nil, map[reflect.Type]bool{})
+	var fields []*field
+	for i, c := range candidates {
+		dominant := true
+		for j, other := range candidates {
+			if i == j || other.field.name != c.field.name {
+				continue
+			}
+			if other.depth < c.depth || (other.depth == c.depth && (other.tagged || !c.tagged)) {
+				dominant = false
+				break
+			}
+		}
+		if dominant {
+			fields = append(fields, c.field)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,166 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+)
+
+// EntryReader reads the top-level entries of a XON document one at a time,
+// e.g. the blocks within a database dump, so that documents far larger than
+// the available memory can be processed. Only the lines of the entry being
+// read are held in memory, along with the top-level keys and anchors seen so
+// far, so that duplicate keys are still caught, and later entries can refer
+// to the anchors of earlier ones.
+//
+// Entries are parsed as by Parse, with positions relative to the document as
+// a whole. Include directives are returned as is. References at the top level
+// hold all of the nodes of the anchored entry, as the keys defined by other
+// entries aren't taken into account.
+type EntryReader struct {
+	anchorSizes map[string]anchorSize
+	anchors     map[string]Node
+	buf         []byte // the lines of the entry being read
+	duplicates  bool   // whether duplicate keys are left to the decoder
+	err         error
+	included    int    // the number of bytes included so far
+	keys        keySet // the top-level keys read so far
+	limits      Limits
+	line        int // the number of lines before buf
+	loader      Loader
+	nodes       []Node // the parsed entries which haven't been returned yet
+	offset      int    // the number of bytes before buf
+	r           *bufio.Reader
+	spec        int  // the minor version of the spec declared by the document
+	started     bool // whether any entries other than comments have been read
+	tokens      int  // the number of tokens parsed so far
+	version     int64
+	versioned   bool
+}
+
+// Line returns the number of lines that have been parsed so far, which is the
+// last line of the most recently parsed entry.
+func (r *EntryReader) Line() int {
+	return r.line
+}
+
+// ReadEntry returns the next top-level node, which may be a comment. It
+// returns io.EOF at the end of the input, and parse errors as an *Error, after
+// which any later calls return the same error.
+func (r *EntryReader) ReadEntry() (Node, error) {
+	for len(r.nodes) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.err = r.next()
+	}
+	node := r.nodes[0]
+	r.nodes[0] = nil
+	r.nodes = r.nodes[1:]
+	return node, nil
+}
+
+// checkKeys checks the top-level keys parsed by p against those of earlier
+// entries, and adds them to the set of keys read so far.
+func (r *EntryReader) checkKeys(p *parser, nodes []Node) error {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *KeyValue:
+			if pos, ok := r.keys[node.Key]; ok && !r.duplicates {
+				offset := p.lines[node.Pos.Line-p.lineBase-1] + node.Pos.Column - 1
+				return p.errorf(offset, CodeDuplicateKey, "duplicate key %q, first defined at %s", node.Key, pos)
+			}
+			r.keys[node.Key] = node.Pos
+		case *VersionedBlock:
+			if err := r.checkKeys(p, node.Block.Nodes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// next reads lines until they hold one or more complete entries, and parses
+// them. An entry can only end on a line which closes a block, list, or
+// multiline string, or on the line it starts on, so parsing is only attempted
+// for those lines.
+func (r *EntryReader) next() error {
+	for {
+		start := len(r.buf)
+		data, err := r.r.ReadSlice('\n')
+		r.buf = append(r.buf, data...)
+		for err == bufio.ErrBufferFull {
+			data, err = r.r.ReadSlice('\n')
+			r.buf = append(r.buf, data...)
+		}
+		eof := err == io.EOF
+		if err != nil && !eof {
+			return err
+		}
+		if len(r.buf) == 0 {
+			return io.EOF
+		}
+		if limit := r.limits.MaxSize; limit > 0 && r.offset+len(r.buf) > limit {
+			return &Error{
+				Code:    CodeLimitExceeded,
+				Column:  1,
+				Line:    1,
+				Message: fmt.Sprintf("input size exceeds the limit of %d bytes", limit),
+			}
+		}
+		if start > 0 && !eof && !bytes.ContainsAny(r.buf[start:], "]}`") {
+			continue
+		}
+		lines := bytes.Count(r.buf, []byte("\n"))
+		if eof && r.buf[len(r.buf)-1] != '\n' {
+			lines++
+		}
+		p := newParser(r.buf)
+		// Size the arena to the entry, instead of the default for documents.
+		p.arena.ChunkSize = max(lines, 8)
+		if len(r.anchors) > 0 {
+			// Clone the anchors, as a failed attempt at parsing an incomplete
+			// entry may still define them.
+			p.anchors, p.anchorSizes = maps.Clone(r.anchors), maps.Clone(r.anchorSizes)
+		}
+		p.duplicates, p.limits, p.loader = r.duplicates, r.limits, r.loader
+		p.lineBase, p.offsetBase = r.line, r.offset
+		p.spec, p.started = r.spec, r.started
+		p.size, p.tokenCount = r.included+r.offset+len(r.buf), r.tokens
+		p.version, p.versioned = r.version, r.versioned
+		nodes, err := p.parse()
+		if err != nil {
+			// Incomplete entries fail on the line after those read so far.
+			if perr, ok := err.(*Error); ok && !eof && perr.Line > r.line+lines {
+				continue
+			}
+			return err
+		}
+		if err := r.checkKeys(p, nodes); err != nil {
+			return err
+		}
+		r.anchors, r.anchorSizes = p.anchors, p.anchorSizes
+		r.included, r.tokens = p.size-r.offset-len(r.buf), p.tokenCount
+		r.spec, r.started = p.spec, p.started
+		r.version, r.versioned = p.version, p.versioned
+		r.line += lines
+		r.offset += len(r.buf)
+		r.buf = r.buf[:0]
+		r.nodes = nodes
+		if eof && len(nodes) == 0 {
+			return io.EOF
+		}
+		return nil
+	}
+}
+
+// NewEntryReader returns a new reader for the top-level entries of the XON
+// document read from r.
+func NewEntryReader(r io.Reader) *EntryReader {
+	return &EntryReader{keys: keySet{}, r: bufio.NewReader(r)}
+}
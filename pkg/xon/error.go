@@ -0,0 +1,127 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error codes. These are stable across releases, so that tools can match on
+// them instead of on the wording of error messages.
+const (
+	CodeAmbiguousList      ErrorCode = "ambiguous_list"      // an element with spaces used with comma separators
+	CodeDuplicateKey       ErrorCode = "duplicate_key"       // a key defined more than once within a block
+	CodeIncludeCycle       ErrorCode = "include_cycle"       // a file that includes itself, directly or indirectly
+	CodeInvalidAnchor      ErrorCode = "invalid_anchor"      // a malformed or duplicate anchor
+	CodeInvalidBytes       ErrorCode = "invalid_bytes"       // malformed base64 or hex data within a bytes literal
+	CodeInvalidEncoding    ErrorCode = "invalid_encoding"    // invalid UTF-8 or a raw carriage return
+	CodeInvalidEscape      ErrorCode = "invalid_escape"      // a malformed `<|0xNN|>` byte escape
+	CodeInvalidIdentifier  ErrorCode = "invalid_identifier"  // a malformed key or block name
+	CodeInvalidInclude     ErrorCode = "invalid_include"     // an included file that couldn't be loaded
+	CodeInvalidIndentation ErrorCode = "invalid_indentation" // a badly indented multiline string
+	CodeInvalidLine        ErrorCode = "invalid_line"        // a malformed `\` escape within XON Lines
+	CodeInvalidList        ErrorCode = "invalid_list"        // misplaced commas or elements within a list
+	CodeInvalidReference   ErrorCode = "invalid_reference"   // a reference to an unknown or unusable anchor
+	CodeInvalidSpec        ErrorCode = "invalid_spec"        // a misplaced `xon` line, or one with an unknown version
+	CodeInvalidValue       ErrorCode = "invalid_value"       // an unquoted value with reserved characters
+	CodeInvalidVersion     ErrorCode = "invalid_version"     // a malformed versioned block
+	CodeLimitExceeded      ErrorCode = "limit_exceeded"      // input beyond the configured Limits
+	CodeMissingSpace       ErrorCode = "missing_space"       // no space after a quoted key, '=', or ','
+	CodeMissingValue       ErrorCode = "missing_value"       // no value after '='
+	CodeMixedVersions      ErrorCode = "mixed_versions"      // more than one versioned block number
+	CodeReferenceCycle     ErrorCode = "reference_cycle"     // an anchored entry that references itself
+	CodeUnexpectedEOF      ErrorCode = "unexpected_eof"      // an unclosed block or list
+	CodeUnexpectedToken    ErrorCode = "unexpected_token"    // an unexpected character
+	CodeUnterminatedString ErrorCode = "unterminated_string" // a quoted or multiline string without an end
+)
+
+// Error represents a parse error. Line and Column are 1-indexed, and Column is
+// measured in bytes. Expected lists what would have been valid at the point of
+// the error, e.g. `'='`, if anything in particular, and Source holds the text
+// of the line containing the error. For documents loaded with a Loader, File
+// is the path of the file containing the error.
+type Error struct {
+	Code     ErrorCode `json:"code"`
+	Column   int       `json:"column"`
+	Expected []string  `json:"expected,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Line     int       `json:"line"`
+	Message  string    `json:"message"`
+	Source   string    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("xon: %s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("xon: %d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	type parseError Error
+	return marshalJSON(map[string]*parseError{"parse_error": (*parseError)(e)})
+}
+
+// Pretty returns the error formatted over multiple lines, with the error code,
+// the offending line from the source, a caret marking the column, and what
+// was expected instead, e.g.
+//
+//	xon: 2:10: expected newline or '}' after '{', got 'x' [unexpected_token]
+//	  |
+//	2 | server { x
+//	  |          ^
+//	  = expected newline, comment, or '}'
+func (e *Error) Pretty() string {
+	b := &strings.Builder{}
+	b.WriteString(e.Error())
+	if e.Code != "" {
+		b.WriteString(" [" + string(e.Code) + "]")
+	}
+	if e.Line < 1 {
+		return b.String()
+	}
+	gutter := strings.Repeat(" ", len(strconv.Itoa(e.Line)))
+	fmt.Fprintf(b, "\n%s |\n%d | %s\n%s | ", gutter, e.Line, e.Source, gutter)
+	// Pad up to the column so that the caret lines up with the source when
+	// displayed, by keeping tabs and counting multi-byte characters once.
+	for i := 0; i < e.Column-1; i++ {
+		switch {
+		case i >= len(e.Source):
+			b.WriteByte(' ')
+		case e.Source[i] == '\t':
+			b.WriteByte('\t')
+		case utf8.RuneStart(e.Source[i]):
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	if len(e.Expected) > 0 {
+		fmt.Fprintf(b, "\n%s = expected %s", gutter, joinExpected(e.Expected))
+	}
+	return b.String()
+}
+
+// expect sets the tokens that were expected at the point of the error.
+func (e *Error) expect(tokens ...string) *Error {
+	e.Expected = tokens
+	return e
+}
+
+// ErrorCode identifies the kind of a parse error.
+type ErrorCode string
+
+// joinExpected joins the given tokens into a list like "a, b, or c".
+func joinExpected(tokens []string) string {
+	switch len(tokens) {
+	case 1:
+		return tokens[0]
+	case 2:
+		return tokens[0] + " or " + tokens[1]
+	}
+	return strings.Join(tokens[:len(tokens)-1], ", ") + ", or " + tokens[len(tokens)-1]
+}
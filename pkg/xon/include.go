@@ -0,0 +1,60 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Loader loads the source of files for include directives. Paths are always
+// slash-separated and relative to the root of the loader, like those of an
+// fs.FS, e.g. `config/servers.xon`.
+type Loader interface {
+	Load(path string) ([]byte, error)
+}
+
+// LoaderFunc adapts an ordinary function into a Loader.
+type LoaderFunc func(path string) ([]byte, error)
+
+// Load calls f(path).
+func (f LoaderFunc) Load(path string) ([]byte, error) {
+	return f(path)
+}
+
+// FSLoader returns a Loader that reads files from the given filesystem, e.g.
+// an embed.FS, or the result of os.DirFS for files on disk.
+func FSLoader(fsys fs.FS) Loader {
+	return LoaderFunc(func(path string) ([]byte, error) {
+		return fs.ReadFile(fsys, path)
+	})
+}
+
+// Load parses the file at the given path using the loader, and replaces any
+// include directives with the nodes of the files they include, so that large
+// configurations can be split across files, e.g.
+//
+//	include "servers.xon"
+//	include "/shared/defaults.xon"
+//
+// Includes are resolved relative to the directory of the including file, or
+// to the root of the loader if they start with a `/`. They can appear at the
+// top level or within blocks, and their nodes are merged into the including
+// block, so keys defined in more than one file are caught as duplicates.
+// Files that include themselves, directly or indirectly, result in an error.
+//
+// The positions of nodes, and of any errors, have their File set to the path
+// of the file they come from.
+func Load(loader Loader, name string) ([]Node, error) {
+	name = path.Clean(name)
+	src, err := loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(src)
+	p.file = name
+	p.loader = loader
+	p.stack = []string{name}
+	return p.parse()
+}
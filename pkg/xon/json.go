@@ -0,0 +1,346 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type jsonDecoder struct {
+	dec       *json.Decoder
+	version   int64
+	versioned bool
+}
+
+// decodeArray converts the elements of a JSON array, after its opening bracket,
+// into either a list, or the members of a block for each object if blocks is
+// set. The members of each object are added to the given keys, or to a new set
+// if it is nil.
+func (d *jsonDecoder) decodeArray(path string, blocks bool, keys keySet) (*List, [][]Node, error) {
+	list := &List{Content: []Value{}}
+	var members [][]Node
+	for i := 0; d.dec.More(); i++ {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, nil, jsonSyntaxError(err)
+		}
+		elemPath := indexPath(path, i)
+		switch tok {
+		case json.Delim('{'):
+			if !blocks {
+				return nil, nil, decodeErrorf(elemPath, "cannot convert an object within a nested array")
+			}
+			memberKeys := keys
+			if memberKeys == nil {
+				memberKeys = keySet{}
+			}
+			nodes, err := d.decodeNodes(elemPath, memberKeys)
+			if err != nil {
+				return nil, nil, err
+			}
+			members = append(members, nodes)
+		case json.Delim('['):
+			elem, _, err := d.decodeArray(elemPath, false, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			list.Content = append(list.Content, elem)
+		default:
+			list.Content = append(list.Content, jsonString(tok))
+		}
+		if len(members) > 0 && len(list.Content) > 0 {
+			return nil, nil, decodeErrorf(path, "cannot mix objects with other values in an array")
+		}
+	}
+	if _, err := d.dec.Token(); err != nil {
+		return nil, nil, jsonSyntaxError(err)
+	}
+	return list, members, nil
+}
+
+// decodeNodes converts the members of a JSON object, after its opening brace,
+// into nodes. As in XON, the keys within versioned blocks share the given set
+// with the keys of the enclosing object.
+func (d *jsonDecoder) decodeNodes(path string, keys keySet) ([]Node, error) {
+	nodes := []Node{}
+	seen := map[string]bool{}
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, jsonSyntaxError(err)
+		}
+		key := tok.(string)
+		keyPath := childPath(path, key)
+		if seen[key] {
+			return nil, decodeErrorf(keyPath, "duplicate member in JSON object")
+		}
+		seen[key] = true
+		if tok, err = d.dec.Token(); err != nil {
+			return nil, jsonSyntaxError(err)
+		}
+		version, versioned := versionKey(key)
+		var blockKeys keySet
+		if versioned {
+			if d.versioned && version != d.version {
+				return nil, decodeErrorf(keyPath, "only one versioned block number allowed per document (found v%d after v%d)", version, d.version)
+			}
+			d.version, d.versioned = version, true
+			blockKeys = keys
+		}
+		block := func(members []Node) Node {
+			if versioned {
+				return &VersionedBlock{Block: &Block{Nodes: members}, Version: version}
+			}
+			return &Block{Name: key, Nodes: members}
+		}
+		switch tok {
+		case json.Delim('{'):
+			if blockKeys == nil {
+				blockKeys = keySet{}
+			}
+			members, err := d.decodeNodes(keyPath, blockKeys)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, block(members))
+		case json.Delim('['):
+			list, members, err := d.decodeArray(keyPath, true, blockKeys)
+			if err != nil {
+				return nil, err
+			}
+			if len(members) == 0 {
+				if versioned {
+					return nil, decodeErrorf(keyPath, "versioned blocks must be objects")
+				}
+				if err := addKey(keys, key, keyPath); err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, &KeyValue{Key: key, Value: list})
+				break
+			}
+			for _, m := range members {
+				nodes = append(nodes, block(m))
+			}
+		default:
+			if versioned {
+				return nil, decodeErrorf(keyPath, "versioned blocks must be objects")
+			}
+			if err := addKey(keys, key, keyPath); err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, &KeyValue{Key: key, Value: jsonString(tok)})
+		}
+	}
+	if _, err := d.dec.Token(); err != nil {
+		return nil, jsonSyntaxError(err)
+	}
+	return nodes, nil
+}
+
+// jsonObject represents a JSON object whose members are kept in the order that
+// they were first defined. Member values are either nil, a string, a []any, or
+// a []*jsonObject for blocks.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+// MarshalJSON implements the json.Marshaler interface. Blocks are encoded as
+// objects, unless a block name is repeated, in which case they are encoded as
+// an array of objects.
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := marshalJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		value := o.values[key]
+		if blocks, ok := value.([]*jsonObject); ok && len(blocks) == 1 {
+			value = blocks[0]
+		}
+		v, err := marshalJSON(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// FromJSON converts the given JSON document, which must be an object, into
+// XON nodes, keeping the order of the object members.
+//
+// Objects are converted into blocks, and arrays of objects into repeated
+// blocks with the same name. Members named like `[v5]` are converted into
+// versioned blocks. All other arrays are converted into lists, and may not
+// contain objects. Strings, numbers, and booleans are converted into strings,
+// with numbers kept exactly as written, and null is converted into `nil`.
+func FromJSON(data []byte) ([]Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonSyntaxError(err)
+	}
+	if tok != json.Delim('{') {
+		return nil, errors.New("xon: cannot convert JSON to XON: expected an object at the top level")
+	}
+	d := &jsonDecoder{dec: dec}
+	nodes, err := d.decodeNodes("", keySet{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("xon: invalid JSON: unexpected data after the top-level object")
+	}
+	return nodes, nil
+}
+
+// ToJSON converts the given XON nodes into a JSON object, with members in the
+// order that they were first defined. Comments are dropped.
+//
+// Blocks are converted into objects, unless the block name is repeated, in
+// which case the blocks are converted into an array of objects. Versioned
+// blocks are converted into members named like `[v5]`. Lists are converted
+// into arrays, and strings are converted into JSON strings, except for the
+// unquoted value `nil`, which is converted into null.
+//
+// The conversion fails if a name is used for both a key and a block, or if a
+// key is named like a versioned block, as they can't be told apart in JSON.
+func ToJSON(nodes []Node) ([]byte, error) {
+	obj, err := jsonMembers(nodes, "")
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSON(obj)
+}
+
+// addKey adds the given key to the set, failing if it is already defined, e.g.
+// within a versioned block as well as outside of it.
+func addKey(keys keySet, key string, path string) error {
+	if _, ok := keys[key]; ok {
+		return decodeErrorf(path, "duplicate key")
+	}
+	keys[key] = Position{}
+	return nil
+}
+
+// jsonMembers converts the given nodes into a JSON object.
+func jsonMembers(nodes []Node, path string) (*jsonObject, error) {
+	obj := &jsonObject{values: map[string]any{}}
+	isKey := map[string]bool{}
+	add := func(name string, value any, key bool) error {
+		prev, ok := obj.values[name]
+		if !ok {
+			obj.keys = append(obj.keys, name)
+			obj.values[name] = value
+			isKey[name] = key
+			return nil
+		}
+		if key || isKey[name] {
+			return decodeErrorf(childPath(path, name), "cannot convert to JSON as the name is used for both a key and a block")
+		}
+		obj.values[name] = append(prev.([]*jsonObject), value.([]*jsonObject)...)
+		return nil
+	}
+	for _, node := range flattenReferences(nodes) {
+		var err error
+		switch node := node.(type) {
+		case *Block:
+			var members *jsonObject
+			if members, err = jsonMembers(node.Nodes, childPath(path, node.Name)); err == nil {
+				err = add(node.Name, []*jsonObject{members}, false)
+			}
+		case *Include:
+			return nil, decodeErrorf(path, "cannot convert to JSON as the include of %q hasn't been resolved", node.Path)
+		case *KeyValue:
+			if _, ok := versionKey(node.Key); ok {
+				return nil, decodeErrorf(childPath(path, node.Key), "cannot convert to JSON as the key is named like a versioned block")
+			}
+			err = add(node.Key, jsonValue(node.Value), true)
+		case *VersionedBlock:
+			name := "[v" + strconv.FormatInt(node.Version, 10) + "]"
+			var members *jsonObject
+			if members, err = jsonMembers(node.Block.Nodes, childPath(path, name)); err == nil {
+				err = add(name, []*jsonObject{members}, false)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// jsonString converts a JSON scalar token into a string value.
+func jsonString(tok json.Token) *String {
+	switch tok := tok.(type) {
+	case bool:
+		return &String{Value: strconv.FormatBool(tok)}
+	case json.Number:
+		return &String{Value: tok.String()}
+	case string:
+		return &String{Quoted: tok == "nil", Value: tok}
+	}
+	return &String{Value: "nil"}
+}
+
+func jsonSyntaxError(err error) error {
+	return fmt.Errorf("xon: invalid JSON: %w", err)
+}
+
+// jsonValue converts a XON value into the equivalent JSON value.
+func jsonValue(value Value) any {
+	switch value := referencedValue(value).(type) {
+	case *List:
+		elems := []any{}
+		for _, elem := range value.Content {
+			if _, ok := elem.(*Comment); !ok {
+				elems = append(elems, jsonValue(elem))
+			}
+		}
+		return elems
+	case *Bytes:
+		return value.Data
+	case *String:
+		if !value.Quoted && value.Value == "nil" {
+			return nil
+		}
+		return value.Value
+	}
+	return nil
+}
+
+// versionKey returns the version for names like `[v5]`, which are used for
+// versioned blocks within JSON.
+func versionKey(name string) (int64, bool) {
+	digits, ok := strings.CutPrefix(name, "[v")
+	if !ok {
+		return 0, false
+	}
+	if digits, ok = strings.CutSuffix(digits, "]"); !ok || digits == "" {
+		return 0, false
+	}
+	for i := range len(digits) {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
+	version, err := strconv.ParseInt(digits, 10, 64)
+	return version, err == nil
+}
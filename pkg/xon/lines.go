@@ -0,0 +1,239 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// LineReader reads documents from XON Lines input, where each line holds a
+// complete document, e.g. an entry within a log or an event within a stream.
+// As documents span multiple lines, any newlines within them are written as
+// `\n`, and backslashes as `\\`, so that lines without either hold the
+// document as is, e.g.
+//
+//	event = login
+//	event = logout\nsession {\nid = 42\nuser = tav\n}
+//
+// Each line, including the last, ends with a newline, with an optional
+// carriage return before it. Empty lines hold empty documents.
+type LineReader struct {
+	line    int
+	partial []byte // an incomplete line, held until the rest of it is read
+	r       *bufio.Reader
+	skip    bool
+	skipped int
+}
+
+// Decode reads the next document, and stores the result in the value pointed
+// to by v, following the same rules as Unmarshal. It returns the same errors
+// as ReadNodes, and invalid lines are skipped in the same way, though errors
+// from decoding valid documents are always returned.
+func (r *LineReader) Decode(v any) error {
+	for {
+		raw, doc, err := r.next()
+		if err != nil {
+			return err
+		}
+		err = (&decodeState{}).unmarshal(doc, v)
+		if perr, ok := err.(*Error); ok {
+			err = lineError(perr, r.line, raw, doc)
+			if r.skip {
+				r.skipped++
+				continue
+			}
+		}
+		return err
+	}
+}
+
+// Line returns the number of the line holding the document that was last
+// read, starting from 1.
+func (r *LineReader) Line() int {
+	return r.line
+}
+
+// ReadNodes reads the next document, and returns its nodes as parsed by Parse.
+// Parse errors are positioned within the line as written, with the Line set to
+// the line number within the input.
+//
+// It returns io.EOF at the end of the input. If the input ends partway through
+// a line, it returns io.ErrUnexpectedEOF instead, and holds on to the partial
+// line, so that reading can resume once more of the input is available, e.g.
+// when following a log file that's still being written to.
+func (r *LineReader) ReadNodes() ([]Node, error) {
+	for {
+		raw, doc, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := Parse(doc)
+		if err != nil {
+			err = lineError(err.(*Error), r.line, raw, doc)
+			if r.skip {
+				r.skipped++
+				continue
+			}
+			return nil, err
+		}
+		return nodes, nil
+	}
+}
+
+// SetSkipInvalid sets whether lines that can't be parsed are skipped instead
+// of resulting in an error, e.g. so that lines which were corrupted by a crash
+// partway through writing them don't stop the rest of a log from being read.
+// The number of lines skipped so far is returned by Skipped.
+func (r *LineReader) SetSkipInvalid(skip bool) {
+	r.skip = skip
+}
+
+// Skipped returns the number of invalid lines that have been skipped.
+func (r *LineReader) Skipped() int {
+	return r.skipped
+}
+
+// next reads the next complete line, and returns it as written, along with the
+// document that it holds. Invalid escapes are skipped over like any other
+// invalid line.
+func (r *LineReader) next() ([]byte, []byte, error) {
+	for {
+		data, err := r.r.ReadBytes('\n')
+		if err != nil {
+			r.partial = append(r.partial, data...)
+			if err == io.EOF && len(r.partial) > 0 {
+				return nil, nil, io.ErrUnexpectedEOF
+			}
+			return nil, nil, err
+		}
+		if len(r.partial) > 0 {
+			data = append(r.partial, data...)
+			r.partial = nil
+		}
+		r.line++
+		raw := bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r"))
+		doc, perr := unescapeLine(raw, r.line)
+		if perr != nil {
+			if r.skip {
+				r.skipped++
+				continue
+			}
+			return nil, nil, perr
+		}
+		return raw, doc, nil
+	}
+}
+
+// LineWriter writes documents as XON Lines, as described by LineReader.
+// Documents are printed without any indentation, to keep lines short.
+type LineWriter struct {
+	autoFlush bool
+	buf       *bufio.Writer
+	doc       bytes.Buffer
+}
+
+// Encode writes the XON encoding of v to the output as a single line,
+// following the same rules as Marshal. The line is flushed to the underlying
+// writer straight away, unless automatic flushing has been turned off.
+func (w *LineWriter) Encode(v any) error {
+	w.doc.Reset()
+	if err := encode(&w.doc, v, encodeState{}, printer{}); err != nil {
+		return err
+	}
+	for _, c := range bytes.TrimSuffix(w.doc.Bytes(), []byte("\n")) {
+		switch c {
+		case '\n':
+			w.buf.WriteString(`\n`)
+		case '\\':
+			w.buf.WriteString(`\\`)
+		default:
+			w.buf.WriteByte(c)
+		}
+	}
+	w.buf.WriteByte('\n')
+	if w.autoFlush {
+		return w.buf.Flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered lines to the underlying writer.
+func (w *LineWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// SetAutoFlush sets whether each line is flushed as soon as it's written. The
+// default is true, so that lines are visible to readers straight away, while
+// turning it off batches writes for higher throughput, with Flush needing to
+// be called once done.
+func (w *LineWriter) SetAutoFlush(flush bool) {
+	w.autoFlush = flush
+}
+
+// NewLineReader returns a new XON Lines reader that reads from r.
+func NewLineReader(r io.Reader) *LineReader {
+	return &LineReader{r: bufio.NewReader(r)}
+}
+
+// NewLineWriter returns a new XON Lines writer that writes to w.
+func NewLineWriter(w io.Writer) *LineWriter {
+	return &LineWriter{autoFlush: true, buf: bufio.NewWriter(w)}
+}
+
+// lineError returns the given parse error for the document held by the given
+// line, with its position moved to the line as written.
+func lineError(err *Error, n int, raw []byte, doc []byte) *Error {
+	if err.Line < 1 {
+		return err
+	}
+	offset := 0
+	for line := 1; line < err.Line; line++ {
+		offset += bytes.IndexByte(doc[offset:], '\n') + 1
+	}
+	offset = min(offset+err.Column-1, len(doc))
+	col := 0
+	for range offset {
+		if raw[col] == '\\' {
+			col++
+		}
+		col++
+	}
+	err.Column = col + 1
+	err.Line = n
+	err.Source = string(raw)
+	return err
+}
+
+// unescapeLine returns the document held by the given line, with any `\n` and
+// `\\` escapes decoded.
+func unescapeLine(raw []byte, n int) ([]byte, *Error) {
+	if bytes.IndexByte(raw, '\\') == -1 {
+		return raw, nil
+	}
+	doc := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			doc = append(doc, raw[i])
+			continue
+		}
+		i++
+		switch {
+		case i < len(raw) && raw[i] == 'n':
+			doc = append(doc, '\n')
+		case i < len(raw) && raw[i] == '\\':
+			doc = append(doc, '\\')
+		default:
+			return nil, &Error{
+				Code:    CodeInvalidLine,
+				Column:  i,
+				Line:    n,
+				Message: `invalid escape within line (use \n for newlines and \\ for backslashes)`,
+				Source:  string(raw),
+			}
+		}
+	}
+	return doc, nil
+}
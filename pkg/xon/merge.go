@@ -0,0 +1,247 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+// Merge returns the result of layering each of the overlays on top of the base
+// nodes in turn, e.g. for defaults, followed by environment-specific settings,
+// and then local overrides. The given nodes are left unchanged.
+//
+// Blocks with the same name are merged recursively, as long as there's only
+// one of them within both the base and the overlay. Otherwise, and for
+// key/value pairs, entries within the overlay replace all of the entries with
+// the same name within the base, in the position of the first one, while new
+// entries are added at the end, along with any comments directly above them.
+//
+// Lists are replaced as a whole, unless the overlay uses `+=`, e.g.
+// `ports += [8443]`, in which case its elements are appended to those of the
+// list within the base. An overlay key with the unquoted value `nil` deletes
+// any entries with that name from the base, along with any comments directly
+// above them.
+//
+// Versioned blocks with the same version are merged like blocks. References
+// are resolved, and anchors dropped, as part of merging, and any unresolved
+// include directives result in an error.
+func Merge(base []Node, overlays ...[]Node) ([]Node, error) {
+	nodes, err := resolveNodes(base, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, overlay := range overlays {
+		resolved, err := resolveNodes(overlay, "")
+		if err != nil {
+			return nil, err
+		}
+		if nodes, err = mergeNodes(nodes, resolved, ""); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// entryName returns the key or block name of the given node, if it has one.
+func entryName(node Node) (string, bool) {
+	switch node := node.(type) {
+	case *Block:
+		return node.Name, true
+	case *KeyValue:
+		return node.Key, true
+	}
+	return "", false
+}
+
+// mergeNodes merges the overlay nodes into the base nodes, both of which must
+// have been resolved first.
+func mergeNodes(base []Node, overlay []Node, path string) ([]Node, error) {
+	// Each base node gets a slot, so that replacements can take its place,
+	// and deletions can empty it, without shifting the other nodes.
+	slots := make([][]Node, len(base))
+	for i, node := range base {
+		slots[i] = []Node{node}
+	}
+	var (
+		added    []Node
+		comments []Node
+		counts   = map[string]int{}
+		replaced = map[string]int{}
+	)
+	for _, node := range overlay {
+		if block, ok := node.(*Block); ok {
+			counts[block.Name]++
+		}
+	}
+	matches := func(name string) []int {
+		var idxs []int
+		for i, slot := range slots {
+			if len(slot) == 1 {
+				if n, ok := entryName(slot[0]); ok && n == name {
+					idxs = append(idxs, i)
+				}
+			}
+		}
+		return idxs
+	}
+	remove := func(idxs []int) {
+		for _, i := range idxs {
+			slots[i] = nil
+			for j := i - 1; j >= 0 && len(slots[j]) == 1; j-- {
+				if _, ok := slots[j][0].(*Comment); !ok {
+					break
+				}
+				slots[j] = nil
+			}
+		}
+	}
+	add := func(name string, node Node) {
+		if i, ok := replaced[name]; ok && i >= 0 {
+			slots[i] = append(slots[i], node)
+			return
+		}
+		added = append(added, comments...)
+		added = append(added, node)
+		replaced[name] = -1
+	}
+	replace := func(name string, node Node) {
+		if _, ok := replaced[name]; ok {
+			add(name, node)
+			return
+		}
+		idxs := matches(name)
+		if len(idxs) == 0 {
+			add(name, node)
+			return
+		}
+		for _, i := range idxs[1:] {
+			slots[i] = nil
+		}
+		slots[idxs[0]] = []Node{node}
+		replaced[name] = idxs[0]
+	}
+	for _, node := range overlay {
+		switch node := node.(type) {
+		case *Block:
+			idxs := matches(node.Name)
+			if _, ok := replaced[node.Name]; !ok && counts[node.Name] == 1 && len(idxs) == 1 {
+				if block, ok := slots[idxs[0]][0].(*Block); ok {
+					var err error
+					if block.Nodes, err = mergeNodes(block.Nodes, node.Nodes, childPath(path, node.Name)); err != nil {
+						return nil, err
+					}
+					if node.OpeningComment != "" {
+						block.OpeningComment = node.OpeningComment
+					}
+					if node.ClosingComment != "" {
+						block.ClosingComment = node.ClosingComment
+					}
+					break
+				}
+			}
+			replace(node.Name, node)
+		case *Comment:
+			comments = append(comments, node)
+			continue
+		case *KeyValue:
+			if s, ok := node.Value.(*String); ok && !s.Quoted && s.Value == "nil" {
+				remove(matches(node.Key))
+				replaced[node.Key] = -1
+				break
+			}
+			if !node.Append {
+				replace(node.Key, node)
+				break
+			}
+			node.Append = false
+			idxs := matches(node.Key)
+			if len(idxs) == 0 {
+				replace(node.Key, node)
+				break
+			}
+			kv, ok := slots[idxs[0]][0].(*KeyValue)
+			if !ok || len(idxs) > 1 {
+				return nil, decodeErrorf(childPath(path, node.Key), "cannot append to a block")
+			}
+			list, ok := kv.Value.(*List)
+			if !ok {
+				return nil, decodeErrorf(childPath(path, node.Key), "cannot append to a value which isn't a list")
+			}
+			list.Content = append(list.Content, node.Value.(*List).Content...)
+			if node.Comment != "" {
+				kv.Comment = node.Comment
+			}
+		case *VersionedBlock:
+			merged := false
+			for _, slot := range slots {
+				if len(slot) != 1 {
+					continue
+				}
+				if vb, ok := slot[0].(*VersionedBlock); ok && vb.Version == node.Version {
+					var err error
+					if vb.Block.Nodes, err = mergeNodes(vb.Block.Nodes, node.Block.Nodes, path); err != nil {
+						return nil, err
+					}
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				added = append(added, comments...)
+				added = append(added, node)
+			}
+		}
+		comments = nil
+	}
+	nodes := []Node{}
+	for _, slot := range slots {
+		nodes = append(nodes, slot...)
+	}
+	return append(nodes, added...), nil
+}
+
+// resolveNodes returns a copy of the given nodes, with any references
+// resolved, and anchors dropped.
+func resolveNodes(nodes []Node, path string) ([]Node, error) {
+	resolved := []Node{}
+	for _, node := range flattenReferences(nodes) {
+		switch node := node.(type) {
+		case *Block:
+			clone := *node
+			clone.Anchor = ""
+			var err error
+			if clone.Nodes, err = resolveNodes(node.Nodes, childPath(path, node.Name)); err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, &clone)
+		case *Include:
+			return nil, decodeErrorf(path, "cannot merge as the include of %q hasn't been resolved", node.Path)
+		case *KeyValue:
+			clone := *node
+			clone.Anchor = ""
+			clone.Value = resolveValue(node.Value)
+			resolved = append(resolved, &clone)
+		case *VersionedBlock:
+			clone := *node
+			block := *node.Block
+			var err error
+			if block.Nodes, err = resolveNodes(node.Block.Nodes, path); err != nil {
+				return nil, err
+			}
+			clone.Block = &block
+			resolved = append(resolved, &clone)
+		default:
+			resolved = append(resolved, cloneNode(node))
+		}
+	}
+	return resolved, nil
+}
+
+// resolveValue returns a copy of the given value, with any references
+// resolved.
+func resolveValue(value Value) Value {
+	value = cloneValue(referencedValue(value))
+	if list, ok := value.(*List); ok {
+		for i, elem := range list.Content {
+			list.Content[i] = resolveValue(elem)
+		}
+	}
+	return value
+}
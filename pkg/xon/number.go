@@ -0,0 +1,92 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Number represents a number as the exact literal within XON, e.g. `1_000`,
+// `0xff`, or `12.50`, so that values like IDs or monetary amounts can be
+// decoded without any loss of precision, and then converted as needed.
+//
+// Numbers can be integers or floats, following the same syntax as when
+// decoding into integer and float types, except for the special float values
+// `nan` and `inf`, which aren't accepted.
+type Number string
+
+// BigFloat returns the number as a big.Float. Integers are converted exactly,
+// and floats are given enough precision to hold every significant digit of the
+// literal.
+func (n Number) BigFloat() (*big.Float, error) {
+	if _, err := parseNumber(string(n)); err != nil {
+		return nil, err
+	}
+	if i, err := n.BigInt(); err == nil {
+		return new(big.Float).SetInt(i), nil
+	}
+	s := strings.ReplaceAll(string(n), "_", "")
+	mantissa, _, _ := strings.Cut(strings.ToLower(s), "e")
+	f, _, err := big.ParseFloat(s, 10, max(64, 4*uint(len(mantissa))), big.ToNearestEven)
+	if err != nil {
+		return nil, errRange
+	}
+	return f, nil
+}
+
+// BigInt returns the number as a big.Int. Only integer literals, i.e. those
+// accepted when decoding into an integer type, are supported, but they can be
+// of any size.
+func (n Number) BigInt() (*big.Int, error) {
+	s := string(n)
+	if _, _, err := parseInteger(s); err != nil && err != errRange {
+		return nil, err
+	}
+	i, ok := new(big.Int).SetString(strings.ReplaceAll(s, "_", ""), 0)
+	if !ok {
+		return nil, errSyntax
+	}
+	return i, nil
+}
+
+// Float64 returns the number as a float64, following the same conventions as
+// when decoding into a float64.
+func (n Number) Float64() (float64, error) {
+	return parseFloat(string(n), 64)
+}
+
+// Int64 returns the number as an int64, following the same conventions as when
+// decoding into an int64.
+func (n Number) Int64() (int64, error) {
+	return parseInt(string(n))
+}
+
+// String returns the literal of the number.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Uint64 returns the number as a uint64, following the same conventions as
+// when decoding into a uint64.
+func (n Number) Uint64() (uint64, error) {
+	return parseUint(string(n))
+}
+
+// parseNumber validates that s is an integer or float literal, regardless of
+// whether it's within the range of any particular type.
+func parseNumber(s string) (Number, error) {
+	if _, _, err := parseInteger(s); err == nil || err == errRange {
+		return Number(s), nil
+	}
+	switch s {
+	case "nan", "inf", "+inf", "-inf":
+		return "", errors.New("nan and inf are not allowed")
+	}
+	if _, err := parseFloat(s, 64); err != nil && err != errRange {
+		return "", err
+	}
+	return Number(s), nil
+}
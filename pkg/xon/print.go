@@ -0,0 +1,612 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Contexts in which a string can be printed, as each has different rules for
+// when a string needs to be quoted.
+const (
+	keyString stringContext = iota
+	listString
+	listLineString // an element on its own line within a list
+	valueString
+)
+
+// printer writes nodes in XON syntax to buf, formatted according to the
+// options set on an Encoder.
+type printer struct {
+	alignValues bool // pad keys so that the values of adjacent pairs line up
+	buf         *bufio.Writer
+	expandLists bool // print each element of non-empty lists on its own line
+	indent      string
+	maxWidth    int // expand lists which would otherwise exceed this width
+	prefix      string
+	sortKeys    bool
+	spec        int // the minor version of the spec declared by the document, if any
+}
+
+// compactWidth returns the number of characters taken up by the given value
+// when printed on a single line within a list.
+func (p *printer) compactWidth(value Value, depth int) int {
+	list, ok := value.(*List)
+	if !ok {
+		return utf8.RuneCountInString(p.formatValue(value, listString, depth))
+	}
+	width := len("[]")
+	for i, elem := range list.Content {
+		if i > 0 {
+			width += len(", ")
+		}
+		width += p.compactWidth(elem, depth)
+	}
+	return width
+}
+
+// expandList returns whether the given list, starting at the given column,
+// needs to be printed with each element on its own line.
+func (p *printer) expandList(list *List, depth int, col int) bool {
+	switch {
+	case isExpanded(list):
+		return true
+	case p.expandLists:
+		return len(list.Content) > 0
+	case p.maxWidth > 0:
+		return col+p.compactWidth(list, depth) > p.maxWidth
+	}
+	return false
+}
+
+// formatHeredoc returns s, which must contain a newline, as a heredoc string.
+// Unlike multiline strings, these can represent any string, including ones
+// with leading or trailing whitespace or newlines.
+func (p *printer) formatHeredoc(s string, depth int) string {
+	s = escape(s, false)
+	delim := multilineDelimiter(s)
+	marker := "|"
+	body, ok := strings.CutSuffix(s, "\n")
+	if !ok {
+		marker = "|-"
+	}
+	b := &strings.Builder{}
+	b.WriteString(delim + marker + "\n")
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" {
+			b.WriteString(p.lineStart(depth + 1))
+			b.WriteString(line)
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(p.lineStart(depth + 1))
+	b.WriteString(delim)
+	return b.String()
+}
+
+// formatKey returns the key of the given key/value pair, along with any anchor
+// before it, and the operator which follows it, padded with spaces to the
+// given width if it's any shorter.
+func (p *printer) formatKey(kv *KeyValue, depth int, width int) string {
+	key := p.formatString(kv.Key, false, false, keyString, depth)
+	if kv.Anchor != "" {
+		key = "[&" + kv.Anchor + "] " + key
+	}
+	op := " = "
+	if kv.Append {
+		op = " += "
+	}
+	if pad := width - utf8.RuneCountInString(key+op); pad > 0 {
+		key += strings.Repeat(" ", pad)
+	}
+	return key + op
+}
+
+// formatMultiline returns s as a multiline string, or false if it can't be
+// represented as one without losing whitespace. Strings without newlines are
+// written on a single line.
+func (p *printer) formatMultiline(s string, depth int) (string, bool) {
+	s = escape(s, false)
+	if s == "" || isSpace(s[0]) || isSpace(s[len(s)-1]) {
+		return "", false
+	}
+	delim := multilineDelimiter(s)
+	if !strings.Contains(s, "\n") && s[0] != '`' && s[len(s)-1] != '`' {
+		return delim + s + delim, true
+	}
+	lines := strings.Split(s, "\n")
+	if lines[0] == "" || lines[len(lines)-1] == "" {
+		return "", false
+	}
+	b := &strings.Builder{}
+	b.WriteString(delim)
+	b.WriteByte('\n')
+	for _, line := range lines {
+		if line == "" {
+			b.WriteByte('\n')
+			continue
+		}
+		if strings.Trim(line, " \t") == "" {
+			return "", false
+		}
+		b.WriteString(p.lineStart(depth + 1))
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString(p.lineStart(depth))
+	b.WriteString(delim)
+	return b.String(), true
+}
+
+func (p *printer) formatString(s string, quoted, raw bool, ctx stringContext, depth int) string {
+	// Prefer raw strings to escaping any literal `<|0x` sequences.
+	if raw || strings.Contains(s, "<|0x") {
+		if text, ok := formatRaw(s); ok {
+			return text
+		}
+	}
+	if ctx != keyString && strings.ContainsAny(s, "\"\n") {
+		if text, ok := p.formatMultiline(s, depth); ok {
+			return text
+		}
+		if p.spec >= 2 && strings.Contains(s, "\n") {
+			return p.formatHeredoc(s, depth)
+		}
+	}
+	if !quoted && !needsQuotes(s, ctx, p.spec) {
+		return escape(s, false)
+	}
+	return `"` + escape(s, true) + `"`
+}
+
+// formatValue returns the given value, which mustn't be a list, as it would be
+// printed.
+func (p *printer) formatValue(value Value, ctx stringContext, depth int) string {
+	switch value := value.(type) {
+	case *Bytes:
+		if value.Hex {
+			return `hex"` + hex.EncodeToString(value.Data) + `"`
+		}
+		return `b64"` + base64.StdEncoding.EncodeToString(value.Data) + `"`
+	case *Reference:
+		return "[*" + value.Name + "]"
+	case *String:
+		return p.formatString(value.Value, value.Quoted, value.Raw, ctx, depth)
+	}
+	return ""
+}
+
+func (p *printer) lineStart(depth int) string {
+	return p.prefix + strings.Repeat(p.indent, depth)
+}
+
+func (p *printer) printAnchor(name string) {
+	if name != "" {
+		p.buf.WriteString("[&" + name + "] ")
+	}
+}
+
+func (p *printer) printBody(block *Block, depth int) {
+	if len(block.Nodes) == 0 && block.OpeningComment == "" {
+		p.buf.WriteString(" {}")
+		p.printInlineComment(block.ClosingComment)
+		p.buf.WriteByte('\n')
+		return
+	}
+	p.buf.WriteString(" {")
+	p.printInlineComment(block.OpeningComment)
+	p.buf.WriteByte('\n')
+	p.printNodes(block.Nodes, depth+1)
+	p.buf.WriteString(p.lineStart(depth))
+	p.buf.WriteByte('}')
+	p.printInlineComment(block.ClosingComment)
+	p.buf.WriteByte('\n')
+}
+
+func (p *printer) printComment(text string) {
+	p.buf.WriteString("//")
+	if text != "" {
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(text)
+	}
+}
+
+func (p *printer) printInlineComment(text string) {
+	if text != "" {
+		p.buf.WriteString("  ")
+		p.printComment(text)
+	}
+}
+
+// printList prints the given list, which starts at the given column.
+func (p *printer) printList(list *List, depth int, col int) {
+	if !p.expandList(list, depth, col) {
+		p.buf.WriteByte('[')
+		for i, elem := range list.Content {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.printValue(elem, listString, depth, col)
+		}
+		p.buf.WriteByte(']')
+		return
+	}
+	p.buf.WriteByte('[')
+	p.printInlineComment(list.OpeningComment)
+	afterElem := false
+	for _, elem := range list.Content {
+		comment, ok := elem.(*Comment)
+		if ok && comment.Inline && afterElem {
+			p.printInlineComment(comment.Text)
+			afterElem = false
+			continue
+		}
+		p.buf.WriteByte('\n')
+		start := p.lineStart(depth + 1)
+		p.buf.WriteString(start)
+		if ok {
+			p.printComment(comment.Text)
+		} else {
+			p.printValue(elem, listLineString, depth+1, utf8.RuneCountInString(start))
+		}
+		afterElem = !ok
+	}
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(p.lineStart(depth))
+	p.buf.WriteByte(']')
+}
+
+// printNode prints the given node, with the keys of key/value pairs padded to
+// the given width, including the operator.
+func (p *printer) printNode(node Node, depth int, width int) {
+	switch node := node.(type) {
+	case *Block:
+		p.buf.WriteString(p.lineStart(depth))
+		p.printAnchor(node.Anchor)
+		p.buf.WriteString(p.formatString(node.Name, false, false, keyString, depth))
+		p.printBody(node, depth)
+	case *Comment:
+		p.buf.WriteString(p.lineStart(depth))
+		p.printComment(node.Text)
+		p.buf.WriteByte('\n')
+	case *Include:
+		p.buf.WriteString(p.lineStart(depth))
+		p.buf.WriteString("include ")
+		p.buf.WriteString(p.formatString(node.Path, true, false, keyString, depth))
+		p.printInlineComment(node.Comment)
+		p.buf.WriteByte('\n')
+	case *KeyValue:
+		start := p.lineStart(depth) + p.formatKey(node, depth, width)
+		p.buf.WriteString(start)
+		p.printValue(node.Value, valueString, depth, utf8.RuneCountInString(start))
+		p.printInlineComment(node.Comment)
+		p.buf.WriteByte('\n')
+	case *Reference:
+		p.buf.WriteString(p.lineStart(depth))
+		p.buf.WriteString("[*" + node.Name + "]")
+		p.printInlineComment(node.Comment)
+		p.buf.WriteByte('\n')
+	case *Spec:
+		p.buf.WriteString(p.lineStart(depth))
+		p.buf.WriteString("xon " + node.Version)
+		p.printInlineComment(node.Comment)
+		p.buf.WriteByte('\n')
+	case *VersionedBlock:
+		p.buf.WriteString(p.lineStart(depth))
+		fmt.Fprintf(p.buf, "[v%d]", node.Version)
+		p.printBody(node.Block, depth)
+	}
+}
+
+// printNodes prints the given nodes, with blank lines separating blocks from
+// their neighbours, and following any spec line. Comments that directly precede
+// a block are kept together with it.
+//
+// When aligning values, the keys within each run of key/value pairs, which may
+// be interspersed with comments, are padded to the width of the longest one.
+func (p *printer) printNodes(nodes []Node, depth int) {
+	if p.sortKeys {
+		nodes = sortNodes(nodes)
+	}
+	widths := make([]int, len(nodes))
+	if p.alignValues {
+		start, width := 0, 0
+		for i := 0; i <= len(nodes); i++ {
+			if i < len(nodes) {
+				switch node := nodes[i].(type) {
+				case *Comment:
+					continue
+				case *KeyValue:
+					width = max(width, utf8.RuneCountInString(p.formatKey(node, depth, 0)))
+					continue
+				}
+			}
+			for j := start; j < i; j++ {
+				widths[j] = width
+			}
+			start, width = i+1, 0
+		}
+	}
+	for i, node := range nodes {
+		if i > 0 {
+			_, comment := nodes[i-1].(*Comment)
+			_, spec := nodes[i-1].(*Spec)
+			if spec || (!comment && (isBlockNode(nodes[i-1]) || isBlockNode(attachedNode(nodes[i:])))) {
+				p.buf.WriteByte('\n')
+			}
+		}
+		p.printNode(node, depth, widths[i])
+	}
+}
+
+// printValue prints the given value, which starts at the given column.
+func (p *printer) printValue(value Value, ctx stringContext, depth int, col int) {
+	if list, ok := value.(*List); ok {
+		p.printList(list, depth, col)
+		return
+	}
+	p.buf.WriteString(p.formatValue(value, ctx, depth))
+}
+
+type stringContext int
+
+// attachedNode returns the first node that isn't a comment, i.e. the node that
+// any leading comments are attached to, or nil if there isn't one.
+func attachedNode(nodes []Node) Node {
+	for _, node := range nodes {
+		if _, ok := node.(*Comment); !ok {
+			return node
+		}
+	}
+	return nil
+}
+
+// escape replaces the bytes within s which can't be written as is with byte
+// escapes, including any literal `<|0x` sequences. If quote is set, double
+// quotes and newlines are escaped too.
+func escape(s string, quote bool) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "<|0x") {
+			b.WriteString("<|0x3C|>|0x")
+			i += 4
+			continue
+		}
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if (r == utf8.RuneError && size == 1) || unicode.IsControl(r) {
+				for j := range size {
+					fmt.Fprintf(b, "<|0x%02X|>", s[i+j])
+				}
+			} else {
+				b.WriteString(s[i : i+size])
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '\t':
+			b.WriteByte(c)
+		case c == '\n' || c == '"':
+			if quote {
+				fmt.Fprintf(b, "<|0x%02X|>", c)
+			} else {
+				b.WriteByte(c)
+			}
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(b, "<|0x%02X|>", c)
+		default:
+			b.WriteByte(c)
+		}
+		i++
+	}
+	return b.String()
+}
+
+// formatRaw returns s as a raw string, with enough '#' characters around its
+// quotes for it to contain any '"' characters, or false if it has characters
+// which need byte escapes, i.e. newlines, control characters, or invalid UTF-8.
+func formatRaw(s string) (string, bool) {
+	if !utf8.ValidString(s) {
+		return "", false
+	}
+	for _, r := range s {
+		if r != '\t' && unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	hashes := ""
+	for strings.Contains(s, `"`+hashes) {
+		hashes += "#"
+	}
+	return "r" + hashes + `"` + s + `"` + hashes, true
+}
+
+func isBlockNode(node Node) bool {
+	switch node.(type) {
+	case *Block, *VersionedBlock:
+		return true
+	}
+	return false
+}
+
+// isExpanded returns whether the given list needs to be printed with each
+// element on its own line, i.e. if it has comments or multiline strings.
+func isExpanded(list *List) bool {
+	if list.OpeningComment != "" {
+		return true
+	}
+	for _, elem := range list.Content {
+		switch elem := elem.(type) {
+		case *Comment:
+			return true
+		case *List:
+			if isExpanded(elem) {
+				return true
+			}
+		case *String:
+			if strings.Contains(elem.Value, "\n") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPinned returns whether the given node needs to stay in place when sorting,
+// i.e. if it's a versioned block, include directive, reference, or spec line,
+// or if it defines an anchor, either directly or within a nested block.
+func isPinned(node Node) bool {
+	switch node := node.(type) {
+	case *Block:
+		return node.Anchor != "" || slices.ContainsFunc(node.Nodes, isPinned)
+	case *Include, *Reference, *Spec, *VersionedBlock:
+		return true
+	case *KeyValue:
+		return node.Anchor != ""
+	}
+	return false
+}
+
+// multilineDelimiter returns the shortest odd run of backticks which is longer
+// than any run within s.
+func multilineDelimiter(s string) string {
+	run, longest := 0, 0
+	for i := range len(s) {
+		if s[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", longest+1+longest%2)
+}
+
+// needsQuotes returns whether s needs to be quoted when printed in the given
+// context, within a document of the given spec version. Strings which contain
+// double quotes or newlines always need to be quoted, as they are only left
+// unquoted as multiline strings. From spec version 0.2, list elements starting
+// with `*` or `&` are quoted, so that the only element of a list, e.g. `[*a]`,
+// can't be mistaken for a reference or anchor.
+func needsQuotes(s string, ctx stringContext, spec int) bool {
+	if s == "" || strings.ContainsAny(s, "\"\n") || isSpace(s[0]) || isSpace(s[len(s)-1]) {
+		return true
+	}
+	if strings.HasPrefix(s, "//") || strings.ContainsRune("[]{}`", rune(s[0])) {
+		return true
+	}
+	switch s[len(s)-1] {
+	case ',', ']':
+		return true
+	}
+	inList := ctx == listString || ctx == listLineString
+	if inList && spec >= 2 && (s[0] == '*' || s[0] == '&') {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t':
+			if ctx == listString {
+				return true
+			}
+			j := skipSpace([]byte(s), i)
+			if strings.HasPrefix(s[j:], "//") || (spec >= 2 && strings.HasPrefix(s[j:], "+=")) || strings.ContainsRune("=[]{}", rune(s[j])) {
+				return true
+			}
+			if inList && s[j] == ',' {
+				return true
+			}
+			i = j - 1
+		case ',', ']':
+			if inList && isListBoundary([]byte(s), i+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requiredSpec returns the minor version of the spec that is needed to parse
+// the given nodes, i.e. 2 if they use anchors, references, or appends, or 0
+// otherwise.
+func requiredSpec(nodes []Node) int {
+	spec := 0
+	Walk(nodes, func(node Node) bool {
+		switch node := node.(type) {
+		case *Block:
+			if node.Anchor != "" {
+				spec = 2
+			}
+		case *KeyValue:
+			if node.Anchor != "" || node.Append || usesReference(node.Value) {
+				spec = 2
+			}
+		case *Reference:
+			spec = 2
+		}
+		return spec < 2
+	})
+	return spec
+}
+
+// sortNodes returns a copy of the given nodes with keys and blocks sorted by
+// name, and with any comments preceding a node moving along with it. Pinned
+// nodes stay in place, so that anchors are still defined before they're
+// referenced, and the nodes between them are sorted separately, as are any
+// trailing comments which aren't attached to a node.
+func sortNodes(nodes []Node) []Node {
+	var (
+		group  []Node
+		groups [][]Node
+		out    []Node
+	)
+	flush := func() {
+		slices.SortStableFunc(groups, func(a, b []Node) int {
+			x, _ := entryName(a[len(a)-1])
+			y, _ := entryName(b[len(b)-1])
+			return strings.Compare(x, y)
+		})
+		for _, g := range groups {
+			out = append(out, g...)
+		}
+		groups = nil
+	}
+	for _, node := range nodes {
+		if isPinned(node) {
+			flush()
+			out = append(out, group...)
+			out = append(out, node)
+			group = nil
+			continue
+		}
+		group = append(group, node)
+		if _, ok := node.(*Comment); !ok {
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	flush()
+	return append(out, group...)
+}
+
+// usesReference returns whether the given value is, or is a list that contains,
+// a reference.
+func usesReference(value Value) bool {
+	switch value := value.(type) {
+	case *List:
+		return slices.ContainsFunc(value.Content, usesReference)
+	case *Reference:
+		return true
+	}
+	return false
+}
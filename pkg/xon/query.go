@@ -0,0 +1,376 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	selectFilter querySelectorKind = iota
+	selectIndex
+	selectWildcard
+)
+
+// Match represents a node or list element matched by a query. Node is the
+// matched block or key/value pair, or the key/value pair holding the list for
+// list elements. Value is the value of a matched key/value pair, or the matched
+// list element, and is nil for blocks. Path is the canonical path to the match,
+// e.g. `server[1].ports[0]`, and the match spans from Pos up to End.
+type Match struct {
+	End   Position
+	Node  Node
+	Path  string
+	Pos   Position
+	Value Value
+}
+
+// queryParser parses the text of a query.
+type queryParser struct {
+	pos   int
+	query string
+}
+
+func (q *queryParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("xon: invalid query %q: %s", q.query, fmt.Sprintf(format, args...))
+}
+
+// parseName parses a name within a query, which is either quoted, or runs up
+// to the next `.`, `[`, `]`, or `=`, with any surrounding whitespace trimmed.
+func (q *queryParser) parseName(stop string) (string, bool, error) {
+	for q.pos < len(q.query) && (q.query[q.pos] == ' ' || q.query[q.pos] == '\t') {
+		q.pos++
+	}
+	if q.pos < len(q.query) && q.query[q.pos] == '"' {
+		p := newParser([]byte(q.query[q.pos:]))
+		s, err := p.parseQuoted()
+		if err != nil {
+			return "", false, q.errorf("unterminated quoted name")
+		}
+		q.pos += p.pos
+		return s, true, nil
+	}
+	start := q.pos
+	for q.pos < len(q.query) && !strings.ContainsRune(stop, rune(q.query[q.pos])) {
+		q.pos++
+	}
+	return strings.TrimSpace(q.query[start:q.pos]), false, nil
+}
+
+func (q *queryParser) parseSegment() (querySegment, error) {
+	name, quoted, err := q.parseName(".[]=")
+	if err != nil {
+		return querySegment{}, err
+	}
+	if name == "" && !quoted {
+		return querySegment{}, q.errorf("missing name at offset %d", q.pos)
+	}
+	seg := querySegment{name: name, wildcard: name == "*" && !quoted}
+	for q.pos < len(q.query) && q.query[q.pos] == '[' {
+		q.pos++
+		sel, err := q.parseSelector()
+		if err != nil {
+			return querySegment{}, err
+		}
+		seg.selectors = append(seg.selectors, sel)
+	}
+	return seg, nil
+}
+
+func (q *queryParser) parseSelector() (querySelector, error) {
+	key, quoted, err := q.parseName("[]=")
+	if err != nil {
+		return querySelector{}, err
+	}
+	sel := querySelector{key: key}
+	switch {
+	case quoted:
+	case key == "*":
+		sel.kind = selectWildcard
+	case key != "" && strings.Trim(key, "0123456789") == "":
+		if len(key) > 1 && key[0] == '0' {
+			return querySelector{}, q.errorf("leading zeros are not allowed in index %s", key)
+		}
+		if sel.index, err = strconv.Atoi(key); err != nil {
+			return querySelector{}, q.errorf("index %s is out of range", key)
+		}
+		sel.kind = selectIndex
+	case key == "":
+		return querySelector{}, q.errorf("empty selector at offset %d", q.pos)
+	}
+	if sel.kind == selectFilter && q.pos < len(q.query) && q.query[q.pos] == '=' {
+		q.pos++
+		if sel.value, _, err = q.parseName("[]"); err != nil {
+			return querySelector{}, err
+		}
+		sel.hasValue = true
+	}
+	if q.pos >= len(q.query) || q.query[q.pos] != ']' {
+		return querySelector{}, q.errorf("missing ']' at offset %d", q.pos)
+	}
+	q.pos++
+	return sel, nil
+}
+
+// querySegment represents a name within a query, along with any selectors in
+// square brackets which follow it.
+type querySegment struct {
+	name      string
+	selectors []querySelector
+	wildcard  bool
+}
+
+// querySelector represents an index, e.g. `[0]`, a wildcard, i.e. `[*]`, or a
+// filter on the keys of blocks, e.g. `[host=a.com]` or `[tls]`.
+type querySelector struct {
+	hasValue bool
+	index    int
+	key      string
+	kind     querySelectorKind
+	value    string
+}
+
+type querySelectorKind int
+
+// Query returns the nodes and list elements within the given nodes which match
+// the given path, e.g. `server.listeners[0].port`. Matches are in document
+// order, except that blocks with the same name are kept together.
+//
+// Paths are made up of key or block names separated by `.`, where names can
+// contain spaces, and can be quoted, e.g. `limits."max.conns"`, if they contain
+// any of `.`, `[`, `]`, `=`, or `"`. The name `*` matches any key or block.
+//
+// Each name can be followed by selectors in square brackets. An index, e.g.
+// `[0]`, selects a block from those with the same name, or an element from a
+// list, and `[*]` selects each of them. A filter, e.g. `[host=a.com]`, selects
+// the blocks which have a key with the given string value, while one without
+// a value, e.g. `[tls]`, selects the blocks which have a key or block with the
+// given name.
+//
+// The contents of versioned blocks, and the entries merged in by references,
+// are matched as if they were part of their parent block.
+func Query(nodes []Node, query string) ([]Match, error) {
+	segs, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return queryMatches(nodes, segs), nil
+}
+
+// parseQuery parses the given query into its segments.
+func parseQuery(query string) ([]querySegment, error) {
+	q := &queryParser{query: query}
+	var segs []querySegment
+	for {
+		seg, err := q.parseSegment()
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+		if q.pos >= len(q.query) {
+			return segs, nil
+		}
+		if q.query[q.pos] != '.' {
+			return nil, q.errorf("unexpected %q at offset %d", q.query[q.pos], q.pos)
+		}
+		q.pos++
+	}
+}
+
+// queryChildren returns the keys and blocks within the given match which have
+// the name of the given segment, with blocks of the same name grouped together.
+func queryChildren(m Match, seg querySegment) [][]Match {
+	block, ok := m.Node.(*Block)
+	if !ok || m.Value != nil {
+		return nil
+	}
+	var (
+		groups [][]Match
+		names  = map[string]int{}
+	)
+	members := queryMembers(block.Nodes)
+	counts := map[string]int{}
+	for _, node := range members {
+		if b, ok := node.(*Block); ok {
+			counts[b.Name]++
+		}
+	}
+	for _, node := range members {
+		switch node := node.(type) {
+		case *Block:
+			if !seg.wildcard && node.Name != seg.name {
+				continue
+			}
+			path := childPath(m.Path, node.Name)
+			i, ok := names[node.Name]
+			if !ok {
+				i = len(groups)
+				names[node.Name] = i
+				groups = append(groups, nil)
+			}
+			if counts[node.Name] > 1 {
+				path = indexPath(path, len(groups[i]))
+			}
+			groups[i] = append(groups[i], Match{End: node.End, Node: node, Path: path, Pos: node.Pos})
+		case *KeyValue:
+			if !seg.wildcard && node.Key != seg.name {
+				continue
+			}
+			groups = append(groups, []Match{{
+				End:   node.End,
+				Node:  node,
+				Path:  childPath(m.Path, node.Key),
+				Pos:   node.Pos,
+				Value: referencedValue(node.Value),
+			}})
+		}
+	}
+	return groups
+}
+
+// queryFilter returns whether the given block has a key with the value of the
+// given filter, or a key or block with its name if the filter has no value.
+func queryFilter(block *Block, sel querySelector) bool {
+	for _, node := range queryMembers(block.Nodes) {
+		switch node := node.(type) {
+		case *Block:
+			if !sel.hasValue && node.Name == sel.key {
+				return true
+			}
+		case *KeyValue:
+			if node.Key != sel.key {
+				continue
+			}
+			if !sel.hasValue {
+				return true
+			}
+			if s, ok := referencedValue(node.Value).(*String); ok && s.Value == sel.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryMatches returns the matches for the given segments within the given
+// nodes. Without any segments, the nodes themselves are matched as a block.
+func queryMatches(nodes []Node, segs []querySegment) []Match {
+	groups := [][]Match{{{Node: &Block{Nodes: nodes}}}}
+	for _, seg := range segs {
+		var next [][]Match
+		for _, group := range groups {
+			for _, m := range group {
+				next = append(next, queryChildren(m, seg)...)
+			}
+		}
+		for _, sel := range seg.selectors {
+			var selected [][]Match
+			for _, group := range next {
+				selected = append(selected, querySelect(group, sel)...)
+			}
+			next = selected
+		}
+		groups = next
+	}
+	matches := []Match{}
+	for _, group := range groups {
+		matches = append(matches, group...)
+	}
+	return matches
+}
+
+// queryMembers returns the key/value pairs and blocks within the given nodes,
+// with the contents of any versioned blocks and references merged in.
+func queryMembers(nodes []Node) []Node {
+	var members []Node
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *Block, *KeyValue:
+			members = append(members, node)
+		case *Reference:
+			members = append(members, queryMembers(node.Nodes)...)
+		case *VersionedBlock:
+			members = append(members, queryMembers(node.Block.Nodes)...)
+		}
+	}
+	return members
+}
+
+// querySelect applies the given selector to a group of matches, i.e. either
+// blocks with the same name, or a single match whose value may be a list, in
+// which case indexes select from its elements.
+func querySelect(group []Match, sel querySelector) [][]Match {
+	var items []Match
+	if group[0].Value == nil {
+		items = group
+	} else if list, ok := group[0].Value.(*List); ok {
+		for _, elem := range list.Content {
+			if _, ok := elem.(*Comment); ok {
+				continue
+			}
+			items = append(items, Match{
+				End:   valueEnd(elem),
+				Node:  group[0].Node,
+				Path:  indexPath(group[0].Path, len(items)),
+				Pos:   valuePos(elem),
+				Value: referencedValue(elem),
+			})
+		}
+	}
+	switch sel.kind {
+	case selectIndex:
+		if sel.index < len(items) {
+			return [][]Match{{items[sel.index]}}
+		}
+	case selectWildcard:
+		var groups [][]Match
+		for _, item := range items {
+			groups = append(groups, []Match{item})
+		}
+		return groups
+	default:
+		var filtered []Match
+		for _, m := range group {
+			if block, ok := m.Node.(*Block); ok && m.Value == nil && queryFilter(block, sel) {
+				filtered = append(filtered, m)
+			}
+		}
+		if len(filtered) > 0 {
+			return [][]Match{filtered}
+		}
+	}
+	return nil
+}
+
+// valueEnd returns the end position of the given value.
+func valueEnd(value Value) Position {
+	switch value := value.(type) {
+	case *Bytes:
+		return value.End
+	case *List:
+		return value.End
+	case *Reference:
+		return value.End
+	case *String:
+		return value.End
+	}
+	return Position{}
+}
+
+// valuePos returns the start position of the given value.
+func valuePos(value Value) Position {
+	switch value := value.(type) {
+	case *Bytes:
+		return value.Pos
+	case *List:
+		return value.Pos
+	case *Reference:
+		return value.Pos
+	case *String:
+		return value.Pos
+	}
+	return Position{}
+}
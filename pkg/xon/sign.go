@@ -0,0 +1,96 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"slices"
+)
+
+// signatureBlock is the name of the block holding the signature of a document.
+const signatureBlock = "signature"
+
+// ErrInvalidSignature is returned by Verify when the signature of a document
+// doesn't match its contents and the given key.
+var ErrInvalidSignature = errors.New("xon: invalid signature")
+
+// Sign signs the canonical form of the given nodes with the given Ed25519 key,
+// and returns them with a trailing signature block, e.g.
+//
+//	signature {
+//	    algorithm = ed25519
+//	    value = b64"..."
+//	}
+//
+// Any existing signature block is replaced. The given nodes aren't modified.
+func Sign(nodes []Node, key ed25519.PrivateKey) []Node {
+	nodes = slices.Clone(unsignedNodes(nodes))
+	sig := ed25519.Sign(key, Canonical(nodes))
+	return append(nodes, &Block{
+		Name: signatureBlock,
+		Nodes: []Node{
+			&KeyValue{Key: "algorithm", Value: &String{Value: "ed25519"}},
+			&KeyValue{Key: "value", Value: &Bytes{Data: sig}},
+		},
+	})
+}
+
+// Verify checks that the given nodes end with a signature block, as added by
+// Sign, which is valid for the canonical form of the nodes before it and the
+// given Ed25519 public key. It returns the nodes without the signature block,
+// so that they can be decoded as usual, or ErrInvalidSignature if the
+// signature doesn't match. Comments after the signature block are ignored.
+func Verify(nodes []Node, key ed25519.PublicKey) ([]Node, error) {
+	unsigned := unsignedNodes(nodes)
+	if len(unsigned) == len(nodes) {
+		return nil, errors.New("xon: document is not signed")
+	}
+	var algorithm, sig Value
+	for _, node := range flattenReferences(nodes[len(unsigned)].(*Block).Nodes) {
+		switch node := node.(type) {
+		case *Comment:
+		case *KeyValue:
+			switch node.Key {
+			case "algorithm":
+				algorithm = referencedValue(node.Value)
+			case "value":
+				sig = referencedValue(node.Value)
+			default:
+				return nil, decodeErrorf(childPath(signatureBlock, node.Key), "unknown key in the signature block")
+			}
+		default:
+			return nil, decodeErrorf(signatureBlock, "the signature block can only contain keys")
+		}
+	}
+	if s, ok := algorithm.(*String); !ok || s.Value != "ed25519" {
+		return nil, decodeErrorf(childPath(signatureBlock, "algorithm"), "unsupported signature algorithm, expected ed25519")
+	}
+	b, ok := sig.(*Bytes)
+	if !ok || len(b.Data) != ed25519.SignatureSize {
+		return nil, decodeErrorf(childPath(signatureBlock, "value"), "expected a bytes literal of %d bytes", ed25519.SignatureSize)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, Canonical(unsigned), b.Data) {
+		return nil, ErrInvalidSignature
+	}
+	return unsigned, nil
+}
+
+// unsignedNodes returns the given nodes without any trailing signature block,
+// and the comments that follow it.
+func unsignedNodes(nodes []Node) []Node {
+	for i := len(nodes) - 1; i >= 0; i-- {
+		switch node := nodes[i].(type) {
+		case *Block:
+			if node.Name == signatureBlock {
+				return nodes[:i]
+			}
+			return nodes
+		case *Comment:
+			continue
+		}
+		return nodes
+	}
+	return nodes
+}
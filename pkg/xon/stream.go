@@ -0,0 +1,197 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"io"
+)
+
+// Duplicate key policies.
+const (
+	DuplicateKeyError     DuplicateKeyPolicy = iota // fail, reporting the positions of both keys
+	DuplicateKeyFirstWins                           // keep the first value, ignoring later ones
+	DuplicateKeyLastWins                            // keep the last value, overriding earlier ones
+)
+
+// Decoder reads and decodes a XON document from an input stream.
+type Decoder struct {
+	done  bool
+	r     io.Reader
+	state decodeState
+}
+
+// Decode reads the XON document from the input, and stores the result in the
+// value pointed to by v, following the same rules as Unmarshal.
+//
+// As XON documents have no terminator, the input is read until EOF, and any
+// later calls return io.EOF. The input is parsed one top-level entry at a
+// time as it's read, so that errors are returned as soon as they're reached,
+// without waiting for the rest of the input.
+func (d *Decoder) Decode(v any) error {
+	if d.done {
+		return io.EOF
+	}
+	d.done = true
+	r := d.r
+	if limit := d.state.limits.MaxSize; limit > 0 {
+		// Read just past the limit, so that oversized input is still caught
+		// without reading all of it.
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	entries := NewEntryReader(r)
+	entries.duplicates = d.state.duplicates != DuplicateKeyError
+	entries.limits = d.state.limits
+	entries.loader = d.state.includeLoader()
+	var nodes []Node
+	for {
+		node, err := entries.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+	}
+	// Let keys defined by other entries override those merged in by top-level
+	// references, as when parsing the document as a whole.
+	mergeReferences(nodes)
+	return d.state.decodeNodes(nodes, v)
+}
+
+// DisallowUnknownFields makes keys without a matching struct field result in
+// an error, along with their position, instead of being ignored, so that typos
+// within configs are caught. Keys held by a map with the `inline` option are
+// still allowed.
+func (d *Decoder) DisallowUnknownFields() {
+	d.state.disallowUnknown = true
+}
+
+// RequireFields makes any struct fields with the `required` tag option, e.g.
+// `xon:"host,required"`, result in an error if their key is missing from the
+// block being decoded. Keys set to `nil` count as present. Fields within
+// blocks are only required when the block itself is present.
+func (d *Decoder) RequireFields() {
+	d.state.requireFields = true
+}
+
+// SetDuplicateKeyPolicy sets how keys that are defined more than once within a
+// block are handled. This covers keys that are repeated exactly, which are
+// otherwise rejected by the parser, as well as different keys that decode
+// into the same struct field or map key, e.g. `port` and `Port`, or `1` and
+// `0x1` for integer keys. Blocks with the same name are decoded together, and
+// are only duplicates of keys or blocks with other names. The default is
+// DuplicateKeyError.
+func (d *Decoder) SetDuplicateKeyPolicy(policy DuplicateKeyPolicy) {
+	d.state.duplicates = policy
+}
+
+// SetEnv enables the interpolation of environment variables within values,
+// with `${NAME}` replaced by the value of the variable from lookup, which
+// would normally be os.LookupEnv, and `${NAME:-default}` falling back to the
+// default if the variable is unset or empty. Unset variables without a default
+// result in an error. A literal `${` can be written as `$${`, and raw strings
+// are never interpolated. Keys and block names are left as is.
+func (d *Decoder) SetEnv(lookup func(name string) (string, bool)) {
+	d.state.lookupEnv = lookup
+}
+
+// SetLimits sets the limits on the input, as described by ParseLimited, so
+// that documents from untrusted sources can be decoded safely. Input beyond
+// the size limit isn't read.
+func (d *Decoder) SetLimits(limits Limits) {
+	d.state.limits = limits
+}
+
+// SetLoader sets the loader used to resolve include directives, as described
+// by Load. Paths are resolved relative to the root of the loader, as the input
+// has no path of its own. Without a loader, include directives result in an
+// error.
+func (d *Decoder) SetLoader(loader Loader) {
+	d.state.loader = loader
+}
+
+// SetVersion sets the version to decode for. The contents of versioned blocks
+// for later versions are skipped, and keys without a matching struct field
+// result in an error instead of being ignored, so that typos are caught while
+// still allowing for config values that only newer versions understand.
+func (d *Decoder) SetVersion(version int64) {
+	d.state.version = version
+	d.state.versioned = true
+}
+
+// DuplicateKeyPolicy determines how a Decoder handles duplicate keys.
+type DuplicateKeyPolicy int
+
+// Encoder writes XON documents to an output stream.
+type Encoder struct {
+	printer printer // the formatting options
+	state   encodeState
+	w       io.Writer
+}
+
+// Encode writes the XON encoding of v to the output, following the same rules
+// as Marshal. Each call writes a complete document, so a stream would normally
+// only be given a single value.
+func (e *Encoder) Encode(v any) error {
+	return encode(e.w, v, e.state, e.printer)
+}
+
+// SetAlignValues sets whether the keys of adjacent key/value pairs are padded,
+// so that their values line up, e.g. for human-edited configs. Pairs are
+// adjacent if there's nothing other than comments between them.
+func (e *Encoder) SetAlignValues(align bool) {
+	e.printer.alignValues = align
+}
+
+// SetExpandLists sets whether lists are always printed with each element on
+// its own line, e.g. to keep diffs of golden files small. By default, lists
+// are printed on a single line, unless they contain comments or multiline
+// strings.
+func (e *Encoder) SetExpandLists(expand bool) {
+	e.printer.expandLists = expand
+}
+
+// SetIndent sets the prefix and indent used for each line, as described by
+// MarshalIndent. The default is no prefix, and an indent of 4 spaces.
+func (e *Encoder) SetIndent(prefix string, indent string) {
+	e.printer.indent = indent
+	e.printer.prefix = prefix
+}
+
+// SetMaxDepth sets the maximum nesting of blocks and lists, as with
+// Limits.MaxDepth, beyond which encoding fails with an error, e.g. to bound
+// the output for deeply nested values. Zero, the default, means no limit.
+// Values that refer back to themselves are always rejected.
+func (e *Encoder) SetMaxDepth(depth int) {
+	e.state.maxDepth = depth
+}
+
+// SetMaxWidth sets the maximum width of lines, in characters. Lists which would
+// take a line beyond the width are printed with each element on its own line,
+// while other lines are left as they are, as they can't be split. The default
+// of 0 means no limit.
+func (e *Encoder) SetMaxWidth(width int) {
+	e.printer.maxWidth = width
+}
+
+// SetSortKeys sets whether keys and blocks are sorted by name within each
+// block, instead of being kept in their original order, i.e. the order of
+// struct fields, or of the nodes when encoding a []Node. Comments move along
+// with the node that follows them. Versioned blocks, include directives,
+// references, and nodes which define anchors stay in place, with the nodes
+// between them sorted separately. Map keys are always sorted.
+func (e *Encoder) SetSortKeys(sort bool) {
+	e.printer.sortKeys = sort
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{printer: printer{indent: "    "}, w: w}
+}
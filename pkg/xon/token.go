@@ -0,0 +1,126 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"io"
+	"strconv"
+)
+
+// Token kinds.
+const (
+	TokenAnchor       TokenKind = iota // an anchor marker, e.g. `[&defaults]`
+	TokenAppend                        // `+=` between a key and a list to append
+	TokenBlockName                     // a block name, e.g. `server`
+	TokenBytes                         // a bytes literal, e.g. `b64"aGVsbG8="`
+	TokenCloseBrace                    // `}`
+	TokenCloseBracket                  // `]`
+	TokenComma                         // `,` between list elements
+	TokenComment                       // a line or inline comment
+	TokenEquals                        // `=` between a key and its value
+	TokenInclude                       // the `include` keyword of an include directive
+	TokenKey                           // the key of a key/value pair
+	TokenOpenBrace                     // `{`
+	TokenOpenBracket                   // `[`
+	TokenReference                     // a reference to an anchor, e.g. `[*defaults]`
+	TokenSpec                          // the `xon` keyword of a spec line
+	TokenString                        // a quoted, unquoted, or multiline string value
+	TokenVersion                       // a versioned block marker, e.g. `[v5]`
+)
+
+var tokenKinds = [...]string{
+	TokenAnchor:       "anchor",
+	TokenAppend:       "'+='",
+	TokenBlockName:    "block name",
+	TokenBytes:        "bytes",
+	TokenCloseBrace:   "'}'",
+	TokenCloseBracket: "']'",
+	TokenComma:        "','",
+	TokenComment:      "comment",
+	TokenEquals:       "'='",
+	TokenInclude:      "include",
+	TokenKey:          "key",
+	TokenOpenBrace:    "'{'",
+	TokenOpenBracket:  "'['",
+	TokenReference:    "reference",
+	TokenSpec:         "xon",
+	TokenString:       "string",
+	TokenVersion:      "version",
+}
+
+// Position represents a location within the source. Line and Column are
+// 1-indexed, and Column is measured in bytes. Offset is the 0-indexed byte
+// offset within the original source, including any carriage returns. For
+// documents loaded with a Loader, File is the path of the file containing the
+// position, so that nodes from included files can be told apart.
+type Position struct {
+	Column int
+	File   string
+	Line   int
+	Offset int
+}
+
+func (p Position) String() string {
+	pos := strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	if p.File != "" {
+		return p.File + ":" + pos
+	}
+	return pos
+}
+
+// Token represents a lexical token within a XON document, spanning from Pos
+// up to End. The Text is the source text of the token, with any CRLF line
+// endings normalized to LF. For block names, keys, strings, and comments, the
+// Value holds the decoded text, i.e. without any quotes or byte escapes, and
+// with the indentation of multiline strings stripped. For versioned block
+// markers, it holds the version number.
+type Token struct {
+	End   Position
+	Kind  TokenKind
+	Pos   Position
+	Text  string
+	Value string
+}
+
+// TokenKind represents the kind of a token.
+type TokenKind int
+
+func (k TokenKind) String() string {
+	if k >= 0 && int(k) < len(tokenKinds) {
+		return tokenKinds[k]
+	}
+	return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Tokenizer yields the tokens within a XON document in source order. It is
+// driven by the same parser as Parse, so that only valid documents produce a
+// complete token stream. If the document is invalid, the tokens before the
+// error are yielded first, followed by the parse error.
+type Tokenizer struct {
+	err    error
+	tokens []Token
+}
+
+// Token returns the next token. At the end of the document, it returns io.EOF,
+// or the *Error for an invalid document.
+func (t *Tokenizer) Token() (Token, error) {
+	if len(t.tokens) == 0 {
+		return Token{}, t.err
+	}
+	tok := t.tokens[0]
+	t.tokens = t.tokens[1:]
+	return tok, nil
+}
+
+// NewTokenizer returns a tokenizer for the given source.
+func NewTokenizer(src []byte) *Tokenizer {
+	p := newParser(src)
+	p.tokenize = true
+	t := &Tokenizer{err: io.EOF}
+	if _, err := p.parse(); err != nil {
+		t.err = err
+	}
+	t.tokens = p.tokens
+	return t
+}
@@ -0,0 +1,906 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bytes"
+	"math"
+	"unicode/utf8"
+)
+
+// validator checks the syntax of a document by following the same grammar as
+// the parser, but without building any nodes or copying the source, so that
+// documents can be checked without allocating. As the source isn't normalized,
+// a '\r' is treated as the start of a newline, which is fine, as the encoding
+// check rejects any that aren't followed by a '\n'.
+type validator struct {
+	err     int // the offset of the first error
+	pos     int
+	spec    int // the minor version of the spec declared by the document, if any
+	src     []byte
+	started bool // whether any entries have been checked
+}
+
+// anchorName returns the length of the anchor or reference marker at the
+// current position, e.g. `[&name]` for the '&' sigil, or `[*name]` for '*',
+// or -1 if there isn't a valid one.
+func (v *validator) anchorName(sigil byte) int {
+	if len(v.src)-v.pos < 2 || v.src[v.pos] != '[' || v.src[v.pos+1] != sigil {
+		return -1
+	}
+	i := v.pos + 2
+	for i < len(v.src) && isAnchorChar(v.src[i]) {
+		i++
+	}
+	if i == v.pos+2 || i >= len(v.src) || v.src[i] != ']' {
+		return -1
+	}
+	return i + 1 - v.pos
+}
+
+// checkAnchor checks an anchor marker like `[&name]`, and the key/value pair
+// or block that follows it.
+func (v *validator) checkAnchor() bool {
+	n := v.anchorName('&')
+	if n == -1 {
+		return v.fail(v.pos)
+	}
+	v.pos += n
+	if !v.eof() && !isNewline(v.src[v.pos]) && !isSpace(v.src[v.pos]) {
+		return v.fail(v.pos)
+	}
+	v.pos = skipSpace(v.src, v.pos)
+	if v.eof() || isNewline(v.src[v.pos]) || v.src[v.pos] == '[' || v.hasPrefix("//") {
+		return v.fail(v.pos)
+	}
+	return v.checkEntry()
+}
+
+func (v *validator) checkBlock() bool {
+	v.pos++
+	if !v.eof() && v.src[v.pos] == '}' {
+		v.pos++
+		return v.checkLineEnd()
+	}
+	v.pos = skipSpace(v.src, v.pos)
+	switch {
+	case v.eof():
+	case isNewline(v.src[v.pos]):
+		v.pos = v.skipNewline(v.pos)
+	case v.hasPrefix("//"):
+		v.skipLine()
+	default:
+		return v.fail(v.pos)
+	}
+	if !v.checkNodes(true) {
+		return false
+	}
+	return v.checkLineEnd()
+}
+
+// checkBytes checks a binary data literal like `b64"aGVsbG8="` or
+// `hex"68656c6c6f"`, including the validity of its data.
+func (v *validator) checkBytes() bool {
+	start := v.pos
+	end := v.lineEnd(start)
+	idx := bytes.IndexByte(v.src[start+4:end], '"')
+	if idx == -1 {
+		return v.fail(end)
+	}
+	data := v.src[start+4 : start+4+idx]
+	if v.hasPrefix("hex") {
+		if !validHex(data) {
+			return v.fail(start + 4)
+		}
+	} else if !validBase64(data) {
+		return v.fail(start + 4)
+	}
+	v.pos = start + 5 + idx
+	return true
+}
+
+// checkEncoding checks that the source is valid UTF-8, and that carriage
+// returns are only used within CRLF line endings.
+func (v *validator) checkEncoding() bool {
+	for i := 0; i < len(v.src); {
+		c := v.src[i]
+		if c == '\r' && (i+1 == len(v.src) || v.src[i+1] != '\n') {
+			return v.fail(i)
+		}
+		if c < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(v.src[i:])
+		if r == utf8.RuneError && size == 1 {
+			return v.fail(i)
+		}
+		i += size
+	}
+	return true
+}
+
+func (v *validator) checkEntry() bool {
+	if hashes := v.rawHashes(); v.src[v.pos] == '"' || hashes >= 0 {
+		if hashes >= 0 {
+			if !v.checkRaw(hashes) {
+				return false
+			}
+		} else if !v.checkQuoted() {
+			return false
+		}
+		if v.eof() || !isSpace(v.src[v.pos]) {
+			return v.fail(v.pos)
+		}
+		v.pos = skipSpace(v.src, v.pos)
+	} else if !v.checkIdentifier() {
+		return false
+	}
+	if v.eof() {
+		return v.fail(v.pos)
+	}
+	switch {
+	case v.src[v.pos] == '=' || (v.spec >= 2 && v.hasPrefix("+=")):
+		appends := v.src[v.pos] == '+'
+		if appends {
+			v.pos += 2
+		} else {
+			v.pos++
+		}
+		if v.eof() || isNewline(v.src[v.pos]) {
+			return v.fail(v.pos)
+		}
+		if !isSpace(v.src[v.pos]) {
+			return v.fail(v.pos)
+		}
+		eq := v.pos
+		v.pos = skipSpace(v.src, v.pos)
+		if v.eof() || isNewline(v.src[v.pos]) {
+			return v.fail(eq)
+		}
+		start := v.pos
+		if !v.checkValue() {
+			return false
+		}
+		// Values appended with `+=` must be lists, or references, which are
+		// only checked when parsing.
+		if appends && v.src[start] != '[' {
+			return v.fail(start)
+		}
+		return v.checkLineEnd()
+	case v.src[v.pos] == '{':
+		return v.checkBlock()
+	}
+	return v.fail(v.pos)
+}
+
+// checkEscapes checks any `<|0xNN|>` byte escapes within the source between
+// the given offsets. Errors are reported at the given offset.
+func (v *validator) checkEscapes(start int, end int, offset int) bool {
+	s := v.src[start:end]
+	for {
+		idx := bytes.Index(s, []byte("<|0x"))
+		if idx == -1 {
+			return true
+		}
+		rest := s[idx+4:]
+		if len(rest) < 4 || unhex(rest[0]) < 0 || unhex(rest[1]) < 0 || rest[2] != '|' || rest[3] != '>' {
+			return v.fail(offset)
+		}
+		s = rest[4:]
+	}
+}
+
+// checkHeredoc checks a heredoc string, where start is the offset just after
+// the opening backticks, and marker is the length of the `|` or `|-` marker
+// line which follows them.
+func (v *validator) checkHeredoc(start int, marker int, n int) bool {
+	var base, closing int
+	i := start + marker
+	for {
+		if i >= len(v.src) {
+			return v.fail(len(v.src))
+		}
+		indent := skipSpace(v.src, i)
+		run := 0
+		for indent+run < len(v.src) && v.src[indent+run] == '`' {
+			run++
+		}
+		if run == n {
+			base = indentWidthAt(v.src, i)
+			closing = i
+			v.pos = indent + n
+			break
+		}
+		i = v.nextLine(i)
+	}
+	for i := start + marker; i < closing; i = v.nextLine(i) {
+		if indentWidthAt(v.src, i) < base && !v.isBlankLine(i) {
+			return v.fail(i)
+		}
+	}
+	return v.checkEscapes(start+marker, closing, v.pos)
+}
+
+// checkIdentifier checks an unquoted block name or key, leaving the validator
+// at the `=` or `{` that follows it.
+func (v *validator) checkIdentifier() bool {
+	start := v.pos
+	i := v.pos
+	for i < len(v.src) && !isNewline(v.src[i]) {
+		if !isSpace(v.src[i]) {
+			i++
+			continue
+		}
+		j := skipSpace(v.src, i)
+		if j < len(v.src) && (v.src[j] == '=' || v.src[j] == '{' || (v.spec >= 2 && v.hasPrefixAt(j, "+="))) {
+			if !v.checkEscapes(start, i, i) {
+				return false
+			}
+			v.pos = j
+			return true
+		}
+		if v.hasPrefixAt(j, "//") {
+			break
+		}
+		i = j
+	}
+	// Errors are reported at the start of the next line, like the parser.
+	if i < len(v.src) {
+		i = v.nextLine(i)
+	}
+	return v.fail(i)
+}
+
+// checkInclude checks an include directive. It returns false for found if the
+// entry isn't an include directive, e.g. for a key like `include "x" = y`, in
+// which case nothing is consumed.
+func (v *validator) checkInclude() (ok bool, found bool) {
+	if !v.hasPrefix("include") {
+		return false, false
+	}
+	start := v.pos
+	v.pos += len("include")
+	if v.eof() || !isSpace(v.src[v.pos]) {
+		v.pos = start
+		return false, false
+	}
+	v.pos = skipSpace(v.src, v.pos)
+	if hashes := v.rawHashes(); hashes >= 0 {
+		ok = v.checkRaw(hashes)
+	} else if !v.eof() && v.src[v.pos] == '"' {
+		ok = v.checkQuoted()
+	} else {
+		v.pos = start
+		return false, false
+	}
+	if ok {
+		v.pos = skipSpace(v.src, v.pos)
+	}
+	if !ok || !(v.eof() || isNewline(v.src[v.pos]) || v.hasPrefix("//")) {
+		v.err, v.pos = -1, start
+		return false, false
+	}
+	return v.checkLineEnd(), true
+}
+
+// checkLineEnd checks the remainder of a line, which may only contain
+// whitespace and an optional inline comment.
+func (v *validator) checkLineEnd() bool {
+	v.pos = skipSpace(v.src, v.pos)
+	switch {
+	case v.eof():
+	case isNewline(v.src[v.pos]):
+		v.pos = v.skipNewline(v.pos)
+	case v.hasPrefix("//"):
+		v.skipLine()
+	default:
+		return v.fail(v.pos)
+	}
+	return true
+}
+
+func (v *validator) checkList() bool {
+	v.pos++
+	v.pos = skipSpace(v.src, v.pos)
+	if v.hasPrefix("//") {
+		v.skipLine()
+	}
+	state := listState{}
+	for {
+		v.pos = skipSpace(v.src, v.pos)
+		if v.eof() {
+			return v.fail(v.pos)
+		}
+		done, ok := v.checkListItem(&state)
+		if !ok {
+			return false
+		}
+		if done {
+			return true
+		}
+	}
+}
+
+// checkListElement checks an unquoted list element, and returns the offset of
+// its end, excluding any trailing whitespace.
+func (v *validator) checkListElement() int {
+	start := v.pos
+	i := v.pos
+loop:
+	for i < len(v.src) {
+		switch v.src[i] {
+		case '\n', '\r':
+			break loop
+		case ' ', '\t':
+			if v.hasPrefixAt(skipSpace(v.src, i), "//") {
+				break loop
+			}
+		case ',':
+			if (i > start && isSpace(v.src[i-1])) || isLineBoundary(v.src, i+1) || v.src[i+1] == ',' {
+				break loop
+			}
+		case ']':
+			if isLineBoundary(v.src, i+1) {
+				break loop
+			}
+		}
+		i++
+	}
+	v.pos = i
+	for i > start && isSpace(v.src[i-1]) {
+		i--
+	}
+	return i
+}
+
+// checkListItem checks the next item within a list, like the parser's
+// parseListItem, and returns true for done at the closing bracket.
+func (v *validator) checkListItem(state *listState) (done bool, ok bool) {
+	c := v.src[v.pos]
+	switch {
+	case isNewline(c) || v.hasPrefix("//"):
+		if isNewline(c) {
+			v.pos = v.skipNewline(v.pos)
+		} else {
+			v.skipLine()
+		}
+		state.afterElem = false
+		state.lineComma = false
+		state.lineSpaced = false
+		return false, true
+	case c == ']':
+		v.pos++
+		return true, true
+	case c == ',', c == '{', state.afterElem:
+		return false, v.fail(v.pos)
+	}
+	start := v.pos
+	var (
+		spaced  bool
+		unquote bool
+	)
+	switch hashes := v.rawHashes(); {
+	case v.isReference(true):
+		ok = v.checkReference()
+	case c == '[':
+		ok = v.checkList()
+	case v.hasPrefix(`b64"`) || v.hasPrefix(`hex"`):
+		ok = v.checkBytes()
+	case c == '"':
+		ok = v.checkQuoted()
+	case hashes >= 0:
+		ok = v.checkRaw(hashes)
+	case c == '`':
+		ok = v.checkMultiline()
+	default:
+		end := v.checkListElement()
+		ok = v.checkEscapes(start, end, v.pos)
+		unquote = true
+		spaced = bytes.ContainsAny(v.src[start:end], " \t")
+	}
+	if !ok {
+		return false, false
+	}
+	state.afterElem = true
+	state.elems++
+	state.lastComma = false
+	if spaced {
+		state.lineSpaced = true
+	}
+	if v.eof() || v.src[v.pos] != ',' {
+		if state.lineComma && spaced {
+			return false, v.fail(start)
+		}
+		return false, true
+	}
+	v.pos++
+	if state.lineSpaced || (unquote && bytes.ContainsAny(v.src[start:v.pos-1], " \t")) {
+		return false, v.fail(v.pos)
+	}
+	state.afterElem = false
+	state.lastComma = true
+	state.lineComma = true
+	if v.eof() {
+		return false, true
+	}
+	switch v.src[v.pos] {
+	case ' ', '\t', '\n', '\r', ']':
+		return false, true
+	}
+	return false, v.fail(v.pos)
+}
+
+// checkMultiline checks a multiline string, including that none of its lines
+// have less indentation than the first.
+func (v *validator) checkMultiline() bool {
+	n := 0
+	for v.pos+n < len(v.src) && v.src[v.pos+n] == '`' {
+		n++
+	}
+	if n%2 == 0 {
+		v.pos += n
+		return true
+	}
+	start := v.pos + n
+	if marker := heredocMarkerAt(v.src, start); marker > 0 && v.spec >= 2 {
+		return v.checkHeredoc(start, marker, n)
+	}
+	end := -1
+	for i := start; i < len(v.src); {
+		if v.src[i] != '`' {
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(v.src) && v.src[i+run] == '`' {
+			run++
+		}
+		if run == n {
+			end = i
+			break
+		}
+		i += run
+	}
+	if end == -1 {
+		return v.fail(len(v.src))
+	}
+	v.pos = end + n
+	// The first non-blank line sets the base indentation, with any text on
+	// the opening line counting as unindented.
+	base := -1
+	for i := start; i < end; i = v.nextLine(i) {
+		if skipSpace(v.src, i) >= min(v.lineEnd(i), end) {
+			continue
+		}
+		width := indentWidthAt(v.src, i)
+		switch {
+		case base == -1 && i == start:
+			base = 0
+		case base == -1:
+			base = width
+		case width < base:
+			return v.fail(v.pos)
+		}
+	}
+	return v.checkEscapes(start, end, v.pos)
+}
+
+func (v *validator) checkNodes(inBlock bool) bool {
+	for {
+		v.pos = skipSpace(v.src, v.pos)
+		if v.eof() {
+			if inBlock {
+				return v.fail(v.pos)
+			}
+			return true
+		}
+		c := v.src[v.pos]
+		switch {
+		case isNewline(c):
+			v.pos = v.skipNewline(v.pos)
+		case v.hasPrefix("//"):
+			v.skipLine()
+		case c == '}':
+			if !inBlock {
+				return v.fail(v.pos)
+			}
+			v.pos++
+			return true
+		case c == '{', c == '`':
+			return v.fail(v.pos)
+		case c == '[' && !v.hasPrefix("[v") && !(v.spec >= 2 && (v.hasPrefix("[&") || v.hasPrefix("[*"))):
+			return v.fail(v.pos)
+		default:
+			if !inBlock {
+				if ok, found := v.checkSpec(); found {
+					if !ok {
+						return false
+					}
+					continue
+				}
+				v.started = true
+			}
+			if ok, found := v.checkInclude(); found {
+				if !ok {
+					return false
+				}
+				continue
+			}
+			var ok bool
+			switch {
+			case v.hasPrefix("[&"):
+				ok = v.checkAnchor()
+			case v.hasPrefix("[*"):
+				ok = v.checkReference() && v.checkLineEnd()
+			case c == '[':
+				ok = v.checkVersionedBlock()
+			default:
+				ok = v.checkEntry()
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+}
+
+func (v *validator) checkQuoted() bool {
+	start := v.pos + 1
+	i := start
+	for i < len(v.src) && v.src[i] != '"' && !isNewline(v.src[i]) {
+		i++
+	}
+	if i >= len(v.src) || isNewline(v.src[i]) {
+		return v.fail(i)
+	}
+	v.pos = i + 1
+	return v.checkEscapes(start, i, i)
+}
+
+// checkRaw checks a raw string, with the given number of '#' characters around
+// its quotes.
+func (v *validator) checkRaw(hashes int) bool {
+	start := v.pos + hashes + 2
+	for i := start; i < len(v.src) && !isNewline(v.src[i]); i++ {
+		if v.src[i] != '"' {
+			continue
+		}
+		j := i + 1
+		for j < len(v.src) && j-i-1 < hashes && v.src[j] == '#' {
+			j++
+		}
+		if j-i-1 == hashes {
+			v.pos = j
+			return true
+		}
+	}
+	return v.fail(v.lineEnd(v.pos))
+}
+
+// checkReference checks a reference marker like `[*name]`. Whether the anchor
+// exists, and what it refers to, is only checked when parsing.
+func (v *validator) checkReference() bool {
+	n := v.anchorName('*')
+	if n == -1 {
+		return v.fail(v.pos)
+	}
+	v.pos += n
+	return true
+}
+
+// checkSpec checks an `xon <version>` line at the top level. It returns false
+// for found if the entry isn't a spec line, e.g. for a key like `xon = 1`, in
+// which case nothing is consumed.
+func (v *validator) checkSpec() (ok bool, found bool) {
+	if !v.hasPrefix("xon") {
+		return false, false
+	}
+	start := v.pos
+	i := start + len("xon")
+	if i >= len(v.src) || !isSpace(v.src[i]) {
+		return false, false
+	}
+	i = skipSpace(v.src, i)
+	versionStart := i
+	for i < len(v.src) && !isNewline(v.src[i]) && !isSpace(v.src[i]) {
+		i++
+	}
+	end := skipSpace(v.src, i)
+	if i == versionStart || !(v.src[versionStart] >= '0' && v.src[versionStart] <= '9') || !(end >= len(v.src) || isNewline(v.src[end]) || v.hasPrefixAt(end, "//")) {
+		return false, false
+	}
+	if v.spec != 0 || v.started {
+		return v.fail(start), true
+	}
+	minor, ok := specVersions[string(v.src[versionStart:i])]
+	if !ok {
+		return v.fail(versionStart), true
+	}
+	v.pos = i
+	v.spec = minor
+	return v.checkLineEnd(), true
+}
+
+func (v *validator) checkUnquoted() bool {
+	start := v.pos
+	i := v.pos
+	for i < len(v.src) && !isNewline(v.src[i]) {
+		if !isSpace(v.src[i]) {
+			i++
+			continue
+		}
+		j := skipSpace(v.src, i)
+		if j >= len(v.src) || isNewline(v.src[j]) || v.hasPrefixAt(j, "//") {
+			break
+		}
+		switch v.src[j] {
+		case '=':
+			return v.fail(j + 1)
+		case '{', '}', '[', ']':
+			return v.fail(j)
+		}
+		i = j
+	}
+	v.pos = i
+	return v.checkEscapes(start, i, i)
+}
+
+func (v *validator) checkValue() bool {
+	switch hashes := v.rawHashes(); {
+	case v.isReference(false):
+		return v.checkReference()
+	case v.src[v.pos] == '[':
+		return v.checkList()
+	case v.hasPrefix(`b64"`) || v.hasPrefix(`hex"`):
+		return v.checkBytes()
+	case v.src[v.pos] == '"':
+		return v.checkQuoted()
+	case hashes >= 0:
+		return v.checkRaw(hashes)
+	case v.src[v.pos] == '`':
+		return v.checkMultiline()
+	}
+	return v.checkUnquoted()
+}
+
+func (v *validator) checkVersionedBlock() bool {
+	i := v.pos + 2
+	digits := i
+	overflow := false
+	version := int64(0)
+	for i < len(v.src) && v.src[i] >= '0' && v.src[i] <= '9' {
+		digit := int64(v.src[i] - '0')
+		if version > (math.MaxInt64-digit)/10 {
+			overflow = true
+		}
+		version = version*10 + digit
+		i++
+	}
+	if i == digits || i >= len(v.src) || v.src[i] != ']' || overflow {
+		return v.fail(i)
+	}
+	v.pos = skipSpace(v.src, i+1)
+	if v.eof() || v.src[v.pos] != '{' || v.pos == i+1 {
+		return v.fail(v.pos)
+	}
+	return v.checkBlock()
+}
+
+func (v *validator) eof() bool {
+	return v.pos >= len(v.src)
+}
+
+// fail records an error at the given offset, and returns false so that the
+// failure can be passed up directly.
+func (v *validator) fail(offset int) bool {
+	v.err = min(offset, len(v.src))
+	return false
+}
+
+func (v *validator) hasPrefix(prefix string) bool {
+	return v.hasPrefixAt(v.pos, prefix)
+}
+
+func (v *validator) hasPrefixAt(i int, prefix string) bool {
+	return len(v.src)-i >= len(prefix) && string(v.src[i:i+len(prefix)]) == prefix
+}
+
+// isBlankLine returns whether the line starting at i only contains whitespace.
+func (v *validator) isBlankLine(i int) bool {
+	i = skipSpace(v.src, i)
+	return i >= len(v.src) || isNewline(v.src[i])
+}
+
+// isReference returns whether a reference like `[*name]`, as opposed to a list
+// like `[*.go]`, starts the value at the current position, or the list element
+// if inList is set. References are only supported from spec version 0.2.
+func (v *validator) isReference(inList bool) bool {
+	if v.spec < 2 {
+		return false
+	}
+	n := v.anchorName('*')
+	if n == -1 {
+		return false
+	}
+	if inList {
+		return isLineBoundary(v.src, v.pos+n)
+	}
+	return v.pos+n >= len(v.src) || isSpace(v.src[v.pos+n]) || isNewline(v.src[v.pos+n])
+}
+
+// lineEnd returns the offset of the end of the line containing i, excluding
+// the newline.
+func (v *validator) lineEnd(i int) int {
+	for i < len(v.src) && !isNewline(v.src[i]) {
+		i++
+	}
+	return i
+}
+
+// nextLine returns the offset of the start of the line after the one
+// containing i, or the length of the source if it's the last line.
+func (v *validator) nextLine(i int) int {
+	i = v.lineEnd(i)
+	if i < len(v.src) {
+		return v.skipNewline(i)
+	}
+	return i
+}
+
+// position returns the position of the given offset, with the Line and Column
+// computed as if any CRLF line endings had been normalized, like the parser.
+func (v *validator) position(offset int) Position {
+	line, start := 1, 0
+	for i := range offset {
+		if v.src[i] == '\n' {
+			line++
+			start = i + 1
+		}
+	}
+	return Position{Column: offset - start + 1, Line: line, Offset: offset}
+}
+
+// rawHashes returns the number of '#' characters in the opening delimiter of
+// the raw string at the current position, e.g. 1 for `r#"`, or -1 if there
+// isn't one.
+func (v *validator) rawHashes() int {
+	if !v.hasPrefix("r") {
+		return -1
+	}
+	i := v.pos + 1
+	for i < len(v.src) && v.src[i] == '#' {
+		i++
+	}
+	if i < len(v.src) && v.src[i] == '"' {
+		return i - v.pos - 1
+	}
+	return -1
+}
+
+func (v *validator) skipLine() {
+	v.pos = v.nextLine(v.pos)
+}
+
+// skipNewline returns the offset just after the newline at i, treating a CRLF
+// line ending as a single newline.
+func (v *validator) skipNewline(i int) int {
+	if v.src[i] == '\r' {
+		i++
+	}
+	return i + 1
+}
+
+func (v *validator) valid() bool {
+	v.err = -1
+	return v.checkEncoding() && v.checkNodes(false)
+}
+
+// ValidSyntax reports whether src is a syntactically valid XON document. It
+// checks the source in a single pass without allocating, so that documents
+// with syntax errors can be rejected cheaply, e.g. on hot paths which don't
+// need the parsed nodes.
+//
+// It's a lexical check, so documents that it accepts may still be rejected by
+// Parse. Checks which depend on the rest of the document, i.e. duplicate keys
+// and anchors, references to unknown anchors, to blocks as values, or from
+// within their own definitions, and mixed version numbers, are left to the
+// parser, as are the limits of ParseLimited. Include directives are checked,
+// but not loaded.
+func ValidSyntax(src []byte) bool {
+	v := validator{src: src}
+	return v.valid()
+}
+
+// ValidSyntaxPos reports whether src is syntactically valid like ValidSyntax,
+// and if it isn't, also returns the position of the first syntax error. The
+// position is usually the same as that of the error returned by Parse, but may
+// differ for some errors, as the validator stops at the first problem it finds.
+func ValidSyntaxPos(src []byte) (Position, bool) {
+	v := validator{src: src}
+	if v.valid() {
+		return Position{}, true
+	}
+	return v.position(v.err), false
+}
+
+// heredocMarkerAt returns the length of the `|` or `|-` marker line at offset i
+// within src, including its newline, or 0 if there isn't one.
+func heredocMarkerAt(src []byte, i int) int {
+	start := i
+	if i >= len(src) || src[i] != '|' {
+		return 0
+	}
+	i++
+	if i < len(src) && src[i] == '-' {
+		i++
+	}
+	i = skipSpace(src, i)
+	if i < len(src) && src[i] == '\r' {
+		i++
+	}
+	if i < len(src) && src[i] == '\n' {
+		return i + 1 - start
+	}
+	return 0
+}
+
+// indentWidthAt returns the width of the indentation at offset i within src,
+// like indentWidth.
+func indentWidthAt(src []byte, i int) int {
+	width := 0
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// isLineBoundary is like isListBoundary, but also treats a '\r' as the end of
+// an element, as the validator doesn't normalize CRLF line endings.
+func isLineBoundary(src []byte, i int) bool {
+	return isListBoundary(src, i) || src[i] == '\r'
+}
+
+func isNewline(c byte) bool {
+	return c == '\n' || c == '\r'
+}
+
+// validBase64 returns whether data is valid padded base64, as accepted by
+// base64.StdEncoding.
+func validBase64(data []byte) bool {
+	if len(data)%4 != 0 {
+		return false
+	}
+	pad := 0
+	for pad < 2 && pad < len(data) && data[len(data)-1-pad] == '=' {
+		pad++
+	}
+	for _, c := range data[:len(data)-pad] {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '+', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validHex(data []byte) bool {
+	if len(data)%2 != 0 {
+		return false
+	}
+	for _, c := range data {
+		if unhex(c) < 0 {
+			return false
+		}
+	}
+	return true
+}
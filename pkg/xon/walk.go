@@ -0,0 +1,173 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"slices"
+)
+
+// Cursor describes a node being visited by Rewrite, and lets it be replaced,
+// deleted, or have other nodes inserted around it.
+type Cursor struct {
+	iter   *rewriteIter
+	node   Node
+	nodes  *[]Node // the nodes holding the current node
+	parent Node
+	path   string
+}
+
+// Delete removes the current node. Its children and post hook aren't visited.
+func (c *Cursor) Delete() {
+	*c.nodes = slices.Delete(*c.nodes, c.iter.index, c.iter.index+1)
+	c.iter.step--
+	c.node = nil
+}
+
+// Index returns the index of the current node within the nodes of its parent,
+// or the top-level nodes.
+func (c *Cursor) Index() int {
+	return c.iter.index
+}
+
+// InsertAfter inserts the given node after the current one. It isn't visited.
+func (c *Cursor) InsertAfter(node Node) {
+	*c.nodes = slices.Insert(*c.nodes, c.iter.index+1, node)
+	c.iter.step++
+}
+
+// InsertBefore inserts the given node before the current one. It isn't
+// visited.
+func (c *Cursor) InsertBefore(node Node) {
+	*c.nodes = slices.Insert(*c.nodes, c.iter.index, node)
+	c.iter.index++
+}
+
+// Node returns the current node, or nil if it has been deleted.
+func (c *Cursor) Node() Node {
+	return c.node
+}
+
+// Parent returns the block, reference, or versioned block holding the current
+// node, or nil for top-level nodes.
+func (c *Cursor) Parent() Node {
+	return c.parent
+}
+
+// Path returns the path to the current node, made up of the names of the
+// blocks holding it and its own key or block name, separated by `.`, e.g.
+// `server.tls.cert`. Repeated blocks aren't distinguished, and versioned
+// blocks and references don't add to the path, as with Query.
+func (c *Cursor) Path() string {
+	if name, ok := entryName(c.node); ok {
+		return childPath(c.path, name)
+	}
+	return c.path
+}
+
+// Replace replaces the current node with the given one, whose children are
+// then visited instead.
+func (c *Cursor) Replace(node Node) {
+	(*c.nodes)[c.iter.index] = node
+	c.node = node
+}
+
+// rewriteIter tracks the position within the nodes being rewritten, with step
+// holding how far to advance once the current node has been visited.
+type rewriteIter struct {
+	index int
+	step  int
+}
+
+// rewriter holds the state of a call to Rewrite.
+type rewriter struct {
+	cursor Cursor
+	iter   rewriteIter
+	post   func(c *Cursor) bool
+	pre    func(c *Cursor) bool
+}
+
+// rewriteNode visits the given node, and returns false if the rewrite has been
+// aborted.
+func (r *rewriter) rewriteNode(parent Node, nodes *[]Node, node Node, path string) bool {
+	saved := r.cursor
+	defer func() {
+		r.cursor = saved
+	}()
+	r.cursor = Cursor{iter: &r.iter, node: node, nodes: nodes, parent: parent, path: path}
+	if r.pre != nil && !r.pre(&r.cursor) {
+		return true
+	}
+	node = r.cursor.node
+	if node == nil {
+		return true
+	}
+	if children := childNodes(node); children != nil {
+		if !r.rewriteNodes(node, children, r.cursor.Path()) {
+			return false
+		}
+	}
+	return r.post == nil || r.post(&r.cursor)
+}
+
+// rewriteNodes visits each of the given nodes in turn, and returns false if the
+// rewrite has been aborted.
+func (r *rewriter) rewriteNodes(parent Node, nodes *[]Node, path string) bool {
+	saved := r.iter
+	defer func() {
+		r.iter = saved
+	}()
+	r.iter = rewriteIter{}
+	for r.iter.index < len(*nodes) {
+		r.iter.step = 1
+		if !r.rewriteNode(parent, nodes, (*nodes)[r.iter.index], path) {
+			return false
+		}
+		r.iter.index += r.iter.step
+	}
+	return true
+}
+
+// Rewrite traverses the given nodes depth-first, like Walk, and calls pre
+// before visiting the children of each node, and post afterwards, either of
+// which can be nil. The hooks can use the given cursor to replace, delete, or
+// insert nodes, or modify the current node in place, e.g. to rename keys,
+// redact values, or add defaults to blocks, and the resulting top-level nodes
+// are returned. The given nodes may be modified.
+//
+// If pre returns false, the children of the node, and the post hook for it,
+// are skipped. If post returns false, the traversal stops, and the nodes are
+// returned as they are at that point.
+func Rewrite(nodes []Node, pre func(c *Cursor) bool, post func(c *Cursor) bool) []Node {
+	r := &rewriter{post: post, pre: pre}
+	r.rewriteNodes(nil, &nodes, "")
+	return nodes
+}
+
+// Walk traverses the given nodes depth-first, and calls fn for each of them.
+// The nodes within blocks, versioned blocks, and references are visited after
+// the node holding them, unless fn returns false for it.
+func Walk(nodes []Node, fn func(node Node) bool) {
+	for _, node := range nodes {
+		if !fn(node) {
+			continue
+		}
+		if children := childNodes(node); children != nil {
+			Walk(*children, fn)
+		}
+	}
+}
+
+// childNodes returns a pointer to the nodes held by the given node, or nil if
+// it can't hold any.
+func childNodes(node Node) *[]Node {
+	switch node := node.(type) {
+	case *Block:
+		return &node.Nodes
+	case *Reference:
+		return &node.Nodes
+	case *VersionedBlock:
+		return &node.Block.Nodes
+	}
+	return nil
+}
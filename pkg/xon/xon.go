@@ -0,0 +1,2168 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+// Package xon implements a parser and encoder for XON (XON Object Notation).
+//
+// The parser keeps all values as strings. It is up to the caller to interpret
+// them according to the target type. Go values can be encoded as XON with
+// Marshal.
+package xon
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Versions of the spec that documents can declare with an `xon` line, mapped to
+// their minor version numbers.
+var specVersions = map[string]int{"0.1": 1, "0.2": 2}
+
+// Block represents a named block, e.g.
+//
+//	server {
+//	    host = localhost
+//	}
+//
+// The block spans from Pos, at the start of its name, up to End, just after
+// its closing brace. For the block within a versioned block, Pos is at the
+// opening brace instead. Anchor holds the name of any anchor defined on the
+// block, e.g. `defaults` for `[&defaults] server {`.
+type Block struct {
+	Anchor         string   `json:"anchor,omitempty"`
+	ClosingComment string   `json:"closing_comment,omitempty"`
+	End            Position `json:"-"`
+	Name           string   `json:"name"`
+	Nodes          []Node   `json:"nodes"`
+	OpeningComment string   `json:"opening_comment,omitempty"`
+	Pos            Position `json:"-"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b *Block) MarshalJSON() ([]byte, error) {
+	type block Block
+	return marshalJSON(map[string]*block{"block": (*block)(b)})
+}
+
+func (b *Block) node() {}
+
+// Bytes represents a binary data literal, written in base64, e.g.
+// `b64"aGVsbG8="`, or in hex if Hex is set, e.g. `hex"68656c6c6f"`. The
+// literal spans from Pos up to End, including its prefix and quotes.
+type Bytes struct {
+	Data []byte   `json:"data"`
+	End  Position `json:"-"`
+	Hex  bool     `json:"hex,omitempty"`
+	Pos  Position `json:"-"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b *Bytes) MarshalJSON() ([]byte, error) {
+	type bytesLiteral Bytes
+	return marshalJSON(map[string]*bytesLiteral{"bytes": (*bytesLiteral)(b)})
+}
+
+func (b *Bytes) value() {}
+
+// Comment represents a line comment. The Text excludes the leading `//` and a
+// single space following it. Within lists, Inline is set for comments that
+// follow an element on the same line. The comment spans from Pos, at the `//`,
+// up to End, at the end of the line.
+type Comment struct {
+	End    Position
+	Inline bool
+	Pos    Position
+	Text   string
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (c *Comment) MarshalJSON() ([]byte, error) {
+	if c.Inline {
+		return marshalJSON(map[string]any{"comment": c.Text, "inline": true})
+	}
+	return marshalJSON(map[string]string{"comment": c.Text})
+}
+
+func (c *Comment) node() {}
+
+func (c *Comment) value() {}
+
+// Include represents an include directive, e.g.
+//
+//	include "servers.xon"
+//
+// Directives are kept as is by Parse and ParseAll, and are replaced by the
+// nodes of the included file when a document is loaded with a Loader. Any
+// inline comment that follows the path is held in the Comment. The directive
+// spans from Pos, at the `include` keyword, up to End, just after the path.
+type Include struct {
+	Comment string   `json:"comment,omitempty"`
+	End     Position `json:"-"`
+	Path    string   `json:"path"`
+	Pos     Position `json:"-"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (i *Include) MarshalJSON() ([]byte, error) {
+	type include Include
+	return marshalJSON(map[string]*include{"include": (*include)(i)})
+}
+
+func (i *Include) node() {}
+
+// KeyValue represents a key/value pair. The Comment holds any inline comment
+// that follows the value, and Anchor holds the name of any anchor defined on
+// the pair, e.g. `port` for `[&port] port = 8080`. The pair spans from Pos, at
+// the start of the key, up to End, just after the value. Append is set for
+// pairs written with `+=`, e.g. `ports += [8443]`, whose lists are appended to
+// those of the base document by Merge.
+type KeyValue struct {
+	Anchor  string   `json:"anchor,omitempty"`
+	Append  bool     `json:"append,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	End     Position `json:"-"`
+	Key     string   `json:"key"`
+	Pos     Position `json:"-"`
+	Value   Value    `json:"value"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (kv *KeyValue) MarshalJSON() ([]byte, error) {
+	type keyValue KeyValue
+	return marshalJSON(map[string]*keyValue{"key_value": (*keyValue)(kv)})
+}
+
+func (kv *KeyValue) node() {}
+
+// Limits bounds the resources used to parse a document, so that untrusted
+// input can be parsed safely. Zero values mean no limit. Limits apply to the
+// document as a whole, including any included files, and the contents copied
+// by references count towards both the depth and token limits, so that
+// repeated references can't be used to expand a small document into a huge
+// one.
+type Limits struct {
+	MaxDepth  int // the maximum nesting of blocks and lists
+	MaxSize   int // the maximum size of the source in bytes
+	MaxTokens int // the maximum number of tokens, e.g. keys, values, and braces
+}
+
+// List represents a list of values. The Content may also include any comments
+// that were on their own lines within the list. The list spans from Pos, at
+// the opening bracket, up to End, just after the closing bracket.
+type List struct {
+	Content        []Value  `json:"content"`
+	End            Position `json:"-"`
+	OpeningComment string   `json:"opening_comment,omitempty"`
+	Pos            Position `json:"-"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (l *List) MarshalJSON() ([]byte, error) {
+	type list List
+	return marshalJSON(map[string]*list{"list": (*list)(l)})
+}
+
+func (l *List) value() {}
+
+// Node represents an entry at the top level of a document or within a block.
+// It is one of *Block, *Comment, *Include, *KeyValue, *Reference, *Spec, or
+// *VersionedBlock.
+//
+// Nodes produced by Parse, and the values within them, have their Pos and End
+// set to the span of their source text. Both are zero for nodes constructed in
+// any other way.
+type Node interface {
+	node()
+}
+
+// Reference represents a reference to an anchored entry, e.g. `[*defaults]`
+// for an entry defined with `[&defaults]`. References are resolved while
+// parsing, and hold copies of the nodes they refer to.
+//
+// As an entry, Nodes holds the anchored key/value pair, or the contents of the
+// anchored block, except for any keys or blocks which are defined directly
+// within the referencing block, or merged in by an earlier reference, so that
+// they can be overridden. As a value, Value holds the value of the anchored
+// key/value pair. The Comment holds any inline comment that follows an entry.
+// The reference spans from Pos, at the `[`, up to End, just after the `]`.
+type Reference struct {
+	Comment string   `json:"comment,omitempty"`
+	End     Position `json:"-"`
+	Name    string   `json:"name"`
+	Nodes   []Node   `json:"nodes,omitempty"`
+	Pos     Position `json:"-"`
+	Value   Value    `json:"value,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r *Reference) MarshalJSON() ([]byte, error) {
+	type reference Reference
+	return marshalJSON(map[string]*reference{"reference": (*reference)(r)})
+}
+
+func (r *Reference) node() {}
+
+func (r *Reference) value() {}
+
+// Spec represents an `xon <version>` line, e.g. `xon 0.2`, which declares the
+// version of the spec that the rest of the file is written for. Files without
+// one follow version 0.1. Any inline comment that follows the version is held
+// in the Comment. The line spans from Pos, at the `xon` keyword, up to End,
+// just after the version.
+type Spec struct {
+	Comment string   `json:"comment,omitempty"`
+	End     Position `json:"-"`
+	Pos     Position `json:"-"`
+	Version string   `json:"version"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s *Spec) MarshalJSON() ([]byte, error) {
+	type spec Spec
+	return marshalJSON(map[string]*spec{"spec": (*spec)(s)})
+}
+
+func (s *Spec) node() {}
+
+// String represents a string value. Quoted is set if the value was enclosed
+// in double quotes, and Raw is also set if it was a raw string, e.g. `r"..."`.
+// The string spans from Pos up to End, including any quotes or multiline
+// delimiters.
+type String struct {
+	End    Position `json:"-"`
+	Pos    Position `json:"-"`
+	Quoted bool     `json:"quoted,omitempty"`
+	Raw    bool     `json:"raw,omitempty"`
+	Value  string   `json:"value"`
+}
+
+// ByteSize returns the value as a byte size, e.g. `4MiB`, following the same
+// conventions as when decoding into a ByteSize.
+func (s *String) ByteSize() (ByteSize, error) {
+	var b ByteSize
+	err := decodeString(s.Value, reflect.ValueOf(&b).Elem(), "")
+	return b, err
+}
+
+// Duration returns the value as a duration, e.g. `1h30m`, following the same
+// conventions as when decoding into a time.Duration.
+func (s *String) Duration() (time.Duration, error) {
+	var d time.Duration
+	err := decodeString(s.Value, reflect.ValueOf(&d).Elem(), "")
+	return d, err
+}
+
+// Number returns the value as a number literal, e.g. `1_000` or `12.50`,
+// following the same conventions as when decoding into a Number.
+func (s *String) Number() (Number, error) {
+	var n Number
+	err := decodeString(s.Value, reflect.ValueOf(&n).Elem(), "")
+	return n, err
+}
+
+// Time returns the value as an RFC 3339 datetime, e.g. `2026-01-02T15:04:05Z`,
+// following the same conventions as when decoding into a time.Time.
+func (s *String) Time() (time.Time, error) {
+	var t time.Time
+	err := decodeString(s.Value, reflect.ValueOf(&t).Elem(), "")
+	return t, err
+}
+
+func (s *String) value() {}
+
+// Value represents the value of a key/value pair or an element of a list. It
+// is one of *Bytes, *List, *Reference, or *String, or a *Comment within a list.
+type Value interface {
+	value()
+}
+
+// VersionedBlock represents a `[v<N>]` block whose contents are only active
+// when decoding for version N or above. It spans from Pos, at the `[`, up to
+// End, just after the closing brace.
+type VersionedBlock struct {
+	Block   *Block
+	End     Position
+	Pos     Position
+	Version int64
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (v *VersionedBlock) MarshalJSON() ([]byte, error) {
+	type block Block
+	return marshalJSON(map[string]any{
+		"versioned_block": struct {
+			Block   *block `json:"block"`
+			Version int64  `json:"version"`
+		}{(*block)(v.Block), v.Version},
+	})
+}
+
+func (v *VersionedBlock) node() {}
+
+// anchorSize holds the nesting depth and number of tokens of an anchored entry,
+// which count towards the limits again for each reference to it.
+type anchorSize struct {
+	depth  int
+	tokens int
+}
+
+// keySet holds the position of each key within a block, so that duplicates can
+// be reported along with the original.
+type keySet map[string]Position
+
+// listState tracks the separators used within a list, as commas can't be
+// combined with unquoted elements that contain whitespace.
+type listState struct {
+	afterElem  bool // on the same line as the previous element
+	elems      int
+	lastComma  bool
+	lineComma  bool // a comma separator was used on the current line
+	lineSpaced bool // an unquoted element with whitespace is on the current line
+}
+
+type parser struct {
+	anchorSizes map[string]anchorSize
+	anchors     map[string]Node // anchored entries, with nil for those being parsed
+	arena       *Arena
+	crlf        []int // offsets within src where a \r was removed
+	deepest     int   // the deepest nesting seen, for sizing anchors
+	depth       int
+	duplicates  bool // allow duplicate keys, leaving them to the decoder
+	errs        []*Error
+	file        string   // the path of the source, when loaded with a Loader
+	keySets     []keySet // the keys of the blocks at each nesting, reused across blocks
+	lastLine    int      // the line of the last position, to speed up lookups
+	lenient     bool     // recover from errors, collecting them in errs
+	limits      Limits
+	lineBase    int    // the number of lines before src, when read by an EntryReader
+	lines       []int  // offsets of the start of each line, computed lazily
+	loader      Loader // resolves include directives, if set
+	nesting     int    // the number of blocks and lists being parsed
+	nodeStack   []Node // the nodes of the blocks being parsed
+	offsetBase  int    // the number of bytes before src, when read by an EntryReader
+	pos         int
+	size        int // the size of the source, including any included files
+	spec        int // the minor version of the spec declared by the file, if any
+	src         []byte
+	stack       []string // paths of the files being included, to detect cycles
+	started     bool     // whether any entries have been parsed, after which the spec can't be declared
+	text        string   // the source as a string, which strings within nodes share
+	tokenCount  int
+	tokenize    bool
+	tokens      []Token
+	valueStack  []Value // the elements of the lists being parsed
+	version     int64
+	versioned   bool
+}
+
+// anchorName returns the name within the anchor or reference marker at the
+// current position, e.g. `[&name]` for the '&' sigil, or `[*name]` for '*',
+// along with the length of the marker, or -1 if there isn't a valid one.
+func (p *parser) anchorName(sigil byte) (string, int) {
+	if len(p.src)-p.pos < 2 || p.src[p.pos] != '[' || p.src[p.pos+1] != sigil {
+		return "", -1
+	}
+	i := p.pos + 2
+	for i < len(p.src) && isAnchorChar(p.src[i]) {
+		i++
+	}
+	if i == p.pos+2 || i >= len(p.src) || p.src[i] != ']' {
+		return "", -1
+	}
+	return p.text[p.pos+2 : i], i + 1 - p.pos
+}
+
+// checkDepth checks the current nesting depth, plus the given extra depth,
+// against the depth limit.
+func (p *parser) checkDepth(offset int, extra int) error {
+	depth := p.nesting + extra
+	p.deepest = max(p.deepest, depth)
+	if p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth {
+		return p.errorf(offset, CodeLimitExceeded, "nesting depth exceeds the limit of %d", p.limits.MaxDepth)
+	}
+	return nil
+}
+
+// checkTokens checks the number of tokens parsed so far against the token
+// limit.
+func (p *parser) checkTokens(offset int) error {
+	if p.limits.MaxTokens > 0 && p.tokenCount > p.limits.MaxTokens {
+		return p.errorf(offset, CodeLimitExceeded, "number of tokens exceeds the limit of %d", p.limits.MaxTokens)
+	}
+	return nil
+}
+
+// emit counts a token spanning the given offsets, and records it when
+// tokenizing.
+func (p *parser) emit(kind TokenKind, start int, end int, value string) {
+	p.tokenCount++
+	if !p.tokenize {
+		return
+	}
+	p.tokens = append(p.tokens, Token{
+		End:   p.position(end),
+		Kind:  kind,
+		Pos:   p.position(start),
+		Text:  p.text[start:end],
+		Value: value,
+	})
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+// errorf returns a parse error with the given code at the given offset, along
+// with the text of the line containing it.
+func (p *parser) errorf(offset int, code ErrorCode, format string, args ...any) *Error {
+	pos := p.position(offset)
+	start := p.lines[pos.Line-p.lineBase-1]
+	end := bytes.IndexByte(p.src[start:], '\n')
+	if end == -1 {
+		end = len(p.src) - start
+	}
+	return &Error{
+		Code:    code,
+		Column:  pos.Column,
+		File:    p.file,
+		Line:    pos.Line,
+		Message: fmt.Sprintf(format, args...),
+		Source:  string(p.src[start : start+end]),
+	}
+}
+
+func (p *parser) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(p.src[p.pos:], []byte(prefix))
+}
+
+// include loads and parses the file at the given path, for the include
+// directive at offset start. The path is relative to the directory of the
+// current file, or to the root of the loader if it starts with a `/`. Keys are
+// shared with the including block, so that duplicates are caught across files.
+func (p *parser) include(start int, name string, keys keySet) ([]Node, error) {
+	if strings.HasPrefix(name, "/") {
+		name = path.Clean(name)[1:]
+	} else {
+		name = path.Join(path.Dir(p.file), name)
+	}
+	if slices.Contains(p.stack, name) {
+		cycle := append(slices.Clone(p.stack), name)
+		return nil, p.errorf(start, CodeIncludeCycle, "include cycle: %s", strings.Join(cycle, " -> "))
+	}
+	src, err := p.loader.Load(name)
+	if err != nil {
+		return nil, p.errorf(start, CodeInvalidInclude, "failed to include %q: %v", name, err)
+	}
+	if p.limits.MaxSize > 0 && p.size+len(src) > p.limits.MaxSize {
+		return nil, p.errorf(start, CodeLimitExceeded, "including %q exceeds the input size limit of %d bytes", name, p.limits.MaxSize)
+	}
+	child := newParser(src)
+	child.anchorSizes = p.anchorSizes
+	child.anchors = p.anchors
+	child.arena = p.arena
+	child.deepest = p.deepest
+	child.duplicates = p.duplicates
+	child.file = name
+	child.limits = p.limits
+	child.loader = p.loader
+	child.nesting = p.nesting
+	child.size = p.size + len(src)
+	child.stack = append(slices.Clone(p.stack), name)
+	child.tokenCount = p.tokenCount
+	if err := child.validate(); err != nil {
+		return nil, err
+	}
+	nodes, err := child.parseNodes(keys, false)
+	p.deepest, p.size, p.tokenCount = child.deepest, child.size, child.tokenCount
+	// The spec only applies to the included file itself.
+	nodes = slices.DeleteFunc(nodes, func(node Node) bool {
+		_, ok := node.(*Spec)
+		return ok
+	})
+	return nodes, err
+}
+
+// isReference returns whether a reference like `[*name]`, as opposed to a list
+// like `[*.go]`, starts the value at the current position, or the list element
+// if inList is set. References are only supported from spec version 0.2.
+func (p *parser) isReference(inList bool) bool {
+	if p.spec < 2 {
+		return false
+	}
+	_, n := p.anchorName('*')
+	if n == -1 {
+		return false
+	}
+	if inList {
+		return isListBoundary(p.src, p.pos+n)
+	}
+	return p.pos+n >= len(p.src) || isSpace(p.src[p.pos+n]) || p.src[p.pos+n] == '\n'
+}
+
+// keySet returns an empty set of keys for a block at the current nesting. The
+// set from the last block at the same nesting is reused, unless it grew large
+// enough that clearing it would cost more than starting afresh.
+func (p *parser) keySet() keySet {
+	for len(p.keySets) <= p.nesting {
+		p.keySets = append(p.keySets, keySet{})
+	}
+	keys := p.keySets[p.nesting]
+	if len(keys) > 64 {
+		keys = keySet{}
+		p.keySets[p.nesting] = keys
+	} else {
+		clear(keys)
+	}
+	return keys
+}
+
+// lineEnd returns the offset of the end of the current line, excluding the
+// newline.
+func (p *parser) lineEnd() int {
+	end := bytes.IndexByte(p.src[p.pos:], '\n')
+	if end == -1 {
+		return len(p.src)
+	}
+	return p.pos + end
+}
+
+// newString returns a string value from the arena.
+func (p *parser) newString(value string, quoted bool, raw bool) *String {
+	s := p.arena.strings.new(p.arena.chunkSize())
+	s.Quoted, s.Raw, s.Value = quoted, raw, value
+	return s
+}
+
+func (p *parser) parse() ([]Node, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	nodes, err := p.parseNodes(keySet{}, false)
+	if err != nil {
+		return nil, err
+	}
+	mergeReferences(nodes)
+	return nodes, nil
+}
+
+// parseAnchor parses an anchor marker like `[&name]`, and the key/value pair
+// or block that follows it, which can then be referenced by later entries.
+func (p *parser) parseAnchor(keys keySet) (Node, error) {
+	start := p.pos
+	name, n := p.anchorName('&')
+	if n == -1 {
+		return nil, p.errorf(p.pos, CodeInvalidAnchor, "invalid anchor: expected a name like [&name]").expect("anchor name")
+	}
+	if _, ok := p.anchors[name]; ok {
+		return nil, p.errorf(p.pos, CodeInvalidAnchor, "duplicate anchor %q", name)
+	}
+	p.emit(TokenAnchor, start, start+n, name)
+	p.pos += n
+	if !p.eof() && p.src[p.pos] != '\n' && !isSpace(p.src[p.pos]) {
+		return nil, p.errorf(p.pos, CodeMissingSpace, "space required after anchor [&%s]", name)
+	}
+	p.skipSpace()
+	if p.eof() || p.src[p.pos] == '\n' || p.src[p.pos] == '[' || p.hasPrefix("//") {
+		return nil, p.errorf(p.pos, CodeInvalidAnchor, "anchor [&%s] must be followed by a key/value pair or block", name).expect("key", "block name")
+	}
+	// Mark the anchor as being parsed, so that references to it from within
+	// the entry are caught as cycles.
+	p.anchors[name] = nil
+	deepest, tokens := p.deepest, p.tokenCount
+	p.deepest = p.nesting
+	node, err := p.parseEntry(keys)
+	size := anchorSize{depth: p.deepest - p.nesting, tokens: p.tokenCount - tokens}
+	p.deepest = max(deepest, p.deepest)
+	if err != nil {
+		delete(p.anchors, name)
+		return nil, err
+	}
+	p.anchorSizes[name] = size
+	switch node := node.(type) {
+	case *Block:
+		node.Anchor = name
+	case *KeyValue:
+		node.Anchor = name
+	}
+	p.anchors[name] = node
+	return node, nil
+}
+
+func (p *parser) parseBlock(block *Block, keys keySet) error {
+	p.nesting++
+	defer func() { p.nesting-- }()
+	if err := p.checkDepth(p.pos, 0); err != nil {
+		return err
+	}
+	p.emit(TokenOpenBrace, p.pos, p.pos+1, "")
+	p.pos++
+	if !p.eof() && p.src[p.pos] == '}' {
+		p.emit(TokenCloseBrace, p.pos, p.pos+1, "")
+		p.pos++
+		block.End = p.position(p.pos)
+		block.Nodes = []Node{}
+		comment, err := p.parseLineEnd("'}'")
+		if err != nil {
+			return err
+		}
+		block.ClosingComment = comment
+		return nil
+	}
+	p.skipSpace()
+	switch {
+	case p.eof():
+	case p.src[p.pos] == '\n':
+		p.pos++
+	case p.hasPrefix("//"):
+		block.OpeningComment = p.readComment()
+	default:
+		r, _ := utf8.DecodeRune(p.src[p.pos:])
+		err := p.errorf(p.pos, CodeUnexpectedToken, "expected newline or '}' after '{', got %q", r).expect("newline", "comment", "'}'")
+		if !p.report(err) {
+			return err
+		}
+		p.pos = min(p.lineEnd()+1, len(p.src))
+	}
+	p.depth++
+	nodes, err := p.parseNodes(keys, true)
+	if err != nil {
+		return err
+	}
+	block.Nodes = nodes
+	block.End = p.position(p.pos)
+	comment, err := p.parseLineEnd("'}'")
+	if err != nil {
+		return err
+	}
+	block.ClosingComment = comment
+	return nil
+}
+
+// parseBytes parses a binary data literal like `b64"aGVsbG8="` or
+// `hex"68656c6c6f"`, which must be on a single line.
+func (p *parser) parseBytes() (*Bytes, error) {
+	start := p.pos
+	value := &Bytes{Hex: p.hasPrefix("hex")}
+	end := bytes.IndexByte(p.src[start+4:p.lineEnd()], '"')
+	if end == -1 {
+		return nil, p.errorf(p.lineEnd(), CodeUnterminatedString, "unterminated bytes literal").expect(`'"'`)
+	}
+	data := p.text[start+4 : start+4+end]
+	var err error
+	if value.Hex {
+		value.Data, err = hex.DecodeString(data)
+	} else {
+		value.Data, err = base64.StdEncoding.DecodeString(data)
+	}
+	if err != nil {
+		encoding := "base64"
+		if value.Hex {
+			encoding = "hex"
+		}
+		return nil, p.errorf(start+4, CodeInvalidBytes, "invalid %s data within bytes literal", encoding)
+	}
+	p.pos = start + 5 + end
+	value.End, value.Pos = p.position(p.pos), p.position(start)
+	p.emit(TokenBytes, start, p.pos, data)
+	return value, nil
+}
+
+// parseComment parses a comment on its own line, or, within lists, following
+// an element on the same line if inline is set.
+func (p *parser) parseComment(inline bool) *Comment {
+	comment := p.arena.comments.new(p.arena.chunkSize())
+	comment.End = p.position(p.lineEnd())
+	comment.Inline = inline
+	comment.Pos = p.position(p.pos)
+	comment.Text = p.readComment()
+	return comment
+}
+
+func (p *parser) parseEntry(keys keySet) (Node, error) {
+	start := p.pos
+	var (
+		end int
+		key string
+	)
+	if hashes := p.rawHashes(); p.src[p.pos] == '"' || hashes >= 0 {
+		var (
+			s   string
+			err error
+		)
+		if hashes >= 0 {
+			s, err = p.parseRaw(hashes)
+		} else {
+			s, err = p.parseQuoted()
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, end = s, p.pos
+		if p.eof() || (p.src[p.pos] != ' ' && p.src[p.pos] != '\t') {
+			return nil, p.errorf(p.pos, CodeMissingSpace, "space required after quoted key")
+		}
+		p.skipSpace()
+	} else {
+		s, keyEnd, err := p.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		key, end = s, keyEnd
+	}
+	if p.eof() {
+		return nil, p.errorf(p.pos, CodeUnexpectedToken, "expected '=' or '{' after key").expect("'='", "'{'")
+	}
+	switch {
+	case p.src[p.pos] == '=' || (p.spec >= 2 && p.hasPrefix("+=")):
+		p.emit(TokenKey, start, end, key)
+		if pos, ok := keys[key]; ok && !p.duplicates {
+			if err := p.errorf(start, CodeDuplicateKey, "duplicate key %q, first defined at %s", key, pos); !p.report(err) {
+				return nil, err
+			}
+		} else if !ok {
+			keys[key] = p.position(start)
+		}
+		op, kind := "=", TokenEquals
+		if p.src[p.pos] == '+' {
+			op, kind = "+=", TokenAppend
+		}
+		p.emit(kind, p.pos, p.pos+len(op), "")
+		p.pos += len(op)
+		if p.eof() || p.src[p.pos] == '\n' {
+			return nil, p.errorf(p.pos, CodeMissingValue, "missing value after '%s'", op).expect("value")
+		}
+		if p.src[p.pos] != ' ' && p.src[p.pos] != '\t' {
+			return nil, p.errorf(p.pos, CodeMissingSpace, "space required after '%s'", op)
+		}
+		eq := p.pos
+		p.skipSpace()
+		if p.eof() || p.src[p.pos] == '\n' {
+			return nil, p.errorf(eq, CodeMissingValue, "missing value after '%s'", op).expect("value")
+		}
+		valueStart := p.pos
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := referencedValue(value).(*List); op == "+=" && !ok {
+			return nil, p.errorf(valueStart, CodeInvalidValue, "only lists can be appended with '+='").expect("'['")
+		}
+		kv := p.arena.keyValues.new(p.arena.chunkSize())
+		*kv = KeyValue{
+			Append: op == "+=",
+			End:    p.position(p.pos),
+			Key:    key,
+			Pos:    p.position(start),
+			Value:  value,
+		}
+		if kv.Comment, err = p.parseLineEnd("value"); err != nil {
+			return nil, err
+		}
+		return kv, nil
+	case p.src[p.pos] == '{':
+		p.emit(TokenBlockName, start, end, key)
+		block := p.arena.blocks.new(p.arena.chunkSize())
+		block.Name, block.Pos = key, p.position(start)
+		if err := p.parseBlock(block, p.keySet()); err != nil {
+			return nil, err
+		}
+		mergeReferences(block.Nodes)
+		return block, nil
+	default:
+		return nil, p.errorf(p.pos, CodeUnexpectedToken, "expected '=' or '{' after key").expect("'='", "'{'")
+	}
+}
+
+// parseHeredoc parses a heredoc string, where start is the offset just after
+// the opening backticks, and marker is the length of the `|` or `|-` marker
+// line which follows them. Lines are kept as is, except for the indentation of
+// the closing backticks, which is stripped from every line.
+func (p *parser) parseHeredoc(start, marker, n int, inList bool) (string, error) {
+	chomp := p.src[start+1] == '-'
+	var (
+		base    int
+		lines   []string
+		offsets []int
+	)
+	i := start + marker
+	for {
+		if i >= len(p.src) {
+			return "", p.unterminatedMultiline(inList)
+		}
+		end := len(p.src)
+		if idx := bytes.IndexByte(p.src[i:], '\n'); idx != -1 {
+			end = i + idx
+		}
+		line := p.text[i:end]
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		run := 0
+		for indent+run < len(line) && line[indent+run] == '`' {
+			run++
+		}
+		if run == n {
+			base = indentWidth(line[:indent])
+			p.pos = i + indent + n
+			break
+		}
+		lines = append(lines, line)
+		offsets = append(offsets, i)
+		i = end + 1
+	}
+	for idx, line := range lines {
+		switch {
+		case indentWidth(line) >= base:
+			lines[idx] = stripIndent(line, base)
+		case strings.TrimLeft(line, " \t") == "":
+			lines[idx] = ""
+		default:
+			err := p.errorf(offsets[idx], CodeInvalidIndentation, "line has less indentation than the closing delimiter of the heredoc string")
+			if !p.report(err) {
+				return "", err
+			}
+			lines[idx] = strings.TrimLeft(line, " \t")
+		}
+	}
+	value := strings.Join(lines, "\n")
+	if len(lines) > 0 && !chomp {
+		value += "\n"
+	}
+	return p.unescape(value, p.pos)
+}
+
+// parseIdentifier parses an unquoted block name or key, leaving the parser at
+// the `=` or `{` that follows it. The offset of the end of the identifier is
+// also returned.
+func (p *parser) parseIdentifier() (string, int, error) {
+	start := p.pos
+	i := p.pos
+	for i < len(p.src) && p.src[i] != '\n' {
+		c := p.src[i]
+		if c != ' ' && c != '\t' {
+			i++
+			continue
+		}
+		j := skipSpace(p.src, i)
+		if j < len(p.src) && (p.src[j] == '=' || p.src[j] == '{' || (p.spec >= 2 && bytes.HasPrefix(p.src[j:], []byte("+=")))) {
+			key, err := p.unescape(p.text[start:i], i)
+			if err != nil {
+				return "", 0, err
+			}
+			p.pos = j
+			return key, i, nil
+		}
+		if bytes.HasPrefix(p.src[j:], []byte("//")) {
+			break
+		}
+		i = j
+	}
+	ident := strings.TrimSpace(string(p.src[start:i]))
+	if i < len(p.src) {
+		i = bytes.IndexByte(p.src[i:], '\n') + i
+		i++
+	}
+	hint := ""
+	brace := strings.IndexByte(ident, '{')
+	equals := strings.IndexByte(ident, '=')
+	if brace >= 0 && (equals < 0 || brace < equals) {
+		hint = " (perhaps add a space before '{')"
+	} else if equals >= 0 {
+		hint = " (perhaps add a space before '=')"
+	}
+	return "", 0, p.errorf(i, CodeInvalidIdentifier, "identifier %q without '=' or '{'%s", ident, hint).expect("'='", "'{'")
+}
+
+// parseInclude parses an include directive, and returns the nodes of the
+// included file, or the directive itself if there's no loader. It returns
+// false if the entry isn't an include directive, e.g. for a key like
+// `include "x" = y`, in which case nothing is consumed.
+func (p *parser) parseInclude(keys keySet) ([]Node, bool, error) {
+	if !p.hasPrefix("include") {
+		return nil, false, nil
+	}
+	start, errs, tokens := p.pos, len(p.errs), len(p.tokens)
+	p.pos += len("include")
+	if p.eof() || !isSpace(p.src[p.pos]) {
+		p.pos = start
+		return nil, false, nil
+	}
+	p.skipSpace()
+	var (
+		err  error
+		path string
+	)
+	pathStart := p.pos
+	if hashes := p.rawHashes(); hashes >= 0 {
+		path, err = p.parseRaw(hashes)
+	} else if !p.eof() && p.src[p.pos] == '"' {
+		path, err = p.parseQuoted()
+	} else {
+		p.pos = start
+		return nil, false, nil
+	}
+	end := p.pos
+	if err == nil {
+		p.skipSpace()
+	}
+	if err != nil || !(p.eof() || p.src[p.pos] == '\n' || p.hasPrefix("//")) {
+		p.errs, p.pos, p.tokens = p.errs[:errs], start, p.tokens[:tokens]
+		return nil, false, nil
+	}
+	p.emit(TokenInclude, start, start+len("include"), "")
+	p.emit(TokenString, pathStart, end, path)
+	include := &Include{
+		End:  p.position(end),
+		Path: path,
+		Pos:  p.position(start),
+	}
+	if include.Comment, err = p.parseLineEnd("include path"); err != nil {
+		return nil, true, err
+	}
+	if p.loader == nil {
+		return []Node{include}, true, nil
+	}
+	nodes, err := p.include(start, path, keys)
+	return nodes, true, err
+}
+
+// parseLineEnd parses the remainder of a line, which may only contain
+// whitespace and an optional inline comment.
+func (p *parser) parseLineEnd(after string) (string, error) {
+	p.skipSpace()
+	switch {
+	case p.eof():
+		return "", nil
+	case p.src[p.pos] == '\n':
+		p.pos++
+		return "", nil
+	case p.hasPrefix("//"):
+		return p.readComment(), nil
+	}
+	r, _ := utf8.DecodeRune(p.src[p.pos:])
+	err := p.errorf(p.pos, CodeUnexpectedToken, "unexpected character %q after %s", r, after).expect("newline", "comment")
+	if !p.report(err) {
+		return "", err
+	}
+	p.pos = min(p.lineEnd()+1, len(p.src))
+	return "", nil
+}
+
+func (p *parser) parseList() (*List, error) {
+	p.nesting++
+	defer func() { p.nesting-- }()
+	if err := p.checkDepth(p.pos, 0); err != nil {
+		return nil, err
+	}
+	list := p.arena.lists.new(p.arena.chunkSize())
+	list.Pos = p.position(p.pos)
+	p.emit(TokenOpenBracket, p.pos, p.pos+1, "")
+	p.pos++
+	p.skipSpace()
+	if p.hasPrefix("//") {
+		list.OpeningComment = p.readComment()
+	}
+	// Elements are gathered on the value stack, like the nodes of blocks.
+	base := len(p.valueStack)
+	fail := func(err error) (*List, error) {
+		clear(p.valueStack[base:])
+		p.valueStack = p.valueStack[:base]
+		return nil, err
+	}
+	state := &listState{}
+	for {
+		if err := p.checkTokens(p.pos); err != nil {
+			return fail(err)
+		}
+		p.skipSpace()
+		if p.eof() {
+			err := p.errorf(p.pos, CodeUnexpectedEOF, "unexpected end of file, expected ']'").expect("']'")
+			err.Column--
+			if !p.report(err) {
+				return fail(err)
+			}
+			list.Content = p.popValues(base)
+			list.End = p.position(p.pos)
+			return list, nil
+		}
+		done, err := p.parseListItem(list, state)
+		if err != nil {
+			if !p.report(err) {
+				return fail(err)
+			}
+			// Skip the rest of the line, stopping at the end of the list if
+			// it closes on the same line.
+			for !p.eof() && p.src[p.pos] != '\n' && p.src[p.pos] != ']' {
+				p.pos++
+			}
+			continue
+		}
+		if done {
+			list.Content = p.popValues(base)
+			return list, nil
+		}
+	}
+}
+
+// parseListElement parses an unquoted list element, and returns the raw text
+// including any trailing whitespace.
+func (p *parser) parseListElement() (string, error) {
+	start := p.pos
+	i := p.pos
+loop:
+	for i < len(p.src) {
+		switch p.src[i] {
+		case '\n':
+			break loop
+		case ' ', '\t':
+			if bytes.HasPrefix(p.src[skipSpace(p.src, i):], []byte("//")) {
+				break loop
+			}
+		case ',':
+			if (i > start && isSpace(p.src[i-1])) || isListBoundary(p.src, i+1) || p.src[i+1] == ',' {
+				break loop
+			}
+		case ']':
+			if isListBoundary(p.src, i+1) {
+				break loop
+			}
+		}
+		i++
+	}
+	p.pos = i
+	return p.text[start:i], nil
+}
+
+// parseListItem parses the next item within a list, i.e. an element and any
+// comma that follows it, a comment, a newline, or the closing bracket, in
+// which case it returns true.
+func (p *parser) parseListItem(list *List, state *listState) (bool, error) {
+	c := p.src[p.pos]
+	switch {
+	case c == '\n':
+		p.pos++
+		state.afterElem = false
+		state.lineComma = false
+		state.lineSpaced = false
+		return false, nil
+	case p.hasPrefix("//"):
+		p.valueStack = append(p.valueStack, p.parseComment(state.afterElem || state.lineComma))
+		state.afterElem = false
+		state.lineComma = false
+		state.lineSpaced = false
+		return false, nil
+	case c == ']':
+		p.emit(TokenCloseBracket, p.pos, p.pos+1, "")
+		p.pos++
+		list.End = p.position(p.pos)
+		return true, nil
+	case c == ',':
+		if state.elems == 0 {
+			return false, p.errorf(p.pos, CodeInvalidList, "unexpected ',' at start of list")
+		}
+		if state.lastComma {
+			return false, p.errorf(p.pos, CodeInvalidList, "unexpected ',' after another ','")
+		}
+		return false, p.errorf(p.pos, CodeInvalidList, "whitespace is not allowed before ','")
+	case c == '{':
+		return false, p.errorf(p.pos, CodeInvalidList, "blocks are not allowed within lists")
+	case state.afterElem:
+		return false, p.errorf(p.pos, CodeInvalidList, "expected ',' or newline between list elements").expect("','", "newline")
+	}
+	start := p.pos
+	var (
+		elem    Value
+		err     error
+		raw     string
+		spaced  bool
+		unquote bool
+	)
+	switch hashes := p.rawHashes(); {
+	case p.isReference(true):
+		elem, err = p.parseReferenceValue()
+	case c == '[':
+		elem, err = p.parseList()
+	case p.hasPrefix(`b64"`) || p.hasPrefix(`hex"`):
+		elem, err = p.parseBytes()
+	case c == '"':
+		var s string
+		s, err = p.parseQuoted()
+		elem = p.newString(s, true, false)
+	case hashes >= 0:
+		var s string
+		s, err = p.parseRaw(hashes)
+		elem = p.newString(s, true, true)
+	case c == '`':
+		var s string
+		s, err = p.parseMultiline(true)
+		elem = p.newString(s, false, false)
+	default:
+		raw, err = p.parseListElement()
+		if err == nil {
+			var s string
+			s, err = p.unescape(strings.TrimRight(raw, " \t"), p.pos)
+			elem = p.newString(s, false, false)
+		}
+		unquote = true
+		spaced = strings.ContainsAny(strings.TrimRight(raw, " \t"), " \t")
+	}
+	if err != nil {
+		return false, err
+	}
+	if s, ok := elem.(*String); ok {
+		end := start + len(strings.TrimRight(p.text[start:p.pos], " \t"))
+		s.End, s.Pos = p.position(end), p.position(start)
+		p.emit(TokenString, start, end, s.Value)
+	}
+	p.valueStack = append(p.valueStack, elem)
+	state.afterElem = true
+	state.elems++
+	state.lastComma = false
+	if spaced {
+		state.lineSpaced = true
+	}
+	if p.eof() || p.src[p.pos] != ',' {
+		if state.lineComma && spaced {
+			return false, p.errorf(start, CodeAmbiguousList, "ambiguous list: element with spaces used with comma separator (quote the element or remove the comma)")
+		}
+		return false, nil
+	}
+	p.emit(TokenComma, p.pos, p.pos+1, "")
+	p.pos++
+	if state.lineSpaced || (unquote && strings.ContainsAny(raw, " \t")) {
+		return false, p.errorf(p.pos, CodeAmbiguousList, "ambiguous list: element with spaces used with comma separator (quote the element or remove the comma)")
+	}
+	state.afterElem = false
+	state.lastComma = true
+	state.lineComma = true
+	if p.eof() {
+		return false, nil
+	}
+	switch p.src[p.pos] {
+	case ' ', '\t', '\n', ']':
+	case ',':
+		return false, p.errorf(p.pos, CodeInvalidList, "unexpected ',' after another ','")
+	default:
+		return false, p.errorf(p.pos, CodeMissingSpace, "space required after ','")
+	}
+	return false, nil
+}
+
+func (p *parser) parseMultiline(inList bool) (string, error) {
+	n := 0
+	for p.pos+n < len(p.src) && p.src[p.pos+n] == '`' {
+		n++
+	}
+	if n%2 == 0 {
+		p.pos += n
+		return "", nil
+	}
+	start := p.pos + n
+	if marker := heredocMarker(p.src[start:]); marker > 0 && p.spec >= 2 {
+		return p.parseHeredoc(start, marker, n, inList)
+	}
+	end := -1
+	for i := start; i < len(p.src); {
+		if p.src[i] != '`' {
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(p.src) && p.src[i+run] == '`' {
+			run++
+		}
+		if run == n {
+			end = i
+			break
+		}
+		i += run
+	}
+	if end == -1 {
+		return "", p.unterminatedMultiline(inList)
+	}
+	p.pos = end + n
+	lines := strings.Split(p.text[start:end], "\n")
+	lines[0] = strings.TrimLeft(lines[0], " \t")
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	last := len(lines) - 1
+	lines[last] = strings.TrimRight(lines[last], " \t")
+	base := indentWidth(lines[0])
+	for i, line := range lines {
+		if strings.TrimLeft(line, " \t") == "" {
+			lines[i] = ""
+			continue
+		}
+		if indentWidth(line) < base {
+			err := p.errorf(p.pos, CodeInvalidIndentation, "line has less indentation than the first line of the multiline string")
+			if !p.report(err) {
+				return "", err
+			}
+			lines[i] = strings.TrimLeft(line, " \t")
+			continue
+		}
+		lines[i] = stripIndent(line, base)
+	}
+	return p.unescape(strings.Join(lines, "\n"), p.pos)
+}
+
+// parseNodes parses the nodes up to the end of the current block, or of the
+// document. The nodes are gathered on the node stack while they're being
+// parsed, and then copied into a slice from the arena, so that the slices for
+// each block don't need to be grown as they're parsed.
+func (p *parser) parseNodes(keys keySet, inBlock bool) ([]Node, error) {
+	base := len(p.nodeStack)
+	if err := p.pushNodes(keys, inBlock); err != nil {
+		clear(p.nodeStack[base:])
+		p.nodeStack = p.nodeStack[:base]
+		return nil, err
+	}
+	return p.popNodes(base), nil
+}
+
+func (p *parser) parseQuoted() (string, error) {
+	start := p.pos + 1
+	i := start
+	for i < len(p.src) && p.src[i] != '"' && p.src[i] != '\n' {
+		i++
+	}
+	if i >= len(p.src) || p.src[i] == '\n' {
+		return "", p.errorf(i, CodeUnterminatedString, "unterminated quoted string").expect("'\"'")
+	}
+	p.pos = i + 1
+	return p.unescape(p.text[start:i], i)
+}
+
+// parseRaw parses a raw string, with the given number of '#' characters
+// around its quotes. Its contents are kept as is, without processing any byte
+// escapes, and it ends at the first '"' followed by the same number of '#'
+// characters.
+func (p *parser) parseRaw(hashes int) (string, error) {
+	start := p.pos + hashes + 2
+	closing := []byte("\"" + strings.Repeat("#", hashes))
+	for i := start; i < len(p.src) && p.src[i] != '\n'; i++ {
+		if bytes.HasPrefix(p.src[i:], closing) {
+			p.pos = i + len(closing)
+			return p.text[start:i], nil
+		}
+	}
+	return "", p.errorf(p.lineEnd(), CodeUnterminatedString, "unterminated raw string").expect("'" + string(closing) + "'")
+}
+
+// parseReference parses a reference like `[*name]`, and returns it along
+// with the anchored node that it refers to.
+func (p *parser) parseReference() (*Reference, Node, error) {
+	start := p.pos
+	name, n := p.anchorName('*')
+	if n == -1 {
+		return nil, nil, p.errorf(p.pos, CodeInvalidReference, "invalid reference: expected a name like [*name]").expect("anchor name")
+	}
+	node, ok := p.anchors[name]
+	if !ok {
+		return nil, nil, p.errorf(p.pos, CodeInvalidReference, "unknown anchor %q (anchors must be defined before they are referenced)", name)
+	}
+	if node == nil {
+		return nil, nil, p.errorf(p.pos, CodeReferenceCycle, "reference to anchor %q from within its own definition", name)
+	}
+	// Count the copied contents towards the limits, where the contents of
+	// blocks are merged in without the block itself.
+	size := p.anchorSizes[name]
+	if _, ok := node.(*Block); ok {
+		size.depth--
+	}
+	if err := p.checkDepth(start, size.depth); err != nil {
+		return nil, nil, err
+	}
+	p.tokenCount += size.tokens
+	if err := p.checkTokens(start); err != nil {
+		return nil, nil, err
+	}
+	p.emit(TokenReference, start, start+n, name)
+	p.pos += n
+	return &Reference{End: p.position(p.pos), Name: name, Pos: p.position(start)}, node, nil
+}
+
+// parseReferenceEntry parses a reference on a line of its own, which merges a
+// copy of the anchored key/value pair, or the contents of the anchored block,
+// into the current block.
+func (p *parser) parseReferenceEntry() (*Reference, error) {
+	ref, node, err := p.parseReference()
+	if err != nil {
+		return nil, err
+	}
+	switch node := node.(type) {
+	case *Block:
+		ref.Nodes = cloneNodes(node.Nodes)
+	case *KeyValue:
+		kv := cloneNode(node).(*KeyValue)
+		kv.Anchor = ""
+		ref.Nodes = []Node{kv}
+	}
+	if ref.Comment, err = p.parseLineEnd("reference"); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// parseReferenceValue parses a reference used as a value, which takes a copy
+// of the value of the anchored key/value pair.
+func (p *parser) parseReferenceValue() (*Reference, error) {
+	start := p.pos
+	ref, node, err := p.parseReference()
+	if err != nil {
+		return nil, err
+	}
+	kv, ok := node.(*KeyValue)
+	if !ok {
+		return nil, p.errorf(start, CodeInvalidReference, "anchor %q is a block, which can only be referenced on a line of its own", ref.Name)
+	}
+	ref.Value = cloneValue(kv.Value)
+	return ref, nil
+}
+
+// parseSpec parses an `xon <version>` line at the top level of a file. It
+// returns false if the entry isn't a spec line, e.g. for a key like `xon = 1`,
+// in which case nothing is consumed.
+func (p *parser) parseSpec() (*Spec, bool, error) {
+	if !p.hasPrefix("xon") {
+		return nil, false, nil
+	}
+	start := p.pos
+	i := start + len("xon")
+	if i >= len(p.src) || !isSpace(p.src[i]) {
+		return nil, false, nil
+	}
+	i = skipSpace(p.src, i)
+	versionStart := i
+	for i < len(p.src) && p.src[i] != '\n' && !isSpace(p.src[i]) {
+		i++
+	}
+	end := skipSpace(p.src, i)
+	if i == versionStart || !(p.src[versionStart] >= '0' && p.src[versionStart] <= '9') || !(end >= len(p.src) || p.src[end] == '\n' || bytes.HasPrefix(p.src[end:], []byte("//"))) {
+		return nil, false, nil
+	}
+	version := p.text[versionStart:i]
+	if p.spec != 0 {
+		return nil, true, p.errorf(start, CodeInvalidSpec, "the spec version can only be declared once")
+	}
+	if p.started {
+		return nil, true, p.errorf(start, CodeInvalidSpec, "the spec version must be declared before any entries")
+	}
+	minor, ok := specVersions[version]
+	if !ok {
+		return nil, true, p.errorf(versionStart, CodeInvalidSpec, "unsupported spec version %q (expected 0.1 or 0.2)", version)
+	}
+	p.emit(TokenSpec, start, start+len("xon"), "")
+	p.emit(TokenString, versionStart, i, version)
+	p.pos = i
+	p.spec = minor
+	spec := &Spec{
+		End:     p.position(i),
+		Pos:     p.position(start),
+		Version: version,
+	}
+	var err error
+	if spec.Comment, err = p.parseLineEnd("spec version"); err != nil {
+		return nil, true, err
+	}
+	return spec, true, nil
+}
+
+func (p *parser) parseUnquoted() (string, error) {
+	start := p.pos
+	i := p.pos
+	for i < len(p.src) && p.src[i] != '\n' {
+		c := p.src[i]
+		if c != ' ' && c != '\t' {
+			i++
+			continue
+		}
+		j := skipSpace(p.src, i)
+		if j >= len(p.src) || p.src[j] == '\n' || bytes.HasPrefix(p.src[j:], []byte("//")) {
+			break
+		}
+		switch p.src[j] {
+		case '=':
+			return "", p.errorf(j+1, CodeInvalidValue, "only one '=' assignment allowed per line")
+		case '{', '}', '[', ']':
+			return "", p.errorf(j, CodeInvalidValue, "unexpected '%c' in unquoted value (quote the value)", p.src[j])
+		}
+		i = j
+	}
+	p.pos = i
+	return p.unescape(p.text[start:i], i)
+}
+
+func (p *parser) parseValue() (Value, error) {
+	start := p.pos
+	value := p.arena.strings.new(p.arena.chunkSize())
+	var err error
+	switch hashes := p.rawHashes(); {
+	case p.isReference(false):
+		return p.parseReferenceValue()
+	case p.src[p.pos] == '[':
+		return p.parseList()
+	case p.hasPrefix(`b64"`) || p.hasPrefix(`hex"`):
+		return p.parseBytes()
+	case p.src[p.pos] == '"':
+		value.Quoted = true
+		value.Value, err = p.parseQuoted()
+	case hashes >= 0:
+		value.Quoted, value.Raw = true, true
+		value.Value, err = p.parseRaw(hashes)
+	case p.src[p.pos] == '`':
+		value.Value, err = p.parseMultiline(false)
+	default:
+		value.Value, err = p.parseUnquoted()
+	}
+	if err != nil {
+		return nil, err
+	}
+	value.End, value.Pos = p.position(p.pos), p.position(start)
+	p.emit(TokenString, start, p.pos, value.Value)
+	return value, nil
+}
+
+func (p *parser) parseVersionedBlock(keys keySet) (*VersionedBlock, error) {
+	start := p.pos
+	i := p.pos + 2
+	digits := i
+	for i < len(p.src) && p.src[i] >= '0' && p.src[i] <= '9' {
+		i++
+	}
+	if i == digits {
+		return nil, p.errorf(i, CodeInvalidVersion, "invalid versioned block: expected version number after [v").expect("version number")
+	}
+	if i >= len(p.src) || p.src[i] != ']' {
+		return nil, p.errorf(i, CodeInvalidVersion, "invalid versioned block: expected ']' after version number").expect("']'")
+	}
+	version, err := strconv.ParseInt(p.text[digits:i], 10, 64)
+	if err != nil {
+		return nil, p.errorf(i, CodeInvalidVersion, "version number overflows int64: %s", p.src[digits:i])
+	}
+	if p.versioned && version != p.version {
+		err := p.errorf(start, CodeMixedVersions, "only one versioned block number allowed per file (found v%d after v%d)", version, p.version)
+		if !p.report(err) {
+			return nil, err
+		}
+	} else {
+		p.version = version
+		p.versioned = true
+	}
+	p.emit(TokenVersion, start, i+1, p.text[digits:i])
+	p.pos = i + 1
+	p.skipSpace()
+	if p.eof() || p.src[p.pos] != '{' || p.pos == i+1 {
+		return nil, p.errorf(p.pos, CodeInvalidVersion, "expected ' {' after versioned block [v%d]", version).expect("'{'")
+	}
+	block := p.arena.blocks.new(p.arena.chunkSize())
+	block.Pos = p.position(p.pos)
+	if err := p.parseBlock(block, keys); err != nil {
+		return nil, err
+	}
+	return &VersionedBlock{
+		Block:   block,
+		End:     block.End,
+		Pos:     p.position(start),
+		Version: version,
+	}, nil
+}
+
+// popNodes removes the nodes above the given base from the node stack, and
+// returns them within a slice from the arena.
+func (p *parser) popNodes(base int) []Node {
+	nodes := p.arena.nodes.alloc(len(p.nodeStack)-base, p.arena.chunkSize())
+	copy(nodes, p.nodeStack[base:])
+	clear(p.nodeStack[base:])
+	p.nodeStack = p.nodeStack[:base]
+	return nodes
+}
+
+// popValues removes the values above the given base from the value stack, and
+// returns them within a slice from the arena.
+func (p *parser) popValues(base int) []Value {
+	values := p.arena.values.alloc(len(p.valueStack)-base, p.arena.chunkSize())
+	copy(values, p.valueStack[base:])
+	clear(p.valueStack[base:])
+	p.valueStack = p.valueStack[:base]
+	return values
+}
+
+// position returns the position of the given offset within the normalized
+// source, with the Offset adjusted to account for any removed carriage
+// returns.
+func (p *parser) position(offset int) Position {
+	offset = min(offset, len(p.src))
+	if p.lines == nil {
+		p.lines = make([]int, 1, bytes.Count(p.src, []byte("\n"))+1)
+		for i, c := range p.src {
+			if c == '\n' {
+				p.lines = append(p.lines, i+1)
+			}
+		}
+	}
+	// Positions are mostly looked up in source order, so check the line of
+	// the last position, and the one after it, before searching.
+	line := p.lastLine
+	switch {
+	case line > 0 && p.lines[line-1] <= offset && (line == len(p.lines) || offset < p.lines[line]):
+	case line > 0 && line < len(p.lines) && offset >= p.lines[line] && (line+1 == len(p.lines) || offset < p.lines[line+1]):
+		line++
+	default:
+		line, _ = slices.BinarySearch(p.lines, offset+1)
+	}
+	p.lastLine = line
+	removed := 0
+	if len(p.crlf) > 0 {
+		removed, _ = slices.BinarySearch(p.crlf, offset)
+	}
+	return Position{
+		Column: offset - p.lines[line-1] + 1,
+		File:   p.file,
+		Line:   line + p.lineBase,
+		Offset: offset + removed + p.offsetBase,
+	}
+}
+
+// pushNodes parses the nodes up to the end of the current block, or of the
+// document, and pushes them onto the node stack.
+func (p *parser) pushNodes(keys keySet, inBlock bool) error {
+	for {
+
+		if err := p.checkTokens(p.pos); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if p.eof() {
+			if p.depth > 0 {
+				err := p.errorf(p.pos, CodeUnexpectedEOF, "unexpected end of file, %d unclosed block(s)", p.depth).expect("'}'")
+				if !p.report(err) {
+					return err
+				}
+				p.depth = 0
+			}
+			return nil
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.pos++
+		case p.hasPrefix("//"):
+			p.nodeStack = append(p.nodeStack, p.parseComment(false))
+		case c == '}':
+			if !inBlock {
+				err := p.errorf(p.pos, CodeUnexpectedToken, "unexpected '}' without matching '{'")
+				if !p.report(err) {
+					return err
+				}
+				p.pos++
+				continue
+			}
+			p.emit(TokenCloseBrace, p.pos, p.pos+1, "")
+			p.pos++
+			p.depth--
+			return nil
+		case c == '{':
+			err := p.errorf(p.pos, CodeInvalidIdentifier, "unnamed blocks are not allowed")
+			if !p.report(err) {
+				return err
+			}
+			p.skipEntry()
+		case c == '`':
+			err := p.errorf(p.pos, CodeInvalidIdentifier, "multiline strings cannot be used as block names or keys")
+			if !p.report(err) {
+				return err
+			}
+			// Skip the whole string, as it may span multiple lines.
+			p.parseMultiline(false)
+			p.skipEntry()
+		case c == '[' && !p.hasPrefix("[v") && !(p.spec >= 2 && (p.hasPrefix("[&") || p.hasPrefix("[*"))):
+			err := p.errorf(p.pos, CodeInvalidIdentifier, "unexpected '[' (quote keys and block names that start with '[')")
+			if !p.report(err) {
+				return err
+			}
+			p.skipEntry()
+		default:
+			if !inBlock {
+				spec, ok, err := p.parseSpec()
+				if ok {
+					if err != nil {
+						if !p.report(err) {
+							return err
+						}
+						p.skipEntry()
+						continue
+					}
+					p.nodeStack = append(p.nodeStack, spec)
+					continue
+				}
+				p.started = true
+			}
+			included, ok, err := p.parseInclude(keys)
+			if ok {
+				if err != nil {
+					if !p.report(err) {
+						return err
+					}
+					p.skipEntry()
+					continue
+				}
+				p.nodeStack = append(p.nodeStack, included...)
+				continue
+			}
+			var node Node
+			switch {
+			case p.hasPrefix("[&"):
+				node, err = p.parseAnchor(keys)
+			case p.hasPrefix("[*"):
+				node, err = p.parseReferenceEntry()
+			case c == '[':
+				node, err = p.parseVersionedBlock(keys)
+			default:
+				node, err = p.parseEntry(keys)
+			}
+			if err != nil {
+				if !p.report(err) {
+					return err
+				}
+				p.skipEntry()
+				continue
+			}
+			p.nodeStack = append(p.nodeStack, node)
+		}
+	}
+}
+
+// rawHashes returns the number of '#' characters in the opening delimiter of
+// the raw string at the current position, e.g. 1 for `r#"`, or -1 if there
+// isn't one.
+func (p *parser) rawHashes() int {
+	if !p.hasPrefix("r") {
+		return -1
+	}
+	i := p.pos + 1
+	for i < len(p.src) && p.src[i] == '#' {
+		i++
+	}
+	if i < len(p.src) && p.src[i] == '"' {
+		return i - p.pos - 1
+	}
+	return -1
+}
+
+// readComment reads a comment up to the end of the line, and consumes the
+// trailing newline if there is one.
+func (p *parser) readComment() string {
+	end := p.lineEnd()
+	text := strings.TrimPrefix(p.text[p.pos+2:end], " ")
+	p.emit(TokenComment, p.pos, end, text)
+	p.pos = min(end+1, len(p.src))
+	return text
+}
+
+// report records the given error when parsing leniently, and returns whether
+// it did, in which case the caller should recover from the error and continue.
+func (p *parser) report(err error) bool {
+	if !p.lenient {
+		return false
+	}
+	p.errs = append(p.errs, err.(*Error))
+	return true
+}
+
+// skipEntry skips the rest of the line after an invalid entry. If the line
+// ends with an opening brace, the block that it opens is skipped too, so that
+// its contents and closing brace aren't reported as further errors.
+func (p *parser) skipEntry() {
+	end := p.lineEnd()
+	line := p.src[p.pos:end]
+	if i := bytes.Index(line, []byte(" //")); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if bytes.HasSuffix(line, []byte("{")) {
+		p.pos += len(line) - 1
+		if err := p.parseBlock(&Block{}, keySet{}); err != nil {
+			p.report(err)
+		}
+		return
+	}
+	p.pos = min(end+1, len(p.src))
+}
+
+func (p *parser) skipSpace() {
+	p.pos = skipSpace(p.src, p.pos)
+}
+
+// unescape decodes any `<|0xNN|>` byte escapes within s. Errors are reported
+// at the given offset.
+func (p *parser) unescape(s string, offset int) (string, error) {
+	idx := strings.Index(s, "<|0x")
+	if idx == -1 {
+		return s, nil
+	}
+	// When recovering from errors, strings with invalid escapes are kept as
+	// written.
+	raw := s
+	fail := func(err *Error) (string, error) {
+		if p.report(err) {
+			return raw, nil
+		}
+		return "", err
+	}
+	var b strings.Builder
+	for idx != -1 {
+		b.WriteString(s[:idx])
+		rest := s[idx+4:]
+		if len(rest) < 4 {
+			return fail(p.errorf(offset, CodeInvalidEscape, "incomplete byte escape sequence at end of string"))
+		}
+		hi, lo := unhex(rest[0]), unhex(rest[1])
+		if hi < 0 {
+			return fail(p.errorf(offset, CodeInvalidEscape, "invalid hex digit %q in byte escape sequence", rest[0]))
+		}
+		if lo < 0 {
+			return fail(p.errorf(offset, CodeInvalidEscape, "invalid hex digit %q in byte escape sequence", rest[1]))
+		}
+		if rest[2:4] != "|>" {
+			return fail(p.errorf(offset, CodeInvalidEscape, "byte escape sequence missing closing |>"))
+		}
+		b.WriteByte(byte(hi<<4 | lo))
+		s = rest[4:]
+		idx = strings.Index(s, "<|0x")
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+// unterminatedMultiline returns the error for a multiline string without its
+// closing backticks, or nil if it was recorded in lenient mode.
+func (p *parser) unterminatedMultiline(inList bool) error {
+	msg := "unterminated multiline string"
+	if inList {
+		msg += " in list"
+	}
+	err := p.errorf(len(p.src), CodeUnterminatedString, "%s", msg)
+	if !p.report(err) {
+		return err
+	}
+	p.pos = len(p.src)
+	return nil
+}
+
+func (p *parser) validate() error {
+	for i := 0; i < len(p.src); {
+		c := p.src[i]
+		if c == '\r' {
+			if err := p.errorf(i, CodeInvalidEncoding, "carriage returns must be written as the <|0x0D|> byte escape"); !p.report(err) {
+				return err
+			}
+		}
+		if c < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(p.src[i:])
+		if r == utf8.RuneError && size == 1 {
+			if err := p.errorf(i, CodeInvalidEncoding, "invalid UTF-8 byte sequence (use <|0xNN|> byte escapes)"); !p.report(err) {
+				return err
+			}
+		}
+		i += size
+	}
+	return nil
+}
+
+// Parse parses the given XON source into a list of top-level nodes. If the
+// source is invalid, the returned error will be of type *Error.
+//
+// The source is copied once, and the strings within the nodes share memory
+// with that copy instead of being copied individually, with byte escapes only
+// being decoded for the strings that contain them. Nodes are allocated in
+// chunks, which can be reused across documents with ParseArena.
+func Parse(src []byte) ([]Node, error) {
+	return newParser(src).parse()
+}
+
+// ParseAll parses the given XON source like Parse, but recovers from errors
+// instead of stopping at the first one, so that tools like editors can still
+// analyze the rest of a document while it's being edited. It returns the nodes
+// that could be parsed, along with every error found, ordered by position.
+//
+// Invalid entries are skipped up to the end of their line, and if the line
+// opens a block, up to the end of that block. Invalid list elements are
+// skipped up to the end of their line, or of the list if it closes first.
+// Strings with invalid byte escapes are kept as written.
+func ParseAll(src []byte) ([]Node, []*Error) {
+	p := newParser(src)
+	p.lenient = true
+	nodes, err := p.parse()
+	if err != nil {
+		p.report(err)
+	}
+	slices.SortStableFunc(p.errs, func(a, b *Error) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return nodes, p.errs
+}
+
+// ParseLimited parses the given XON source like Parse, but stops with an error
+// with the CodeLimitExceeded code as soon as any of the given limits is
+// exceeded, e.g. when parsing documents from untrusted sources.
+func ParseLimited(src []byte, limits Limits) ([]Node, error) {
+	p, err := newLimitedParser(src, limits)
+	if err != nil {
+		return nil, err
+	}
+	return p.parse()
+}
+
+// cloneNode returns a deep copy of the given node.
+func cloneNode(node Node) Node {
+	switch node := node.(type) {
+	case *Block:
+		clone := *node
+		clone.Nodes = cloneNodes(node.Nodes)
+		return &clone
+	case *Comment:
+		clone := *node
+		return &clone
+	case *Include:
+		clone := *node
+		return &clone
+	case *KeyValue:
+		clone := *node
+		clone.Value = cloneValue(node.Value)
+		return &clone
+	case *Reference:
+		return cloneValue(node).(*Reference)
+	case *Spec:
+		clone := *node
+		return &clone
+	case *VersionedBlock:
+		clone := *node
+		clone.Block = cloneNode(node.Block).(*Block)
+		return &clone
+	}
+	return node
+}
+
+// cloneNodes returns a deep copy of the given nodes.
+func cloneNodes(nodes []Node) []Node {
+	clone := make([]Node, len(nodes))
+	for i, node := range nodes {
+		clone[i] = cloneNode(node)
+	}
+	return clone
+}
+
+// cloneValue returns a deep copy of the given value.
+func cloneValue(value Value) Value {
+	switch value := value.(type) {
+	case *Bytes:
+		clone := *value
+		clone.Data = bytes.Clone(value.Data)
+		return &clone
+	case *Comment:
+		clone := *value
+		return &clone
+	case *List:
+		clone := *value
+		clone.Content = make([]Value, len(value.Content))
+		for i, elem := range value.Content {
+			clone.Content[i] = cloneValue(elem)
+		}
+		return &clone
+	case *Reference:
+		clone := *value
+		if value.Nodes != nil {
+			clone.Nodes = cloneNodes(value.Nodes)
+		}
+		if value.Value != nil {
+			clone.Value = cloneValue(value.Value)
+		}
+		return &clone
+	case *String:
+		clone := *value
+		return &clone
+	}
+	return value
+}
+
+// flattenReferences returns the given nodes, with any references replaced by
+// the nodes that they merge in.
+func flattenReferences(nodes []Node) []Node {
+	if !slices.ContainsFunc(nodes, func(node Node) bool {
+		_, ok := node.(*Reference)
+		return ok
+	}) {
+		return nodes
+	}
+	var flat []Node
+	for _, node := range nodes {
+		if ref, ok := node.(*Reference); ok {
+			flat = append(flat, flattenReferences(ref.Nodes)...)
+		} else {
+			flat = append(flat, node)
+		}
+	}
+	return flat
+}
+
+// hasReferences returns whether there are any references within the given
+// nodes, including within versioned blocks.
+func hasReferences(nodes []Node) bool {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *Reference:
+			return true
+		case *VersionedBlock:
+			if hasReferences(node.Block.Nodes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// heredocMarker returns the length of the `|` or `|-` marker line which starts
+// src, including its newline, or 0 if src doesn't start with one.
+func heredocMarker(src []byte) int {
+	if len(src) == 0 || src[0] != '|' {
+		return 0
+	}
+	i := 1
+	if i < len(src) && src[i] == '-' {
+		i++
+	}
+	i = skipSpace(src, i)
+	if i < len(src) && src[i] == '\n' {
+		return i + 1
+	}
+	return 0
+}
+
+func indentWidth(line string) int {
+	width := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// isAnchorChar returns whether c can be used within the names of anchors, i.e.
+// letters, digits, `_`, and `-`.
+func isAnchorChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isListBoundary(src []byte, i int) bool {
+	if i >= len(src) {
+		return true
+	}
+	switch src[i] {
+	case ' ', '\t', '\n', ',', ']':
+		return true
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// marshalJSON encodes v without escaping HTML characters, so that messages
+// like "missing closing |>" are left as is.
+func marshalJSON(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// mergeReferences drops the keys and blocks merged in by the references
+// within the given nodes of a block, if they're defined directly within the
+// block, including within versioned blocks, or merged in by an earlier
+// reference. This lets entries override those merged in, with blocks being
+// replaced as a whole.
+func mergeReferences(nodes []Node) {
+	if !hasReferences(nodes) {
+		return
+	}
+	seen := map[string]bool{}
+	var (
+		drop func(nodes []Node) []Node
+		mark func(nodes []Node, merged bool)
+		walk func(nodes []Node)
+	)
+	drop = func(nodes []Node) []Node {
+		return slices.DeleteFunc(nodes, func(node Node) bool {
+			switch node := node.(type) {
+			case *Block:
+				return seen[node.Name]
+			case *KeyValue:
+				return seen[node.Key]
+			case *Reference:
+				node.Nodes = drop(node.Nodes)
+			case *VersionedBlock:
+				node.Block.Nodes = drop(node.Block.Nodes)
+			}
+			return false
+		})
+	}
+	mark = func(nodes []Node, merged bool) {
+		for _, node := range nodes {
+			switch node := node.(type) {
+			case *Block:
+				seen[node.Name] = true
+			case *KeyValue:
+				seen[node.Key] = true
+			case *Reference:
+				if merged {
+					mark(node.Nodes, true)
+				}
+			case *VersionedBlock:
+				mark(node.Block.Nodes, merged)
+			}
+		}
+	}
+	walk = func(nodes []Node) {
+		for _, node := range nodes {
+			switch node := node.(type) {
+			case *Reference:
+				if node.Nodes != nil {
+					node.Nodes = drop(node.Nodes)
+					mark(node.Nodes, true)
+				}
+			case *VersionedBlock:
+				walk(node.Block.Nodes)
+			}
+		}
+	}
+	mark(nodes, false)
+	walk(nodes)
+}
+
+// newLimitedParser returns a parser for the given source with the given limits,
+// after checking the size of the source, so that oversized sources are
+// rejected before any work is done on them.
+func newLimitedParser(src []byte, limits Limits) (*parser, error) {
+	if limits.MaxSize > 0 && len(src) > limits.MaxSize {
+		return nil, &Error{
+			Code:    CodeLimitExceeded,
+			Column:  1,
+			Line:    1,
+			Message: fmt.Sprintf("input size exceeds the limit of %d bytes", limits.MaxSize),
+		}
+	}
+	p := newParser(src)
+	p.limits = limits
+	p.size = len(src)
+	return p, nil
+}
+
+// newParser returns a parser for the given source, with any CRLF line endings
+// normalized to LF.
+func newParser(src []byte) *parser {
+	p := &parser{anchorSizes: map[string]anchorSize{}, anchors: map[string]Node{}, arena: &Arena{}}
+	if bytes.Contains(src, []byte("\r\n")) {
+		normalized := make([]byte, 0, len(src))
+		for i := 0; i < len(src); i++ {
+			if src[i] == '\r' && i+1 < len(src) && src[i+1] == '\n' {
+				p.crlf = append(p.crlf, len(normalized))
+				continue
+			}
+			normalized = append(normalized, src[i])
+		}
+		src = normalized
+	}
+	p.src = src
+	p.text = string(src)
+	return p
+}
+
+// referencedValue returns the given value, or the value that it refers to if
+// it's a reference.
+func referencedValue(value Value) Value {
+	for {
+		ref, ok := value.(*Reference)
+		if !ok {
+			return value
+		}
+		value = ref.Value
+	}
+}
+
+func skipSpace(src []byte, i int) int {
+	for i < len(src) && isSpace(src[i]) {
+		i++
+	}
+	return i
+}
+
+// specVersion returns the minor version of the spec declared by the spec line
+// within the given top-level nodes, or 0 if there isn't one.
+func specVersion(nodes []Node) int {
+	for _, node := range nodes {
+		if spec, ok := node.(*Spec); ok {
+			return specVersions[spec.Version]
+		}
+	}
+	return 0
+}
+
+func stripIndent(line string, width int) string {
+	seen := 0
+	for i := 0; i < len(line); i++ {
+		if seen >= width {
+			return line[i:]
+		}
+		switch line[i] {
+		case ' ':
+			seen++
+		case '\t':
+			seen += 4
+		}
+		if seen > width {
+			return strings.Repeat(" ", seen-width) + line[i+1:]
+		}
+	}
+	return ""
+}
+
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
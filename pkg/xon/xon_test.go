@@ -5,54 +5,3396 @@ package xon
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net"
+	"net/netip"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
-func TestParse(t *testing.T) {
-	data, err := os.ReadFile("parse.tests")
+// level is an enum that is encoded as text.
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case 0:
+		return []byte("debug"), nil
+	case 1:
+		return []byte("info"), nil
+	}
+	return nil, fmt.Errorf("unknown level %d", l)
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
+
+// span is encoded as a list of its start and end.
+type span struct {
+	start int
+	end   int
+}
+
+func (s span) MarshalXON() (Value, error) {
+	return &List{Content: []Value{
+		&String{Value: strconv.Itoa(s.start)},
+		&String{Value: strconv.Itoa(s.end)},
+	}}, nil
+}
+
+func (s *span) UnmarshalXON(value Value) error {
+	list, ok := value.(*List)
+	if !ok || len(list.Content) != 2 {
+		return errors.New("expected a list of 2 elements")
+	}
+	start, err := strconv.Atoi(list.Content[0].(*String).Value)
 	if err != nil {
-		t.Fatalf("failed to read parse.tests: %v", err)
+		return err
 	}
-	buf := &bytes.Buffer{}
-	enc := json.NewEncoder(buf)
-	enc.SetIndent("", "")
-	tests := strings.Split(string(data), "-----\n")
-	for _, test := range tests {
-		test = strings.TrimSpace(test)
-		if test == "" {
+	end, err := strconv.Atoi(list.Content[1].(*String).Value)
+	if err != nil {
+		return err
+	}
+	*s = span{start, end}
+	return nil
+}
+
+func TestAny(t *testing.T) {
+	src := `// Servers.
+name = edge
+proxy = nil
+tags = [a, "b c"]
+token = b64"aGVsbG8="
+
+server {
+    host = a.espra.dev
+}
+
+server {
+    host = b.espra.dev
+}
+
+[v2] {
+    region = eu
+}
+`
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	got := ToAny(nodes)
+	want := map[string]any{
+		"name":   "edge",
+		"proxy":  nil,
+		"region": "eu",
+		"server": []any{map[string]any{"host": "a.espra.dev"}, map[string]any{"host": "b.espra.dev"}},
+		"tags":   []any{"a", "b c"},
+		"token":  []byte("hello"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected result from ToAny: got %#v, want %#v", got, want)
+	}
+	if got := ToAny(nil); !reflect.DeepEqual(got, map[string]any{}) {
+		t.Errorf("unexpected result from ToAny for no nodes: %#v", got)
+	}
+	nodes, err = FromAny(want)
+	if err != nil {
+		t.Fatalf("failed to convert from any: %v", err)
+	}
+	data, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	wantData := `name = edge
+proxy = nil
+region = eu
+
+server {
+    host = a.espra.dev
+}
+
+server {
+    host = b.espra.dev
+}
+
+tags = [a, "b c"]
+token = b64"aGVsbG8="
+`
+	if string(data) != wantData {
+		t.Errorf("unexpected output from FromAny:\n\n%s\n\nwant:\n\n%s", data, wantData)
+	}
+	for _, v := range []any{nil, "a", []any{1}, map[string]any{"a": []any{map[string]any{}, "b"}}} {
+		if _, err := FromAny(v); err == nil {
+			t.Errorf("expected an error when converting %#v", v)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	for _, tt := range []struct {
+		size ByteSize
+		want string
+	}{
+		{0, "0B"},
+		{1536, "1536B"},
+		{4 << 20, "4MiB"},
+		{1_500_000_000, "1500MB"},
+		{1_024_000, "1000KiB"},
+		{1 << 63, "8EiB"},
+	} {
+		if got := tt.size.String(); got != tt.want {
+			t.Errorf("ByteSize(%d).String() = %q, want %q", uint64(tt.size), got, tt.want)
+		}
+		if got, err := parseByteSize(tt.want); err != nil || got != tt.size {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.want, got, err, tt.size)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	nodes, err := Parse([]byte(`// Service config.
+xon 0.2
+
+[&defaults] defaults {
+    retries = 3
+}
+
+server {
+    port = 8080  // The public port.
+    host = "example.com"
+}
+
+server {
+    [*defaults]
+    host = backup.example.com
+    tags = [
+        b,  // Second.
+        r"a",
+    ]
+}
+
+[v2] {
+    mode = fast
+}
+
+name  = ` + "`say \"hi\"`" + `
+data  = hex"6869"
+empty {
+    // Nothing yet.
+}
+note = nil
+quoted = "nil"
+extra += [x]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `xon 0.2
+"data" = b64"aGk="
+"defaults" {
+    "retries" = "3"
+}
+"empty" {}
+"extra" += ["x"]
+"name" = "say <|0x22|>hi<|0x22|>"
+"note" = nil
+"quoted" = "nil"
+"server" {
+    "host" = "example.com"
+    "port" = "8080"
+}
+"server" {
+    "host" = "backup.example.com"
+    "retries" = "3"
+    "tags" = ["b", "a"]
+}
+[v2] {
+    "mode" = "fast"
+}
+`
+	got := Canonical(nodes)
+	if string(got) != want {
+		t.Errorf("Canonical() = %s, want %s", got, want)
+	}
+	reparsed, err := Parse(got)
+	if err != nil {
+		t.Fatalf("failed to parse the canonical form: %v", err)
+	}
+	if !bytes.Equal(Canonical(reparsed), got) {
+		t.Errorf("Canonical() of the canonical form = %s, want %s", Canonical(reparsed), got)
+	}
+	if CanonicalHash(reparsed) != CanonicalHash(nodes) {
+		t.Errorf("expected the canonical form to have the same hash")
+	}
+	other, err := Parse([]byte("quoted = nil\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CanonicalHash(other) == CanonicalHash([]Node{&KeyValue{Key: "quoted", Value: &String{Value: "nil", Quoted: true}}}) {
+		t.Errorf("expected nil and the quoted string \"nil\" to have different hashes")
+	}
+	if got := Canonical(nil); len(got) != 0 {
+		t.Errorf("Canonical(nil) = %q, want an empty document", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type Server struct {
+		Host string `xon:"host"`
+		Port uint16 `xon:"port"`
+	}
+	type Config struct {
+		Name    string   `xon:"name"`
+		Servers []Server `xon:"server"`
+	}
+	src := []byte(`xon 0.2
+
+name = edge
+
+server {
+    host = a.espra.dev
+    port = 8040
+}
+
+server {
+    host = b.espra.dev
+    port = 8041
+}
+
+[&base] defaults {
+    host = localhost
+}
+
+[v2] {
+    port = 9000
+}
+
+local {
+    [*base]
+    port = 99999
+}
+`)
+	cfg, err := Decode[Config](src)
+	if err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	if cfg.Name != "edge" || len(cfg.Servers) != 2 || cfg.Servers[1] != (Server{"b.espra.dev", 8041}) {
+		t.Errorf("unexpected config: %#v", cfg)
+	}
+	if ptr, err := Decode[*Config](src); err != nil || ptr.Name != "edge" {
+		t.Errorf("unexpected result when decoding into a pointer: %#v, %v", ptr, err)
+	}
+	if _, err := Decode[int](src); err == nil {
+		t.Errorf("expected an error when decoding a document into an int")
+	}
+	nodes, err := Parse(src)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	nodes = nodes[1:]
+	name, err := DecodeNode[string](nodes[0])
+	if err != nil || name != "edge" {
+		t.Errorf("unexpected result when decoding a key/value pair: %q, %v", name, err)
+	}
+	server, err := DecodeNode[Server](nodes[1])
+	if err != nil || server != (Server{"a.espra.dev", 8040}) {
+		t.Errorf("unexpected result when decoding a block: %#v, %v", server, err)
+	}
+	server, err = DecodeNode[Server](nodes[4])
+	if err != nil || server != (Server{"", 9000}) {
+		t.Errorf("unexpected result when decoding a versioned block: %#v, %v", server, err)
+	}
+	local := nodes[5].(*Block)
+	server, err = DecodeNode[Server](local.Nodes[0])
+	if err != nil || server != (Server{"localhost", 0}) {
+		t.Errorf("unexpected result when decoding a reference: %#v, %v", server, err)
+	}
+	_, err = DecodeNode[Server](local)
+	if want := `xon: "local.port": cannot decode "99999" as uint16: value out of range`; err == nil || err.Error() != want {
+		t.Errorf("unexpected error when decoding a block: got %v, want %s", err, want)
+	}
+	if _, err := DecodeNode[Server](&Comment{Text: "note"}); err == nil {
+		t.Errorf("expected an error when decoding a comment")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	type Server struct {
+		Host    string `xon:"host"`
+		Port    int    `xon:"port"`
+		TLSMode string `xon:"tls mode"`
+	}
+	type Config struct {
+		Server Server `xon:"server"`
+	}
+	src := "server {\n    host = example.com\n    port = 8080\n    [v5] {\n        tls mode = strict\n    }\n}\n"
+	for _, tt := range []struct {
+		version int64
+		want    Server
+	}{
+		{4, Server{"example.com", 8080, ""}},
+		{5, Server{"example.com", 8080, "strict"}},
+	} {
+		cfg := &Config{}
+		dec := NewDecoder(strings.NewReader(src))
+		dec.SetVersion(tt.version)
+		if err := dec.Decode(cfg); err != nil {
+			t.Fatalf("failed to decode for version %d: %v", tt.version, err)
+		}
+		if cfg.Server != tt.want {
+			t.Errorf("unexpected result for version %d: got %+v, want %+v", tt.version, cfg.Server, tt.want)
+		}
+		if err := dec.Decode(cfg); err != io.EOF {
+			t.Errorf("expected io.EOF from a second call to Decode, got %v", err)
+		}
+	}
+	dec := NewDecoder(strings.NewReader("server {\n    hots = example.com\n}\n"))
+	dec.SetVersion(1)
+	want := `xon: "server.hots": unknown key at 2:5 for xon.Server`
+	if err := dec.Decode(&Config{}); err == nil || err.Error() != want {
+		t.Errorf("unexpected error for an unknown key: got %v, want %s", err, want)
+	}
+	src = "server {\n    include \"conf/server.xon\"\n}\n"
+	fsys := fstest.MapFS{"conf/server.xon": {Data: []byte("host = example.com\nport = 8080\n")}}
+	cfg := &Config{}
+	dec = NewDecoder(strings.NewReader(src))
+	dec.SetLoader(FSLoader(fsys))
+	if err := dec.Decode(cfg); err != nil {
+		t.Fatalf("failed to decode with a loader: %v", err)
+	}
+	if want := (Server{Host: "example.com", Port: 8080}); cfg.Server != want {
+		t.Errorf("unexpected result when decoding with a loader: got %+v, want %+v", cfg.Server, want)
+	}
+	want = `xon: 2:5: failed to include "conf/server.xon": no loader has been set, see Decoder.SetLoader`
+	if err := Unmarshal([]byte(src), &Config{}); err == nil || err.Error() != want {
+		t.Errorf("unexpected error for an include without a loader: got %v, want %s", err, want)
+	}
+	// Errors are returned as soon as they're read, even if the input never
+	// ends, e.g. for a connection that's kept open.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("server {\n    host = a.example.com\n}\nserver {\n    port = 80\n}\nport = 80\nport = 443\n"))
+	want = `xon: 8:1: duplicate key "port", first defined at 7:1`
+	if err := NewDecoder(pr).Decode(&Config{}); err == nil || err.Error() != want {
+		t.Errorf("unexpected error when decoding an unterminated stream: got %v, want %s", err, want)
+	}
+}
+
+func TestDecoderDuplicates(t *testing.T) {
+	type Config struct {
+		Host string `xon:"host"`
+		Port int    `xon:"port"`
+	}
+	for _, tt := range []struct {
+		src    string
+		policy DuplicateKeyPolicy
+		want   any
+	}{
+		{"host = a\nport = 1\nhost = b\n", DuplicateKeyError, `xon: 3:1: duplicate key "host", first defined at 1:1`},
+		{"host = a\nport = 1\nhost = b\n", DuplicateKeyFirstWins, Config{"a", 1}},
+		{"host = a\nport = 1\nhost = b\n", DuplicateKeyLastWins, Config{"b", 1}},
+		{"port = 1\nPort = 2\n", DuplicateKeyError, `xon: "Port": duplicate key at 2:1, first defined as "port" at 1:1`},
+		{"port = 1\nPort = 2\n", DuplicateKeyFirstWins, Config{Port: 1}},
+		{"port = 1\nPort = 2\n", DuplicateKeyLastWins, Config{Port: 2}},
+		{"host = a\nhost {}\n", DuplicateKeyError, `xon: "host": duplicate key at 2:1, first defined at 1:1`},
+		{"port = 1\n[v2] {\n    port = 2\n}\n", DuplicateKeyLastWins, Config{Port: 2}},
+	} {
+		cfg := Config{}
+		dec := NewDecoder(strings.NewReader(tt.src))
+		dec.SetDuplicateKeyPolicy(tt.policy)
+		err := dec.Decode(&cfg)
+		if want, ok := tt.want.(string); ok {
+			if err == nil || err.Error() != want {
+				t.Errorf("unexpected error when decoding %q with policy %d: got %v, want %s", tt.src, tt.policy, err, want)
+			}
 			continue
 		}
-		split := strings.SplitN(test, "---\n", 2)
-		if len(split) != 2 {
-			t.Errorf("failed to split test: %v", test)
+		if err != nil {
+			t.Errorf("failed to decode %q with policy %d: %v", tt.src, tt.policy, err)
 			continue
 		}
-		src, want := split[0], split[1]
+		if cfg != tt.want {
+			t.Errorf("unexpected result when decoding %q with policy %d: got %+v, want %+v", tt.src, tt.policy, cfg, tt.want)
+		}
+	}
+	m := map[int]string{}
+	want := `xon: "0x1": duplicate key at 2:1, first defined as "1" at 1:1`
+	if err := Unmarshal([]byte("1 = a\n0x1 = b\n"), &m); err == nil || err.Error() != want {
+		t.Errorf("unexpected error for keys decoding into the same map key: got %v, want %s", err, want)
+	}
+}
+
+func TestDecoderEnv(t *testing.T) {
+	type Config struct {
+		Extra   any      `xon:"extra"`
+		Host    string   `xon:"host"`
+		Literal string   `xon:"literal"`
+		Peers   []string `xon:"peers"`
+		Port    int      `xon:"port"`
+		Proxy   *string  `xon:"proxy"`
+	}
+	src := `host = ${HOST}:${PORT:-8080}
+port = ${PORT:-8080}
+peers = [${PEER}, "${MISSING:-none}"]
+literal = r"${HOST}"
+proxy = nil
+extra = [${PEER}, nil]
+`
+	env := map[string]string{"HOST": "example.com", "PEER": "peer.example.com", "PORT": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	cfg := &Config{}
+	dec := NewDecoder(strings.NewReader(src))
+	dec.SetEnv(lookup)
+	if err := dec.Decode(cfg); err != nil {
+		t.Fatalf("failed to decode with env interpolation: %v", err)
+	}
+	want := &Config{
+		Extra:   []any{"peer.example.com", nil},
+		Host:    "example.com:8080",
+		Literal: "${HOST}",
+		Peers:   []string{"peer.example.com", "none"},
+		Port:    8080,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("unexpected result with env interpolation: got %+v, want %+v", cfg, want)
+	}
+	m := map[string]any{}
+	if err := Unmarshal([]byte(src), &m); err != nil {
+		t.Fatalf("failed to decode without env interpolation: %v", err)
+	}
+	if m["host"] != "${HOST}:${PORT:-8080}" {
+		t.Errorf("unexpected interpolation without SetEnv: got %q", m["host"])
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"host = ${NOPE}", `xon: "host": environment variable NOPE is not set`},
+		{"peers = [a, ${1X}]", `xon: "peers[1]": invalid environment variable reference "${1X}"`},
+		{"extra = [${HOST]", `xon: "extra[0]": missing closing '}' in environment variable reference "${HOST"`},
+	} {
+		dec := NewDecoder(strings.NewReader(tt.src))
+		dec.SetEnv(lookup)
+		if err := dec.Decode(&Config{}); err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error for %q: got %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestDecoderValidation(t *testing.T) {
+	type TLS struct {
+		Cert string `xon:"cert,required"`
+	}
+	type Server struct {
+		Host string  `xon:"host,required"`
+		Port int     `xon:"port"`
+		TLS  *TLS    `xon:"tls"`
+		Via  *string `xon:"via,required"`
+	}
+	type Config struct {
+		Extra   map[string]string `xon:",inline"`
+		Servers []Server          `xon:"server,required"`
+	}
+	type Strict struct {
+		Server Server `xon:"server"`
+	}
+	for _, tt := range []struct {
+		src  string
+		v    any
+		want string
+	}{
+		{"server {\n    host = a\n    via = nil\n}\nregion = eu\n", &Config{}, ""},
+		{"region = eu\n", &Config{}, `xon: "server": missing required key for xon.Config`},
+		{"server {\n    host = a\n    via = b\n}\nserver {\n    port = 80\n}\n", &Config{}, `xon: "server[1].host": missing required key for xon.Server`},
+		{"server {\n    host = a\n    via = b\n    tls {}\n}\n", &Config{}, `xon: "server[0].tls.cert": missing required key for xon.TLS`},
+		{"server {\n    host = a\n    via = b\n    prot = 80\n}\n", &Config{}, `xon: "server[0].prot": unknown key at 4:5 for xon.Server`},
+		{"server {\n    host = a\n    via = b\n}\nservers = 2\n", &Strict{}, `xon: "servers": unknown key at 5:1 for xon.Strict`},
+	} {
+		dec := NewDecoder(strings.NewReader(tt.src))
+		dec.DisallowUnknownFields()
+		dec.RequireFields()
+		err := dec.Decode(tt.v)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("failed to decode %q: %v", tt.src, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error when decoding %q: got %v, want %s", tt.src, err, tt.want)
+		}
+		// Without the options, the same sources decode fine.
+		if err := Unmarshal([]byte(tt.src), tt.v); err != nil {
+			t.Errorf("failed to decode %q without validation: %v", tt.src, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	parse := func(src string) []Node {
 		nodes, err := Parse([]byte(src))
-		buf.Reset()
 		if err != nil {
-			jerr := enc.Encode(err)
-			if jerr != nil {
-				t.Errorf("failed to marshal parse error: %v", jerr)
-				continue
+			t.Fatalf("failed to parse %q: %v", src, err)
+		}
+		return nodes
+	}
+	a := parse(`xon 0.2
+
+[&port] port = 8080
+
+// Logging settings.
+log {
+    level = info
+    format = json
+}
+
+server {
+    listen = [[*port], 8443]
+    tls = off
+}
+
+node {
+    id = 1
+}
+
+node {
+    id = 2
+}
+
+debug = true
+cache = nil
+
+[v2] {
+    mode = legacy
+}
+`)
+	b := parse(`port = 9090
+
+log {
+    level = info  // unchanged
+    format = "json"
+}
+
+server {
+    listen = [
+        8080  // from port
+        8443
+    ]
+    tls {
+        mode = strict
+    }
+}
+
+node {
+    id = 1
+}
+
+cache = "nil"
+cluster = prod
+
+[v2] {
+    mode = fast
+}
+`)
+	changes := Diff(a, b)
+	var got []string
+	for _, change := range changes {
+		got = append(got, fmt.Sprintf("%s %s", change.Kind, change.Path))
+	}
+	want := []string{
+		"modified port",
+		"removed server.tls",
+		"added server.tls",
+		"removed node[1]",
+		"removed debug",
+		"modified cache",
+		"modified [v2].mode",
+		"added cluster",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected changes:\n\n%s\n\nwant:\n\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	out := FormatDiff(changes)
+	wantOut := `- port = 8080
++ port = 9090
+- server.tls = off
++ server.tls {
++     mode = strict
++ }
+- "node[1]" {
+-     id = 2
+- }
+- debug = true
+- cache = nil
++ cache = "nil"
+- "[v2].mode" = legacy
++ "[v2].mode" = fast
++ cluster = prod
+`
+	if out != wantOut {
+		t.Errorf("unexpected output when formatting changes:\n\n%s\n\nwant:\n\n%s", out, wantOut)
+	}
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Errorf("unexpected changes when diffing a document with itself: %v", changes)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	src := `// Service config.
+xon 0.2
+
+name = api  // The service name.
+
+[&defaults] defaults {
+  retries = 3
+}
+
+server {
+  [*defaults]
+  host  = example.com
+  ports = [80, 443]  // Public ports.
+}
+
+cache {}
+
+tls {
+  // Nothing yet.
+}
+`
+	for _, tt := range []struct {
+		path   string
+		value  any
+		delete bool
+		want   string
+	}{
+		{path: "name", value: "web", want: "name = web  // The service name.\n"},
+		{path: "server.host", value: "a b {c}", want: "  host  = \"a b {c}\"\n"},
+		{path: "server.ports", value: []int{8080}, want: "  ports = [8080]  // Public ports.\n"},
+		{path: "server.ports[1]", value: 8443, want: "  ports = [80, 8443]  // Public ports.\n"},
+		{path: "server.retries", value: 5, want: "  ports = [80, 443]  // Public ports.\n  retries = 5\n}\n"},
+		{path: "server.tls.mode", value: "strict", want: "  ports = [80, 443]  // Public ports.\n\n  tls {\n    mode = strict\n  }\n}\n"},
+		{path: "cache.size", value: "1GB", want: "\ncache {\n  size = 1GB\n}\n"},
+		{path: "tls.cert", value: "/etc/cert.pem", want: "tls {\n  // Nothing yet.\n  cert = /etc/cert.pem\n}\n"},
+		{path: "debug", value: true, want: "  // Nothing yet.\n}\n\ndebug = true\n"},
+		{path: "note", value: "line 1\nline 2", want: "\nnote = `\n  line 1\n  line 2\n`\n"},
+		{path: "key", value: &Bytes{Data: []byte("hi"), Hex: true}, want: "\nkey = hex\"6869\"\n"},
+		{path: "name", delete: true, want: "// Service config.\nxon 0.2\n\n[&defaults]"},
+		{path: "server.ports", delete: true, want: "  host  = example.com\n}\n"},
+		{path: "cache", delete: true, want: "}\n\ntls {\n"},
+		{path: "tls", delete: true, want: "cache {}\n"},
+		{path: "server", value: "x", want: `xon: "server": cannot set the value of a block`},
+		{path: "name.first", value: "x", want: `xon: "name.first": cannot set a value within name, as it isn't a block`},
+		{path: "server.ports[5]", value: 1, want: `xon: "server.ports[5]": no entry to set, and "ports" can't be added as it has a wildcard or selector`},
+		{path: "server", value: map[string]int{"a": 1}, want: `xon: "server": cannot set map[string]int as a value, as it would be a block`},
+		{path: "name", value: "]", want: "name = \"]\"  // The service name.\n"},
+		{path: "server.retries", delete: true, want: `xon: "server.retries": no entry to delete`},
+		{path: "server.ports[0]", delete: true, want: `xon: "server.ports[0]": cannot delete a list element, set the list instead`},
+		{path: "*", delete: true, want: `xon: "*": matches 5 entries, e.g. name and defaults, instead of a single one`},
+		{path: "server..", value: 1, want: `xon: invalid query "server..": missing name at offset 7`},
+	} {
+		e, err := NewEdit([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.delete {
+			err = e.Delete(tt.path)
+		} else {
+			err = e.Set(tt.path, tt.value)
+		}
+		if strings.HasPrefix(tt.want, "xon: ") {
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("editing %q = %v, want %s", tt.path, err, tt.want)
 			}
-			got := strings.TrimSuffix(buf.String(), "\n")
-			if got != want {
-				t.Errorf("unexpected parse error for:\n\n%s\n---\n\ngot:\n\n%s\n\nwant:\n\n%s\n\n", src, got, want)
-				continue
+			if string(e.Bytes()) != src {
+				t.Errorf("editing %q modified the source despite failing: %s", tt.path, e.Bytes())
 			}
 			continue
 		}
-		buf.Reset()
-		for _, node := range nodes {
-			enc.Encode(node)
+		if err != nil {
+			t.Errorf("editing %q = %v", tt.path, err)
+			continue
 		}
-		got := strings.TrimSuffix(buf.String(), "\n")
-		if got != want {
-			t.Errorf("unexpected parsed result for:\n\n%s\n---\n\ngot:\n\n%s\n\nwant:\n\n%s\n\n", src, got, want)
+		got := string(e.Bytes())
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("editing %q = %s, want it to contain %q", tt.path, got, tt.want)
+		}
+		// Only the edited lines should differ.
+		before, after := strings.Split(src, "\n"), strings.Split(got, "\n")
+		for len(before) > 0 && len(after) > 0 && before[0] == after[0] {
+			before, after = before[1:], after[1:]
+		}
+		for len(before) > 0 && len(after) > 0 && before[len(before)-1] == after[len(after)-1] {
+			before, after = before[:len(before)-1], after[:len(after)-1]
+		}
+		if len(before) > 1 && len(after) > 1 {
+			t.Errorf("editing %q changed more than the edited entry: %q became %q", tt.path, before, after)
+		}
+	}
+	// Edits build on each other, and keep CRLF line endings.
+	e, err := NewEdit([]byte("a {\r\n\tb = 1\r\n}\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a.c", "a.d.e", "f"} {
+		if err := e.Set(path, 2); err != nil {
+			t.Fatal(err)
 		}
 	}
+	if err := e.Delete("a.b"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(e.Bytes()), "a {\r\n\tc = 2\r\n\r\n\td {\r\n\t\te = 2\r\n\t}\r\n}\r\n\r\nf = 2\r\n"; got != want {
+		t.Errorf("Bytes() = %q, want %q", got, want)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(map[string]any{"server": map[string]int{"port": 8080}}); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	if got, want := buf.String(), "server {\n\tport = 8080\n}\n"; got != want {
+		t.Errorf("unexpected output from Encode: got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	src := `xon 0.2
+
+name = node
+// The ports to listen on.
+listen ports = [8080, 8443]
+[&tags] tags = [web, api, internal]
+
+server {
+    timeout = 30s
+    hosts = [a.example.com, b.example.com, c.example.com]
+    host = a.example.com
+}
+
+all tags = [*tags]
+`
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	for _, tt := range []struct {
+		name  string
+		setup func(enc *Encoder)
+		want  string
+	}{
+		{"default", func(enc *Encoder) {}, src},
+		{"aligned", func(enc *Encoder) {
+			enc.SetAlignValues(true)
+		}, `xon 0.2
+
+name         = node
+// The ports to listen on.
+listen ports = [8080, 8443]
+[&tags] tags = [web, api, internal]
+
+server {
+    timeout = 30s
+    hosts   = [a.example.com, b.example.com, c.example.com]
+    host    = a.example.com
+}
+
+all tags = [*tags]
+`},
+		{"expanded", func(enc *Encoder) {
+			enc.SetExpandLists(true)
+			enc.SetIndent("", "  ")
+		}, `xon 0.2
+
+name = node
+// The ports to listen on.
+listen ports = [
+  8080
+  8443
+]
+[&tags] tags = [
+  web
+  api
+  internal
+]
+
+server {
+  timeout = 30s
+  hosts = [
+    a.example.com
+    b.example.com
+    c.example.com
+  ]
+  host = a.example.com
+}
+
+all tags = [*tags]
+`},
+		{"max width", func(enc *Encoder) {
+			enc.SetMaxWidth(40)
+		}, `xon 0.2
+
+name = node
+// The ports to listen on.
+listen ports = [8080, 8443]
+[&tags] tags = [web, api, internal]
+
+server {
+    timeout = 30s
+    hosts = [
+        a.example.com
+        b.example.com
+        c.example.com
+    ]
+    host = a.example.com
+}
+
+all tags = [*tags]
+`},
+		{"sorted", func(enc *Encoder) {
+			enc.SetSortKeys(true)
+		}, `xon 0.2
+
+// The ports to listen on.
+listen ports = [8080, 8443]
+name = node
+[&tags] tags = [web, api, internal]
+all tags = [*tags]
+
+server {
+    host = a.example.com
+    hosts = [a.example.com, b.example.com, c.example.com]
+    timeout = 30s
+}
+`},
+	} {
+		buf := &bytes.Buffer{}
+		enc := NewEncoder(buf)
+		tt.setup(enc)
+		if err := enc.Encode(nodes); err != nil {
+			t.Fatalf("failed to encode with %s options: %v", tt.name, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("unexpected output with %s options:\n\n%s\n\nwant:\n\n%s", tt.name, got, tt.want)
+		}
+	}
+	type Config struct {
+		Name  string   `xon:"name"`
+		Addrs []string `xon:"addrs"`
+	}
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	enc.SetSortKeys(true)
+	enc.SetAlignValues(true)
+	if err := enc.Encode(&Config{Name: "node", Addrs: []string{"a"}}); err != nil {
+		t.Fatalf("failed to encode struct: %v", err)
+	}
+	if got, want := buf.String(), "addrs = [a]\nname  = node\n"; got != want {
+		t.Errorf("unexpected output when encoding a sorted struct: got %q, want %q", got, want)
+	}
+}
+
+func TestEntryReader(t *testing.T) {
+	src := "// Exported rows.\r\nxon 0.2\r\n\r\n[&base] defaults {\r\n    region = eu\r\n}\r\n\r\nrow {\r\n    [*base]\r\n    id = 1\r\n    tags = [\r\n        a,\r\n        b,\r\n    ]\r\n}\r\n" +
+		"note = `\r\n    {not a block}\r\n    `\r\n[v2] {\r\n    mode = fast\r\n}\r\nrow {\r\n    id = 2\r\n}"
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewEntryReader(strings.NewReader(src))
+	var entries []Node
+	for {
+		node, err := r.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadEntry() = %v", err)
+		}
+		entries = append(entries, node)
+	}
+	if !reflect.DeepEqual(entries, nodes) {
+		got, _ := json.Marshal(entries)
+		want, _ := json.Marshal(nodes)
+		t.Errorf("ReadEntry() = %s, want %s", got, want)
+	}
+	if r.Line() != 24 {
+		t.Errorf("Line() = %d, want 24", r.Line())
+	}
+	if _, err := r.ReadEntry(); err != io.EOF {
+		t.Errorf("ReadEntry() after the end = %v, want io.EOF", err)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"a = 1\nb {\n    c = 2\n}\na = 3\n", `5:1: duplicate key "a", first defined at 1:1`},
+		{"a = 1\n[v2] {\n    a = 2\n}\n", `3:5: duplicate key "a", first defined at 1:1`},
+		{"a {\n    b = 1\n}\n[v1] {\n}\n[v2] {\n}\n", "6:1: only one versioned block number allowed per file (found v2 after v1)"},
+		{"a {\n    b = 1\n    c\n}\n", `4:1: identifier "c" without '=' or '{'`},
+		{"xon 0.2\na = [*missing]\n", "2:5: unknown anchor"},
+		{"a {\n    b = [\n        1,\n", "4:0: unexpected end of file"},
+	} {
+		r := NewEntryReader(strings.NewReader(tt.src))
+		var err error
+		for err == nil {
+			_, err = r.ReadEntry()
+		}
+		if err == io.EOF || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ReadEntry() of %q = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+		if _, again := r.ReadEntry(); again != err {
+			t.Errorf("ReadEntry() after an error = %v, want %v", again, err)
+		}
+	}
+	// Only the lines of the current entry are held in memory.
+	pr, pw := io.Pipe()
+	go func() {
+		for i := range 10_000 {
+			fmt.Fprintf(pw, "row {\n    id = %d\n    name = `\n        row %d\n        `\n}\n", i, i)
+		}
+		pw.Close()
+	}()
+	r = NewEntryReader(pr)
+	count := 0
+	for {
+		node, err := r.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block, ok := node.(*Block); !ok || block.Name != "row" || block.Pos.Line != count*6+1 {
+			t.Fatalf("ReadEntry() = %#v, want row %d", node, count)
+		}
+		count++
+	}
+	if count != 10_000 || cap(r.buf) > 256 {
+		t.Errorf("read %d rows with a buffer of %d bytes, want 10000 rows with at most 256 bytes", count, cap(r.buf))
+	}
+}
+
+func TestErrorPretty(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"name = a\nserver { x\n}\n", `xon: 2:10: expected newline or '}' after '{', got 'x' [unexpected_token]
+  |
+2 | server { x
+  |          ^
+  = expected newline, comment, or '}'`},
+		{"a = 1\nb = 2\nc = 3\nd = 4\ne = 5\nf = 6\ng = 7\nh = 8\ni = 9\na = 10", `xon: 10:1: duplicate key "a", first defined at 1:1 [duplicate_key]
+   |
+10 | a = 10
+   | ^`},
+		{"\tk\u00e9y = [x,, y]", "xon: 1:12: unexpected ',' after another ',' [invalid_list]\n  |\n1 | \tk\u00e9y = [x,, y]\n  | \t         ^"},
+	} {
+		_, err := Parse([]byte(tt.src))
+		var perr *Error
+		if !errors.As(err, &perr) {
+			t.Errorf("Parse(%q) = %v, want a parse error", tt.src, err)
+			continue
+		}
+		if got := perr.Pretty(); got != tt.want {
+			t.Errorf("unexpected pretty error for %q:\n\n%s\n\nwant:\n\n%s", tt.src, got, tt.want)
+		}
+	}
+	err := &Error{Column: 3, Line: 1, Message: "custom"}
+	if got, want := err.Pretty(), "xon: 1:3: custom\n  |\n1 | \n  |   ^"; got != want {
+		t.Errorf("unexpected pretty error without a code: %q, want %q", got, want)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"A": "1", "B_2": "two", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"plain $A value", "plain $A value"},
+		{"${A}", "1"},
+		{"${A}${B_2}", "1two"},
+		{"x-${B_2}-y", "x-two-y"},
+		{"${EMPTY}", ""},
+		{"${EMPTY:-fallback}", "fallback"},
+		{"${UNSET:-}", ""},
+		{"${UNSET:-a:-b}", "a:-b"},
+		{"${A:-fallback}", "1"},
+		{"$${A}", "${A}"},
+		{"$$${A}", "$${A}"},
+		{"$${A}${A}", "${A}1"},
+		{"${A}}", "1}"},
+	} {
+		got, err := expandEnv(tt.src, lookup)
+		if err != nil {
+			t.Errorf("failed to expand %q: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("unexpected expansion of %q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+	for _, src := range []string{"${UNSET}", "${}", "${A B}", "${A-x}", "${A"} {
+		if got, err := expandEnv(src, lookup); err == nil {
+			t.Errorf("expected an error when expanding %q, got %q", src, got)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	src := `{
+		"name": "node 1",
+		"port": 8080,
+		"debug": false,
+		"proxy": null,
+		"alias": "nil",
+		"tags": ["a", 1.50, ["b c"]],
+		"server": [{"host": "a.com"}, {"host": "b.com", "ports": []}],
+		"empty": {},
+		"[v2]": {"tls": "strict"}
+	}`
+	nodes, err := FromJSON([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to convert JSON: %v", err)
+	}
+	got, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	want := `name = node 1
+port = 8080
+debug = false
+proxy = nil
+alias = "nil"
+tags = [a, 1.50, ["b c"]]
+
+server {
+    host = a.com
+}
+
+server {
+    host = b.com
+    ports = []
+}
+
+empty {}
+
+[v2] {
+    tls = strict
+}
+`
+	if string(got) != want {
+		t.Errorf("unexpected output when converting JSON:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	if _, err := Parse(got); err != nil {
+		t.Errorf("failed to parse the converted JSON: %v", err)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{`[]`, `xon: cannot convert JSON to XON: expected an object at the top level`},
+		{`{"a": 1`, `xon: invalid JSON: unexpected end of JSON input`},
+		{`{} {}`, `xon: invalid JSON: unexpected data after the top-level object`},
+		{`{"a": 1, "a": 2}`, `xon: "a": duplicate member in JSON object`},
+		{`{"a": [{}, 1]}`, `xon: "a": cannot mix objects with other values in an array`},
+		{`{"a": [[{}]]}`, `xon: "a[0][0]": cannot convert an object within a nested array`},
+		{`{"a": 1, "[v2]": {"a": 2}}`, `xon: "[v2].a": duplicate key`},
+		{`{"[v2]": {}, "b": {"[v3]": {}}}`, `xon: "b.[v3]": only one versioned block number allowed per document (found v3 after v2)`},
+		{`{"[v2]": 1}`, `xon: "[v2]": versioned blocks must be objects`},
+	} {
+		_, err := FromJSON([]byte(tt.src))
+		if err == nil {
+			t.Errorf("FromJSON(%s) succeeded, want error %q", tt.src, tt.want)
+		} else if err.Error() != tt.want {
+			t.Errorf("FromJSON(%s) = error %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestFromYAML(t *testing.T) {
+	src := `# service config
+defaults: &defaults
+  retries: 3
+  debug: False
+name: node 1
+proxy: ~
+alias: "nil"
+ports: [80, 443]
+tags:
+  - a  # first
+  - 'b c'
+servers:
+  - host: a.com
+    <<: *defaults
+  - host: b.com
+    retries: 5
+    <<: *defaults
+script: |-
+  echo hi
+  done
+summary: >
+  folded
+  text
+limit: !!float .inf
+users: !!set {alice, bob}
+key: !!binary |
+  aGVs
+  bG8=
+---
+second: doc
+`
+	docs, err := FromYAML([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to convert YAML: %v", err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents when converting YAML, want 2", len(docs))
+	}
+	got, err := Marshal(docs[0])
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	want := `// service config
+defaults {
+    retries = 3
+    debug = false
+}
+
+name = node 1
+proxy = nil
+alias = "nil"
+ports = [80, 443]
+tags = [
+    a  // first
+    b c
+]
+
+servers {
+    host = a.com
+    retries = 3
+    debug = false
+}
+
+servers {
+    host = b.com
+    retries = 5
+    debug = false
+}
+
+script = ` + "`" + `
+    echo hi
+    done
+` + "`" + `
+summary = "folded text<|0x0A|>"
+limit = inf
+users = [alice, bob]
+key = b64"aGVsbG8="
+`
+	if string(got) != want {
+		t.Errorf("unexpected output when converting YAML:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	if _, err := Parse(got); err != nil {
+		t.Errorf("failed to parse the converted YAML: %v", err)
+	}
+	got, err = Marshal(docs[1])
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	if want := "second = doc\n"; string(got) != want {
+		t.Errorf("unexpected output when converting the second YAML document: %q, want %q", got, want)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"- a", `xon: cannot convert YAML document: the top level must be a mapping`},
+		{"a: b: c", `xon: invalid YAML: 1:4: mapping values are not allowed in this context`},
+		{"a: 1\na: 2", `xon: "a": duplicate key in YAML mapping`},
+		{"a: *b", `xon: invalid YAML: 1:6: unknown anchor "b"`},
+		{"a: !foo x", `xon: "a": cannot convert the YAML tag !foo`},
+		{"a: !!int x", `xon: "a": cannot convert "x" as !!int`},
+		{"a: [1, {b: 2}]", `xon: "a": cannot mix mappings with other values in a sequence`},
+		{"? a\n: b", `xon: invalid YAML: 1:1: complex mapping keys are not supported`},
+		{"a:\n\tb: 1", `xon: invalid YAML: 2:1: tabs cannot be used for indentation`},
+		{"a: \"b", `xon: invalid YAML: 1:4: unterminated quoted scalar`},
+	} {
+		_, err := FromYAML([]byte(tt.src))
+		if err == nil {
+			t.Errorf("FromYAML(%q) succeeded, want error %q", tt.src, tt.want)
+		} else if err.Error() != tt.want {
+			t.Errorf("FromYAML(%q) = error %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	type Event struct {
+		Kind string            `xon:"kind"`
+		Path string            `xon:"path"`
+		Tags map[string]string `xon:"tags"`
+	}
+	out := &bytes.Buffer{}
+	w := NewLineWriter(out)
+	w.SetAutoFlush(false)
+	events := []Event{
+		{Kind: "login", Path: `C:\Users`, Tags: map[string]string{}},
+		{Kind: "upload", Path: "/tmp", Tags: map[string]string{"size": "4MiB"}},
+		{Kind: "note", Path: "a\nb", Tags: map[string]string{}},
+	}
+	for _, event := range events {
+		if err := w.Encode(event); err != nil {
+			t.Fatalf("failed to encode event: %v", err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected output before flushing: %q", out)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("failed to flush lines: %v", err)
+	}
+	want := "kind = login\\npath = C:\\\\Users\\n\\ntags {}\n" +
+		"kind = upload\\npath = /tmp\\n\\ntags {\\nsize = 4MiB\\n}\n" +
+		"kind = note\\npath = `\\na\\nb\\n`\\n\\ntags {}\n"
+	if got := out.String(); got != want {
+		t.Errorf("unexpected lines:\n\n%s\nwant:\n\n%s", got, want)
+	}
+	r := NewLineReader(out)
+	for i, want := range events {
+		got := Event{}
+		if err := r.Decode(&got); err != nil {
+			t.Fatalf("failed to decode line %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected event on line %d: got %+v, want %+v", r.Line(), got, want)
+		}
+	}
+	if _, err := r.ReadNodes(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the lines, got %v", err)
+	}
+	// Partial lines are held until the rest of the line is available.
+	stream := &bytes.Buffer{}
+	r = NewLineReader(stream)
+	stream.WriteString("a = 1\nb = ")
+	if _, err := r.ReadNodes(); err != nil {
+		t.Fatalf("failed to read the first line: %v", err)
+	}
+	if _, err := r.ReadNodes(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a partial line, got %v", err)
+	}
+	stream.WriteString("2\r\n")
+	nodes, err := r.ReadNodes()
+	if err != nil {
+		t.Fatalf("failed to read the completed line: %v", err)
+	}
+	if kv := nodes[0].(*KeyValue); kv.Key != "b" || kv.Value.(*String).Value != "2" {
+		t.Errorf("unexpected node for the completed line: %+v", kv)
+	}
+	// Invalid lines result in errors positioned within the line, or are
+	// skipped if enabled.
+	src := "a = 1\nb {\\nc = [1, 2\\n}\nd = \\x\ne = 5\n"
+	r = NewLineReader(strings.NewReader(src))
+	for _, want := range []string{
+		"",
+		"xon: 2:17: unexpected end of file, expected ']'",
+		`xon: 3:5: invalid escape within line (use \n for newlines and \\ for backslashes)`,
+		"",
+	} {
+		_, err := r.ReadNodes()
+		if want == "" {
+			if err != nil {
+				t.Errorf("failed to read line %d: %v", r.Line(), err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != want {
+			t.Errorf("unexpected error for line %d: got %v, want %s", r.Line(), err, want)
+		}
+	}
+	r = NewLineReader(strings.NewReader(src))
+	r.SetSkipInvalid(true)
+	var keys []string
+	for {
+		nodes, err := r.ReadNodes()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error when skipping invalid lines: %v", err)
+		}
+		keys = append(keys, nodes[0].(*KeyValue).Key)
+	}
+	if got := strings.Join(keys, " "); got != "a e" || r.Skipped() != 2 {
+		t.Errorf("unexpected keys after skipping invalid lines: got %q with %d skipped", got, r.Skipped())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.xon": {Data: []byte(`name = app
+include "conf/servers.xon"  // all servers
+limits {
+    include "/shared/limits.xon"
+}
+`)},
+		"conf/servers.xon":  {Data: []byte("server {\n    include \"../shared/tls.xon\"\n}\n")},
+		"shared/limits.xon": {Data: []byte("rate = 10\n")},
+		"shared/tls.xon":    {Data: []byte("tls = strict\n")},
+		"cycle/a.xon":       {Data: []byte("include \"b.xon\"\n")},
+		"cycle/b.xon":       {Data: []byte("x = 1\ninclude \"a.xon\"\n")},
+		"dupe.xon":          {Data: []byte("name = a\ninclude \"shared/name.xon\"\n")},
+		"shared/name.xon":   {Data: []byte("// override\nname = b\n")},
+		"missing.xon":       {Data: []byte("include \"nope.xon\"\n")},
+	}
+	loader := FSLoader(fsys)
+	nodes, err := Load(loader, "app.xon")
+	if err != nil {
+		t.Fatalf("failed to load app.xon: %v", err)
+	}
+	got, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	want := `name = app
+
+server {
+    tls = strict
+}
+
+limits {
+    rate = 10
+}
+`
+	if string(got) != want {
+		t.Errorf("unexpected nodes after loading:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	tls := nodes[1].(*Block).Nodes[0].(*KeyValue)
+	if want := (Position{Column: 1, File: "shared/tls.xon", Line: 1}); tls.Pos != want {
+		t.Errorf("unexpected position for an included node: got %+v, want %+v", tls.Pos, want)
+	}
+	if got := tls.Value.(*String).End.String(); got != "shared/tls.xon:1:13" {
+		t.Errorf("unexpected position string for an included node: got %s", got)
+	}
+	for _, tt := range []struct {
+		path string
+		code ErrorCode
+		want string
+	}{
+		{"cycle/a.xon", CodeIncludeCycle, `xon: cycle/b.xon:2:1: include cycle: cycle/a.xon -> cycle/b.xon -> cycle/a.xon`},
+		{"dupe.xon", CodeDuplicateKey, `xon: shared/name.xon:2:1: duplicate key "name", first defined at dupe.xon:1:1`},
+		{"missing.xon", CodeInvalidInclude, `xon: missing.xon:1:1: failed to include "nope.xon": open nope.xon: file does not exist`},
+	} {
+		_, err := Load(loader, tt.path)
+		var perr *Error
+		if !errors.As(err, &perr) {
+			t.Errorf("expected a parse error when loading %s, got %v", tt.path, err)
+			continue
+		}
+		if perr.Code != tt.code || perr.Error() != tt.want {
+			t.Errorf("unexpected error when loading %s: got %s [%s], want %s [%s]", tt.path, perr, perr.Code, tt.want, tt.code)
+		}
+	}
+	if _, err := Load(loader, "nope.xon"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist when loading a missing file, got %v", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	type Node struct {
+		Host string `xon:"host"`
+		Port uint16 `xon:"port"`
+	}
+	type Base struct {
+		Name string `xon:"name"`
+	}
+	type Config struct {
+		Base
+		BootstrapLink string         `xon:"bootstrap link"`
+		Debug         *bool          `xon:"debug"`
+		Limits        map[string]int `xon:"limits"`
+		Nodes         []Node         `xon:"node"`
+		Ratio         float64        `xon:"ratio"`
+		Skipped       string         `xon:"-"`
+		Tags          []string       `xon:"tags"`
+		Timeout       time.Duration  `xon:"timeout"`
+		internal      string
+	}
+	cfg := &Config{
+		Base:          Base{Name: "nil"},
+		BootstrapLink: "https://espra.dev/bootstrap?limit=50",
+		Limits:        map[string]int{"max conns": 100, "idle": 5},
+		Nodes:         []Node{{"fast.espra.dev", 8040}, {"archive.espra.dev", 8041}},
+		Ratio:         0.5,
+		Skipped:       "skipped",
+		Tags:          []string{"a", "b c", "d, e"},
+		Timeout:       90 * time.Second,
+		internal:      "internal",
+	}
+	got, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	want := `name = "nil"
+bootstrap link = https://espra.dev/bootstrap?limit=50
+debug = nil
+
+limits {
+    idle = 5
+    max conns = 100
+}
+
+node {
+    host = fast.espra.dev
+    port = 8040
+}
+
+node {
+    host = archive.espra.dev
+    port = 8041
+}
+
+ratio = 0.5
+tags = [a, "b c", "d, e"]
+timeout = 1m30s
+`
+	if string(got) != want {
+		t.Errorf("unexpected output from Marshal:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	for _, v := range []any{nil, 42, []string{"a"}, map[string]any{"f": func() {}}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("expected an error when marshalling %#v", v)
+		}
+	}
+	// List elements which look like references or anchors are only quoted
+	// within documents that declare xon 0.2, as they're plain strings in 0.1.
+	lists := map[string][][]string{"l": {{"*a"}, {"&a"}, {"*a", "b"}}}
+	got, err = Marshal(lists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "l = [[*a], [&a], [*a, b]]\n"; string(got) != want {
+		t.Errorf("Marshal(%v) = %q, want %q", lists, got, want)
+	}
+	nodes, err := Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := Parse([]byte("xon 0.2\n[&a] a = x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = Marshal(append(header, nodes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "xon 0.2\n\n[&a] a = x\nl = [[\"*a\"], [\"&a\"], [\"*a\", b]]\n"; string(got) != want {
+		t.Errorf("Marshal(%v) with a spec version = %q, want %q", lists, got, want)
+	}
+	var decoded struct {
+		L [][]string `xon:"l"`
+	}
+	if err := Unmarshal(got, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.L, lists["l"]) {
+		t.Errorf("Unmarshal() = %v, want %v", decoded.L, lists["l"])
+	}
+	// A spec line is added to documents without one that use anchors,
+	// references, or appends, as they would otherwise be parsed as 0.1.
+	for _, src := range []string{"xon 0.2\n\n[&a] a = x\nb = [*a]\n", "xon 0.2\n\nc += [x]\n"} {
+		nodes, err = Parse([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = Marshal(nodes[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != src {
+			t.Errorf("Marshal() without a spec line = %q, want %q", got, src)
+		}
+	}
+}
+
+func TestMarshalCycles(t *testing.T) {
+	type Node struct {
+		Name string `xon:"name"`
+		Next *Node  `xon:"next"`
+	}
+	a := &Node{Name: "a"}
+	a.Next = &Node{Name: "b", Next: a}
+	m := map[string]any{"name": "m"}
+	m["child"] = map[string]any{"parent": m}
+	list := []any{"x", nil}
+	list[1] = list
+	var self any
+	self = &self
+	for _, tt := range []struct {
+		v    any
+		want string
+	}{
+		{a, `xon: "next.next": cannot marshal a value that refers back to the document`},
+		{map[string]any{"root": a}, `xon: "root.next.next": cannot marshal a value that refers back to "root"`},
+		{m, `xon: "child.parent": cannot marshal a value that refers back to the document`},
+		{map[string]any{"list": list}, `xon: "list[1]": cannot marshal a value that refers back to "list"`},
+		{map[string]any{"self": &self}, `xon: "self": cannot marshal a pointer that refers back to itself`},
+	} {
+		_, err := Marshal(tt.v)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error when marshalling a cycle: got %v, want %s", err, tt.want)
+		}
+	}
+	// Values which are shared, without referring back to themselves, are
+	// encoded each time.
+	shared := &Node{Name: "shared"}
+	tags := []string{"a"}
+	got, err := Marshal(map[string]any{"a": shared, "b": shared, "c": tags, "d": tags})
+	if err != nil {
+		t.Fatalf("failed to marshal shared values: %v", err)
+	}
+	if want := "\na {\n    name = shared\n    next = nil\n}\n\nb {\n    name = shared\n    next = nil\n}\n\nc = [a]\nd = [a]\n"; string(got) != want[1:] {
+		t.Errorf("unexpected output when marshalling shared values:\n\n%s", got)
+	}
+	deep := map[string]any{"a": map[string]any{"b": map[string]any{"c": []any{[]any{"d"}}}}}
+	for _, tt := range []struct {
+		depth int
+		want  string
+	}{
+		{0, ""},
+		{4, ""},
+		{3, `xon: "a.b.c[0]": cannot marshal beyond the maximum depth of 3`},
+		{2, `xon: "a.b.c": cannot marshal beyond the maximum depth of 2`},
+		{1, `xon: "a.b": cannot marshal beyond the maximum depth of 1`},
+	} {
+		enc := NewEncoder(io.Discard)
+		enc.SetMaxDepth(tt.depth)
+		err := enc.Encode(deep)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("failed to encode with a maximum depth of %d: %v", tt.depth, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error with a maximum depth of %d: got %v, want %s", tt.depth, err, tt.want)
+		}
+	}
+}
+
+func TestMarshalDurations(t *testing.T) {
+	type Config struct {
+		Timeout time.Duration `xon:"timeout"`
+	}
+	for _, tt := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{1500 * time.Microsecond, "1ms500µs"},
+		{-1500 * time.Nanosecond, "-1µs500ns"},
+		{999 * time.Millisecond, "999ms"},
+		{90*time.Second + 1500*time.Microsecond, "1m30.0015s"},
+		{math.MinInt64, "-2562047h47m16.854775808s"},
+	} {
+		data, err := Marshal(&Config{Timeout: tt.d})
+		if err != nil {
+			t.Errorf("failed to marshal %v: %v", tt.d, err)
+			continue
+		}
+		if got, want := string(data), "timeout = "+tt.want+"\n"; got != want {
+			t.Errorf("Marshal(%v) = %q, want %q", tt.d, got, want)
+		}
+		cfg := &Config{}
+		if err := Unmarshal(data, cfg); err != nil {
+			t.Errorf("failed to unmarshal %q: %v", data, err)
+		} else if cfg.Timeout != tt.d {
+			t.Errorf("round trip of %v = %v", tt.d, cfg.Timeout)
+		}
+	}
+}
+
+func TestMarshalNodes(t *testing.T) {
+	src := `// Config for the espra node.
+xon 0.2  // for heredoc strings
+
+name = node 1  // the display name
+include "defaults.xon"  // shared settings
+
+// The servers to connect to.
+server {  // primary
+    host = example.com
+    path = r"C:\Program Files\Espra"
+    pattern = r#"^"\w+"$"#
+    fingerprint = hex"9f86d081"
+    keys = [b64"aGVsbG8=", b64""]
+    ports = [  // in order of preference
+        // standard ports
+        80
+        443  // tls
+        // fallback
+        8080, 8443  // both
+    ]
+    motd = ` + "`" + `
+        hello
+          world
+    ` + "`" + `
+    banner = ` + "`" + `|
+          welcome
+        ` + "`" + `
+
+    empty {}  // nothing yet
+
+    [v2] {
+        tls mode = strict
+    }
+}  // end server
+
+tags = [a, b]  // closing
+extra tags += [c]
+// trailing comment
+`
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	got, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	want := strings.Replace(src, "8080, 8443  // both", "8080\n        8443  // both", 1)
+	if string(got) != want {
+		t.Errorf("unexpected output when marshalling parsed nodes:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+}
+
+func TestMarshalStrings(t *testing.T) {
+	for _, s := range []string{
+		"",
+		" leading",
+		"trailing ",
+		"nil",
+		"plain value",
+		"say \"hello\"",
+		"`quoted`",
+		"line 1\nline 2\n    indented\n\nline 5",
+		"\n",
+		"line 1\n  \nline 3",
+		"crlf\r\n",
+		"<|0x0D|>",
+		"\x00\x7f\xff",
+		"a = b",
+		"a {",
+		"[a]",
+		"// comment",
+		"a // comment",
+		"a, b",
+		"a,,b",
+		"a] b",
+		"trailing,",
+		"{",
+		"}",
+		"``` ` ``",
+		"-----BEGIN KEY-----\nMIIB\n-----END KEY-----\n",
+		"    indented first\nsecond",
+		"\nleading newline",
+		"trailing spaces  \n\t\n\n",
+		"|\nnot a heredoc",
+		"`\n```\n",
+		"say \"hi\" <|0x22|>",
+		"ends with \"#",
+		"<|0x0D|>\n",
+		"a += b",
+	} {
+		doc := map[string]any{s: s, "list": []string{s, s}, "lines": []string{s, "a\nb"}}
+		data, err := Marshal(doc)
+		if err != nil {
+			t.Errorf("failed to marshal %q: %v", s, err)
+			continue
+		}
+		nodes, err := Parse(data)
+		if err != nil {
+			t.Errorf("failed to parse the encoding of %q: %v\n\n%s", s, err, data)
+			continue
+		}
+		// Heredoc strings are only written for documents that declare the
+		// spec version that supports them.
+		data, err = Marshal(append([]Node{&Spec{Version: "0.2"}}, nodes...))
+		if err != nil {
+			t.Errorf("failed to marshal %q with a spec version: %v", s, err)
+			continue
+		}
+		nodes, err = Parse(data)
+		if err != nil {
+			t.Errorf("failed to parse the encoding of %q with a spec version: %v\n\n%s", s, err, data)
+			continue
+		}
+		for _, node := range nodes[1:] {
+			kv := node.(*KeyValue)
+			var values []Value
+			switch kv.Key {
+			case s:
+				values = []Value{kv.Value}
+			case "lines", "list":
+				values = kv.Value.(*List).Content[:1]
+			}
+			for _, value := range values {
+				if got := value.(*String).Value; got != s {
+					t.Errorf("unexpected round trip for %q in %q: got %q\n\n%s", s, kv.Key, got, data)
+				}
+			}
+		}
+	}
+}
+
+func TestMarshalTags(t *testing.T) {
+	type Meta struct {
+		Owner string `xon:"owner"`
+	}
+	type TLS struct {
+		Cert string `xon:"cert"`
+	}
+	type Config struct {
+		Name    string            `xon:"name,omitempty"`
+		Debug   bool              `xon:"debug,omitempty"`
+		Port    int               `xon:"port,string"`
+		Limit   *int              `xon:"limit,string"`
+		Tags    []string          `xon:"tags,omitempty"`
+		Meta    Meta              `xon:",inline"`
+		TLS     *TLS              `xon:"tls,inline"`
+		Extra   map[string]string `xon:",inline"`
+		Timeout time.Duration     `xon:"timeout,omitempty,string"`
+	}
+	cfg := &Config{
+		Port:  8080,
+		Meta:  Meta{Owner: "tav"},
+		TLS:   &TLS{Cert: "server.pem"},
+		Extra: map[string]string{"region": "eu", "zone": "b"},
+	}
+	got, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	want := `port = "8080"
+limit = nil
+owner = tav
+cert = server.pem
+region = eu
+zone = b
+`
+	if string(got) != want {
+		t.Errorf("unexpected output from Marshal:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	dec := &Config{}
+	if err := Unmarshal(got, dec); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if !reflect.DeepEqual(dec, cfg) {
+		t.Errorf("unexpected round trip: got %#v, want %#v", dec, cfg)
+	}
+	limit := 5
+	cfg = &Config{Name: "edge", Debug: true, Limit: &limit, Tags: []string{"a"}, Timeout: time.Minute}
+	if got, err = Marshal(cfg); err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	want = `name = edge
+debug = true
+port = "0"
+limit = "5"
+tags = [a]
+owner = ""
+timeout = "1m0s"
+`
+	if string(got) != want {
+		t.Errorf("unexpected output from Marshal:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	if _, err := Marshal(&Config{Extra: map[string]string{"Port": "80"}}); err == nil {
+		t.Errorf("expected an error when an inline map key conflicts with a field")
+	}
+	type Ambiguous struct {
+		A map[string]string `xon:",inline"`
+		B map[string]string `xon:",inline"`
+	}
+	amb := &Ambiguous{}
+	if err := Unmarshal([]byte("key = value"), amb); err != nil || amb.A != nil || amb.B != nil {
+		t.Errorf("expected conflicting inline maps to be ignored: %v, %#v", err, amb)
+	}
+}
+
+func TestMarshaler(t *testing.T) {
+	type Config struct {
+		Addrs  []netip.Addr     `xon:"addrs"`
+		IP     net.IP           `xon:"ip"`
+		Level  level            `xon:"level"`
+		Levels map[level]string `xon:"levels"`
+		Lines  *span            `xon:"lines"`
+		Spans  map[string]span  `xon:"spans"`
+		Start  time.Time        `xon:"start"`
+	}
+	cfg := &Config{
+		Addrs:  []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("::1")},
+		IP:     net.IPv4(192, 168, 0, 1),
+		Level:  1,
+		Levels: map[level]string{0: "verbose", 1: "normal"},
+		Lines:  &span{10, 20},
+		Spans:  map[string]span{"header": {0, 4}},
+		Start:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	got, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	want := `addrs = [10.0.0.1, ::1]
+ip = 192.168.0.1
+level = info
+
+levels {
+    debug = verbose
+    info = normal
+}
+
+lines = [10, 20]
+
+spans {
+    header = [0, 4]
+}
+
+start = 2026-01-02T03:04:05Z
+`
+	if string(got) != want {
+		t.Errorf("unexpected output from Marshal:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	dec := &Config{}
+	if err := Unmarshal(got, dec); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if !reflect.DeepEqual(dec, cfg) {
+		t.Errorf("unexpected round trip: got %#v, want %#v", dec, cfg)
+	}
+	d := NewDecoder(strings.NewReader("level = ${LEVEL}\nlines = [${START}, 5]"))
+	d.SetEnv(func(name string) (string, bool) {
+		return map[string]string{"LEVEL": "info", "START": "2"}[name], true
+	})
+	dec = &Config{}
+	if err := d.Decode(dec); err != nil {
+		t.Fatalf("failed to decode config with env references: %v", err)
+	}
+	if dec.Level != 1 || *dec.Lines != (span{2, 5}) {
+		t.Errorf("unexpected values decoded with env references: %v, %v", dec.Level, *dec.Lines)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"level = trace", `xon: "level": cannot decode "trace" as xon.level: unknown level "trace"`},
+		{"level = [info]", `xon: "level": cannot decode a list into xon.level`},
+		{"level {}", `xon: "level": cannot decode a block into xon.level`},
+		{"addrs {}", `xon: "addrs[0]": cannot decode a block into netip.Addr`},
+		{"levels {\n    trace = x\n}", `xon: "levels.trace": cannot decode key "trace" as xon.level: unknown level "trace"`},
+		{"lines = 5", `xon: "lines": cannot decode into xon.span: expected a list of 2 elements`},
+	} {
+		err := Unmarshal([]byte(tt.src), &Config{})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error for %q: got %v, want %s", tt.src, err, tt.want)
+		}
+	}
+	for _, v := range []any{&Config{Level: 5}, &Config{Levels: map[level]string{5: "x"}}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("expected an error when marshalling %#v", v)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	parse := func(src string) []Node {
+		nodes, err := Parse([]byte(src))
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", src, err)
+		}
+		return nodes
+	}
+	defaults := parse(`xon 0.2
+
+[&port] port = 8080
+
+// Logging settings.
+log {
+    level = info
+    format = json
+}
+
+server {
+    listen = [[*port]]
+    tls = off  // for now
+}
+
+// Enables the debug endpoints.
+debug = true
+
+[v2] {
+    mode = legacy
+}
+`)
+	env := parse(`xon 0.2
+
+log {
+    level = warn
+}
+
+server {
+    listen += [8443]
+    tls = on
+}
+
+debug = nil
+
+// The cluster to join.
+cluster = prod
+
+[v2] {
+    mode = fast
+}
+`)
+	local := parse(`log = nil
+
+server {
+    listen = [9090]
+}
+`)
+	got, err := Merge(defaults, env)
+	if err != nil {
+		t.Fatalf("failed to merge nodes: %v", err)
+	}
+	want := `xon 0.2
+
+port = 8080
+
+// Logging settings.
+log {
+    level = warn
+    format = json
+}
+
+server {
+    listen = [8080, 8443]
+    tls = on
+}
+
+[v2] {
+    mode = fast
+}
+
+// The cluster to join.
+cluster = prod
+`
+	if out, err := Marshal(got); err != nil || string(out) != want {
+		t.Errorf("unexpected output when merging:\n\n%s\n\nwant:\n\n%s", out, want)
+	}
+	got, err = Merge(defaults, env, local)
+	if err != nil {
+		t.Fatalf("failed to merge nodes: %v", err)
+	}
+	want = `xon 0.2
+
+port = 8080
+
+server {
+    listen = [9090]
+    tls = on
+}
+
+[v2] {
+    mode = fast
+}
+
+// The cluster to join.
+cluster = prod
+`
+	if out, err := Marshal(got); err != nil || string(out) != want {
+		t.Errorf("unexpected output when merging layers:\n\n%s\n\nwant:\n\n%s", out, want)
+	}
+	if out, err := Marshal(defaults); err != nil || !strings.HasPrefix(string(out), "xon 0.2\n\n[&port] port = 8080\n") || !strings.Contains(string(out), "debug = true") {
+		t.Errorf("base nodes were changed by merging:\n\n%s", out)
+	}
+	got, err = Merge(parse("node {\n    a = 1\n}\nnode {\n    a = 2\n}\n"), parse("node {\n    b = 1\n}\n"))
+	if err != nil {
+		t.Fatalf("failed to merge repeated blocks: %v", err)
+	}
+	if out, _ := Marshal(got); string(out) != "node {\n    b = 1\n}\n" {
+		t.Errorf("unexpected output when merging repeated blocks:\n\n%s", out)
+	}
+	for _, tt := range []struct {
+		base    string
+		overlay string
+		want    string
+	}{
+		{"a = b", "xon 0.2\na += [c]", `xon: "a": cannot append to a value which isn't a list`},
+		{"x {\n    a {}\n}", "xon 0.2\nx {\n    a += [c]\n}", `xon: "x.a": cannot append to a block`},
+		{"include \"a.xon\"", "a = b", `xon: cannot merge as the include of "a.xon" hasn't been resolved`},
+	} {
+		if _, err := Merge(parse(tt.base), parse(tt.overlay)); err == nil || err.Error() != tt.want {
+			t.Errorf("Merge(%q, %q) = %v, want error %q", tt.base, tt.overlay, err, tt.want)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	type Order struct {
+		ID    Number `xon:"id"`
+		Total Number `xon:"total"`
+	}
+	order := &Order{}
+	if err := Unmarshal([]byte("id = 18_446_744_073_709_551_616\ntotal = 19.99\n"), order); err != nil {
+		t.Fatalf("failed to unmarshal order: %v", err)
+	}
+	if order.ID != "18_446_744_073_709_551_616" || order.Total != "19.99" {
+		t.Errorf("unexpected order: %+v", order)
+	}
+	if _, err := order.ID.Uint64(); err != errRange {
+		t.Errorf("Uint64() = %v, want %v", err, errRange)
+	}
+	if i, err := order.ID.BigInt(); err != nil || i.String() != "18446744073709551616" {
+		t.Errorf("BigInt() = %v, %v, want 18446744073709551616", i, err)
+	}
+	if f, err := order.ID.BigFloat(); err != nil || f.Text('f', 0) != "18446744073709551616" {
+		t.Errorf("BigFloat() = %v, %v, want 18446744073709551616", f, err)
+	}
+	if f, err := order.Total.Float64(); err != nil || f != 19.99 {
+		t.Errorf("Float64() = %v, %v, want 19.99", f, err)
+	}
+	if _, err := order.Total.BigInt(); err != errSyntax {
+		t.Errorf("BigInt() on a float = %v, want %v", err, errSyntax)
+	}
+	if _, err := order.Total.Int64(); err != errSyntax {
+		t.Errorf("Int64() on a float = %v, want %v", err, errSyntax)
+	}
+	if f, err := Number("0.1000000000000000000000000001").BigFloat(); err != nil || f.Text('f', 28) != "0.1000000000000000000000000001" {
+		t.Errorf("BigFloat() = %v, %v, want 0.1000000000000000000000000001", f, err)
+	}
+	if i, err := Number("-0x10").Int64(); err != nil || i != -16 {
+		t.Errorf("Int64() = %v, %v, want -16", i, err)
+	}
+	data, err := Marshal(order)
+	if err != nil {
+		t.Fatalf("failed to marshal order: %v", err)
+	}
+	if want := "id = 18_446_744_073_709_551_616\ntotal = 19.99\n"; string(data) != want {
+		t.Errorf("unexpected output when marshalling order:\n\n%s\n\nwant:\n\n%s", data, want)
+	}
+	if data, err := Marshal(&Order{}); err != nil || string(data) != "id = 0\ntotal = 0\n" {
+		t.Errorf("Marshal of zero numbers = %q, %v", data, err)
+	}
+	if _, err := Marshal(&Order{ID: "x"}); err == nil || err.Error() != `xon: cannot marshal invalid number "x": invalid syntax` {
+		t.Errorf("Marshal of an invalid number = %v, want an error", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	data, err := os.ReadFile("parse.tests")
+	if err != nil {
+		t.Fatalf("failed to read parse.tests: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "")
+	tests := strings.Split(string(data), "-----\n")
+	for _, test := range tests {
+		test = strings.TrimSpace(test)
+		if test == "" {
+			continue
+		}
+		split := strings.SplitN(test, "---\n", 2)
+		if len(split) != 2 {
+			t.Errorf("failed to split test: %v", test)
+			continue
+		}
+		src, want := split[0], split[1]
+		nodes, err := Parse([]byte(src))
+		buf.Reset()
+		if err != nil {
+			jerr := enc.Encode(err)
+			if jerr != nil {
+				t.Errorf("failed to marshal parse error: %v", jerr)
+				continue
+			}
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if got != want {
+				t.Errorf("unexpected parse error for:\n\n%s\n---\n\ngot:\n\n%s\n\nwant:\n\n%s\n\n", src, got, want)
+				continue
+			}
+			continue
+		}
+		buf.Reset()
+		for _, node := range nodes {
+			enc.Encode(node)
+		}
+		got := strings.TrimSuffix(buf.String(), "\n")
+		if got != want {
+			t.Errorf("unexpected parsed result for:\n\n%s\n---\n\ngot:\n\n%s\n\nwant:\n\n%s\n\n", src, got, want)
+		}
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	src := `name = node
+port =80
+server{
+    host = a
+}
+tags = [a,, b
+    c]
+name = again
+[v2] {
+    debug = true }
+}
+{
+    x = 1
+}
+last = <|0xZZ|>
+`
+	nodes, errs := ParseAll([]byte(src))
+	var got []string
+	for _, err := range errs {
+		got = append(got, fmt.Sprintf("%d:%d %s", err.Line, err.Column, err.Code))
+	}
+	want := []string{
+		"2:7 missing_space",
+		"4:1 invalid_identifier",
+		"6:11 invalid_list",
+		"8:1 duplicate_key",
+		"10:18 invalid_value",
+		"12:1 invalid_identifier",
+		"15:16 invalid_escape",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected errors:\n\n%s\n\nwant:\n\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	out, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	wantOut := `name = node
+tags = [a, c]
+name = again
+
+[v2] {}
+
+last = r"<|0xZZ|>"
+`
+	if string(out) != wantOut {
+		t.Errorf("unexpected nodes after recovering from errors:\n\n%s\n\nwant:\n\n%s", out, wantOut)
+	}
+	if nodes, errs := ParseAll([]byte("a = 1\n")); len(errs) != 0 || len(nodes) != 1 {
+		t.Errorf("ParseAll on a valid document = %d nodes and errors %v, want 1 node and no errors", len(nodes), errs)
+	}
+}
+
+func TestParseArena(t *testing.T) {
+	arena := &Arena{ChunkSize: 4}
+	src := []byte(`// servers
+server {
+    host = a.espra.dev
+    ports = [80, 443, 8080, 8443, 9000]
+}
+server {
+    host = b.espra.dev
+    tags = []
+}
+name = "<|0x41|>pp"
+`)
+	want, err := Parse(src)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	for i := range 3 {
+		nodes, err := ParseArena(src, arena)
+		if err != nil {
+			t.Fatalf("failed to parse source with an arena: %v", err)
+		}
+		if !reflect.DeepEqual(nodes, want) {
+			t.Errorf("unexpected nodes when parsing with an arena for the %d time", i+1)
+		}
+		// Appending to the slices from an arena must not overwrite the
+		// nodes that follow them.
+		first := nodes[1].(*Block)
+		first.Nodes = append(first.Nodes, &Comment{Text: "appended"})
+		if host := nodes[2].(*Block).Nodes[0].(*KeyValue); host.Value.(*String).Value != "b.espra.dev" {
+			t.Errorf("unexpected host after appending to an earlier block: %q", host.Value.(*String).Value)
+		}
+		kept := Clone(nodes)
+		arena.Reset()
+		if !reflect.DeepEqual(kept[3], want[3]) || len(kept[1].(*Block).Nodes) != 3 {
+			t.Errorf("unexpected nodes cloned out of an arena after resetting it")
+		}
+	}
+	if _, err := ParseArena([]byte("a = [1, 2"), arena); err == nil {
+		t.Errorf("expected an error when parsing an invalid source with an arena")
+	}
+	if nodes, err := ParseArena([]byte("a = 1\n"), arena); err != nil || len(nodes) != 1 {
+		t.Errorf("unexpected result when reusing an arena after an error: %v, %v", nodes, err)
+	}
+}
+
+func TestParseLimited(t *testing.T) {
+	bomb := "xon 0.2\n[&a] a = [x, x, x, x, x, x, x, x]\n"
+	for _, name := range []string{"b", "c", "d"} {
+		prev := string(rune(name[0] - 1))
+		bomb += fmt.Sprintf("[&%s] %s = [%s]\n", name, name, strings.TrimSuffix(strings.Repeat("[*"+prev+"], ", 8), ", "))
+	}
+	for _, tt := range []struct {
+		src    string
+		limits Limits
+		want   string
+	}{
+		{"a = b\n", Limits{MaxSize: 6}, ""},
+		{"a = b\n", Limits{MaxSize: 5}, "xon: 1:1: input size exceeds the limit of 5 bytes"},
+		{"a {\n    b {\n        c {}\n    }\n}\n", Limits{MaxDepth: 3}, ""},
+		{"a {\n    b {\n        c {}\n    }\n}\n", Limits{MaxDepth: 2}, "xon: 3:11: nesting depth exceeds the limit of 2"},
+		{"a = [[x]]", Limits{MaxDepth: 2}, ""},
+		{"a {\n    b = [x]\n}\n", Limits{MaxDepth: 1}, "xon: 2:9: nesting depth exceeds the limit of 1"},
+		{"xon 0.2\n[&a] a = [[x]]\nb = [[*a]]\n", Limits{MaxDepth: 3}, ""},
+		{"xon 0.2\n[&a] a = [[x]]\nb = [[*a]]\n", Limits{MaxDepth: 2}, "xon: 3:6: nesting depth exceeds the limit of 2"},
+		{"xon 0.2\n[&a] a {\n    b {}\n}\nc {\n    [*a]\n}\n", Limits{MaxDepth: 2}, ""},
+		{"xon 0.2\n[&a] a {\n    b {}\n}\nc {\n    d {\n        [*a]\n    }\n}\n", Limits{MaxDepth: 2}, "xon: 7:9: nesting depth exceeds the limit of 2"},
+		{"a = [1, 2, 3]\n", Limits{MaxTokens: 9}, ""},
+		{"a = [1, 2, 3]\n", Limits{MaxTokens: 8}, "xon: 2:1: number of tokens exceeds the limit of 8"},
+		{bomb, Limits{MaxTokens: 1000}, "xon: 4:35: number of tokens exceeds the limit of 1000"},
+		{bomb, Limits{}, ""},
+	} {
+		_, err := ParseLimited([]byte(tt.src), tt.limits)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("ParseLimited(%q, %+v) failed: %v", tt.src, tt.limits, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("ParseLimited(%q, %+v) = %v, want %s", tt.src, tt.limits, err, tt.want)
+		}
+		if err, ok := err.(*Error); ok && err.Code != CodeLimitExceeded {
+			t.Errorf("ParseLimited(%q, %+v) returned an error with code %s, want %s", tt.src, tt.limits, err.Code, CodeLimitExceeded)
+		}
+	}
+	fsys := fstest.MapFS{"big.xon": {Data: []byte("a = " + strings.Repeat("x", 100) + "\n")}}
+	for _, tt := range []struct {
+		limits Limits
+		want   string
+	}{
+		{Limits{MaxSize: 200}, ""},
+		{Limits{MaxSize: 100}, `xon: 1:1: including "big.xon" exceeds the input size limit of 100 bytes`},
+		{Limits{MaxSize: 10}, "xon: 1:1: input size exceeds the limit of 10 bytes"},
+	} {
+		dec := NewDecoder(strings.NewReader("include \"big.xon\"\n" + strings.Repeat("\n", 10)))
+		dec.SetLimits(tt.limits)
+		dec.SetLoader(FSLoader(fsys))
+		var v map[string]string
+		err := dec.Decode(&v)
+		if tt.want == "" {
+			if err != nil || len(v["a"]) != 100 {
+				t.Errorf("failed to decode with %+v: %v", tt.limits, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error when decoding with %+v: got %v, want %s", tt.limits, err, tt.want)
+		}
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	src := "// header\r\nname = \"node\"  // inline\r\nserver {\r\n    tags = [a, [b]  // note\r\n    ]\r\n}\r\n[v2] {\r\n    motd = `hi`\r\n}"
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	var got []string
+	add := func(kind string, pos Position, end Position) {
+		got = append(got, fmt.Sprintf("%s %s-%s %d-%d", kind, pos, end, pos.Offset, end.Offset))
+	}
+	var addValue func(value Value)
+	addValue = func(value Value) {
+		switch value := value.(type) {
+		case *Comment:
+			add("comment", value.Pos, value.End)
+		case *List:
+			add("list", value.Pos, value.End)
+			for _, elem := range value.Content {
+				addValue(elem)
+			}
+		case *String:
+			add("string", value.Pos, value.End)
+		}
+	}
+	var addNodes func(nodes []Node)
+	addNodes = func(nodes []Node) {
+		for _, node := range nodes {
+			switch node := node.(type) {
+			case *Block:
+				add("block", node.Pos, node.End)
+				addNodes(node.Nodes)
+			case *Comment:
+				add("comment", node.Pos, node.End)
+			case *KeyValue:
+				add("key_value", node.Pos, node.End)
+				addValue(node.Value)
+			case *VersionedBlock:
+				add("versioned_block", node.Pos, node.End)
+				add("block", node.Block.Pos, node.Block.End)
+				addNodes(node.Block.Nodes)
+			}
+		}
+	}
+	addNodes(nodes)
+	want := []string{
+		"comment 1:1-1:10 0-9",
+		"key_value 2:1-2:14 11-24",
+		"string 2:8-2:14 18-24",
+		"block 3:1-6:2 37-84",
+		"key_value 4:5-5:6 51-81",
+		"list 4:12-5:6 58-81",
+		"string 4:13-4:14 59-60",
+		"list 4:16-4:19 62-65",
+		"string 4:17-4:18 63-64",
+		"comment 4:21-4:28 67-74",
+		"versioned_block 7:1-9:2 86-112",
+		"block 7:6-9:2 91-112",
+		"key_value 8:5-8:16 98-109",
+		"string 8:12-8:16 105-109",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected positions:\n\n%s\n\nwant:\n\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestQuery(t *testing.T) {
+	src := `xon 0.2
+
+name = node 1
+[&tls] tls = strict
+
+server {
+    host = a.com
+    listener {
+        port = 80
+    }
+    listener {
+        port = 443
+        [*tls]
+    }
+}
+
+server {
+    host = b.com
+    ports = [8080, [9090, 9091]]
+    "max.conns" = 100
+    [v2] {
+        listener {
+            port = 8443
+        }
+    }
+}
+`
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"name", "name@3:1=node 1"},
+		{"server.listeners[0].port", ""},
+		{"server.listener[0].port", "server[0].listener[0].port@9:9=80 server[1].listener.port@23:13=8443"},
+		{"server[0].listener.port", "server[0].listener[0].port@9:9=80 server[0].listener[1].port@12:9=443"},
+		{"server[1].host", "server[1].host@18:5=b.com"},
+		{"server[2].host", ""},
+		{"server[host=b.com].ports[1][0]", "server[1].ports[1][0]@19:21=9090"},
+		{"server.ports[*]", "server[1].ports[0]@19:14=8080 server[1].ports[1]@19:20=[9090, 9091]"},
+		{"server[*].host", "server[0].host@7:5=a.com server[1].host@18:5=b.com"},
+		{"server.listener[tls].port", "server[0].listener[1].port@12:9=443"},
+		{"server.listener[tls=strict]", "server[0].listener[1]@11:5"},
+		{"server.listener[tls=loose]", ""},
+		{`server."max.conns"`, "server[1].max.conns@20:5=100"},
+		{"server[1].*", "server[1].host@18:5=b.com server[1].ports@19:5=[8080, [9090, 9091]] server[1].max.conns@20:5=100 server[1].listener@22:9"},
+		{"name[0]", ""},
+	} {
+		matches, err := Query(nodes, tt.query)
+		if err != nil {
+			t.Errorf("Query(%q) failed: %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, m := range matches {
+			s := fmt.Sprintf("%s@%d:%d", m.Path, m.Pos.Line, m.Pos.Column)
+			if m.Value != nil {
+				s += "=" + src[valuePos(m.Value).Offset:valueEnd(m.Value).Offset]
+			}
+			got = append(got, s)
+		}
+		if got := strings.Join(got, " "); got != tt.want {
+			t.Errorf("Query(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"", `xon: invalid query "": missing name at offset 0`},
+		{"server..host", `xon: invalid query "server..host": missing name at offset 7`},
+		{"server[0", `xon: invalid query "server[0": missing ']' at offset 8`},
+		{"server[]", `xon: invalid query "server[]": empty selector at offset 7`},
+		{"server[01]", `xon: invalid query "server[01]": leading zeros are not allowed in index 01`},
+		{"server]", `xon: invalid query "server]": unexpected ']' at offset 6`},
+		{`"server`, `xon: invalid query "\"server": unterminated quoted name`},
+	} {
+		if _, err := Query(nodes, tt.query); err == nil || err.Error() != tt.want {
+			t.Errorf("Query(%q) = %v, want error %q", tt.query, err, tt.want)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	nodes, err := Parse([]byte(`// Database settings.
+xon 0.2
+
+db {
+    host = localhost
+    password = hunter2
+
+    [&replica] replica {
+        password = letmein
+    }
+}
+
+debug = true
+
+legacy {
+    timeout = 30s
+}
+`))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	var paths []string
+	nodes = Rewrite(nodes, func(c *Cursor) bool {
+		paths = append(paths, c.Path())
+		switch node := c.Node().(type) {
+		case *Block:
+			if node.Name == "legacy" {
+				// Skip the contents of deleted blocks.
+				c.Delete()
+				return false
+			}
+		case *Comment:
+			c.Replace(&Comment{Text: "Rewritten."})
+		case *KeyValue:
+			switch node.Key {
+			case "debug":
+				c.InsertBefore(&KeyValue{Key: "log level", Value: &String{Value: "info"}})
+				c.Delete()
+			case "host":
+				node.Key = "hostname"
+			case "password":
+				node.Value = &String{Value: "<redacted>"}
+			}
+		}
+		return true
+	}, func(c *Cursor) bool {
+		if b, ok := c.Node().(*Block); ok && b.Name == "db" {
+			b.Nodes = append(b.Nodes, &KeyValue{Key: "port", Value: &String{Value: "5432"}})
+			c.InsertAfter(&Block{Name: "cache", Nodes: []Node{}})
+		}
+		return true
+	})
+	got, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal the rewritten nodes: %v", err)
+	}
+	want := `// Rewritten.
+xon 0.2
+
+db {
+    hostname = localhost
+    password = <redacted>
+
+    [&replica] replica {
+        password = <redacted>
+    }
+
+    port = 5432
+}
+
+cache {}
+
+log level = info
+`
+	if string(got) != want {
+		t.Errorf("unexpected output after rewriting:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	wantPaths := []string{"", "", "db", "db.host", "db.password", "db.replica", "db.replica.password", "debug", "legacy"}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("unexpected paths visited: got %q, want %q", paths, wantPaths)
+	}
+	// Stop at db.password, after the top-level comment, the spec line, and
+	// db.hostname.
+	visited := 0
+	Rewrite(nodes, nil, func(c *Cursor) bool {
+		visited++
+		return c.Path() != "db.password"
+	})
+	if visited != 4 {
+		t.Errorf("expected the rewrite to stop after 4 nodes, visited %d", visited)
+	}
+}
+
+func TestSign(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	pub := key.Public().(ed25519.PublicKey)
+	nodes, err := Parse([]byte("name = Alice\nserver {\n    port = 8080\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Marshal(Sign(nodes, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := Parse(src)
+	if err != nil {
+		t.Fatalf("failed to parse the signed document: %v", err)
+	}
+	unsigned, err := Verify(signed, pub)
+	if err != nil {
+		t.Fatalf("Verify() = %v, want no error", err)
+	}
+	if !bytes.Equal(Canonical(unsigned), Canonical(nodes)) {
+		t.Errorf("Verify() = %s, want %s", Canonical(unsigned), Canonical(nodes))
+	}
+	// Formatting, comments, and ordering don't affect the signature.
+	sig := src[bytes.Index(src, []byte("signature {")):]
+	reordered, err := Parse(append([]byte("// Reordered.\nserver {\n    port = \"8080\"\n}\nname = \"Alice\"\n\n"), sig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(reordered, pub); err != nil {
+		t.Errorf("Verify() of the reordered document = %v, want no error", err)
+	}
+	// Re-signing replaces the existing signature block.
+	if resigned := Sign(signed, key); len(resigned) != len(signed) {
+		t.Errorf("Sign() of a signed document has %d nodes, want %d", len(resigned), len(signed))
+	}
+	tampered, err := Parse(bytes.Replace(src, []byte("8080"), []byte("8081"), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(tampered, pub); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() of a tampered document = %v, want ErrInvalidSignature", err)
+	}
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize))
+	if _, err := Verify(signed, other.Public().(ed25519.PublicKey)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() with another key = %v, want ErrInvalidSignature", err)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"name = Alice\n", "xon: document is not signed"},
+		{"signature {\n    algorithm = rsa\n    value = b64\"\"\n}\n", `xon: "signature.algorithm": unsupported signature algorithm, expected ed25519`},
+		{"signature {\n    algorithm = ed25519\n    value = abc\n}\n", `xon: "signature.value": expected a bytes literal of 64 bytes`},
+		{"signature {\n    algorithm = ed25519\n    key = abc\n}\n", `xon: "signature.key": unknown key in the signature block`},
+	} {
+		nodes, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(nodes, pub); err == nil || err.Error() != tt.want {
+			t.Errorf("Verify(%q) = %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestStringValues(t *testing.T) {
+	nodes, err := Parse([]byte("timeout = 1h30m\ncreated = 2026-01-02T15:04:05+01:00\nname = x\nlimit = 1.5GiB\n"))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	value := func(i int) *String {
+		return nodes[i].(*KeyValue).Value.(*String)
+	}
+	if d, err := value(0).Duration(); err != nil || d != 90*time.Minute {
+		t.Errorf("Duration() = %v, %v, want 1h30m", d, err)
+	}
+	if tm, err := value(1).Time(); err != nil || !tm.Equal(time.Date(2026, 1, 2, 14, 4, 5, 0, time.UTC)) {
+		t.Errorf("Time() = %v, %v, want 2026-01-02T14:04:05Z", tm, err)
+	}
+	if b, err := value(3).ByteSize(); err != nil || b != 3<<29 {
+		t.Errorf("ByteSize() = %v, %v, want 1.5GiB", b, err)
+	}
+	if n, err := value(3).Number(); err == nil {
+		t.Errorf("Number() = %v, want a syntax error", n)
+	}
+	if _, err := value(2).ByteSize(); err == nil || err.Error() != `xon: cannot decode "x" as xon.ByteSize: invalid syntax` {
+		t.Errorf("ByteSize() on an invalid value = %v, want a syntax error", err)
+	}
+	if _, err := value(2).Duration(); err == nil || err.Error() != `xon: cannot decode "x" as time.Duration: invalid syntax` {
+		t.Errorf("Duration() on an invalid value = %v, want a syntax error", err)
+	}
+	if _, err := value(2).Time(); err == nil || err.Error() != `xon: cannot decode "x" as time.Time: invalid syntax` {
+		t.Errorf("Time() on an invalid value = %v, want a syntax error", err)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	src := `// Config for the espra node.
+name = node 1
+proxy = nil
+alias = "nil"
+tags = [a, // first
+    b, [c]]
+
+server {
+    host = a.com
+}
+
+limits {}
+
+server {
+    host = <|0x3C|>b.com
+}
+
+[v2] {
+    tls = strict
+}
+`
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	got, err := ToJSON(nodes)
+	if err != nil {
+		t.Fatalf("failed to convert to JSON: %v", err)
+	}
+	want := `{"name":"node 1","proxy":null,"alias":"nil","tags":["a","b",["c"]],"server":[{"host":"a.com"},{"host":"<b.com"}],"limits":{},"[v2]":{"tls":"strict"}}`
+	if string(got) != want {
+		t.Errorf("ToJSON = %s, want %s", got, want)
+	}
+	back, err := FromJSON(got)
+	if err != nil {
+		t.Fatalf("failed to convert JSON back: %v", err)
+	}
+	if again, err := ToJSON(back); err != nil || string(again) != want {
+		t.Errorf("ToJSON(FromJSON(%s)) = %s, %v", want, again, err)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"a = 1\na {}\n", `xon: "a": cannot convert to JSON as the name is used for both a key and a block`},
+		{"x {\n    a {}\n    a = 1\n}\n", `xon: "x.a": cannot convert to JSON as the name is used for both a key and a block`},
+		{"\"[v2]\" = 1\n", `xon: "[v2]": cannot convert to JSON as the key is named like a versioned block`},
+	} {
+		nodes, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.src, err)
+		}
+		_, err = ToJSON(nodes)
+		if err == nil {
+			t.Errorf("ToJSON(%q) succeeded, want error %q", tt.src, tt.want)
+		} else if err.Error() != tt.want {
+			t.Errorf("ToJSON(%q) = error %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	src := "// header\r\nserver {  // opening\n    \"host name\" = example.com\n    ports = [80, \"443\"]\n    [v2] {\n        motd = `\n            hello\n        `\n    }\n}\nbad"
+	tok := NewTokenizer([]byte(src))
+	var got []string
+	for {
+		token, err := tok.Token()
+		if err != nil {
+			got = append(got, err.Error())
+			break
+		}
+		if strings.ReplaceAll(src[token.Pos.Offset:token.End.Offset], "\r\n", "\n") != token.Text {
+			t.Errorf("unexpected offsets for %q: %d to %d", token.Text, token.Pos.Offset, token.End.Offset)
+		}
+		got = append(got, fmt.Sprintf("%s-%s %s %q %q", token.Pos, token.End, token.Kind, token.Text, token.Value))
+	}
+	want := `1:1-1:10 comment "// header" "header"
+2:1-2:7 block name "server" "server"
+2:8-2:9 '{' "{" ""
+2:11-2:21 comment "// opening" "opening"
+3:5-3:16 key "\"host name\"" "host name"
+3:17-3:18 '=' "=" ""
+3:19-3:30 string "example.com" "example.com"
+4:5-4:10 key "ports" "ports"
+4:11-4:12 '=' "=" ""
+4:13-4:14 '[' "[" ""
+4:14-4:16 string "80" "80"
+4:16-4:17 ',' "," ""
+4:18-4:23 string "\"443\"" "443"
+4:23-4:24 ']' "]" ""
+5:5-5:9 version "[v2]" "2"
+5:10-5:11 '{' "{" ""
+6:9-6:13 key "motd" "motd"
+6:14-6:15 '=' "=" ""
+6:16-8:10 string "` + "`\\n            hello\\n        `" + `" "hello"
+9:5-9:6 '}' "}" ""
+10:1-10:2 '}' "}" ""
+xon: 11:4: identifier "bad" without '=' or '{'`
+	if got := strings.Join(got, "\n"); got != want {
+		t.Errorf("unexpected tokens:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	if _, err := NewTokenizer(nil).Token(); err != io.EOF {
+		t.Errorf("expected io.EOF for an empty document, got %v", err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	type Node struct {
+		Host string `xon:"host"`
+		Port uint16 `xon:"port"`
+	}
+	type Base struct {
+		Name *string `xon:"name"`
+	}
+	type Config struct {
+		*Base
+		Any       any            `xon:"any"`
+		Created   time.Time      `xon:"created"`
+		Data      []byte         `xon:"data"`
+		Key       []byte         `xon:"key"`
+		Limits    map[string]int `xon:"limits"`
+		Matrix    [][]int        `xon:"matrix"`
+		Nodes     []*Node        `xon:"node"`
+		Optional  *int           `xon:"optional"`
+		Ratio     float32        `xon:"ratio"`
+		TLS       bool           `xon:"tls"`
+		Timeout   time.Duration  `xon:"timeout"`
+		Untagged  string
+		Versioned map[string]string `xon:"versioned"`
+	}
+	src := `
+name = "nil"
+any = [a, nil, [b]]
+created = 2026-01-15T23:30:00.5+05:30
+data = aGVsbG8=
+key = hex"68656C6C6F"
+limits {
+    max conns = 1_000
+    idle = 0x10
+}
+matrix = [[1, 2], [3]]
+node {
+    host = fast.espra.dev
+    port = 8040
+}
+node {
+    host = archive.espra.dev
+    port = 8041
+}
+optional = nil
+ratio = .5
+tls = true
+timeout = 1h30m0.25s
+untagged = value
+unknown = ignored
+versioned {
+    a = 1
+    [v2] {
+        b = 2
+    }
+}
+`
+	cfg := &Config{Optional: new(int)}
+	if err := Unmarshal([]byte(src), cfg); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if cfg.Base == nil || cfg.Name == nil || *cfg.Name != "nil" {
+		t.Errorf("unexpected value for the embedded name: %#v", cfg.Base)
+	}
+	if got, want := fmt.Sprint(cfg.Any), "[a <nil> [b]]"; got != want {
+		t.Errorf("unexpected value for any: got %s, want %s", got, want)
+	}
+	if got, want := cfg.Created.UTC().Format(time.RFC3339Nano), "2026-01-15T18:00:00.5Z"; got != want {
+		t.Errorf("unexpected value for created: got %s, want %s", got, want)
+	}
+	if string(cfg.Data) != "hello" {
+		t.Errorf("unexpected value for data: %q", cfg.Data)
+	}
+	if string(cfg.Key) != "hello" {
+		t.Errorf("unexpected value for key: %q", cfg.Key)
+	}
+	if cfg.Limits["max conns"] != 1000 || cfg.Limits["idle"] != 16 {
+		t.Errorf("unexpected value for limits: %v", cfg.Limits)
+	}
+	if got, want := fmt.Sprint(cfg.Matrix), "[[1 2] [3]]"; got != want {
+		t.Errorf("unexpected value for matrix: got %s, want %s", got, want)
+	}
+	if len(cfg.Nodes) != 2 || *cfg.Nodes[0] != (Node{"fast.espra.dev", 8040}) || *cfg.Nodes[1] != (Node{"archive.espra.dev", 8041}) {
+		t.Errorf("unexpected value for nodes: %v", cfg.Nodes)
+	}
+	if cfg.Optional != nil {
+		t.Errorf("expected optional to be reset to nil, got %d", *cfg.Optional)
+	}
+	if cfg.Ratio != 0.5 || !cfg.TLS || cfg.Untagged != "value" {
+		t.Errorf("unexpected scalar values: %v, %v, %q", cfg.Ratio, cfg.TLS, cfg.Untagged)
+	}
+	if cfg.Timeout != 90*time.Minute+250*time.Millisecond {
+		t.Errorf("unexpected value for timeout: %s", cfg.Timeout)
+	}
+	if cfg.Versioned["a"] != "1" || cfg.Versioned["b"] != "2" {
+		t.Errorf("unexpected value for versioned: %v", cfg.Versioned)
+	}
+	var doc any
+	if err := Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatalf("failed to unmarshal into an interface: %v", err)
+	}
+	if got, want := doc.(map[string]any)["key"], []byte("hello"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected value for key within interface: got %#v, want %#v", got, want)
+	}
+	if got, want := fmt.Sprint(doc.(map[string]any)["node"]), "[map[host:fast.espra.dev port:8040] map[host:archive.espra.dev port:8041]]"; got != want {
+		t.Errorf("unexpected value for node within interface: got %s, want %s", got, want)
+	}
+	data, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	again := &Config{}
+	if err := Unmarshal(data, again); err != nil {
+		t.Fatalf("failed to unmarshal marshalled config: %v\n\n%s", err, data)
+	}
+	if !reflect.DeepEqual(cfg, again) {
+		t.Errorf("config changed after a round trip:\n\n%s", data)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type Server struct {
+		Port uint16 `xon:"port"`
+	}
+	type Config struct {
+		Server Server    `xon:"server"`
+		Tags   [1]string `xon:"tags"`
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"server {\n    port = 0123\n}", `xon: "server.port": cannot decode "0123" as uint16: leading zeros are not allowed`},
+		{"server {\n    port = 70000\n}", `xon: "server.port": cannot decode "70000" as uint16: value out of range`},
+		{"server {\n    port = -1\n}", `xon: "server.port": cannot decode "-1" as uint16: value out of range`},
+		{"server = nil", `xon: "server": cannot decode a string into xon.Server`},
+		{"server {}\nserver {}", `xon: "server": cannot decode 2 blocks into xon.Server`},
+		{"tags = [a, b]", `xon: "tags": cannot decode a list of 2 elements into [1]string`},
+		{"tags {}", `xon: "tags[0]": cannot decode a block into string`},
+		{"tags = [hex\"ff\"]", `xon: "tags[0]": cannot decode bytes into string`},
+		{"key", `xon: 1:4: identifier "key" without '=' or '{'`},
+	} {
+		err := Unmarshal([]byte(tt.src), &Config{})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error for %q: got %v, want %s", tt.src, err, tt.want)
+		}
+	}
+	if err := Unmarshal([]byte("a = b"), Config{}); err == nil {
+		t.Errorf("expected an error when unmarshalling into a non-pointer")
+	}
+}
+
+func TestUnmarshalReferences(t *testing.T) {
+	type Server struct {
+		Listen  []int         `xon:"listen"`
+		Name    string        `xon:"name"`
+		Retries int           `xon:"retries"`
+		Timeout time.Duration `xon:"timeout"`
+	}
+	type Config struct {
+		Servers []Server `xon:"server"`
+	}
+	src := `xon 0.2
+
+[&port] port = 8080
+
+[&defaults] defaults {
+    listen = [[*port]]
+    retries = 3
+    timeout = 30s
+}
+
+server {
+    [*defaults]
+    name = a
+}
+
+server {
+    [*defaults]
+    name = b
+    retries = 5
+
+    [v2] {
+        listen = [[*port], 9090]
+    }
+}
+`
+	cfg := &Config{}
+	if err := Unmarshal([]byte(src), cfg); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	want := []Server{
+		{Listen: []int{8080}, Name: "a", Retries: 3, Timeout: 30 * time.Second},
+		{Listen: []int{8080, 9090}, Name: "b", Retries: 5, Timeout: 30 * time.Second},
+	}
+	if !reflect.DeepEqual(cfg.Servers, want) {
+		t.Errorf("unexpected servers: got %+v, want %+v", cfg.Servers, want)
+	}
+	nodes, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	got, err := ToJSON(nodes[3:])
+	if err != nil {
+		t.Fatalf("failed to convert to JSON: %v", err)
+	}
+	if want := `{"server":[{"listen":["8080"],"retries":"3","timeout":"30s","name":"a"},{"timeout":"30s","name":"b","retries":"5","[v2]":{"listen":["8080","9090"]}}]}`; string(got) != want {
+		t.Errorf("ToJSON = %s, want %s", got, want)
+	}
+	out, err := Marshal(nodes)
+	if err != nil {
+		t.Fatalf("failed to marshal nodes: %v", err)
+	}
+	if string(out) != src {
+		t.Errorf("unexpected output when marshalling parsed nodes:\n\n%s\n\nwant:\n\n%s", out, src)
+	}
+}
+
+func TestUnmarshalTimes(t *testing.T) {
+	type Config struct {
+		Created  time.Time       `xon:"created,layout=DateOnly"`
+		Expires  *time.Time      `xon:"expires,layout=Mon, 02 Jan 2006"`
+		Holidays []time.Time     `xon:"holidays,layout=2006-01-02"`
+		Interval time.Duration   `xon:"interval,unit=ms"`
+		Retries  []time.Duration `xon:"retries,unit=s"`
+		Seen     time.Time       `xon:"seen,layout=unix"`
+		Timeout  time.Duration   `xon:"timeout,unit=s"`
+		Updated  time.Time       `xon:"updated"`
+	}
+	expires := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	cfg := &Config{
+		Created:  time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+		Expires:  &expires,
+		Holidays: []time.Time{time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC)},
+		Interval: 1500 * time.Microsecond,
+		Retries:  []time.Duration{time.Second, 90 * time.Second},
+		Seen:     time.Unix(1767225600, 0).UTC(),
+		Timeout:  30 * time.Second,
+		Updated:  time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC),
+	}
+	got, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	want := `created = 2026-01-15
+expires = Mon, 09 Mar 2026
+holidays = [2026-12-25]
+interval = 1ms500µs
+retries = [1, 90]
+seen = 1767225600
+timeout = 30
+updated = 2026-01-15T10:30:00Z
+`
+	if string(got) != want {
+		t.Errorf("unexpected output from Marshal:\n\n%s\n\nwant:\n\n%s", got, want)
+	}
+	dec := &Config{}
+	if err := Unmarshal(got, dec); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if !reflect.DeepEqual(dec, cfg) {
+		t.Errorf("unexpected round trip: got %+v, want %+v", dec, cfg)
+	}
+	src := `created = 2026-01-15T10:30:00Z
+interval = 2.5
+retries = [1m, "0.5", 2]
+seen = "2026-01-01T00:00:00Z"
+timeout = 1m30s
+`
+	dec = &Config{}
+	if err := Unmarshal([]byte(src), dec); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	if !dec.Created.Equal(cfg.Updated) || !dec.Seen.Equal(cfg.Seen) {
+		t.Errorf("unexpected times when falling back to RFC 3339: %v, %v", dec.Created, dec.Seen)
+	}
+	if dec.Interval != 2500*time.Microsecond || dec.Timeout != 90*time.Second {
+		t.Errorf("unexpected durations: %v, %v", dec.Interval, dec.Timeout)
+	}
+	if want := []time.Duration{time.Minute, 500 * time.Millisecond, 2 * time.Second}; !reflect.DeepEqual(dec.Retries, want) {
+		t.Errorf("unexpected list of durations: got %v, want %v", dec.Retries, want)
+	}
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{"created = 15/01/2026", `xon: "created": cannot decode "15/01/2026" as time.Time: expected the layout "2006-01-02" or RFC 3339`},
+		{"seen = yesterday", `xon: "seen": cannot decode "yesterday" as time.Time: expected the layout "unix" or RFC 3339`},
+		{"timeout = 99999999999999", `xon: "timeout": cannot decode "99999999999999" as time.Duration: value out of range`},
+		{"timeout = soon", `xon: "timeout": cannot decode "soon" as time.Duration: invalid syntax`},
+	} {
+		err := Unmarshal([]byte(tt.src), &Config{})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error for %q: got %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalValues(t *testing.T) {
+	var (
+		boolType  = reflect.TypeFor[bool]()
+		floatType = reflect.TypeFor[float64]()
+		intType   = reflect.TypeFor[int64]()
+	)
+	for _, tt := range []struct {
+		src  string
+		typ  reflect.Type
+		want any
+	}{
+		{"true", boolType, true},
+		{"NO", boolType, errSyntax},
+		{"42", intType, int64(42)},
+		{"-567", intType, int64(-567)},
+		{"+89", intType, int64(89)},
+		{"0", intType, int64(0)},
+		{"0123", intType, errLeadingZero},
+		{"0xab47", intType, int64(0xab47)},
+		{"0XAB47", intType, int64(0xab47)},
+		{"-0xab47", intType, int64(-0xab47)},
+		{"0o777", intType, int64(0o777)},
+		{"2_00_000", intType, int64(200000)},
+		{"0xdead_beef", intType, int64(0xdeadbeef)},
+		{"0x_ab", intType, errSyntax},
+		{"1__0", intType, errSyntax},
+		{"_1", intType, errSyntax},
+		{"3.14", intType, errSyntax},
+		{"9223372036854775808", intType, errRange},
+		{"-9223372036854775808", intType, int64(math.MinInt64)},
+		{"42", floatType, 42.0},
+		{"3.14", floatType, 3.14},
+		{".5", floatType, 0.5},
+		{"2.", floatType, 2.0},
+		{"-.5", floatType, -0.5},
+		{"1.2e10", floatType, 1.2e10},
+		{"1.2E-10", floatType, 1.2e-10},
+		{"3.141_592", floatType, 3.141592},
+		{"3._14", floatType, errSyntax},
+		{"0123.5", floatType, errLeadingZero},
+		{"0x1p3", floatType, errSyntax},
+		{"1e", floatType, errSyntax},
+		{"inf", floatType, math.Inf(1)},
+		{"-inf", floatType, math.Inf(-1)},
+		{"Inf", floatType, errSyntax},
+		{"14h35m0.2s", durationType, 14*time.Hour + 35*time.Minute + 200*time.Millisecond},
+		{"-4h30m", durationType, -(4*time.Hour + 30*time.Minute)},
+		{"1w2d", durationType, 9 * 24 * time.Hour},
+		{"5µs10us15μs", durationType, 30 * time.Microsecond},
+		{"1.5h", durationType, errors.New("only seconds can be fractional")},
+		{"30", durationType, errSyntax},
+		{"20000w", durationType, errRange},
+		{"512KB", byteSizeType, ByteSize(512_000)},
+		{"512kB", byteSizeType, ByteSize(512_000)},
+		{"4MiB", byteSizeType, ByteSize(4 << 20)},
+		{"1.5GB", byteSizeType, ByteSize(1_500_000_000)},
+		{"0.5KiB", byteSizeType, ByteSize(512)},
+		{"1_024B", byteSizeType, ByteSize(1024)},
+		{"42", byteSizeType, ByteSize(42)},
+		{"16EiB", byteSizeType, errRange},
+		{"1.0001KB", byteSizeType, errors.New("not a whole number of bytes")},
+		{"0512KB", byteSizeType, errLeadingZero},
+		{"4mb", byteSizeType, errSyntax},
+		{"4 MiB", byteSizeType, errSyntax},
+		{"1.GB", byteSizeType, errSyntax},
+		{"-1KB", byteSizeType, errSyntax},
+		{"12345678901234567890123", numberType, Number("12345678901234567890123")},
+		{"0xdead_beef", numberType, Number("0xdead_beef")},
+		{"-12.50", numberType, Number("-12.50")},
+		{"1e400", numberType, Number("1e400")},
+		{"0123", numberType, errLeadingZero},
+		{"12.5.0", numberType, errSyntax},
+		{"nan", numberType, errors.New("nan and inf are not allowed")},
+	} {
+		target := reflect.New(tt.typ).Elem()
+		err := decodeString(tt.src, target, "v")
+		if want, ok := tt.want.(error); ok {
+			if err == nil || !strings.HasSuffix(err.Error(), ": "+want.Error()) {
+				t.Errorf("unexpected error when decoding %q into %s: got %v, want %v", tt.src, tt.typ, err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to decode %q into %s: %v", tt.src, tt.typ, err)
+			continue
+		}
+		if got := target.Interface(); got != tt.want {
+			t.Errorf("unexpected value when decoding %q into %s: got %v, want %v", tt.src, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestValidSyntax(t *testing.T) {
+	data, err := os.ReadFile("parse.tests")
+	if err != nil {
+		t.Fatalf("failed to read parse.tests: %v", err)
+	}
+	for _, test := range strings.Split(string(data), "-----\n") {
+		src, _, _ := strings.Cut(strings.TrimSpace(test), "---\n")
+		for _, src := range []string{src, strings.ReplaceAll(src, "\n", "\r\n")} {
+			_, err := Parse([]byte(src))
+			pos, ok := ValidSyntaxPos([]byte(src))
+			if err == nil {
+				if !ok {
+					t.Errorf("unexpected syntax error at %s for valid source:\n\n%s", pos, src)
+				}
+				continue
+			}
+			perr := err.(*Error)
+			semantic := false
+			switch perr.Code {
+			case CodeDuplicateKey, CodeMixedVersions, CodeReferenceCycle:
+				semantic = true
+			case CodeInvalidAnchor:
+				semantic = strings.HasPrefix(perr.Message, "duplicate anchor")
+			case CodeInvalidReference:
+				semantic = !strings.HasPrefix(perr.Message, "invalid reference")
+			}
+			// Errors which depend on the rest of the document are left to the
+			// parser, so the syntax is still valid.
+			if semantic {
+				if !ok {
+					t.Errorf("unexpected syntax error at %s for source only rejected by Parse with %q:\n\n%s", pos, perr.Message, src)
+				}
+				continue
+			}
+			if ok {
+				t.Errorf("missing syntax error %q for source:\n\n%s", perr.Message, src)
+				continue
+			}
+			// The parser moves errors at the end of an unclosed list back by
+			// a column.
+			if perr.Code == CodeUnexpectedEOF && pos.Column == perr.Column+1 {
+				pos.Column--
+			}
+			if pos.Line != perr.Line || pos.Column != perr.Column {
+				t.Errorf("unexpected position of syntax error %q: got %d:%d, want %d:%d", perr.Message, pos.Line, pos.Column, perr.Line, perr.Column)
+			}
+		}
+	}
+	src := []byte(`xon 0.2
+[&base] server {
+    hosts = [a, b, "c<|0x41|>"]
+    data = b64"aGVsbG8="
+    motd = ` + "```" + `|
+        hello
+        ` + "```" + `
+}
+[v2] {
+    copy {
+        [*base]
+        ports += [80, 443]
+    }
+}
+`)
+	if !ValidSyntax(src) {
+		t.Fatalf("unexpected syntax error for valid source:\n\n%s", src)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { ValidSyntax(src) }); allocs != 0 {
+		t.Errorf("unexpected allocations by ValidSyntax: got %v, want 0", allocs)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	nodes, err := Parse([]byte(`xon 0.2
+
+a = 1
+
+[&base] b {
+    c = 2
+    d {
+        e = 3
+    }
+}
+
+[v2] {
+    f = 4
+}
+
+g {
+    [*base]
+}
+`))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+	var names []string
+	Walk(nodes, func(node Node) bool {
+		switch node := node.(type) {
+		case *Block:
+			names = append(names, node.Name)
+			return node.Name != "d"
+		case *KeyValue:
+			names = append(names, node.Key)
+		case *Reference:
+			names = append(names, "*"+node.Name)
+		case *VersionedBlock:
+			names = append(names, fmt.Sprintf("v%d", node.Version))
+		}
+		return true
+	})
+	want := []string{"a", "b", "c", "d", "v2", "f", "g", "*base", "c", "d"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected nodes visited: got %q, want %q", names, want)
+	}
+}
+
+// benchmarkSource returns a multi-megabyte document with a mix of blocks,
+// key/value pairs, lists, comments, and strings.
+func benchmarkSource() []byte {
+	b := &bytes.Buffer{}
+	for i := range 10000 {
+		fmt.Fprintf(b, "// Server %d\n", i)
+		fmt.Fprintf(b, "server {\n")
+		fmt.Fprintf(b, "    host = node-%d.espra.dev\n", i)
+		fmt.Fprintf(b, "    port = %d  // the port to listen on\n", 8000+i%1000)
+		fmt.Fprintf(b, "    description = \"Server number %d, with a <|0x41|> byte escape\"\n", i)
+		fmt.Fprintf(b, "    tags = [alpha, beta, gamma, delta]\n")
+		fmt.Fprintf(b, "    limits {\n        rate = %d\n        burst = 50\n        timeout = 1m30s\n    }\n", i%100)
+		fmt.Fprintf(b, "    motd = `\n        Welcome to server %d.\n        Be nice.\n    `\n", i)
+		fmt.Fprintf(b, "}\n\n")
+	}
+	return b.Bytes()
+}
+
+func BenchmarkParse(b *testing.B) {
+	src := benchmarkSource()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Parse(src); err != nil {
+			b.Fatalf("failed to parse: %v", err)
+		}
+	}
+}
+
+func BenchmarkParseArena(b *testing.B) {
+	src := benchmarkSource()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	arena := &Arena{}
+	for b.Loop() {
+		if _, err := ParseArena(src, arena); err != nil {
+			b.Fatalf("failed to parse: %v", err)
+		}
+		arena.Reset()
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	type Server struct {
+		Description string            `xon:"description"`
+		Host        string            `xon:"host"`
+		Limits      map[string]string `xon:"limits"`
+		MOTD        string            `xon:"motd"`
+		Port        int               `xon:"port"`
+		Tags        []string          `xon:"tags"`
+	}
+	type Config struct {
+		Servers []Server `xon:"server"`
+	}
+	src := benchmarkSource()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := Unmarshal(src, &Config{}); err != nil {
+			b.Fatalf("failed to unmarshal: %v", err)
+		}
+	}
+}
+
+func BenchmarkValidSyntax(b *testing.B) {
+	src := benchmarkSource()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if !ValidSyntax(src) {
+			b.Fatal("unexpected syntax error")
+		}
+	}
+}
+
+// FuzzParse checks that parsing never panics, that limits are respected, that
+// ValidSyntax accepts parsed documents, and that parsed documents can be printed
+// and parsed again, starting from the sources within parse.tests.
+func FuzzParse(f *testing.F) {
+	data, err := os.ReadFile("parse.tests")
+	if err != nil {
+		f.Fatalf("failed to read parse.tests: %v", err)
+	}
+	for _, test := range strings.Split(string(data), "-----\n") {
+		src, _, _ := strings.Cut(strings.TrimSpace(test), "---\n")
+		f.Add([]byte(src))
+	}
+	limits := Limits{MaxDepth: 16, MaxSize: 1 << 16, MaxTokens: 1 << 12}
+	f.Fuzz(func(t *testing.T, src []byte) {
+		nodes, err := ParseLimited(src, limits)
+		if err == nil && !ValidSyntax(src) {
+			t.Fatalf("unexpected syntax error from ValidSyntax for parsed input:\n%s", src)
+		}
+		if err != nil {
+			perr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			// Only recover from errors within documents that are small
+			// enough to be parsed without limits.
+			if perr.Code != CodeLimitExceeded {
+				ParseAll(src)
+			}
+			return
+		}
+		out, err := Marshal(nodes)
+		if err != nil {
+			t.Fatalf("failed to marshal parsed nodes: %v\ninput:\n%s", err, src)
+		}
+		if _, err := Parse(out); err != nil {
+			t.Fatalf("failed to parse printed nodes: %v\ninput:\n%s\noutput:\n%s", err, src, out)
+		}
+	})
 }
@@ -0,0 +1,1442 @@
+// Public Domain (-) 2026-present, The Espra Core Authors.
+// See the Espra Core UNLICENSE file for details.
+
+package xon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Contexts in which a YAML node can start, as each allows different kinds of
+// content on the same line.
+const (
+	yamlAfterDash yamlContext = iota // after the `-` of a sequence entry
+	yamlAfterKey                     // after the `:` of a mapping key
+	yamlRoot                         // at the start of a document
+)
+
+// Kinds of YAML nodes.
+const (
+	yamlMapping yamlKind = iota
+	yamlScalar
+	yamlSequence
+)
+
+// maxYAMLNodes limits the number of nodes that a YAML document may expand into
+// when aliases are resolved, so that documents which nest aliases, e.g. a
+// "billion laughs" attack, can't exhaust memory.
+const maxYAMLNodes = 1_000_000
+
+// yamlEscapes maps the single character escape sequences within double-quoted
+// YAML scalars to the characters they represent.
+var yamlEscapes = map[byte]rune{
+	'\t': '\t',
+	' ':  ' ',
+	'"':  '"',
+	'/':  '/',
+	'0':  0,
+	'L':  '\u2028',
+	'N':  '\u0085',
+	'P':  '\u2029',
+	'\\': '\\',
+	'_':  '\u00a0',
+	'a':  '\a',
+	'b':  '\b',
+	'e':  0x1b,
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+}
+
+type yamlContext int
+
+// yamlConverter converts parsed YAML documents into XON nodes.
+type yamlConverter struct {
+	nodes int
+}
+
+func (c *yamlConverter) convert(doc *yamlDocument) ([]Node, error) {
+	nodes := []Node{}
+	if root := doc.root; root != nil && !isYAMLNull(root) {
+		if root.kind != yamlMapping || root.tag == "!!set" {
+			return nil, errors.New("xon: cannot convert YAML document: the top level must be a mapping")
+		}
+		var err error
+		if nodes, err = c.members(root, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, text := range doc.comments {
+		nodes = append(nodes, &Comment{Text: text})
+	}
+	return nodes, nil
+}
+
+func (c *yamlConverter) count() error {
+	c.nodes++
+	if c.nodes > maxYAMLNodes {
+		return fmt.Errorf("xon: cannot convert YAML document: it expands into more than %d nodes", maxYAMLNodes)
+	}
+	return nil
+}
+
+// entry appends the nodes for the given mapping entry. Mappings become blocks,
+// and sequences of mappings become repeated blocks with the same name.
+func (c *yamlConverter) entry(nodes []Node, key string, value *yamlNode, path string) ([]Node, error) {
+	if err := c.count(); err != nil {
+		return nil, err
+	}
+	if value.kind == yamlMapping && value.tag != "!!set" {
+		members, err := c.members(value, path)
+		if err != nil {
+			return nil, err
+		}
+		return append(nodes, &Block{
+			ClosingComment: value.comment,
+			Name:           key,
+			Nodes:          members,
+			OpeningComment: value.opening,
+		}), nil
+	}
+	if value.kind == yamlSequence {
+		blocks := 0
+		for _, item := range value.entries {
+			if item.value.kind == yamlMapping && item.value.tag != "!!set" {
+				blocks++
+			}
+		}
+		if blocks > 0 {
+			if blocks != len(value.entries) {
+				return nil, decodeErrorf(path, "cannot mix mappings with other values in a sequence")
+			}
+			if err := checkYAMLTag(value, path, "!!seq"); err != nil {
+				return nil, err
+			}
+			for _, text := range []string{value.opening, value.comment} {
+				if text != "" {
+					nodes = append(nodes, &Comment{Text: text})
+				}
+			}
+			for i, item := range value.entries {
+				for _, text := range item.comments {
+					nodes = append(nodes, &Comment{Text: text})
+				}
+				members, err := c.members(item.value, indexPath(path, i))
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, &Block{
+					ClosingComment: item.value.comment,
+					Name:           key,
+					Nodes:          members,
+					OpeningComment: item.value.opening,
+				})
+			}
+			return nodes, nil
+		}
+	}
+	v, err := c.value(value, path)
+	if err != nil {
+		return nil, err
+	}
+	return append(nodes, &KeyValue{Comment: value.comment, Key: key, Value: v}), nil
+}
+
+// members returns the nodes for the entries of a mapping, after resolving any
+// merge keys.
+func (c *yamlConverter) members(node *yamlNode, path string) ([]Node, error) {
+	if err := checkYAMLTag(node, path, "!!map"); err != nil {
+		return nil, err
+	}
+	entries, err := mergeYAMLEntries(node, path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		for _, text := range e.comments {
+			nodes = append(nodes, &Comment{Text: text})
+		}
+		if e.key.kind != yamlScalar {
+			return nil, decodeErrorf(path, "cannot convert a mapping key which isn't a scalar")
+		}
+		key := e.key.value
+		keyPath := childPath(path, key)
+		if seen[key] {
+			return nil, decodeErrorf(keyPath, "duplicate key in YAML mapping")
+		}
+		seen[key] = true
+		if nodes, err = c.entry(nodes, key, e.value, keyPath); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// value returns the XON value for a scalar, or a list for sequences and sets.
+func (c *yamlConverter) value(node *yamlNode, path string) (Value, error) {
+	switch node.kind {
+	case yamlMapping:
+		if node.tag != "!!set" {
+			return nil, decodeErrorf(path, "cannot convert a mapping within a sequence")
+		}
+		entries, err := mergeYAMLEntries(node, path)
+		if err != nil {
+			return nil, err
+		}
+		list := &List{Content: []Value{}}
+		for _, e := range entries {
+			if e.key.kind != yamlScalar {
+				return nil, decodeErrorf(path, "cannot convert a set element which isn't a scalar")
+			}
+			list.Content = append(list.Content, &String{Quoted: e.key.value == "nil", Value: e.key.value})
+		}
+		return list, nil
+	case yamlSequence:
+		if err := checkYAMLTag(node, path, "!!seq"); err != nil {
+			return nil, err
+		}
+		list := &List{Content: []Value{}, OpeningComment: node.opening}
+		for i, item := range node.entries {
+			if err := c.count(); err != nil {
+				return nil, err
+			}
+			for _, text := range item.comments {
+				list.Content = append(list.Content, &Comment{Text: text})
+			}
+			elem, err := c.value(item.value, indexPath(path, i))
+			if err != nil {
+				return nil, err
+			}
+			list.Content = append(list.Content, elem)
+			if item.value.comment != "" {
+				list.Content = append(list.Content, &Comment{Inline: true, Text: item.value.comment})
+			}
+		}
+		return list, nil
+	}
+	return yamlScalarValue(node, path)
+}
+
+// yamlDocument represents a parsed YAML document, along with any comments that
+// follow its content.
+type yamlDocument struct {
+	comments []string
+	root     *yamlNode
+}
+
+// yamlEntry represents an entry of a mapping, or of a sequence, in which case
+// the key is nil. The comments are those on the lines before the entry.
+type yamlEntry struct {
+	comments []string
+	key      *yamlNode
+	value    *yamlNode
+}
+
+type yamlKind int
+
+// yamlNode represents a YAML node. For block collections, the opening comment
+// is the one on the line that starts the collection. For all other nodes, the
+// comment is the one on the line where the node ends.
+type yamlNode struct {
+	comment string
+	entries []*yamlEntry
+	kind    yamlKind
+	opening string
+	plain   bool
+	tag     string
+	value   string
+}
+
+// yamlParser parses the subset of YAML 1.2 which can be represented in XON,
+// i.e. block and flow collections, all scalar styles, anchors and aliases,
+// tags, and multiple documents. Complex mapping keys and directives other than
+// %YAML aren't supported.
+type yamlParser struct {
+	anchors  map[string]*yamlNode
+	comments []string // comment lines not yet attached to an entry
+	pos      int
+	src      []byte
+}
+
+func (p *yamlParser) at(i int) byte {
+	if i < len(p.src) {
+		return p.src[i]
+	}
+	return 0
+}
+
+func (p *yamlParser) column() int {
+	return p.pos - (bytes.LastIndexByte(p.src[:p.pos], '\n') + 1)
+}
+
+func (p *yamlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	line := 1 + bytes.Count(p.src[:p.pos], []byte("\n"))
+	return fmt.Errorf("xon: invalid YAML: %d:%d: %s", line, p.column()+1, fmt.Sprintf(format, args...))
+}
+
+// isDocumentMarker returns whether a `---` or `...` marker starts at the given
+// offset, which must be at the start of a line.
+func (p *yamlParser) isDocumentMarker(i int) bool {
+	if !bytes.HasPrefix(p.src[i:], []byte("---")) && !bytes.HasPrefix(p.src[i:], []byte("...")) {
+		return false
+	}
+	return isYAMLSpace(p.at(i + 3))
+}
+
+// isMappingKey returns whether an implicit mapping key, i.e. a single line
+// scalar followed by a `:` and whitespace, starts at the given offset.
+func (p *yamlParser) isMappingKey(i int) bool {
+	switch c := p.at(i); c {
+	case '"', '\'':
+		end, ok := p.quotedEnd(i)
+		if !ok {
+			return false
+		}
+		for i = end; p.at(i) == ' ' || p.at(i) == '\t'; i++ {
+		}
+		return p.at(i) == ':' && isYAMLSpace(p.at(i+1))
+	case 0, '\n', '#', '&', '!', '*', '[', ']', '{', '}', ',', '|', '>', '%', '@', '`':
+		return false
+	}
+	for ; !isYAMLBreak(p.at(i)); i++ {
+		switch p.at(i) {
+		case ':':
+			if isYAMLSpace(p.at(i + 1)) {
+				return true
+			}
+		case '#':
+			if p.src[i-1] == ' ' || p.src[i-1] == '\t' {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+func (p *yamlParser) isSequenceEntry(i int) bool {
+	return p.at(i) == '-' && isYAMLSpace(p.at(i+1))
+}
+
+// lineEnd skips the rest of the current line, which may only hold whitespace
+// and a comment, and returns the comment.
+func (p *yamlParser) lineEnd() (string, error) {
+	start := p.pos
+	p.skipSpace()
+	comment := ""
+	if p.at(p.pos) == '#' {
+		if p.pos == start && p.column() > 0 {
+			return "", p.errorf("comments must be separated from other tokens by whitespace")
+		}
+		comment = p.readComment()
+	}
+	if p.eof() {
+		return comment, nil
+	}
+	if p.src[p.pos] != '\n' {
+		r, _ := utf8.DecodeRune(p.src[p.pos:])
+		return "", p.errorf("unexpected %q", r)
+	}
+	p.pos++
+	return comment, nil
+}
+
+// parseAlias parses an alias, and returns a copy of the anchored node without
+// its comments.
+func (p *yamlParser) parseAlias() (*yamlNode, error) {
+	p.pos++
+	name := p.readName()
+	target, ok := p.anchors[name]
+	if !ok {
+		return nil, p.errorf("unknown anchor %q", name)
+	}
+	node := *target
+	node.comment, node.opening = "", ""
+	return &node, nil
+}
+
+// parseBlock parses a node which starts on its own line, at the given column,
+// within a parent node at the given indentation.
+func (p *yamlParser) parseBlock(indent int, parent int) (*yamlNode, error) {
+	switch {
+	case p.isSequenceEntry(p.pos):
+		return p.parseSequence(indent)
+	case p.at(p.pos) == '?' && isYAMLSpace(p.at(p.pos+1)):
+		return nil, p.errorf("complex mapping keys are not supported")
+	case p.isMappingKey(p.pos):
+		return p.parseMapping(indent)
+	}
+	return p.parseValue(parent, yamlAfterKey)
+}
+
+// parseBlockScalar parses a literal or folded block scalar, whose content is
+// indented more than the parent node at the given indentation.
+func (p *yamlParser) parseBlockScalar(indent int) (*yamlNode, error) {
+	folded := p.src[p.pos] == '>'
+	p.pos++
+	chomp, explicit := byte(0), 0
+	for range 2 {
+		switch c := p.at(p.pos); {
+		case (c == '+' || c == '-') && chomp == 0:
+			chomp = c
+			p.pos++
+		case c >= '1' && c <= '9' && explicit == 0:
+			explicit = int(c - '0')
+			p.pos++
+		}
+	}
+	comment, err := p.lineEnd()
+	if err != nil {
+		return nil, err
+	}
+	width := -1
+	if explicit > 0 {
+		width = indent + explicit
+	}
+	var lines []string
+	broken := true // whether the last line ends with a line break
+	for !p.eof() {
+		end := bytes.IndexByte(p.src[p.pos:], '\n')
+		if end == -1 {
+			end = len(p.src)
+		} else {
+			end += p.pos
+		}
+		line := string(p.src[p.pos:end])
+		spaces := len(line) - len(strings.TrimLeft(line, " "))
+		if spaces == len(line) {
+			if width >= 0 && spaces > width {
+				line = line[width:]
+			} else {
+				line = ""
+			}
+		} else {
+			if width < 0 {
+				if spaces <= indent {
+					break
+				}
+				width = spaces
+			}
+			if spaces < width || (width == 0 && p.isDocumentMarker(p.pos)) {
+				break
+			}
+			line = line[width:]
+		}
+		lines = append(lines, line)
+		broken = end < len(p.src)
+		p.pos = min(end+1, len(p.src))
+	}
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	var value string
+	if folded {
+		value = foldYAMLLines(lines)
+	} else {
+		value = strings.Join(lines, "\n")
+	}
+	if trailing > 0 || broken {
+		switch {
+		case chomp == '+':
+			value += strings.Repeat("\n", trailing)
+			if len(lines) > 0 && broken {
+				value += "\n"
+			}
+		case chomp == 0 && len(lines) > 0:
+			value += "\n"
+		}
+	}
+	return &yamlNode{comment: comment, kind: yamlScalar, value: value}, nil
+}
+
+// parseContent parses the content of a node, after any properties.
+func (p *yamlParser) parseContent(indent int, ctx yamlContext, props bool) (*yamlNode, error) {
+	c := p.at(p.pos)
+	switch {
+	case c == 0 || c == '\n' || c == '#':
+		return p.parseIndented(indent, ctx)
+	case c == '*':
+		if props {
+			return nil, p.errorf("aliases cannot have an anchor or tag")
+		}
+		node, err := p.parseAlias()
+		if err != nil {
+			return nil, err
+		}
+		node.comment, err = p.lineEnd()
+		return node, err
+	case c == '[' || c == '{':
+		node, err := p.parseFlow()
+		if err != nil {
+			return nil, err
+		}
+		node.comment, err = p.lineEnd()
+		return node, err
+	case c == '|' || c == '>':
+		return p.parseBlockScalar(indent)
+	case p.isSequenceEntry(p.pos):
+		if ctx == yamlAfterKey {
+			return nil, p.errorf("block sequence entries are not allowed in this context")
+		}
+		return p.parseSequence(p.column())
+	case c == '?' && isYAMLSpace(p.at(p.pos+1)):
+		return nil, p.errorf("complex mapping keys are not supported")
+	case p.isMappingKey(p.pos):
+		if ctx == yamlAfterKey {
+			return nil, p.errorf("mapping values are not allowed in this context")
+		}
+		if props {
+			return nil, p.errorf("anchors and tags on mapping keys are not supported")
+		}
+		return p.parseMapping(p.column())
+	}
+	return p.parseScalar(indent)
+}
+
+// parseEscape parses an escape sequence within a double-quoted scalar, and
+// appends the escaped character.
+func (p *yamlParser) parseEscape(buf []byte) ([]byte, error) {
+	c := p.at(p.pos + 1)
+	if r, ok := yamlEscapes[c]; ok {
+		p.pos += 2
+		return utf8.AppendRune(buf, r), nil
+	}
+	size := 0
+	switch c {
+	case 'x':
+		size = 2
+	case 'u':
+		size = 4
+	case 'U':
+		size = 8
+	default:
+		p.pos++
+		return nil, p.errorf("invalid escape sequence")
+	}
+	start := p.pos + 2
+	if start+size > len(p.src) {
+		return nil, p.errorf("invalid escape sequence")
+	}
+	r, err := strconv.ParseUint(string(p.src[start:start+size]), 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return nil, p.errorf("invalid escape sequence")
+	}
+	p.pos = start + size
+	return utf8.AppendRune(buf, rune(r)), nil
+}
+
+// parseFlow parses a flow sequence or mapping, which may span multiple lines.
+// Comments within flow collections are dropped.
+func (p *yamlParser) parseFlow() (*yamlNode, error) {
+	node := &yamlNode{kind: yamlSequence}
+	end := byte(']')
+	if p.src[p.pos] == '{' {
+		node.kind = yamlMapping
+		end = '}'
+	}
+	p.pos++
+	for {
+		if err := p.skipFlowSpace(); err != nil {
+			return nil, err
+		}
+		if p.at(p.pos) == end {
+			p.pos++
+			return node, nil
+		}
+		entry := &yamlEntry{}
+		value, err := p.parseFlowNode()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.skipFlowSpace(); err != nil {
+			return nil, err
+		}
+		if node.kind == yamlMapping {
+			entry.key = value
+			value = &yamlNode{kind: yamlScalar, plain: true}
+			if p.at(p.pos) == ':' {
+				p.pos++
+				if err := p.skipFlowSpace(); err != nil {
+					return nil, err
+				}
+				if c := p.at(p.pos); c != ',' && c != end {
+					if value, err = p.parseFlowNode(); err != nil {
+						return nil, err
+					}
+					if err := p.skipFlowSpace(); err != nil {
+						return nil, err
+					}
+				}
+			}
+		} else if p.at(p.pos) == ':' {
+			return nil, p.errorf("mappings within flow sequences are not supported")
+		}
+		entry.value = value
+		node.entries = append(node.entries, entry)
+		switch p.at(p.pos) {
+		case ',':
+			p.pos++
+		case end:
+		default:
+			return nil, p.errorf("expected ',' or '%c' in flow collection", end)
+		}
+	}
+}
+
+// parseFlowNode parses a node within a flow collection.
+func (p *yamlParser) parseFlowNode() (*yamlNode, error) {
+	anchor, tag, err := p.parseProperties()
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" || tag != "" {
+		if err := p.skipFlowSpace(); err != nil {
+			return nil, err
+		}
+	}
+	var node *yamlNode
+	switch c := p.at(p.pos); c {
+	case '*':
+		if anchor != "" || tag != "" {
+			return nil, p.errorf("aliases cannot have an anchor or tag")
+		}
+		return p.parseAlias()
+	case '[', '{':
+		node, err = p.parseFlow()
+	case '"', '\'':
+		var s string
+		s, err = p.parseQuoted()
+		node = &yamlNode{kind: yamlScalar, value: s}
+	case ',', ']', '}', ':':
+		if anchor == "" && tag == "" {
+			return nil, p.errorf("unexpected '%c' in flow collection", c)
+		}
+		node = &yamlNode{kind: yamlScalar, plain: true}
+	default:
+		node = &yamlNode{kind: yamlScalar, plain: true, value: p.parseFlowPlain()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	node.tag = tag
+	if anchor != "" {
+		p.anchors[anchor] = node
+	}
+	return node, nil
+}
+
+// parseFlowPlain parses a plain scalar within a flow collection, folding any
+// line breaks within it.
+func (p *yamlParser) parseFlowPlain() string {
+	var buf []byte
+	for {
+		c := p.at(p.pos)
+		if isFlowPlainEnd(c, p.at(p.pos+1)) {
+			return string(buf)
+		}
+		if c != ' ' && c != '\t' && c != '\n' {
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+		i, breaks := p.pos, 0
+		for c := p.at(i); c == ' ' || c == '\t' || c == '\n'; c = p.at(i) {
+			if c == '\n' {
+				breaks++
+			}
+			i++
+		}
+		if next := p.at(i); next == '#' || isFlowPlainEnd(next, p.at(i+1)) {
+			p.pos = i
+			return string(buf)
+		}
+		switch breaks {
+		case 0:
+			buf = append(buf, p.src[p.pos:i]...)
+		case 1:
+			buf = append(buf, ' ')
+		default:
+			buf = append(buf, strings.Repeat("\n", breaks-1)...)
+		}
+		p.pos = i
+	}
+}
+
+// parseIndented parses a node which starts on a following line, or returns a
+// null node if there isn't one, e.g. for `key:` on its own.
+func (p *yamlParser) parseIndented(indent int, ctx yamlContext) (*yamlNode, error) {
+	comment, err := p.lineEnd()
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.skipBlank()
+	if err != nil {
+		return nil, err
+	}
+	if n > indent || (n == indent && ctx == yamlAfterKey && p.isSequenceEntry(p.pos+n)) {
+		p.pos += n
+		node, err := p.parseBlock(n, indent)
+		if err != nil {
+			return nil, err
+		}
+		if node.kind != yamlScalar {
+			node.opening = comment
+		} else if node.comment == "" {
+			node.comment = comment
+		}
+		return node, nil
+	}
+	return &yamlNode{comment: comment, kind: yamlScalar, plain: true}, nil
+}
+
+// parseKey parses an implicit mapping key, along with the `:` after it.
+func (p *yamlParser) parseKey() (*yamlNode, error) {
+	key := &yamlNode{kind: yamlScalar}
+	if c := p.at(p.pos); c == '"' || c == '\'' {
+		s, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		key.value = s
+		p.skipSpace()
+	} else {
+		start := p.pos
+		for p.at(p.pos) != ':' || !isYAMLSpace(p.at(p.pos+1)) {
+			p.pos++
+		}
+		key.plain = true
+		key.value = strings.TrimRight(string(p.src[start:p.pos]), " \t")
+	}
+	p.pos++
+	return key, nil
+}
+
+// parseMapping parses a block mapping whose keys are at the given column.
+func (p *yamlParser) parseMapping(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlMapping}
+	for {
+		if !p.isMappingKey(p.pos) {
+			if p.at(p.pos) == '?' && isYAMLSpace(p.at(p.pos+1)) {
+				return nil, p.errorf("complex mapping keys are not supported")
+			}
+			return nil, p.errorf("expected a mapping key")
+		}
+		entry := &yamlEntry{comments: p.takeComments()}
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		entry.key = key
+		if entry.value, err = p.parseValue(indent, yamlAfterKey); err != nil {
+			return nil, err
+		}
+		node.entries = append(node.entries, entry)
+		n, err := p.skipBlank()
+		if err != nil {
+			return nil, err
+		}
+		if n < indent || (n == indent && p.isSequenceEntry(p.pos+n)) {
+			return node, nil
+		}
+		p.pos += n
+		if n > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+	}
+}
+
+// parsePlain parses a plain scalar, folding any continuation lines which are
+// indented more than the parent node at the given indentation. Lines which
+// look like mapping keys are left for the caller to reject.
+func (p *yamlParser) parsePlain(indent int) string {
+	b := &strings.Builder{}
+	b.WriteString(p.plainLine())
+	for {
+		end := p.pos
+		i := skipSpace(p.src, p.pos)
+		if p.at(i) != '\n' {
+			return b.String()
+		}
+		breaks := 0
+		for p.at(i) == '\n' {
+			breaks++
+			i = skipSpace(p.src, i+1)
+		}
+		start := bytes.LastIndexByte(p.src[:i], '\n') + 1
+		n := 0
+		for p.at(start+n) == ' ' {
+			n++
+		}
+		if c := p.at(i); c == 0 || c == '#' || n <= indent || (n == 0 && p.isDocumentMarker(start)) || p.isMappingKey(i) {
+			p.pos = end
+			return b.String()
+		}
+		if breaks == 1 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(strings.Repeat("\n", breaks-1))
+		}
+		p.pos = i
+		b.WriteString(p.plainLine())
+	}
+}
+
+// parseProperties parses any anchor and tag that precede a node.
+func (p *yamlParser) parseProperties() (string, string, error) {
+	var anchor, tag string
+	for {
+		switch p.at(p.pos) {
+		case '&':
+			if anchor != "" {
+				return "", "", p.errorf("a node can only have one anchor")
+			}
+			p.pos++
+			if anchor = p.readName(); anchor == "" {
+				return "", "", p.errorf("missing anchor name")
+			}
+		case '!':
+			if tag != "" {
+				return "", "", p.errorf("a node can only have one tag")
+			}
+			var err error
+			if tag, err = p.readTag(); err != nil {
+				return "", "", err
+			}
+		default:
+			return anchor, tag, nil
+		}
+		p.skipSpace()
+	}
+}
+
+// parseQuoted parses a single or double-quoted scalar, which may span multiple
+// lines.
+func (p *yamlParser) parseQuoted() (string, error) {
+	quote := p.src[p.pos]
+	start := p.pos
+	p.pos++
+	var buf []byte
+	keep := 0 // escaped whitespace at the end of buf isn't trimmed when folding
+	for {
+		c := p.at(p.pos)
+		switch {
+		case c == 0:
+			p.pos = start
+			return "", p.errorf("unterminated quoted scalar")
+		case c == quote:
+			if quote == '\'' && p.at(p.pos+1) == '\'' {
+				buf = append(buf, '\'')
+				p.pos += 2
+				continue
+			}
+			p.pos++
+			return string(buf), nil
+		case c == '\\' && quote == '"':
+			if p.at(p.pos+1) == '\n' {
+				p.pos = skipSpace(p.src, p.pos+2)
+				keep = len(buf)
+				continue
+			}
+			var err error
+			if buf, err = p.parseEscape(buf); err != nil {
+				return "", err
+			}
+			keep = len(buf)
+		case c == '\n':
+			for len(buf) > keep && (buf[len(buf)-1] == ' ' || buf[len(buf)-1] == '\t') {
+				buf = buf[:len(buf)-1]
+			}
+			breaks := 0
+			for p.at(p.pos) == '\n' {
+				breaks++
+				p.pos = skipSpace(p.src, p.pos+1)
+			}
+			if breaks == 1 {
+				buf = append(buf, ' ')
+			} else {
+				buf = append(buf, strings.Repeat("\n", breaks-1)...)
+			}
+		default:
+			buf = append(buf, c)
+			p.pos++
+		}
+	}
+}
+
+// parseScalar parses a quoted or plain scalar in block context, along with
+// the rest of the line.
+func (p *yamlParser) parseScalar(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlScalar}
+	switch c := p.at(p.pos); c {
+	case '"', '\'':
+		s, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		node.value = s
+	case ',', ']', '}', '%', '@', '`':
+		return nil, p.errorf("unexpected '%c' at the start of a plain scalar", c)
+	default:
+		node.plain = true
+		node.value = p.parsePlain(indent)
+	}
+	comment, err := p.lineEnd()
+	if err != nil {
+		return nil, err
+	}
+	node.comment = comment
+	return node, nil
+}
+
+// parseSequence parses a block sequence whose `-` indicators are at the given
+// column.
+func (p *yamlParser) parseSequence(indent int) (*yamlNode, error) {
+	node := &yamlNode{kind: yamlSequence}
+	for {
+		entry := &yamlEntry{comments: p.takeComments()}
+		p.pos++
+		value, err := p.parseValue(indent, yamlAfterDash)
+		if err != nil {
+			return nil, err
+		}
+		entry.value = value
+		node.entries = append(node.entries, entry)
+		n, err := p.skipBlank()
+		if err != nil {
+			return nil, err
+		}
+		if n < indent || (n == indent && !p.isSequenceEntry(p.pos+n)) {
+			return node, nil
+		}
+		p.pos += n
+		if n > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+	}
+}
+
+// parseStream parses all of the documents within the source.
+func (p *yamlParser) parseStream() ([]*yamlDocument, error) {
+	var docs []*yamlDocument
+	for {
+		indent, err := p.skipBlank()
+		if err != nil {
+			return nil, err
+		}
+		for indent == 0 && p.at(p.pos) == '%' {
+			if !bytes.HasPrefix(p.src[p.pos:], []byte("%YAML ")) {
+				return nil, p.errorf("unsupported directive")
+			}
+			for !isYAMLBreak(p.at(p.pos)) {
+				p.pos++
+			}
+			if indent, err = p.skipBlank(); err != nil {
+				return nil, err
+			}
+		}
+		if p.eof() {
+			if len(docs) == 0 {
+				docs = append(docs, &yamlDocument{comments: p.takeComments()})
+			}
+			return docs, nil
+		}
+		if indent == -1 {
+			marker := p.src[p.pos]
+			p.pos += 3
+			if marker == '.' {
+				if _, err := p.lineEnd(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		root, err := p.parseValue(-1, yamlRoot)
+		if err != nil {
+			return nil, err
+		}
+		if indent, err = p.skipBlank(); err != nil {
+			return nil, err
+		}
+		if indent > 0 {
+			p.pos += indent
+			return nil, p.errorf("unexpected indentation")
+		}
+		if indent == 0 {
+			return nil, p.errorf("unexpected content after the end of the document")
+		}
+		docs = append(docs, &yamlDocument{comments: p.takeComments(), root: root})
+		if !p.eof() && p.src[p.pos] == '.' {
+			p.pos += 3
+			if _, err := p.lineEnd(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// parseValue parses a node, along with any properties, which starts after a
+// key, a sequence entry indicator, or at the start of a document. The given
+// indentation is that of the enclosing collection.
+func (p *yamlParser) parseValue(indent int, ctx yamlContext) (*yamlNode, error) {
+	p.skipSpace()
+	anchor, tag, err := p.parseProperties()
+	if err != nil {
+		return nil, err
+	}
+	node, err := p.parseContent(indent, ctx, anchor != "" || tag != "")
+	if err != nil {
+		return nil, err
+	}
+	if tag != "" {
+		node.tag = tag
+	}
+	if anchor != "" {
+		p.anchors[anchor] = node
+	}
+	return node, nil
+}
+
+// plainLine returns the rest of a plain scalar on the current line, without
+// any trailing whitespace, stopping before any comment.
+func (p *yamlParser) plainLine() string {
+	start, end := p.pos, p.pos
+	for i := p.pos; !isYAMLBreak(p.at(i)); i++ {
+		c := p.src[i]
+		if c == '#' && i > start && (p.src[i-1] == ' ' || p.src[i-1] == '\t') {
+			break
+		}
+		if c != ' ' && c != '\t' {
+			end = i + 1
+		}
+	}
+	p.pos = end
+	return string(p.src[start:end])
+}
+
+// quotedEnd returns the offset after the closing quote of the quoted scalar
+// starting at the given offset, or false if it doesn't end on the same line.
+func (p *yamlParser) quotedEnd(i int) (int, bool) {
+	quote := p.src[i]
+	for i++; !isYAMLBreak(p.at(i)); i++ {
+		switch p.src[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			if quote == '\'' && p.at(i+1) == '\'' {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// readComment reads a comment up to the end of the line, and returns its text
+// without the leading `#` and a single space following it.
+func (p *yamlParser) readComment() string {
+	start := p.pos + 1
+	for !isYAMLBreak(p.at(p.pos)) {
+		p.pos++
+	}
+	text := strings.TrimPrefix(string(p.src[start:p.pos]), " ")
+	return strings.TrimRight(text, " \t")
+}
+
+// readName reads an anchor or alias name.
+func (p *yamlParser) readName() string {
+	start := p.pos
+	for c := p.at(p.pos); !isYAMLSpace(c) && !strings.ContainsRune(",[]{}", rune(c)); c = p.at(p.pos) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// readTag reads a tag, and returns it with the standard tag prefix shortened
+// to `!!`, e.g. `!<tag:yaml.org,2002:str>` is returned as `!!str`.
+func (p *yamlParser) readTag() (string, error) {
+	if p.at(p.pos+1) != '<' {
+		return p.readName(), nil
+	}
+	end := bytes.IndexByte(p.src[p.pos:], '>')
+	if end == -1 || bytes.IndexByte(p.src[p.pos:p.pos+end], '\n') != -1 {
+		return "", p.errorf("unterminated verbatim tag")
+	}
+	tag := string(p.src[p.pos+2 : p.pos+end])
+	p.pos += end + 1
+	if name, ok := strings.CutPrefix(tag, "tag:yaml.org,2002:"); ok {
+		return "!!" + name, nil
+	}
+	return "!<" + tag + ">", nil
+}
+
+// skipBlank skips any blank lines and comment lines from the start of a line,
+// collecting the comments, and returns the indentation of the next line with
+// content, leaving the parser at the start of that line. At the end of the
+// source, or of a document, it returns -1.
+func (p *yamlParser) skipBlank() (int, error) {
+	for !p.eof() {
+		start := p.pos
+		i := start
+		for p.at(i) == ' ' {
+			i++
+		}
+		j := skipSpace(p.src, i)
+		switch p.at(j) {
+		case 0:
+			p.pos = j
+			return -1, nil
+		case '\n':
+			p.pos = j + 1
+			continue
+		case '#':
+			p.pos = j
+			p.comments = append(p.comments, p.readComment())
+			continue
+		}
+		if j != i {
+			p.pos = i
+			return 0, p.errorf("tabs cannot be used for indentation")
+		}
+		if i == start && p.isDocumentMarker(start) {
+			return -1, nil
+		}
+		return i - start, nil
+	}
+	return -1, nil
+}
+
+// skipFlowSpace skips whitespace, line breaks, and comments within a flow
+// collection.
+func (p *yamlParser) skipFlowSpace() error {
+	for {
+		switch c := p.at(p.pos); c {
+		case ' ', '\t', '\n':
+			p.pos++
+		case '#':
+			p.readComment()
+		case 0:
+			return p.errorf("unterminated flow collection")
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *yamlParser) skipSpace() {
+	p.pos = skipSpace(p.src, p.pos)
+}
+
+func (p *yamlParser) takeComments() []string {
+	comments := p.comments
+	p.comments = nil
+	return comments
+}
+
+// FromYAML converts the given YAML source into XON nodes, with one list of
+// nodes for each document within the source. The top level of each document
+// must be a mapping.
+//
+// Mappings are converted into blocks, and sequences of mappings into repeated
+// blocks with the same name. All other sequences are converted into lists,
+// and sets into lists of their elements. Aliases are expanded, and merge keys,
+// i.e. `<<`, are resolved. Comments are kept, except for those within flow
+// collections.
+//
+// Scalars are kept as written, as XON leaves the interpretation of values to
+// the decoder, except that nulls are converted into `nil`, booleans are
+// lowercased, and `.inf` and `.nan` are converted into `inf` and `nan`. Strings
+// with the value "nil" are quoted, so that they can be told apart. Literal and
+// folded block scalars keep their final line break, unless they use the `-`
+// chomping indicator, e.g. `|-`, which means that they can't be written as
+// XON multiline strings, and are quoted with the line break escaped instead.
+//
+// The standard tags, e.g. `!!str` and `!!int`, are supported, and any values
+// are checked against them. All other tags result in an error, as do complex
+// mapping keys, and directives other than %YAML.
+func FromYAML(src []byte) ([][]Node, error) {
+	src = bytes.TrimPrefix(src, []byte("\ufeff"))
+	src = bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+	p := &yamlParser{anchors: map[string]*yamlNode{}, src: src}
+	docs, err := p.parseStream()
+	if err != nil {
+		return nil, err
+	}
+	c := &yamlConverter{}
+	out := make([][]Node, len(docs))
+	for i, doc := range docs {
+		if out[i], err = c.convert(doc); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// checkYAMLTag returns an error if the given collection has a tag other than
+// the non-specific tag, or the given standard tag.
+func checkYAMLTag(node *yamlNode, path string, tag string) error {
+	if node.tag == "" || node.tag == "!" || node.tag == tag {
+		return nil
+	}
+	return decodeErrorf(path, "cannot convert the YAML tag %s", node.tag)
+}
+
+// foldYAMLLines joins the lines of a folded block scalar, so that line breaks
+// between lines of text become spaces, except around lines which are indented
+// more than the rest, and empty lines become line breaks.
+func foldYAMLLines(lines []string) string {
+	b := &strings.Builder{}
+	text, prevMore := false, false
+	breaks := 0
+	for _, line := range lines {
+		if line == "" {
+			breaks++
+			continue
+		}
+		more := line[0] == ' ' || line[0] == '\t'
+		switch {
+		case !text:
+			b.WriteString(strings.Repeat("\n", breaks))
+		case !more && !prevMore && breaks == 0:
+			b.WriteByte(' ')
+		case !more && !prevMore:
+			b.WriteString(strings.Repeat("\n", breaks))
+		default:
+			b.WriteString(strings.Repeat("\n", breaks+1))
+		}
+		b.WriteString(line)
+		text, prevMore, breaks = true, more, 0
+	}
+	return b.String()
+}
+
+func isFlowPlainEnd(c byte, next byte) bool {
+	switch c {
+	case 0, ',', '[', ']', '{', '}':
+		return true
+	case ':':
+		return isYAMLSpace(next) || strings.IndexByte(",[]{}", next) != -1
+	}
+	return false
+}
+
+func isYAMLBreak(c byte) bool {
+	return c == 0 || c == '\n'
+}
+
+// isYAMLNull returns whether the given node resolves to null.
+func isYAMLNull(node *yamlNode) bool {
+	if node.kind != yamlScalar {
+		return false
+	}
+	switch node.tag {
+	case "!!null":
+		return true
+	case "":
+		return node.plain && yamlNull(node.value)
+	}
+	return false
+}
+
+func isYAMLSpace(c byte) bool {
+	return c == 0 || c == ' ' || c == '\t' || c == '\n'
+}
+
+// mergeYAMLEntries returns the entries of a mapping, with any merge keys
+// replaced by the entries of the mappings they refer to. Entries from merged
+// mappings are skipped if the key is set explicitly, or by an earlier merge.
+func mergeYAMLEntries(node *yamlNode, path string) ([]*yamlEntry, error) {
+	isMerge := func(key *yamlNode) bool {
+		return key.kind == yamlScalar && key.plain && key.tag == "" && key.value == "<<"
+	}
+	seen := map[string]bool{}
+	for _, e := range node.entries {
+		if !isMerge(e.key) {
+			seen[e.key.value] = true
+		}
+	}
+	var entries []*yamlEntry
+	for _, e := range node.entries {
+		if !isMerge(e.key) {
+			entries = append(entries, e)
+			continue
+		}
+		sources := []*yamlNode{e.value}
+		if e.value.kind == yamlSequence {
+			sources = nil
+			for _, item := range e.value.entries {
+				sources = append(sources, item.value)
+			}
+		}
+		for _, src := range sources {
+			if src.kind != yamlMapping {
+				return nil, decodeErrorf(childPath(path, "<<"), "merge keys must refer to mappings")
+			}
+			merged, err := mergeYAMLEntries(src, childPath(path, "<<"))
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range merged {
+				if m.key.kind == yamlScalar && seen[m.key.value] {
+					continue
+				}
+				seen[m.key.value] = true
+				entries = append(entries, &yamlEntry{key: m.key, value: m.value})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// yamlBool returns the XON form of a YAML boolean.
+func yamlBool(s string) (string, bool) {
+	switch s {
+	case "true", "True", "TRUE":
+		return "true", true
+	case "false", "False", "FALSE":
+		return "false", true
+	}
+	return "", false
+}
+
+// yamlDigits returns whether s only consists of decimal digits, and isn't
+// empty, unless empty is set.
+func yamlDigits(s string, empty bool) bool {
+	if s == "" {
+		return empty
+	}
+	for i := range len(s) {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// yamlFloat returns the XON form of a YAML float, which may also be written as
+// an integer.
+func yamlFloat(s string) (string, bool) {
+	switch strings.TrimLeft(s, "+-") {
+	case ".inf", ".Inf", ".INF":
+		if s[0] == '-' {
+			return "-inf", true
+		}
+		return "inf", true
+	case ".nan", ".NaN", ".NAN":
+		if s[0] == '.' {
+			return "nan", true
+		}
+		return "", false
+	}
+	if _, ok := yamlInt(s); ok {
+		return s, true
+	}
+	mantissa, exp, hasExp := strings.Cut(strings.ReplaceAll(s, "E", "e"), "e")
+	mantissa = strings.TrimLeft(mantissa, "+-")
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	if whole == "" && frac == "" {
+		return "", false
+	}
+	if !yamlDigits(whole, true) || !yamlDigits(frac, true) {
+		return "", false
+	}
+	if hasExp && !yamlDigits(strings.TrimLeft(exp, "+-"), false) {
+		return "", false
+	}
+	if !strings.Contains(mantissa, ".") && !hasExp {
+		return "", false
+	}
+	return s, true
+}
+
+// yamlInt returns whether s is a YAML integer, which is kept as written.
+func yamlInt(s string) (string, bool) {
+	switch {
+	case strings.HasPrefix(s, "0o"):
+		for i := 2; i < len(s); i++ {
+			if s[i] < '0' || s[i] > '7' {
+				return "", false
+			}
+		}
+		return s, len(s) > 2
+	case strings.HasPrefix(s, "0x"):
+		for i := 2; i < len(s); i++ {
+			if unhex(s[i]) < 0 {
+				return "", false
+			}
+		}
+		return s, len(s) > 2
+	}
+	return s, yamlDigits(strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+"), false)
+}
+
+func yamlNull(s string) bool {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return true
+	}
+	return false
+}
+
+// yamlScalarValue returns the XON value for a scalar, following its tag, or
+// the YAML core schema if it has none.
+func yamlScalarValue(node *yamlNode, path string) (Value, error) {
+	s := node.value
+	ok := true
+	switch node.tag {
+	case "":
+		if !node.plain {
+			break
+		}
+		if yamlNull(s) {
+			return &String{Value: "nil"}, nil
+		}
+		if v, ok := yamlBool(s); ok {
+			return &String{Value: v}, nil
+		}
+		if v, ok := yamlFloat(s); ok {
+			return &String{Value: v}, nil
+		}
+	case "!", "!!str", "!!timestamp":
+	case "!!binary":
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+		if err != nil {
+			return nil, decodeErrorf(path, "cannot convert invalid base64 data as !!binary")
+		}
+		return &Bytes{Data: data}, nil
+	case "!!bool":
+		s, ok = yamlBool(s)
+	case "!!float":
+		s, ok = yamlFloat(s)
+	case "!!int":
+		s, ok = yamlInt(s)
+	case "!!null":
+		if yamlNull(s) {
+			return &String{Value: "nil"}, nil
+		}
+		ok = false
+	default:
+		return nil, decodeErrorf(path, "cannot convert the YAML tag %s", node.tag)
+	}
+	if !ok {
+		return nil, decodeErrorf(path, "cannot convert %q as %s", node.value, node.tag)
+	}
+	return &String{Quoted: s == "nil", Value: s}, nil
+}